@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/zgsm-ai/client-manager/internal"
+	"github.com/zgsm-ai/client-manager/services"
+)
+
+// maxConfigurationWait 客户端长轮询等待配置变化的最长时间
+const maxConfigurationWait = 60 * time.Second
+
+// configurationKeepAlive SSE连接上发送心跳的间隔
+const configurationKeepAlive = 30 * time.Second
+
+/**
+ * ConfigurationController handles HTTP requests for remote configuration
+ * @description
+ * - Implements the admin API managing configuration items and their versions
+ * - Implements the client API reading the resolved configuration of a namespace,
+ *   with ETags, long polling and server-sent events
+ * - Integrates with ConfigurationService for business logic
+ */
+type ConfigurationController struct {
+	configService *services.ConfigurationService
+	log           *logrus.Logger
+}
+
+/**
+ * NewConfigurationController creates a new ConfigurationController instance
+ * @param {services.ConfigurationService} configService - Configuration service instance
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*ConfigurationController} New ConfigurationController instance
+ */
+func NewConfigurationController(configService *services.ConfigurationService, log *logrus.Logger) *ConfigurationController {
+	return &ConfigurationController{
+		configService: configService,
+		log:           log,
+	}
+}
+
+// ListConfigurations handles GET /configurations request
+// @Summary List configurations
+// @Description List configuration items, optionally filtered by namespace and scope
+// @Tags Configuration
+// @Produce json
+// @Param namespace query string false "Namespace"
+// @Param scope query string false "Scope (global, org, user, client)"
+// @Param scope_id query string false "Scope identifier"
+// @Success 200 {object} map[string]interface{} "Configuration items"
+// @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Router /client-manager/api/v1/configurations [get]
+func (cc *ConfigurationController) ListConfigurations(c *gin.Context) {
+	var args services.ListConfigurationsArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	cfgs, err := cc.configService.ListConfigurations(c.Request.Context(), &args)
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configurations retrieved successfully",
+		"data":    cfgs,
+	})
+}
+
+// GetConfiguration handles GET /configurations/{namespace}/{key} request
+// @Summary Get configuration
+// @Description Retrieve a configuration item of a scope
+// @Tags Configuration
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param key path string true "Key"
+// @Param scope query string false "Scope (global, org, user, client)" default(global)
+// @Param scope_id query string false "Scope identifier"
+// @Success 200 {object} map[string]interface{} "Configuration item"
+// @Failure 404 {object} map[string]interface{} "Configuration not found"
+// @Router /client-manager/api/v1/configurations/{namespace}/{key} [get]
+func (cc *ConfigurationController) GetConfiguration(c *gin.Context) {
+	var args services.ConfigurationScopeArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	cfg, err := cc.configService.GetConfiguration(c.Request.Context(), c.Param("namespace"), c.Param("key"), &args)
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configuration retrieved successfully",
+		"data":    cfg,
+	})
+}
+
+// SaveConfiguration handles PUT /configurations/{namespace}/{key} request
+// @Summary Save configuration
+// @Description Create or update a configuration item of a scope, as a new version
+// @Tags Configuration
+// @Accept json
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param key path string true "Key"
+// @Param configuration body services.SaveConfigurationArgs true "Scope, value and description"
+// @Success 200 {object} map[string]interface{} "Saved configuration item"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Router /client-manager/api/v1/configurations/{namespace}/{key} [put]
+func (cc *ConfigurationController) SaveConfiguration(c *gin.Context) {
+	var args services.SaveConfigurationArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	operator := internal.GetOperator(c)
+	cfg, err := cc.configService.SaveConfiguration(c.Request.Context(), c.Param("namespace"), c.Param("key"), &args, operator)
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configuration saved successfully",
+		"data":    cfg,
+	})
+}
+
+// DeleteConfiguration handles DELETE /configurations/{namespace}/{key} request
+// @Summary Delete configuration
+// @Description Delete a configuration item of a scope, it can be restored by a rollback
+// @Tags Configuration
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param key path string true "Key"
+// @Param scope query string false "Scope (global, org, user, client)" default(global)
+// @Param scope_id query string false "Scope identifier"
+// @Success 200 {object} map[string]interface{} "Configuration deleted"
+// @Failure 404 {object} map[string]interface{} "Configuration not found"
+// @Router /client-manager/api/v1/configurations/{namespace}/{key} [delete]
+func (cc *ConfigurationController) DeleteConfiguration(c *gin.Context) {
+	var args services.ConfigurationScopeArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	operator := internal.GetOperator(c)
+	if err := cc.configService.DeleteConfiguration(c.Request.Context(), c.Param("namespace"), c.Param("key"), &args, operator); err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configuration deleted successfully",
+	})
+}
+
+// GetConfigurationHistory handles GET /configurations/{namespace}/{key}/history request
+// @Summary Get configuration history
+// @Description List the versions of a configuration item of a scope, the latest first
+// @Tags Configuration
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param key path string true "Key"
+// @Param scope query string false "Scope (global, org, user, client)" default(global)
+// @Param scope_id query string false "Scope identifier"
+// @Success 200 {object} map[string]interface{} "Configuration versions"
+// @Failure 404 {object} map[string]interface{} "Configuration not found"
+// @Router /client-manager/api/v1/configurations/{namespace}/{key}/history [get]
+func (cc *ConfigurationController) GetConfigurationHistory(c *gin.Context) {
+	var args services.ConfigurationScopeArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	versions, err := cc.configService.GetConfigurationHistory(c.Request.Context(), c.Param("namespace"), c.Param("key"), &args)
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configuration history retrieved successfully",
+		"data":    versions,
+	})
+}
+
+// RollbackConfiguration handles POST /configurations/{namespace}/{key}/rollback request
+// @Summary Roll back configuration
+// @Description Restore a version of a configuration item of a scope, as a new version
+// @Tags Configuration
+// @Accept json
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param key path string true "Key"
+// @Param rollback body services.RollbackConfigurationArgs true "Scope and version to restore"
+// @Success 200 {object} map[string]interface{} "Restored configuration item"
+// @Failure 404 {object} map[string]interface{} "Version not found"
+// @Router /client-manager/api/v1/configurations/{namespace}/{key}/rollback [post]
+func (cc *ConfigurationController) RollbackConfiguration(c *gin.Context) {
+	var args services.RollbackConfigurationArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	operator := internal.GetOperator(c)
+	cfg, err := cc.configService.RollbackConfiguration(c.Request.Context(), c.Param("namespace"), c.Param("key"), &args, operator)
+	if err != nil {
+		cc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Configuration rolled back successfully",
+		"data":    cfg,
+	})
+}
+
+// GetClientConfiguration handles GET /client/configurations/{namespace} request
+// @Summary Get client configuration
+// @Description Resolve the configuration of a namespace for the calling client, the values
+// @Description of a key are overridden in the order global -> org -> user -> client.
+// @Description The user, org and client ID come from the verified bearer token.
+// @Description With If-None-Match set to the current ETag, returns 304, after waiting up to
+// @Description wait seconds for a change.
+// @Tags Configuration
+// @Produce json
+// @Param namespace path string true "Namespace"
+// @Param wait query int false "Seconds to wait for a change (long polling, max 60)"
+// @Param If-None-Match header string false "ETag of the configuration held by the client"
+// @Security ApiKeyAuth
+// @Success 200 {object} services.ResolvedConfiguration "Resolved configuration"
+// @Success 304 "Configuration not modified"
+// @Router /client-manager/api/v1/client/configurations/{namespace} [get]
+func (cc *ConfigurationController) GetClientConfiguration(c *gin.Context) {
+	var args services.ClientConfigurationArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	namespace := c.Param("namespace")
+	id := internal.GetIdentity(c)
+	etag := c.GetHeader("If-None-Match")
+	wait := time.Duration(args.Wait) * time.Second
+	if wait > maxConfigurationWait {
+		wait = maxConfigurationWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		changed := cc.configService.WatchConfiguration(namespace)
+		rc, err := cc.configService.ResolveConfiguration(c.Request.Context(), namespace, id.Org, id.UserID, id.ClientID)
+		if err != nil {
+			cc.handleError(c, err)
+			return
+		}
+		c.Header("ETag", rc.ETag)
+		c.Header("Cache-Control", "no-cache")
+		if etag != rc.ETag {
+			c.JSON(http.StatusOK, rc)
+			return
+		}
+		select {
+		case <-changed:
+			continue
+		case <-timeout.C:
+		case <-c.Request.Context().Done():
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
+}
+
+// WatchClientConfiguration handles GET /client/configurations/{namespace}/events request
+// @Summary Watch client configuration
+// @Description Stream the resolved configuration of a namespace as server-sent events: a
+// @Description "config" event with the configuration when it differs from If-None-Match,
+// @Description then on each change, and "ping" events to keep the connection alive.
+// @Description Changes are only notified when made through the same instance.
+// @Tags Configuration
+// @Produce text/event-stream
+// @Param namespace path string true "Namespace"
+// @Param If-None-Match header string false "ETag of the configuration held by the client"
+// @Security ApiKeyAuth
+// @Success 200 {object} services.ResolvedConfiguration "Configuration events"
+// @Router /client-manager/api/v1/client/configurations/{namespace}/events [get]
+func (cc *ConfigurationController) WatchClientConfiguration(c *gin.Context) {
+	var args services.ClientConfigurationArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	namespace := c.Param("namespace")
+	id := internal.GetIdentity(c)
+	etag := c.GetHeader("If-None-Match")
+	keepAlive := time.NewTicker(configurationKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	var changed <-chan struct{}
+	c.Stream(func(w io.Writer) bool {
+		if changed != nil {
+			select {
+			case <-changed:
+			case <-keepAlive.C:
+				c.SSEvent("ping", "")
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		}
+		changed = cc.configService.WatchConfiguration(namespace)
+		rc, err := cc.configService.ResolveConfiguration(c.Request.Context(), namespace, id.Org, id.UserID, id.ClientID)
+		if err != nil {
+			cc.log.WithError(err).WithField("namespace", namespace).Error("Failed to resolve configuration")
+			c.SSEvent("error", err.Error())
+			return false
+		}
+		if rc.ETag != etag {
+			c.SSEvent("config", rc)
+			etag = rc.ETag
+		}
+		return true
+	})
+}
+
+/**
+ * handleError handles errors and returns appropriate HTTP responses
+ * @param {gin.Context} c - Gin context
+ * @param {error} err - Error to handle
+ * @description
+ * - Maps different error types to appropriate HTTP status codes
+ * - Returns standardized error response format
+ * - Logs errors for debugging
+ */
+func (cc *ConfigurationController) handleError(c *gin.Context, err error) {
+	cc.log.WithError(err).Error("Request processing failed")
+
+	switch e := err.(type) {
+	case *services.ValidationError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "validation.error",
+			"message": e.Message,
+			"field":   e.Field,
+		})
+	case *services.NotFoundError:
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    "notfound.error",
+			"message": e.Message,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    "internal.error",
+			"message": "Internal server error",
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/zgsm-ai/client-manager/internal"
+	"github.com/zgsm-ai/client-manager/models"
+	"github.com/zgsm-ai/client-manager/services"
+)
+
+// feedbackColumns are the columns of the CSV export
+var feedbackColumns = []string{
+	"id", "created_at", "type", "user_id", "client_id", "client_version",
+	"conversation_id", "request_id", "completion_id", "score", "content", "metadata",
+}
+
+/**
+ * csvCell neutralizes a cell a spreadsheet would run as a formula
+ * @param {string} s - Cell value, from the clients
+ * @returns {string} The value, prefixed with ' when it starts with = + - @ or a tab/CR
+ */
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+/**
+ * FeedbackController handles HTTP requests for feedback operations
+ * @description
+ * - Implements the client API posting feedbacks
+ * - Implements the admin API querying, aggregating and exporting feedbacks
+ * - Integrates with FeedbackService for business logic
+ */
+type FeedbackController struct {
+	feedbackService *services.FeedbackService
+	log             *logrus.Logger
+}
+
+/**
+ * NewFeedbackController creates a new FeedbackController instance
+ * @param {services.FeedbackService} feedbackService - Feedback service instance
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*FeedbackController} New FeedbackController instance
+ */
+func NewFeedbackController(feedbackService *services.FeedbackService, log *logrus.Logger) *FeedbackController {
+	return &FeedbackController{
+		feedbackService: feedbackService,
+		log:             log,
+	}
+}
+
+// PostFeedback handles POST /feedbacks request
+// @Summary Create feedback
+// @Description Record a feedback (thumbs_up, thumbs_down, copy, accept, evaluate) on a
+// @Description chat-rag request or a code completion, as the user of the verified bearer token
+// @Tags Feedback
+// @Accept json
+// @Produce json
+// @Param feedback body services.FeedbackArgs true "Feedback"
+// @Success 201 {object} map[string]interface{} "Created feedback"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Failure 401 {object} map[string]interface{} "Missing or invalid bearer token"
+// @Router /client-manager/api/v1/feedbacks [post]
+func (fc *FeedbackController) PostFeedback(c *gin.Context) {
+	var args services.FeedbackArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	feedbacks, err := fc.feedbackService.CreateFeedbacks(c.Request.Context(), []services.FeedbackArgs{args}, internal.GetIdentity(c).UserID)
+	if err != nil {
+		fc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"code":    "success",
+		"message": "Feedback created successfully",
+		"data":    feedbacks[0],
+	})
+}
+
+// PostFeedbacks handles POST /feedbacks/batch request
+// @Summary Create feedbacks
+// @Description Record up to 100 feedbacks at once, all of them or none, as the user of the verified bearer token
+// @Tags Feedback
+// @Accept json
+// @Produce json
+// @Param feedbacks body []services.FeedbackArgs true "Feedbacks"
+// @Success 201 {object} map[string]interface{} "Number of created feedbacks"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Failure 401 {object} map[string]interface{} "Missing or invalid bearer token"
+// @Router /client-manager/api/v1/feedbacks/batch [post]
+func (fc *FeedbackController) PostFeedbacks(c *gin.Context) {
+	var args []services.FeedbackArgs
+	if err := c.ShouldBindJSON(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	feedbacks, err := fc.feedbackService.CreateFeedbacks(c.Request.Context(), args, internal.GetIdentity(c).UserID)
+	if err != nil {
+		fc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"code":    "success",
+		"message": "Feedbacks created successfully",
+		"count":   len(feedbacks),
+	})
+}
+
+// ListFeedbacks handles GET /feedbacks request
+// @Summary List feedbacks
+// @Description List feedbacks with filtering and pagination, the latest first
+// @Tags Feedback
+// @Produce json
+// @Param type query string false "Feedback type"
+// @Param user_id query string false "User ID"
+// @Param client_id query string false "Client ID"
+// @Param client_version query string false "Client version"
+// @Param conversation_id query string false "Conversation ID"
+// @Param request_id query string false "chat-rag request ID"
+// @Param completion_id query string false "Completion ID"
+// @Param start_date query string false "Start date (YYYY-MM-DD)"
+// @Param end_date query string false "End date (YYYY-MM-DD), included"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Number of items per page" default(20)
+// @Success 200 {object} map[string]interface{} "Feedbacks with pagination"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Router /client-manager/api/v1/feedbacks [get]
+func (fc *FeedbackController) ListFeedbacks(c *gin.Context) {
+	var args services.ListFeedbacksArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	feedbacks, paging, err := fc.feedbackService.ListFeedbacks(c.Request.Context(), &args)
+	if err != nil {
+		fc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Feedbacks retrieved successfully",
+		"data":    feedbacks,
+		"paging":  paging,
+	})
+}
+
+// GetFeedbackStats handles GET /feedbacks/stats request
+// @Summary Get feedback statistics
+// @Description Count feedbacks and average their scores, grouped by type, day and/or client version
+// @Tags Feedback
+// @Produce json
+// @Param group_by query string false "Comma separated groups: type, day, client_version" default(type)
+// @Param type query string false "Feedback type"
+// @Param client_version query string false "Client version"
+// @Param start_date query string false "Start date (YYYY-MM-DD)"
+// @Param end_date query string false "End date (YYYY-MM-DD), included"
+// @Success 200 {object} map[string]interface{} "Feedback statistics"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Router /client-manager/api/v1/feedbacks/stats [get]
+func (fc *FeedbackController) GetFeedbackStats(c *gin.Context) {
+	var args services.FeedbackStatsArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	stats, err := fc.feedbackService.FeedbackStats(c.Request.Context(), &args)
+	if err != nil {
+		fc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Feedback statistics retrieved successfully",
+		"data":    stats,
+	})
+}
+
+// ExportFeedbacks handles GET /feedbacks/export request
+// @Summary Export feedbacks
+// @Description Download the feedbacks matching the filters as CSV or JSON, oldest first.
+// @Description CSV cells starting with = + - @ are prefixed with ' so spreadsheets show them as text.
+// @Tags Feedback
+// @Produce text/csv,application/json
+// @Param format query string false "csv or json" default(csv)
+// @Param type query string false "Feedback type"
+// @Param client_version query string false "Client version"
+// @Param start_date query string false "Start date (YYYY-MM-DD)"
+// @Param end_date query string false "End date (YYYY-MM-DD), included"
+// @Success 200 {file} file "Feedbacks"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Router /client-manager/api/v1/feedbacks/export [get]
+func (fc *FeedbackController) ExportFeedbacks(c *gin.Context) {
+	var args services.QueryFeedbacksArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "argument.invalid",
+			"message": err.Error(),
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		fc.handleError(c, &services.ValidationError{Field: "format", Message: "format must be csv or json"})
+		return
+	}
+	// 首批数据写出前校验参数，之后的错误只能记录日志
+	started := false
+	start := func() {
+		started = true
+		fileName := fmt.Sprintf("feedbacks-%s.%s", time.Now().Format("20060102150405"), format)
+		c.Header("Content-Disposition", "attachment; filename="+fileName)
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+		} else {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+		}
+		c.Status(http.StatusOK)
+	}
+	var err error
+	if format == "csv" {
+		w := csv.NewWriter(c.Writer)
+		err = fc.feedbackService.ExportFeedbacks(c.Request.Context(), &args, func(batch []models.Feedback) error {
+			if !started {
+				start()
+				w.Write(feedbackColumns)
+			}
+			for _, f := range batch {
+				w.Write([]string{
+					strconv.FormatUint(uint64(f.ID), 10), f.CreatedAt.Format(time.RFC3339), csvCell(f.Type), csvCell(f.UserID),
+					csvCell(f.ClientID), csvCell(f.ClientVersion), csvCell(f.ConversationID), csvCell(f.RequestID), csvCell(f.CompletionID),
+					strconv.Itoa(f.Score), csvCell(f.Content), csvCell(f.Metadata),
+				})
+			}
+			w.Flush()
+			return w.Error()
+		})
+		if err == nil && !started {
+			start()
+			w.Write(feedbackColumns)
+			w.Flush()
+		}
+	} else {
+		enc := json.NewEncoder(c.Writer)
+		err = fc.feedbackService.ExportFeedbacks(c.Request.Context(), &args, func(batch []models.Feedback) error {
+			for _, f := range batch {
+				sep := ","
+				if !started {
+					start()
+					sep = "["
+				}
+				c.Writer.WriteString(sep)
+				if err := enc.Encode(f); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			if !started {
+				start()
+				c.Writer.WriteString("[")
+			}
+			c.Writer.WriteString("]\n")
+		}
+	}
+	if err != nil {
+		if !started {
+			fc.handleError(c, err)
+			return
+		}
+		fc.log.WithError(err).Error("Failed to export feedbacks")
+	}
+}
+
+/**
+ * handleError handles errors and returns appropriate HTTP responses
+ * @param {gin.Context} c - Gin context
+ * @param {error} err - Error to handle
+ * @description
+ * - Maps different error types to appropriate HTTP status codes
+ * - Returns standardized error response format
+ * - Logs errors for debugging
+ */
+func (fc *FeedbackController) handleError(c *gin.Context, err error) {
+	fc.log.WithError(err).Error("Request processing failed")
+
+	switch e := err.(type) {
+	case *services.ValidationError:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    "validation.error",
+			"message": e.Message,
+			"field":   e.Field,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    "internal.error",
+			"message": "Internal server error",
+		})
+	}
+}
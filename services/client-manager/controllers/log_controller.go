@@ -1,14 +1,15 @@
 package controllers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -36,7 +37,7 @@ type LogController struct {
  */
 func NewLogController(log *logrus.Logger) *LogController {
 	// Initialize DAOs and services here
-	logService := services.NewLogService(nil, log) // Will be properly initialized later
+	logService := services.NewLogService(nil, nil, services.LogLimits{}, log) // Will be properly initialized later
 
 	return &LogController{
 		logService: logService,
@@ -90,20 +91,32 @@ func getUserId(header http.Header) string {
 
 // PostLog handles POST /logs request
 // @Summary Create log
-// @Description Create a new log record
+// @Description Upload a log file (multipart form: logfile, args), stored compressed
 // @Tags Log
-// @Accept json
+// @Accept multipart/form-data
 // @Produce json
-// @Param log body map[string]interface{} true "Log data"
-// @Success 201 {object} map[string]interface{} "Created log"
+// @Param logfile formData file true "Log file, text or gzip"
+// @Param args formData string true "Log data (services.UploadLogArgs as JSON)"
+// @Success 200 {object} map[string]interface{} "Created log"
 // @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Failure 413 {object} map[string]interface{} "File too large"
+// @Failure 415 {object} map[string]interface{} "Content type not allowed"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
 // @Router /client-manager/api/v1/logs [post]
 func (lc *LogController) PostLog(c *gin.Context) {
+	if maxSize := lc.logService.MaxSize(); maxSize > 0 {
+		// 预留表单其它字段的空间，文件本身的大小由服务校验
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+	}
 	// 获取上传的文件
 	file, fileHead, err := c.Request.FormFile("logfile")
 	if err != nil {
 		lc.log.Errorf("get FormFile('logfile') error: %s", err.Error())
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			lc.handleError(c, &services.TooLargeError{Message: "log file exceeds the size limit"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -121,68 +134,129 @@ func (lc *LogController) PostLog(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "userID is invalid"})
 		return
 	}
-
-	if _, err := lc.logService.CreateLog(context.Background(), &args); err != nil {
-		lc.handleError(c, err)
-		return
+	if args.FileName == "" {
+		args.FileName = fileHead.Filename
 	}
 
-	destPath := filepath.Join("/data", args.ClientID, fileHead.Filename)
-	if err := os.MkdirAll(filepath.Join("/data", args.ClientID), 0755); err != nil {
-		lc.log.Errorf("Failed to create file: %s, error: %s", destPath, err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
-		return
-	}
-	destFile, err := os.Create(destPath)
+	log, err := lc.logService.UploadLog(c.Request.Context(), &args, file)
 	if err != nil {
-		lc.log.Errorf("Failed to create file: %s, error: %s", destPath, err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
-		return
-	}
-	defer destFile.Close()
-	// 将上传的文件内容复制到目标文件
-	if _, err := io.Copy(destFile, file); err != nil {
-		lc.log.Errorf("Failed to save file: %s, error: %s", destPath, err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		lc.handleError(c, err)
 		return
 	}
 
 	// 返回成功响应
 	c.JSON(http.StatusOK, gin.H{
 		"code":    "success",
-		"message": fmt.Sprintf("File uploaded successfully: %s", destPath),
+		"message": fmt.Sprintf("File uploaded successfully: %s/%s", log.ClientID, log.FileName),
+		"data":    log,
 	})
 }
 
 // GetLogs handles GET /logs/{client_id}/{file_name} request
-// @Summary Get logs by client
-// @Description Retrieve logs for a specific client with pagination
+// @Summary Get log file
+// @Description Download the content of the log file of a client
+// @Description Requires the admin token.
 // @Tags Log
-// @Accept json
-// @Produce json
+// @Produce plain
 // @Param client_id path string true "Client ID"
-// @Param page query int false "Page number" default(1)
-// @Param page_size query int false "Number of items per page" default(20)
-// @Success 200 {object} map[string]interface{} "Logs list with pagination"
+// @Param file_name path string true "File name"
+// @Success 200 {file} file "Log file"
 // @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Failure 404 {object} map[string]interface{} "Log not found"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Security ApiKeyAuth
 // @Router /client-manager/api/v1/logs/{client_id}/{file_name} [get]
 func (lc *LogController) GetLogs(c *gin.Context) {
 	clientID := c.Param("client_id")
 	fileName := c.Param("file_name")
 
-	filePath, err := lc.logService.GetLogs(c.Request.Context(), clientID, fileName)
+	r, err := lc.logService.GetLogs(c.Request.Context(), clientID, fileName)
+	if err != nil {
+		lc.handleError(c, err)
+		return
+	}
+	defer r.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		lc.log.WithError(err).Error("Failed to send log file")
+	}
+}
+
+// GetLogURL handles GET /logs/{client_id}/{file_name}/url request
+// @Summary Get log download URL
+// @Description Get a signed URL downloading the stored (gzipped) log file of a client
+// @Description Requires the admin token.
+// @Tags Log
+// @Produce json
+// @Param client_id path string true "Client ID"
+// @Param file_name path string true "File name"
+// @Param expires query int false "Validity of the URL in seconds (max 604800)" default(3600)
+// @Success 200 {object} map[string]interface{} "Signed URL"
+// @Failure 400 {object} map[string]interface{} "Invalid parameters"
+// @Failure 404 {object} map[string]interface{} "Log not found"
+// @Security ApiKeyAuth
+// @Router /client-manager/api/v1/logs/{client_id}/{file_name}/url [get]
+func (lc *LogController) GetLogURL(c *gin.Context) {
+	expires, err := strconv.Atoi(c.DefaultQuery("expires", "3600"))
+	if err != nil || expires < 1 || expires > 7*24*3600 {
+		lc.handleError(c, &services.ValidationError{Field: "expires", Message: "expires must be between 1 and 604800 seconds"})
+		return
+	}
+	validity := time.Duration(expires) * time.Second
+	u, err := lc.logService.GetLogURL(c.Request.Context(), c.Param("client_id"), c.Param("file_name"), validity)
+	if err != nil {
+		lc.handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    "success",
+		"message": "Log URL signed successfully",
+		"data": gin.H{
+			"url":        u,
+			"expires_at": time.Now().Add(validity).Format(time.RFC3339),
+		},
+	})
+}
+
+// DownloadLog handles GET /logs/download request
+// @Summary Download log file
+// @Description Download a stored log file from a signed URL of the local storage
+// @Tags Log
+// @Produce application/gzip
+// @Param key query string true "Storage key"
+// @Param name query string true "Download name"
+// @Param expires query int true "Expiration time (unix)"
+// @Param signature query string true "Signature"
+// @Success 200 {file} file "Stored log file"
+// @Failure 403 {object} map[string]interface{} "Invalid or expired URL"
+// @Failure 404 {object} map[string]interface{} "File not found"
+// @Router /client-manager/api/v1/logs/download [get]
+func (lc *LogController) DownloadLog(c *gin.Context) {
+	r, fileName, err := lc.logService.OpenSignedURL(c.Request.Context(), c.Request.URL.Query())
 	if err != nil {
 		lc.handleError(c, err)
 		return
 	}
+	defer r.Close()
 
-	c.File(filePath)
+	if strings.HasSuffix(fileName, ".gz") {
+		c.Header("Content-Type", "application/gzip")
+	} else {
+		c.Header("Content-Type", "application/octet-stream")
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		lc.log.WithError(err).Error("Failed to send log file")
+	}
 }
 
 // ListLogs handles GET /logs request
 // @Summary Get log statistics
 // @Description Retrieve log statistics for a given time period
+// @Description Requires the admin token.
 // @Tags Log
 // @Accept json
 // @Produce json
@@ -191,6 +265,7 @@ func (lc *LogController) GetLogs(c *gin.Context) {
 // @Success 200 {object} map[string]interface{} "Log statistics"
 // @Failure 400 {object} map[string]interface{} "Invalid parameters"
 // @Failure 500 {object} map[string]interface{} "Internal server error"
+// @Security ApiKeyAuth
 // @Router /client-manager/api/v1/logs [get]
 func (lc *LogController) ListLogs(c *gin.Context) {
 	// Get query parameters
@@ -249,6 +324,22 @@ func (lc *LogController) handleError(c *gin.Context, err error) {
 			"code":    "notfound.error",
 			"message": e.Message,
 		})
+	case *services.ForbiddenError:
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    "forbidden.error",
+			"message": e.Message,
+		})
+	case *services.TooLargeError:
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"code":    "toolarge.error",
+			"message": e.Message,
+		})
+	case *services.UnsupportedMediaTypeError:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"code":         "mediatype.error",
+			"message":      e.Message,
+			"content_type": e.ContentType,
+		})
 	default:
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    "internal.error",
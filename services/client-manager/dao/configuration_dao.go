@@ -0,0 +1,283 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+// Configuration history actions
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionRollback = "rollback"
+)
+
+/**
+ * ConfigurationDAO handles data access operations for configuration data
+ * @description
+ * - Provides CRUD operations for configuration items using GORM
+ * - A configuration item is identified by namespace, key, scope and scope ID
+ * - Each change is recorded with a new version in the configuration history
+ * - Changes lock the item, the unique indexes reject concurrent versions
+ *   where the database has no row locks (SQLite serializes the writes)
+ */
+type ConfigurationDAO struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+/**
+ * NewConfigurationDAO creates a new ConfigurationDAO instance
+ * @param {*gorm.DB} db - Database connection
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*ConfigurationDAO} New ConfigurationDAO instance
+ */
+func NewConfigurationDAO(db *gorm.DB, log *logrus.Logger) *ConfigurationDAO {
+	return &ConfigurationDAO{
+		db:  db,
+		log: log,
+	}
+}
+
+func whereItem(db *gorm.DB, namespace, key, scope, scopeID string) *gorm.DB {
+	return db.Where("namespace = ? AND key = ? AND scope = ? AND scope_id = ?", namespace, key, scope, scopeID)
+}
+
+/**
+ * Get retrieves a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {string} scope - Configuration scope
+ * @param {string} scopeID - Scope identifier, empty for the global scope
+ * @returns {*models.Configuration, error} Configuration item, gorm.ErrRecordNotFound if none
+ */
+func (dao *ConfigurationDAO) Get(ctx context.Context, namespace, key, scope, scopeID string) (*models.Configuration, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var cfg models.Configuration
+	if err := whereItem(dao.db.WithContext(ctx), namespace, key, scope, scopeID).First(&cfg).Error; err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+/**
+ * List retrieves configuration items with filtering
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Namespace filter (optional)
+ * @param {string} scope - Scope filter (optional)
+ * @param {string} scopeID - Scope identifier filter (optional)
+ * @returns {[]models.Configuration, error} Configuration items ordered by namespace and key
+ */
+func (dao *ConfigurationDAO) List(ctx context.Context, namespace, scope, scopeID string) ([]models.Configuration, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	query := dao.db.WithContext(ctx).Model(&models.Configuration{})
+	if namespace != "" {
+		query = query.Where("namespace = ?", namespace)
+	}
+	if scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	if scopeID != "" {
+		query = query.Where("scope_id = ?", scopeID)
+	}
+	var cfgs []models.Configuration
+	if err := query.Order("namespace, key, scope, scope_id").Find(&cfgs).Error; err != nil {
+		dao.log.WithError(err).Error("Failed to list configurations")
+		return nil, err
+	}
+	return cfgs, nil
+}
+
+/**
+ * ListForClient retrieves the configuration items of a namespace applying to a client
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} org - Org/department of the client (optional)
+ * @param {string} userID - User of the client (optional)
+ * @param {string} clientID - Client identifier (optional)
+ * @returns {[]models.Configuration, error} Global items and the items of the given scopes
+ */
+func (dao *ConfigurationDAO) ListForClient(ctx context.Context, namespace, org, userID, clientID string) ([]models.Configuration, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	cond := dao.db.Where("scope = ?", models.ScopeGlobal)
+	for scope, id := range map[string]string{models.ScopeOrg: org, models.ScopeUser: userID, models.ScopeClient: clientID} {
+		if id != "" {
+			cond = cond.Or("scope = ? AND scope_id = ?", scope, id)
+		}
+	}
+	var cfgs []models.Configuration
+	err := dao.db.WithContext(ctx).Where("namespace = ?", namespace).Where(cond).Find(&cfgs).Error
+	if err != nil {
+		dao.log.WithError(err).WithField("namespace", namespace).Error("Failed to list client configurations")
+		return nil, err
+	}
+	return cfgs, nil
+}
+
+// nextVersion returns the version following the last recorded version of an item
+func nextVersion(tx *gorm.DB, namespace, key, scope, scopeID string) (int64, error) {
+	var last int64
+	err := whereItem(tx.Model(&models.ConfigurationHistory{}), namespace, key, scope, scopeID).
+		Select("COALESCE(MAX(version), 0)").Scan(&last).Error
+	return last + 1, err
+}
+
+func recordHistory(tx *gorm.DB, cfg *models.Configuration, action string) error {
+	return tx.Create(&models.ConfigurationHistory{
+		Namespace:   cfg.Namespace,
+		Key:         cfg.Key,
+		Scope:       cfg.Scope,
+		ScopeID:     cfg.ScopeID,
+		Version:     cfg.Version,
+		Action:      action,
+		Value:       cfg.Value,
+		Description: cfg.Description,
+		Operator:    cfg.UpdatedBy,
+	}).Error
+}
+
+/**
+ * Save creates or updates a configuration item, as a new version
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*models.Configuration} cfg - Configuration item, updated with its ID and version
+ * @param {string} action - History action, ActionRollback for rollbacks, else create/update is detected
+ * @returns {error} Error if any
+ * @description
+ * - The item and its history record are written in one transaction
+ */
+func (dao *ConfigurationDAO) Save(ctx context.Context, cfg *models.Configuration, action string) error {
+	if dao.db == nil {
+		return fmt.Errorf("Database is not initialized")
+	}
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.Configuration
+		err := whereItem(tx.Clauses(clause.Locking{Strength: "UPDATE"}), cfg.Namespace, cfg.Key, cfg.Scope, cfg.ScopeID).First(&existing).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			cfg.ID = 0
+			if action == "" {
+				action = ActionCreate
+			}
+		case err != nil:
+			return err
+		default:
+			cfg.ID = existing.ID
+			cfg.CreatedAt = existing.CreatedAt
+			if action == "" {
+				action = ActionUpdate
+			}
+		}
+		if cfg.Version, err = nextVersion(tx, cfg.Namespace, cfg.Key, cfg.Scope, cfg.ScopeID); err != nil {
+			return err
+		}
+		if err := tx.Save(cfg).Error; err != nil {
+			return err
+		}
+		return recordHistory(tx, cfg, action)
+	})
+	if err != nil {
+		dao.log.WithError(err).WithFields(logrus.Fields{
+			"namespace": cfg.Namespace,
+			"key":       cfg.Key,
+			"scope":     cfg.Scope,
+			"scope_id":  cfg.ScopeID,
+		}).Error("Failed to save configuration")
+	}
+	return err
+}
+
+/**
+ * Delete deletes a configuration item, as a new version
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {string} scope - Configuration scope
+ * @param {string} scopeID - Scope identifier
+ * @param {string} operator - User deleting the item
+ * @returns {*models.Configuration, error} Deleted item, gorm.ErrRecordNotFound if none
+ * @description
+ * - The item is removed, it's restored by rolling back to a previous version
+ */
+func (dao *ConfigurationDAO) Delete(ctx context.Context, namespace, key, scope, scopeID, operator string) (*models.Configuration, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var cfg models.Configuration
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := whereItem(tx.Clauses(clause.Locking{Strength: "UPDATE"}), namespace, key, scope, scopeID).First(&cfg).Error; err != nil {
+			return err
+		}
+		var err error
+		if cfg.Version, err = nextVersion(tx, namespace, key, scope, scopeID); err != nil {
+			return err
+		}
+		cfg.UpdatedBy = operator
+		//the versions keep the deleted item, a new item may reuse its scope
+		if err := tx.Unscoped().Delete(&cfg).Error; err != nil {
+			return err
+		}
+		return recordHistory(tx, &cfg, ActionDelete)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+/**
+ * History retrieves the versions of a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {string} scope - Configuration scope
+ * @param {string} scopeID - Scope identifier
+ * @returns {[]models.ConfigurationHistory, error} Versions, the latest first
+ */
+func (dao *ConfigurationDAO) History(ctx context.Context, namespace, key, scope, scopeID string) ([]models.ConfigurationHistory, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var versions []models.ConfigurationHistory
+	err := whereItem(dao.db.WithContext(ctx), namespace, key, scope, scopeID).Order("version DESC").Find(&versions).Error
+	if err != nil {
+		dao.log.WithError(err).Error("Failed to list configuration history")
+		return nil, err
+	}
+	return versions, nil
+}
+
+/**
+ * GetVersion retrieves a version of a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {string} scope - Configuration scope
+ * @param {string} scopeID - Scope identifier
+ * @param {int64} version - Version number
+ * @returns {*models.ConfigurationHistory, error} Version, gorm.ErrRecordNotFound if none
+ */
+func (dao *ConfigurationDAO) GetVersion(ctx context.Context, namespace, key, scope, scopeID string, version int64) (*models.ConfigurationHistory, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var h models.ConfigurationHistory
+	err := whereItem(dao.db.WithContext(ctx), namespace, key, scope, scopeID).Where("version = ?", version).First(&h).Error
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
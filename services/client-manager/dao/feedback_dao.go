@@ -0,0 +1,203 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+/**
+ * FeedbackFilter selects feedback records, empty fields don't filter
+ */
+type FeedbackFilter struct {
+	Type           string
+	UserID         string
+	ClientID       string
+	ClientVersion  string
+	ConversationID string
+	RequestID      string
+	CompletionID   string
+	Start          time.Time
+	End            time.Time
+}
+
+/**
+ * FeedbackStat is the aggregate of a group of feedback records
+ * @description
+ * - Type, Day and ClientVersion are set when the records are grouped by them
+ * - AvgScore is the average score of the records having a score
+ */
+type FeedbackStat struct {
+	Type          string  `json:"type,omitempty"`
+	Day           string  `json:"day,omitempty"`
+	ClientVersion string  `json:"client_version,omitempty"`
+	Count         int64   `json:"count"`
+	AvgScore      float64 `json:"avg_score"`
+}
+
+// feedbackGroups are the columns feedback records can be grouped by
+var feedbackGroups = map[string]string{
+	"type":           "type",
+	"day":            "date(created_at)",
+	"client_version": "client_version",
+}
+
+/**
+ * FeedbackDAO handles data access operations for feedback data
+ * @description
+ * - Provides create and query operations for feedback using GORM
+ * - Supports filtering, pagination, aggregation and batched export
+ */
+type FeedbackDAO struct {
+	db  *gorm.DB
+	log *logrus.Logger
+}
+
+/**
+ * NewFeedbackDAO creates a new FeedbackDAO instance
+ * @param {*gorm.DB} db - Database connection
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*FeedbackDAO} New FeedbackDAO instance
+ */
+func NewFeedbackDAO(db *gorm.DB, log *logrus.Logger) *FeedbackDAO {
+	return &FeedbackDAO{
+		db:  db,
+		log: log,
+	}
+}
+
+/**
+ * Create creates feedback records
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {[]*models.Feedback} feedbacks - Feedback records, all created or none
+ * @returns {error} Error if any
+ */
+func (dao *FeedbackDAO) Create(ctx context.Context, feedbacks []*models.Feedback) error {
+	if dao.db == nil {
+		return fmt.Errorf("Database is not initialized")
+	}
+	if err := dao.db.WithContext(ctx).Create(feedbacks).Error; err != nil {
+		dao.log.WithError(err).Error("Failed to create feedbacks")
+		return err
+	}
+	return nil
+}
+
+func (dao *FeedbackDAO) query(ctx context.Context, f *FeedbackFilter) *gorm.DB {
+	query := dao.db.WithContext(ctx).Model(&models.Feedback{})
+	for column, value := range map[string]string{
+		"type":            f.Type,
+		"user_id":         f.UserID,
+		"client_id":       f.ClientID,
+		"client_version":  f.ClientVersion,
+		"conversation_id": f.ConversationID,
+		"request_id":      f.RequestID,
+		"completion_id":   f.CompletionID,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !f.Start.IsZero() {
+		query = query.Where("created_at >= ?", f.Start)
+	}
+	if !f.End.IsZero() {
+		query = query.Where("created_at <= ?", f.End)
+	}
+	return query
+}
+
+/**
+ * List retrieves feedback records with filtering and pagination
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*FeedbackFilter} f - Filter of the records
+ * @param {int} page - Page number
+ * @param {int} pageSize - Number of items per page
+ * @returns {[]models.Feedback, int64, error} Records (latest first), total count, and error
+ */
+func (dao *FeedbackDAO) List(ctx context.Context, f *FeedbackFilter, page, pageSize int) ([]models.Feedback, int64, error) {
+	if dao.db == nil {
+		return nil, 0, fmt.Errorf("Database is not initialized")
+	}
+	var total int64
+	if err := dao.query(ctx, f).Count(&total).Error; err != nil {
+		dao.log.WithError(err).Error("Failed to count feedbacks")
+		return nil, 0, err
+	}
+	var feedbacks []models.Feedback
+	err := dao.query(ctx, f).Order("created_at DESC, id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&feedbacks).Error
+	if err != nil {
+		dao.log.WithError(err).Error("Failed to list feedbacks")
+		return nil, 0, err
+	}
+	return feedbacks, total, nil
+}
+
+/**
+ * Stats aggregates feedback records
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*FeedbackFilter} f - Filter of the records
+ * @param {[]string} groupBy - Groups: type, day and/or client_version
+ * @returns {[]FeedbackStat, error} Aggregates, ordered by groups
+ */
+func (dao *FeedbackDAO) Stats(ctx context.Context, f *FeedbackFilter, groupBy []string) ([]FeedbackStat, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	selects := []string{"COUNT(*) AS count", "COALESCE(AVG(NULLIF(score, 0)), 0) AS avg_score"}
+	query := dao.query(ctx, f)
+	for _, g := range groupBy {
+		column, ok := feedbackGroups[g]
+		if !ok {
+			return nil, fmt.Errorf("invalid group %s", g)
+		}
+		selects = append(selects, column+" AS "+g)
+		query = query.Group(column).Order(column)
+	}
+	var stats []FeedbackStat
+	if err := query.Select(selects).Scan(&stats).Error; err != nil {
+		dao.log.WithError(err).Error("Failed to aggregate feedbacks")
+		return nil, err
+	}
+	return stats, nil
+}
+
+/**
+ * Export retrieves all the feedback records of a filter, in batches
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*FeedbackFilter} f - Filter of the records
+ * @param {int} limit - Maximum number of records
+ * @param {func([]models.Feedback) error} fn - Called with each batch, in creation order
+ * @returns {error} Error of the query or of fn
+ */
+func (dao *FeedbackDAO) Export(ctx context.Context, f *FeedbackFilter, limit int, fn func([]models.Feedback) error) error {
+	if dao.db == nil {
+		return fmt.Errorf("Database is not initialized")
+	}
+	var lastID uint
+	for exported := 0; exported < limit; {
+		var batch []models.Feedback
+		size := limit - exported
+		if size > 500 {
+			size = 500
+		}
+		if err := dao.query(ctx, f).Where("id > ?", lastID).Order("id").Limit(size).Find(&batch).Error; err != nil {
+			dao.log.WithError(err).Error("Failed to export feedbacks")
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		exported += len(batch)
+		lastID = batch[len(batch)-1].ID
+	}
+	return nil
+}
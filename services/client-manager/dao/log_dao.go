@@ -153,42 +153,61 @@ func (dao *LogDAO) ListLogs(ctx context.Context, clientID, userID, fileName stri
 }
 
 /**
- * DeleteOldLogs deletes logs older than specified date
+ * GetLog retrieves the log of a client file
  * @param {context.Context} ctx - Context for request cancellation
- * @param {string} beforeDate - Delete logs before this date
- * @returns {int64, error} Number of deleted records and error if any
- * @description
- * - Performs cleanup of old log records
- * - Uses database delete operation for bulk deletion
- * - Returns count of deleted records
- * - Logs deletion operation
- * @throws
- * - Database delete errors
+ * @param {string} clientID - Client identifier
+ * @param {string} fileName - File name
+ * @returns {*models.Log, error} Log, gorm.ErrRecordNotFound if not found
  */
-func (dao *LogDAO) DeleteOldLogs(ctx context.Context, beforeDate string) (int64, error) {
+func (dao *LogDAO) GetLog(ctx context.Context, clientID, fileName string) (*models.Log, error) {
 	if dao.db == nil {
-		return 0, fmt.Errorf("Database is not initialized")
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var log models.Log
+	err := dao.db.WithContext(ctx).Where("client_id = ? AND file_name = ?", clientID, fileName).First(&log).Error
+	if err != nil {
+		return nil, err
 	}
+	return &log, nil
+}
 
-	// Parse the before date
-	parsedDate, err := time.Parse("2006-01-02", beforeDate)
+/**
+ * ListLogsBefore retrieves the logs last updated before a time
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {time.Time} before - Update time limit, excluded
+ * @param {int} limit - Maximum number of logs
+ * @returns {[]models.Log, error} Logs, the oldest first
+ */
+func (dao *LogDAO) ListLogsBefore(ctx context.Context, before time.Time, limit int) ([]models.Log, error) {
+	if dao.db == nil {
+		return nil, fmt.Errorf("Database is not initialized")
+	}
+	var logs []models.Log
+	err := dao.db.WithContext(ctx).Where("updated_at < ?", before).Order("updated_at").Limit(limit).Find(&logs).Error
 	if err != nil {
-		return 0, fmt.Errorf("invalid date format: %w", err)
+		dao.log.WithError(err).Error("Failed to list old logs")
+		return nil, err
 	}
+	return logs, nil
+}
 
-	// Execute delete operation and get count
-	result := dao.db.Where("updated_at < ?", parsedDate).Delete(&models.Log{})
+/**
+ * DeleteLogBefore deletes a log unless it was updated since a time
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {uint} id - Log ID
+ * @param {time.Time} before - Update time limit, excluded
+ * @returns {bool, error} Whether the log was deleted
+ * @description
+ * - A log uploaded again meanwhile is kept, with its new file
+ */
+func (dao *LogDAO) DeleteLogBefore(ctx context.Context, id uint, before time.Time) (bool, error) {
+	if dao.db == nil {
+		return false, fmt.Errorf("Database is not initialized")
+	}
+	result := dao.db.WithContext(ctx).Where("id = ? AND updated_at < ?", id, before).Delete(&models.Log{})
 	if result.Error != nil {
-		dao.log.WithError(result.Error).Error("Failed to delete old logs")
-		return 0, result.Error
+		dao.log.WithError(result.Error).Error("Failed to delete log")
+		return false, result.Error
 	}
-
-	deletedCount := result.RowsAffected
-
-	dao.log.WithFields(logrus.Fields{
-		"before_date":   beforeDate,
-		"deleted_count": deletedCount,
-	}).Info("Successfully deleted old logs")
-
-	return deletedCount, nil
+	return result.RowsAffected > 0, nil
 }
@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/zgsm-ai/client-manager/storage"
 )
 
 // Config holds the application configuration
@@ -46,6 +50,17 @@ func LoadConfig(configPath string) error {
 	viper.SetDefault("server.listen", ":8080")
 	viper.SetDefault("database.dsn", "./data/client-manager.db")
 	viper.SetDefault("log.level", "info")
+	viper.SetDefault("storage.type", "local")
+	viper.SetDefault("storage.local.path", "/data")
+	viper.SetDefault("storage.local.url_prefix", "/client-manager/api/v1/logs/download")
+	viper.SetDefault("storage.s3.region", "us-east-1")
+	viper.SetDefault("logs.max_size", 50<<20)
+	viper.SetDefault("logs.allowed_types", []string{"text/plain", "application/x-gzip"})
+	viper.SetDefault("logs.retention_days", 30)
+	viper.SetDefault("logs.retention_interval", "24h")
+	viper.SetDefault("auth.claims.user", "id")
+	viper.SetDefault("auth.claims.org", "owner")
+	viper.SetDefault("auth.claims.client_id", "client_id")
 
 	// Enable environment variable override
 	viper.AutomaticEnv()
@@ -76,3 +91,50 @@ func GetListenAddr() string {
 	}
 	return port
 }
+
+// GetAdminToken returns the bearer token of the admin APIs, they are disabled when empty
+func GetAdminToken() string {
+	return viper.GetString("admin.token")
+}
+
+// GetStorageConfig returns the configuration of the storage of the uploaded files
+func GetStorageConfig() *storage.Config {
+	return &storage.Config{
+		Type:      viper.GetString("storage.type"),
+		Path:      viper.GetString("storage.local.path"),
+		URLPrefix: viper.GetString("storage.local.url_prefix"),
+		SignKey:   viper.GetString("storage.local.sign_key"),
+		S3: storage.S3Config{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Region:    viper.GetString("storage.s3.region"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			AccessKey: viper.GetString("storage.s3.access_key"),
+			SecretKey: viper.GetString("storage.s3.secret_key"),
+			PathStyle: viper.GetBool("storage.s3.path_style"),
+		},
+	}
+}
+
+// GetLogMaxSize returns the maximum size of an uploaded log file, in bytes
+func GetLogMaxSize() int64 {
+	return viper.GetInt64("logs.max_size")
+}
+
+// GetLogAllowedTypes returns the content types accepted for the uploaded log files
+func GetLogAllowedTypes() []string {
+	return viper.GetStringSlice("logs.allowed_types")
+}
+
+// GetLogRetention returns how long the log files are kept after their last upload, 0 to keep them
+func GetLogRetention() time.Duration {
+	return time.Duration(viper.GetInt("logs.retention_days")) * 24 * time.Hour
+}
+
+// GetLogRetentionInterval returns the interval of the deletion of the expired log files
+func GetLogRetentionInterval() time.Duration {
+	d := viper.GetDuration("logs.retention_interval")
+	if d <= 0 {
+		d = 24 * time.Hour
+	}
+	return d
+}
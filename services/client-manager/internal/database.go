@@ -84,13 +84,59 @@ func InitDB() (*gorm.DB, error) {
  * - Migration errors
  */
 func autoMigrate(db *gorm.DB) error {
+	if err := migrateConfigurations(db); err != nil {
+		return err
+	}
 	return db.AutoMigrate(
 		&models.Configuration{},
+		&models.ConfigurationHistory{},
 		&models.Feedback{},
 		&models.Log{},
 	)
 }
 
+/**
+ * migrateConfigurations prepares the configuration tables for their unique indexes
+ * @param {gorm.DB} db - Database connection
+ * @returns {error} Error if migration fails
+ * @description
+ * - Backfills the NULL scope IDs of the items and versions with ''
+ * - Removes the soft-deleted items, deleted items are now removed, their versions are kept
+ * - Keeps the last duplicated item and the first duplicated version
+ * - Drops the non-unique index of the versions, replaced by idx_config_version
+ */
+func migrateConfigurations(db *gorm.DB) error {
+	m := db.Migrator()
+	var stmts []string
+	if m.HasTable(&models.Configuration{}) {
+		stmts = append(stmts, "DELETE FROM configurations WHERE deleted_at IS NOT NULL")
+		if m.HasColumn(&models.Configuration{}, "scope_id") {
+			stmts = append(stmts,
+				"UPDATE configurations SET scope_id = '' WHERE scope_id IS NULL",
+				"DELETE FROM configurations WHERE id NOT IN (SELECT MAX(id) FROM configurations GROUP BY namespace, key, scope, scope_id)")
+		} else {
+			stmts = append(stmts,
+				"DELETE FROM configurations WHERE id NOT IN (SELECT MAX(id) FROM configurations GROUP BY namespace, key)")
+		}
+	}
+	if m.HasTable(&models.ConfigurationHistory{}) {
+		if m.HasIndex(&models.ConfigurationHistory{}, "idx_config_history") {
+			if err := m.DropIndex(&models.ConfigurationHistory{}, "idx_config_history"); err != nil {
+				return err
+			}
+		}
+		stmts = append(stmts,
+			"UPDATE configuration_histories SET scope_id = '' WHERE scope_id IS NULL",
+			"DELETE FROM configuration_histories WHERE id NOT IN (SELECT MIN(id) FROM configuration_histories GROUP BY namespace, key, scope, scope_id, version)")
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 /**
  * GetDB returns the global database instance
  * @returns {gorm.DB} Database connection
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+func TestMigrateConfigurations(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string
+		items int
+	}{
+		{
+			name: "without scopes",
+			setup: []string{
+				"CREATE TABLE `configurations` (`id` integer PRIMARY KEY,`namespace` text NOT NULL,`key` text NOT NULL,`value` text,`description` text,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime)",
+				"INSERT INTO configurations (id, namespace, key, value) VALUES (1, 'ide', 'theme', 'a'), (2, 'ide', 'theme', 'b'), (3, 'ide', 'font', 'c')",
+				"INSERT INTO configurations (id, namespace, key, value, deleted_at) VALUES (4, 'ide', 'model', 'd', '2026-01-01')",
+			},
+			items: 2,
+		},
+		{
+			name: "with NULL scope IDs",
+			setup: []string{
+				"CREATE TABLE `configurations` (`id` integer PRIMARY KEY,`namespace` text NOT NULL,`key` text NOT NULL,`scope` text NOT NULL DEFAULT 'global',`scope_id` text,`value` text,`description` text,`version` integer,`updated_by` text,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime)",
+				"INSERT INTO configurations (id, namespace, key, scope_id, value) VALUES (1, 'ide', 'theme', NULL, 'a'), (2, 'ide', 'theme', '', 'b'), (3, 'ide', 'font', NULL, 'c')",
+				"INSERT INTO configurations (id, namespace, key, scope, scope_id, value) VALUES (4, 'ide', 'theme', 'user', 'u1', 'e')",
+				"INSERT INTO configurations (id, namespace, key, value, deleted_at) VALUES (5, 'ide', 'model', 'd', '2026-01-01')",
+				"CREATE TABLE `configuration_histories` (`id` integer PRIMARY KEY,`namespace` text NOT NULL,`key` text NOT NULL,`scope` text NOT NULL,`scope_id` text,`version` integer,`action` text,`value` text,`description` text,`operator` text,`created_at` datetime)",
+				"CREATE INDEX idx_config_history ON configuration_histories (namespace, key, scope, scope_id, version)",
+				"INSERT INTO configuration_histories (id, namespace, key, scope, scope_id, version, action) VALUES (1, 'ide', 'theme', 'global', NULL, 1, 'create'), (2, 'ide', 'theme', 'global', '', 1, 'update')",
+			},
+			items: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stmt := range tt.setup {
+				if err := db.Exec(stmt).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := autoMigrate(db); err != nil {
+				t.Fatal(err)
+			}
+			var cfgs []models.Configuration
+			if err := db.Unscoped().Order("id").Find(&cfgs).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(cfgs) != tt.items {
+				t.Fatalf("expected %d items, got %+v", tt.items, cfgs)
+			}
+			if cfgs[0].ID != 2 || cfgs[0].Value != "b" {
+				t.Errorf("expected the last duplicated item to be kept, got %+v", cfgs[0])
+			}
+			var nulls int64
+			db.Model(&models.Configuration{}).Where("scope_id IS NULL").Count(&nulls)
+			if nulls != 0 {
+				t.Errorf("%d NULL scope IDs left", nulls)
+			}
+			dup := &models.Configuration{Namespace: "ide", Key: "theme", Scope: models.ScopeGlobal}
+			if err := db.Create(dup).Error; err == nil {
+				t.Error("expected the unique index to reject a duplicated item")
+			}
+			var versions int64
+			db.Model(&models.ConfigurationHistory{}).Count(&versions)
+			if versions > 1 {
+				t.Errorf("expected duplicated versions to be removed, got %d", versions)
+			}
+			db.Create(&models.ConfigurationHistory{Namespace: "ide", Key: "theme", Scope: models.ScopeGlobal, Version: 1})
+			dupVersion := &models.ConfigurationHistory{Namespace: "ide", Key: "theme", Scope: models.ScopeGlobal, Version: 1}
+			if err := db.Create(dupVersion).Error; err == nil {
+				t.Error("expected the unique index to reject a duplicated version")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+)
+
+/**
+ * Identity is the verified identity of a client
+ * @description
+ * - Read from the claims of its bearer token, named by the auth.claims settings
+ * - Org and ClientID are empty when the token doesn't carry them
+ */
+type Identity struct {
+	UserID   string
+	Org      string
+	ClientID string
+}
+
+// publicKey caches the key of auth.jwt_public_key, loaded again when the path changes
+var publicKey struct {
+	sync.Mutex
+	path string
+	key  interface{}
+}
+
+func loadPublicKey(path string) (interface{}, error) {
+	publicKey.Lock()
+	defer publicKey.Unlock()
+	if publicKey.path == path && publicKey.key != nil {
+		return publicKey.key, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	var key interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	} else if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+	publicKey.path, publicKey.key = path, key
+	return key, nil
+}
+
+/**
+ * VerifyToken verifies a client JWT and returns its identity
+ * @param {string} token - The JWT, without the "Bearer " prefix
+ * @returns {*Identity, error} Identity of the client, error if the token is invalid
+ * @description
+ * - Tokens signed with HMAC are verified with auth.jwt_secret
+ * - Tokens signed with RSA/ECDSA are verified with auth.jwt_public_key, a PEM public key or certificate
+ * - Fails when neither is set, and when the token is expired or has no user
+ */
+func VerifyToken(token string) (*Identity, error) {
+	secret := viper.GetString("auth.jwt_secret")
+	keyPath := viper.GetString("auth.jwt_public_key")
+	if secret == "" && keyPath == "" {
+		return nil, errors.New("token verification is not configured")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if secret != "" {
+				return []byte(secret), nil
+			}
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			if keyPath != "" {
+				return loadPublicKey(keyPath)
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{
+		UserID:   claimString(claims, viper.GetString("auth.claims.user")),
+		Org:      claimString(claims, viper.GetString("auth.claims.org")),
+		ClientID: claimString(claims, viper.GetString("auth.claims.client_id")),
+	}
+	if id.UserID == "" {
+		return nil, errors.New("token has no user")
+	}
+	return id, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
+
+// GetIdentity returns the identity verified by IdentityMiddleware, nil if none
+func GetIdentity(c *gin.Context) *Identity {
+	if v, ok := c.Get("identity"); ok {
+		return v.(*Identity)
+	}
+	return nil
+}
+
+// GetOperator returns the admin set by AdminMiddleware
+func GetOperator(c *gin.Context) string {
+	return c.GetString("operator")
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
 	"time"
@@ -26,8 +27,9 @@ func CORSMiddleware() gin.HandlerFunc {
 		// Allow all origins for development
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-None-Match")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Header("Access-Control-Expose-Headers", "ETag")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -321,6 +323,75 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// OperatorHeader names the admin making a change, recorded in the configuration history
+const OperatorHeader = "X-Operator"
+
+/**
+ * AdminMiddleware restricts the admin APIs
+ * @description
+ * - Requires the bearer token to equal the admin.token setting
+ * - Returns 403 if the token doesn't match, or for all the requests when admin.token is not set
+ * - The operator is read from the X-Operator header, "admin" if missing
+ * @returns {gin.HandlerFunc} Gin middleware function
+ */
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminToken := GetAdminToken()
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    "auth.admin_disabled",
+				"message": "Admin APIs are disabled, admin.token is not set",
+			})
+			return
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    "auth.forbidden",
+				"message": "Admin token is required",
+			})
+			return
+		}
+		operator := c.GetHeader(OperatorHeader)
+		if operator == "" {
+			operator = "admin"
+		}
+		c.Set("operator", operator)
+		c.Next()
+	}
+}
+
+/**
+ * IdentityMiddleware verifies the bearer token of the client APIs
+ * @description
+ * - The token must be a JWT verified by the auth settings, see VerifyToken
+ * - Adds the verified identity to context, read it with GetIdentity
+ * - Returns 401 if the token is missing or invalid
+ * @returns {gin.HandlerFunc} Gin middleware function
+ */
+func IdentityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    "auth.missing",
+				"message": "Authorization header is required",
+			})
+			return
+		}
+		id, err := VerifyToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    "auth.invalid_token",
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Set("identity", id)
+		c.Next()
+	}
+}
+
 /**
  * RecoveryMiddleware recovers from panics
  * @description
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+)
+
+func setConfig(t *testing.T, values map[string]interface{}) {
+	for k, v := range values {
+		viper.Set(k, v)
+	}
+	t.Cleanup(viper.Reset)
+}
+
+func serve(handler gin.HandlerFunc, header http.Header) (*httptest.ResponseRecorder, *gin.Context) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header = header
+	handler(c)
+	if !c.IsAborted() {
+		c.Status(http.StatusOK)
+	}
+	return w, c
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		header   http.Header
+		status   int
+		operator string
+	}{
+		{"not configured", "", http.Header{"Authorization": {"Bearer "}}, http.StatusForbidden, ""},
+		{"missing token", "s3cret", http.Header{}, http.StatusForbidden, ""},
+		{"wrong token", "s3cret", http.Header{"Authorization": {"Bearer other"}}, http.StatusForbidden, ""},
+		{"default operator", "s3cret", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusOK, "admin"},
+		{"operator", "s3cret", http.Header{"Authorization": {"Bearer s3cret"}, OperatorHeader: {"alice"}}, http.StatusOK, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, map[string]interface{}{"admin.token": tt.token})
+			w, c := serve(AdminMiddleware(), tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if op := GetOperator(c); op != tt.operator {
+				t.Errorf("operator = %q, want %q", op, tt.operator)
+			}
+		})
+	}
+}
+
+func TestIdentityMiddleware(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"id": "u1", "owner": "dev", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	hmacToken := func(c jwt.MapClaims, secret string) string {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+		return s
+	}
+	rsaToken, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims(jwt.MapClaims{"client_id": "c1"})).SignedString(rsaKey)
+	noneToken, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		token  string
+		status int
+		want   Identity
+	}{
+		{"not configured", nil, hmacToken(claims(nil), "k"), http.StatusUnauthorized, Identity{}},
+		{"missing token", map[string]interface{}{"auth.jwt_secret": "k"}, "", http.StatusUnauthorized, Identity{}},
+		{"hmac", map[string]interface{}{"auth.jwt_secret": "k"}, hmacToken(claims(nil), "k"), http.StatusOK, Identity{UserID: "u1", Org: "dev"}},
+		{"wrong secret", map[string]interface{}{"auth.jwt_secret": "k"}, hmacToken(claims(nil), "other"), http.StatusUnauthorized, Identity{}},
+		{"expired", map[string]interface{}{"auth.jwt_secret": "k"}, hmacToken(claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), "k"), http.StatusUnauthorized, Identity{}},
+		{"no expiry", map[string]interface{}{"auth.jwt_secret": "k"}, hmacToken(jwt.MapClaims{"id": "u1"}, "k"), http.StatusUnauthorized, Identity{}},
+		{"no user", map[string]interface{}{"auth.jwt_secret": "k"}, hmacToken(claims(jwt.MapClaims{"id": ""}), "k"), http.StatusUnauthorized, Identity{}},
+		{"rsa", map[string]interface{}{"auth.jwt_public_key": keyPath}, rsaToken, http.StatusOK, Identity{UserID: "u1", Org: "dev", ClientID: "c1"}},
+		{"rsa without key", map[string]interface{}{"auth.jwt_secret": "k"}, rsaToken, http.StatusUnauthorized, Identity{}},
+		{"unsigned", map[string]interface{}{"auth.jwt_secret": "k"}, noneToken, http.StatusUnauthorized, Identity{}},
+		{"claim names", map[string]interface{}{"auth.jwt_secret": "k", "auth.claims.org": "dept", "auth.claims.client_id": "machine"},
+			hmacToken(claims(jwt.MapClaims{"dept": "ops", "machine": "m1"}), "k"), http.StatusOK, Identity{UserID: "u1", Org: "ops", ClientID: "m1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := map[string]interface{}{"auth.claims.user": "id", "auth.claims.org": "owner", "auth.claims.client_id": "client_id"}
+			for k, v := range tt.config {
+				config[k] = v
+			}
+			setConfig(t, config)
+			header := http.Header{}
+			if tt.token != "" {
+				header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w, c := serve(IdentityMiddleware(), header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if id := GetIdentity(c); tt.status == http.StatusOK && (id == nil || *id != tt.want) {
+				t.Errorf("identity = %+v, want %+v", id, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -65,15 +66,20 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Delete the expired log files in background
+		go app.LogService.RunRetention(context.Background(), internal.GetLogRetention(), internal.GetLogRetentionInterval())
+
 		// Initialize controllers
 		logController := controllers.NewLogController(app.Logger)
 		logController.SetLogService(app.LogService)
+		configController := controllers.NewConfigurationController(app.ConfigurationService, app.Logger)
+		feedbackController := controllers.NewFeedbackController(app.FeedbackService, app.Logger)
 
 		// Create Gin engine
 		r := gin.Default()
 
 		// Setup all routes
-		router.SetupRoutes(r, logController, app.Logger)
+		router.SetupRoutes(r, configController, feedbackController, logController, app.Logger)
 
 		// Start server
 		if err := services.StartServer(r, app.Logger); err != nil {
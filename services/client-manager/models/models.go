@@ -6,39 +6,90 @@ import (
 	"gorm.io/gorm"
 )
 
+// Configuration scopes, from the lowest to the highest priority
+const (
+	ScopeGlobal = "global"
+	ScopeOrg    = "org"
+	ScopeUser   = "user"
+	ScopeClient = "client"
+)
+
 /**
  * Configuration model represents a configuration item in the system
  * @description
  * - Stores configuration data with namespace and key
- * - Supports caching for performance optimization
- * - Includes metadata for configuration management
+ * - Scope and ScopeID select who the value applies to: everyone (global),
+ *   an org/department, a user or a client ID
+ * - Version is bumped on each change, the changes are kept in ConfigurationHistory
+ * - Namespace, key, scope and scope ID are unique, deleted items are removed
  */
 type Configuration struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
-	Namespace   string         `json:"namespace" gorm:"index;not null"`
-	Key         string         `json:"key" gorm:"index;not null"`
+	Namespace   string         `json:"namespace" gorm:"index;uniqueIndex:idx_config_item;not null"`
+	Key         string         `json:"key" gorm:"index;uniqueIndex:idx_config_item;not null"`
+	Scope       string         `json:"scope" gorm:"index;uniqueIndex:idx_config_item;not null;default:global"`
+	ScopeID     string         `json:"scope_id" gorm:"index;uniqueIndex:idx_config_item;not null;default:''"`
 	Value       string         `json:"value" gorm:"type:text"`
 	Description string         `json:"description" gorm:"type:text"`
+	Version     int64          `json:"version"`
+	UpdatedBy   string         `json:"updated_by"`
 	CreatedAt   time.Time      `json:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt   time.Time      `json:"updated_at" gorm:"autoUpdateTime"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
+/**
+ * ConfigurationHistory model represents a version of a configuration item
+ * @description
+ * - One record per change (create, update, delete, rollback) of a configuration
+ * - Value and Description are the state after the change
+ * - Used to list the versions and to roll back to one of them
+ * - A version is unique for an item
+ */
+type ConfigurationHistory struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Namespace   string    `json:"namespace" gorm:"uniqueIndex:idx_config_version;not null"`
+	Key         string    `json:"key" gorm:"uniqueIndex:idx_config_version;not null"`
+	Scope       string    `json:"scope" gorm:"uniqueIndex:idx_config_version;not null"`
+	ScopeID     string    `json:"scope_id" gorm:"uniqueIndex:idx_config_version;not null;default:''"`
+	Version     int64     `json:"version" gorm:"uniqueIndex:idx_config_version"`
+	Action      string    `json:"action"`
+	Value       string    `json:"value" gorm:"type:text"`
+	Description string    `json:"description" gorm:"type:text"`
+	Operator    string    `json:"operator"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// Feedback types
+const (
+	FeedbackThumbsUp   = "thumbs_up"
+	FeedbackThumbsDown = "thumbs_down"
+	FeedbackCopy       = "copy"
+	FeedbackAccept     = "accept"
+	FeedbackEvaluate   = "evaluate"
+)
+
 /**
  * Feedback model represents user feedback data
  * @description
  * - Stores various types of user feedback
  * - Includes conversation tracking
- * - Supports different feedback types (completion, copy, evaluate, etc.)
+ * - Supports different feedback types (thumbs up/down, copy, accept, evaluate)
+ * - Tied to a chat-rag request (RequestID) or a code completion (CompletionID)
  */
 type Feedback struct {
 	ID             uint      `json:"id" gorm:"primaryKey"`
 	Type           string    `json:"type" gorm:"index;not null"`
 	ConversationID string    `json:"conversation_id" gorm:"index"`
+	RequestID      string    `json:"request_id" gorm:"index"`
+	CompletionID   string    `json:"completion_id" gorm:"index"`
 	UserID         string    `json:"user_id" gorm:"index"`
+	ClientID       string    `json:"client_id" gorm:"index"`
+	ClientVersion  string    `json:"client_version" gorm:"index"`
+	Score          int       `json:"score"`
 	Content        string    `json:"content" gorm:"type:text"`
 	Metadata       string    `json:"metadata" gorm:"type:text"`
-	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime;index"`
 	UpdatedAt      time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
@@ -48,6 +99,7 @@ type Feedback struct {
  * - Stores log data from clients
  * - Includes client and user identification
  * - Supports structured logging with module information
+ * - The file is stored compressed as StorageKey, files uploaded before have no StorageKey
  */
 type Log struct {
 	ID          uint      `json:"id" gorm:"primaryKey"`
@@ -56,8 +108,10 @@ type Log struct {
 	FileName    string    `json:"file_name" gorm:"index;not null"`
 	FirstLineNo int64     `json:"first_line_no"`
 	LastLineNo  int64     `json:"end_line_no"`
+	StorageKey  string    `json:"storage_key"` //key of the compressed file in the storage
+	Size        int64     `json:"size"`        //size of the uploaded file
 	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"autoUpdateTime;index"`
 }
 
 /**
@@ -68,6 +122,14 @@ func (Configuration) TableName() string {
 	return "configurations"
 }
 
+/**
+ * TableName returns the table name for ConfigurationHistory model
+ * @returns {string} Database table name
+ */
+func (ConfigurationHistory) TableName() string {
+	return "configuration_histories"
+}
+
 /**
  * TableName returns the table name for Feedback model
  * @returns {string} Database table name
@@ -16,7 +16,7 @@ import (
 /**
  * Setup all routes for the application
  * @param {*gin.Engine} r - Gin engine
- * @param {*controllers.ConfigController} configController - Configuration controller
+ * @param {*controllers.ConfigurationController} configController - Configuration controller
  * @param {*controllers.FeedbackController} feedbackController - Feedback controller
  * @param {*controllers.LogController} logController - Log controller
  * @param {*logrus.Logger} logger - Application logger
@@ -29,7 +29,7 @@ import (
  * - Sets up Swagger documentation endpoint
  * - Sets up API routes
  */
-func SetupRoutes(r *gin.Engine, logController *controllers.LogController, logger *logrus.Logger) {
+func SetupRoutes(r *gin.Engine, configController *controllers.ConfigurationController, feedbackController *controllers.FeedbackController, logController *controllers.LogController, logger *logrus.Logger) {
 	// Add CORS middleware
 	r.Use(internal.CORSMiddleware())
 
@@ -49,7 +49,7 @@ func SetupRoutes(r *gin.Engine, logController *controllers.LogController, logger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Setup API routes
-	setupAPIRoutes(r, logController)
+	setupAPIRoutes(r, configController, feedbackController, logController)
 }
 
 // setupHealthCheckRoutes configures health check routes
@@ -74,24 +74,57 @@ func setupHealthCheckRoutes(r *gin.Engine, logger *logrus.Logger) {
 /**
  * Setup API routes for the application
  * @param {*gin.Engine} r - Gin engine
- * @param {*controllers.ConfigController} configController - Configuration controller
+ * @param {*controllers.ConfigurationController} configController - Configuration controller
  * @param {*controllers.FeedbackController} feedbackController - Feedback controller
  * @param {*controllers.LogController} logController - Log controller
  * @description
- * - Sets up configuration API routes
- * - Sets up feedback API routes
- * - Sets up log API routes
+ * - Sets up configuration API routes, the admin routes require the admin token,
+ *   the client routes a verified bearer token
+ * - Sets up feedback API routes, posting requires a verified bearer token,
+ *   the query routes the admin token
+ * - Sets up log API routes, listing, reading and signing the logs require the admin token
  */
-func setupAPIRoutes(r *gin.Engine, logController *controllers.LogController) {
+func setupAPIRoutes(r *gin.Engine, configController *controllers.ConfigurationController, feedbackController *controllers.FeedbackController, logController *controllers.LogController) {
 	// Setup API routes
 	api := r.Group("/client-manager/api/v1")
 	{
+		// Configuration admin routes
+		configs := api.Group("/configurations", internal.AdminMiddleware())
+		{
+			configs.GET("", configController.ListConfigurations)
+			configs.GET("/:namespace/:key", configController.GetConfiguration)
+			configs.PUT("/:namespace/:key", configController.SaveConfiguration)
+			configs.DELETE("/:namespace/:key", configController.DeleteConfiguration)
+			configs.GET("/:namespace/:key/history", configController.GetConfigurationHistory)
+			configs.POST("/:namespace/:key/rollback", configController.RollbackConfiguration)
+		}
+
+		// Client configuration routes
+		clientConfigs := api.Group("/client/configurations", internal.IdentityMiddleware())
+		{
+			clientConfigs.GET("/:namespace", configController.GetClientConfiguration)
+			clientConfigs.GET("/:namespace/events", configController.WatchClientConfiguration)
+		}
+
+		// Feedback routes
+		feedbacks := api.Group("/feedbacks")
+		{
+			feedbacks.POST("", internal.IdentityMiddleware(), feedbackController.PostFeedback)
+			feedbacks.POST("/batch", internal.IdentityMiddleware(), feedbackController.PostFeedbacks)
+			feedbacks.GET("", internal.AdminMiddleware(), feedbackController.ListFeedbacks)
+			feedbacks.GET("/stats", internal.AdminMiddleware(), feedbackController.GetFeedbackStats)
+			feedbacks.GET("/export", internal.AdminMiddleware(), feedbackController.ExportFeedbacks)
+		}
+
 		// Log routes
 		logs := api.Group("/logs")
 		{
 			logs.POST("", logController.PostLog)
-			logs.GET("", logController.ListLogs)
-			logs.GET("/:client_id/:file_name", logController.GetLogs)
+			logs.GET("", internal.AdminMiddleware(), logController.ListLogs)
+			// 签名URL本身即是授权，由管理员获取
+			logs.GET("/download", logController.DownloadLog)
+			logs.GET("/:client_id/:file_name", internal.AdminMiddleware(), logController.GetLogs)
+			logs.GET("/:client_id/:file_name/url", internal.AdminMiddleware(), logController.GetLogURL)
 		}
 	}
 }
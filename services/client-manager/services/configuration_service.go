@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/zgsm-ai/client-manager/dao"
+	"github.com/zgsm-ai/client-manager/internal"
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+// scopeRank is the priority of each scope, higher ranks override lower ones
+var scopeRank = map[string]int{
+	models.ScopeGlobal: 0,
+	models.ScopeOrg:    1,
+	models.ScopeUser:   2,
+	models.ScopeClient: 3,
+}
+
+/**
+ * ConfigurationService handles business logic for remote configuration
+ * @description
+ * - Implements namespaced CRUD of configuration items for admins
+ * - Resolves the configuration of a client: global -> org/department -> user -> client ID
+ * - Keeps a version history of each item, and rolls back to a version
+ * - Notifies the watchers of a namespace when one of its items changes
+ */
+type ConfigurationService struct {
+	configDAO *dao.ConfigurationDAO
+	log       *logrus.Logger
+	mu        sync.Mutex
+	changes   map[string]chan struct{}
+}
+
+// ConfigurationScopeArgs selects the scope of a configuration item
+type ConfigurationScopeArgs struct {
+	Scope   string `form:"scope" json:"scope"`
+	ScopeID string `form:"scope_id" json:"scope_id"`
+}
+
+type SaveConfigurationArgs struct {
+	ConfigurationScopeArgs
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+type ListConfigurationsArgs struct {
+	Namespace string `form:"namespace"`
+	Scope     string `form:"scope"`
+	ScopeID   string `form:"scope_id"`
+}
+
+type RollbackConfigurationArgs struct {
+	ConfigurationScopeArgs
+	Version int64 `json:"version"`
+}
+
+type ClientConfigurationArgs struct {
+	Wait int `form:"wait"` //seconds to wait for a change when the ETag matches
+}
+
+/**
+ * ResolvedConfiguration is the configuration of a namespace as seen by a client
+ * @description
+ * - Values are the values of the keys after applying the scope overrides
+ * - Scopes are the scopes each value comes from
+ * - ETag changes whenever the values change
+ */
+type ResolvedConfiguration struct {
+	Namespace string            `json:"namespace"`
+	Values    map[string]string `json:"values"`
+	Scopes    map[string]string `json:"scopes"`
+	ETag      string            `json:"etag"`
+}
+
+/**
+ * NewConfigurationService creates a new ConfigurationService instance
+ * @param {dao.ConfigurationDAO} configDAO - Configuration data access object
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*ConfigurationService} New ConfigurationService instance
+ */
+func NewConfigurationService(configDAO *dao.ConfigurationDAO, log *logrus.Logger) *ConfigurationService {
+	return &ConfigurationService{
+		configDAO: configDAO,
+		log:       log,
+		changes:   make(map[string]chan struct{}),
+	}
+}
+
+func validateItem(namespace, key string, args *ConfigurationScopeArgs) error {
+	if namespace == "" {
+		return &ValidationError{Field: "namespace", Message: "namespace is required"}
+	}
+	if key == "" {
+		return &ValidationError{Field: "key", Message: "key is required"}
+	}
+	if args.Scope == "" {
+		args.Scope = models.ScopeGlobal
+	}
+	if _, ok := scopeRank[args.Scope]; !ok {
+		return &ValidationError{Field: "scope", Message: "scope must be one of global, org, user, client"}
+	}
+	if args.Scope == models.ScopeGlobal && args.ScopeID != "" {
+		return &ValidationError{Field: "scope_id", Message: "scope_id must be empty for the global scope"}
+	}
+	if args.Scope != models.ScopeGlobal && args.ScopeID == "" {
+		return &ValidationError{Field: "scope_id", Message: "scope_id is required for the " + args.Scope + " scope"}
+	}
+	return nil
+}
+
+func notFound(err error, namespace, key string, args *ConfigurationScopeArgs) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &NotFoundError{Message: fmt.Sprintf("configuration %s/%s not found in scope %s:%s", namespace, key, args.Scope, args.ScopeID)}
+	}
+	return err
+}
+
+/**
+ * ListConfigurations lists the configuration items
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*ListConfigurationsArgs} args - Optional namespace and scope filters
+ * @returns {[]models.Configuration, error} Configuration items
+ */
+func (s *ConfigurationService) ListConfigurations(ctx context.Context, args *ListConfigurationsArgs) ([]models.Configuration, error) {
+	return s.configDAO.List(ctx, args.Namespace, args.Scope, args.ScopeID)
+}
+
+/**
+ * GetConfiguration retrieves a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {*ConfigurationScopeArgs} args - Scope of the item, global by default
+ * @returns {*models.Configuration, error} Configuration item
+ * @throws
+ * - Validation errors for invalid parameters
+ * - NotFoundError if the item doesn't exist
+ */
+func (s *ConfigurationService) GetConfiguration(ctx context.Context, namespace, key string, args *ConfigurationScopeArgs) (*models.Configuration, error) {
+	if err := validateItem(namespace, key, args); err != nil {
+		return nil, err
+	}
+	cfg, err := s.configDAO.Get(ctx, namespace, key, args.Scope, args.ScopeID)
+	if err != nil {
+		return nil, notFound(err, namespace, key, args)
+	}
+	return cfg, nil
+}
+
+/**
+ * SaveConfiguration creates or updates a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {*SaveConfigurationArgs} args - Scope, value and description of the item
+ * @param {string} operator - User changing the item
+ * @returns {*models.Configuration, error} Saved item, with its new version
+ */
+func (s *ConfigurationService) SaveConfiguration(ctx context.Context, namespace, key string, args *SaveConfigurationArgs, operator string) (*models.Configuration, error) {
+	if err := validateItem(namespace, key, &args.ConfigurationScopeArgs); err != nil {
+		return nil, err
+	}
+	cfg := &models.Configuration{
+		Namespace:   namespace,
+		Key:         key,
+		Scope:       args.Scope,
+		ScopeID:     args.ScopeID,
+		Value:       args.Value,
+		Description: args.Description,
+		UpdatedBy:   operator,
+	}
+	if err := s.configDAO.Save(ctx, cfg, ""); err != nil {
+		return nil, err
+	}
+	s.changed(cfg, "Configuration saved")
+	return cfg, nil
+}
+
+/**
+ * DeleteConfiguration deletes a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {*ConfigurationScopeArgs} args - Scope of the item, global by default
+ * @param {string} operator - User deleting the item
+ * @returns {error} NotFoundError if the item doesn't exist
+ * @description
+ * - The deletion is recorded in the history, the item can be restored by a rollback
+ */
+func (s *ConfigurationService) DeleteConfiguration(ctx context.Context, namespace, key string, args *ConfigurationScopeArgs, operator string) error {
+	if err := validateItem(namespace, key, args); err != nil {
+		return err
+	}
+	cfg, err := s.configDAO.Delete(ctx, namespace, key, args.Scope, args.ScopeID, operator)
+	if err != nil {
+		return notFound(err, namespace, key, args)
+	}
+	s.changed(cfg, "Configuration deleted")
+	return nil
+}
+
+/**
+ * GetConfigurationHistory lists the versions of a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {*ConfigurationScopeArgs} args - Scope of the item, global by default
+ * @returns {[]models.ConfigurationHistory, error} Versions, the latest first
+ */
+func (s *ConfigurationService) GetConfigurationHistory(ctx context.Context, namespace, key string, args *ConfigurationScopeArgs) ([]models.ConfigurationHistory, error) {
+	if err := validateItem(namespace, key, args); err != nil {
+		return nil, err
+	}
+	versions, err := s.configDAO.History(ctx, namespace, key, args.Scope, args.ScopeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, notFound(gorm.ErrRecordNotFound, namespace, key, args)
+	}
+	return versions, nil
+}
+
+/**
+ * RollbackConfiguration restores a version of a configuration item
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} key - Configuration key
+ * @param {*RollbackConfigurationArgs} args - Scope of the item and version to restore
+ * @param {string} operator - User rolling back the item
+ * @returns {*models.Configuration, error} Restored item, with a new version
+ * @description
+ * - The restored value is saved as a new version, the history is never rewritten
+ * - Deleted items can be restored, but a deletion can't be rolled back to
+ */
+func (s *ConfigurationService) RollbackConfiguration(ctx context.Context, namespace, key string, args *RollbackConfigurationArgs, operator string) (*models.Configuration, error) {
+	if err := validateItem(namespace, key, &args.ConfigurationScopeArgs); err != nil {
+		return nil, err
+	}
+	if args.Version < 1 {
+		return nil, &ValidationError{Field: "version", Message: "version is required"}
+	}
+	h, err := s.configDAO.GetVersion(ctx, namespace, key, args.Scope, args.ScopeID, args.Version)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &NotFoundError{Message: fmt.Sprintf("version %d of configuration %s/%s not found", args.Version, namespace, key)}
+		}
+		return nil, err
+	}
+	if h.Action == dao.ActionDelete {
+		return nil, &ValidationError{Field: "version", Message: "can't roll back to a deletion, delete the configuration instead"}
+	}
+	cfg := &models.Configuration{
+		Namespace:   namespace,
+		Key:         key,
+		Scope:       args.Scope,
+		ScopeID:     args.ScopeID,
+		Value:       h.Value,
+		Description: h.Description,
+		UpdatedBy:   operator,
+	}
+	if err := s.configDAO.Save(ctx, cfg, dao.ActionRollback); err != nil {
+		return nil, err
+	}
+	s.changed(cfg, fmt.Sprintf("Configuration rolled back to version %d", args.Version))
+	return cfg, nil
+}
+
+/**
+ * ResolveConfiguration resolves the configuration of a namespace for a client
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} namespace - Configuration namespace
+ * @param {string} org - Org/department of the client (optional)
+ * @param {string} userID - User of the client (optional)
+ * @param {string} clientID - Client identifier (optional)
+ * @returns {*ResolvedConfiguration, error} Values of the namespace and their ETag
+ * @description
+ * - Values of a key are overridden in the order global -> org -> user -> client
+ * - Each resolved key is counted in the configuration access metrics
+ */
+func (s *ConfigurationService) ResolveConfiguration(ctx context.Context, namespace, org, userID, clientID string) (*ResolvedConfiguration, error) {
+	if namespace == "" {
+		return nil, &ValidationError{Field: "namespace", Message: "namespace is required"}
+	}
+	cfgs, err := s.configDAO.ListForClient(ctx, namespace, org, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(cfgs, func(i, j int) bool { return scopeRank[cfgs[i].Scope] < scopeRank[cfgs[j].Scope] })
+	rc := &ResolvedConfiguration{
+		Namespace: namespace,
+		Values:    make(map[string]string),
+		Scopes:    make(map[string]string),
+	}
+	for _, cfg := range cfgs {
+		rc.Values[cfg.Key] = cfg.Value
+		rc.Scopes[cfg.Key] = cfg.Scope
+	}
+	for key := range rc.Values {
+		internal.RecordConfigurationAccess(namespace, key)
+	}
+	//maps are encoded with sorted keys, the ETag only depends on the values
+	b, err := json.Marshal(rc.Values)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	rc.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	return rc, nil
+}
+
+/**
+ * WatchConfiguration returns a channel closed on the next change of a namespace
+ * @param {string} namespace - Configuration namespace
+ * @returns {<-chan struct{}} Channel closed when an item of the namespace changes
+ * @description
+ * - Take the channel before resolving the configuration, so no change is missed
+ * - Only the changes made through this instance are notified: with several instances,
+ *   clients watching another instance see the change on their next poll/reconnection
+ */
+func (s *ConfigurationService) WatchConfiguration(namespace string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.changes[namespace]
+	if !ok {
+		ch = make(chan struct{})
+		s.changes[namespace] = ch
+	}
+	return ch
+}
+
+// changed logs a change of an item and wakes up the watchers of its namespace
+func (s *ConfigurationService) changed(cfg *models.Configuration, msg string) {
+	s.mu.Lock()
+	if ch, ok := s.changes[cfg.Namespace]; ok {
+		close(ch)
+		delete(s.changes, cfg.Namespace)
+	}
+	s.mu.Unlock()
+
+	s.log.WithFields(logrus.Fields{
+		"namespace": cfg.Namespace,
+		"key":       cfg.Key,
+		"scope":     cfg.Scope,
+		"scope_id":  cfg.ScopeID,
+		"version":   cfg.Version,
+		"operator":  cfg.UpdatedBy,
+	}).Info(msg)
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/zgsm-ai/client-manager/dao"
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Configuration{}, &models.ConfigurationHistory{}, &models.Feedback{}, &models.Log{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func newTestConfigurationService(t *testing.T) *ConfigurationService {
+	log := newTestLogger()
+	return NewConfigurationService(dao.NewConfigurationDAO(newTestDB(t), log), log)
+}
+
+func saveConfiguration(t *testing.T, s *ConfigurationService, key, scope, scopeID, value string) *models.Configuration {
+	t.Helper()
+	args := &SaveConfigurationArgs{ConfigurationScopeArgs: ConfigurationScopeArgs{Scope: scope, ScopeID: scopeID}, Value: value}
+	cfg, err := s.SaveConfiguration(context.Background(), "ide", key, args, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestResolveConfigurationScopes(t *testing.T) {
+	s := newTestConfigurationService(t)
+	saveConfiguration(t, s, "theme", models.ScopeGlobal, "", "light")
+	saveConfiguration(t, s, "theme", models.ScopeOrg, "dev", "dark")
+	saveConfiguration(t, s, "theme", models.ScopeUser, "u1", "solarized")
+	saveConfiguration(t, s, "theme", models.ScopeClient, "c1", "mono")
+	saveConfiguration(t, s, "model", models.ScopeGlobal, "", "small")
+	saveConfiguration(t, s, "model", models.ScopeOrg, "ops", "large")
+
+	tests := []struct {
+		name              string
+		org, user, client string
+		theme, themeScope string
+		model             string
+	}{
+		{"global", "", "u9", "", "light", models.ScopeGlobal, "small"},
+		{"org", "dev", "u9", "", "dark", models.ScopeOrg, "small"},
+		{"user over org", "dev", "u1", "", "solarized", models.ScopeUser, "small"},
+		{"client over user", "dev", "u1", "c1", "mono", models.ScopeClient, "small"},
+		{"client without user", "", "u9", "c1", "mono", models.ScopeClient, "small"},
+		{"other org", "ops", "u9", "c9", "light", models.ScopeGlobal, "large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := s.ResolveConfiguration(context.Background(), "ide", tt.org, tt.user, tt.client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rc.Values["theme"] != tt.theme || rc.Scopes["theme"] != tt.themeScope {
+				t.Errorf("theme = %q from %q, want %q from %q", rc.Values["theme"], rc.Scopes["theme"], tt.theme, tt.themeScope)
+			}
+			if rc.Values["model"] != tt.model {
+				t.Errorf("model = %q, want %q", rc.Values["model"], tt.model)
+			}
+		})
+	}
+}
+
+func TestResolveConfigurationETag(t *testing.T) {
+	s := newTestConfigurationService(t)
+	saveConfiguration(t, s, "theme", models.ScopeGlobal, "", "light")
+	resolve := func() string {
+		rc, err := s.ResolveConfiguration(context.Background(), "ide", "", "u1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rc.ETag
+	}
+	etag := resolve()
+
+	tests := []struct {
+		name    string
+		key     string
+		scope   string
+		scopeID string
+		value   string
+		changed bool
+	}{
+		{"same value", "theme", models.ScopeGlobal, "", "light", false},
+		{"other user", "theme", models.ScopeUser, "u2", "dark", false},
+		{"new value", "theme", models.ScopeGlobal, "", "dark", true},
+		{"user override", "theme", models.ScopeUser, "u1", "mono", true},
+		{"new key", "model", models.ScopeGlobal, "", "small", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watch := s.WatchConfiguration("ide")
+			saveConfiguration(t, s, tt.key, tt.scope, tt.scopeID, tt.value)
+			select {
+			case <-watch:
+			default:
+				t.Error("watchers not notified")
+			}
+			next := resolve()
+			if (next != etag) != tt.changed {
+				t.Errorf("etag changed = %v, want %v", next != etag, tt.changed)
+			}
+			etag = next
+		})
+	}
+}
+
+func TestRollbackConfiguration(t *testing.T) {
+	s := newTestConfigurationService(t)
+	ctx := context.Background()
+	scope := ConfigurationScopeArgs{Scope: models.ScopeUser, ScopeID: "u1"}
+	saveConfiguration(t, s, "theme", scope.Scope, scope.ScopeID, "light")
+	saveConfiguration(t, s, "theme", scope.Scope, scope.ScopeID, "dark")
+	if err := s.DeleteConfiguration(ctx, "ide", "theme", &scope, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetConfiguration(ctx, "ide", "theme", &scope); !isNotFound(err) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		version int64
+		value   string
+		wantErr error
+		want    int64
+	}{
+		{"restore deleted", 1, "light", nil, 4},
+		{"restore update", 2, "dark", nil, 5},
+		{"restore rollback", 4, "light", nil, 6},
+		{"deletion", 3, "", &ValidationError{}, 0},
+		{"unknown version", 42, "", &NotFoundError{}, 0},
+		{"no version", 0, "", &ValidationError{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := &RollbackConfigurationArgs{ConfigurationScopeArgs: scope, Version: tt.version}
+			cfg, err := s.RollbackConfiguration(ctx, "ide", "theme", args, "admin")
+			if tt.wantErr != nil {
+				if err == nil || errorType(err) != errorType(tt.wantErr) {
+					t.Fatalf("expected %T, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Value != tt.value || cfg.Version != tt.want {
+				t.Errorf("got %q version %d, want %q version %d", cfg.Value, cfg.Version, tt.value, tt.want)
+			}
+		})
+	}
+	versions, err := s.GetConfigurationHistory(ctx, "ide", "theme", &scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, v := range versions {
+		actions = append(actions, v.Action)
+	}
+	want := []string{dao.ActionRollback, dao.ActionRollback, dao.ActionRollback, dao.ActionDelete, dao.ActionUpdate, dao.ActionCreate}
+	if len(actions) != len(want) {
+		t.Fatalf("history = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("history = %v, want %v", actions, want)
+		}
+	}
+}
+
+func TestSaveConfigurationConcurrent(t *testing.T) {
+	s := newTestConfigurationService(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			args := &SaveConfigurationArgs{Value: "v"}
+			if _, err := s.SaveConfiguration(context.Background(), "ide", "theme", args, "admin"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	versions, err := s.GetConfigurationHistory(context.Background(), "ide", "theme", &ConfigurationScopeArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 8 {
+		t.Fatalf("expected 8 versions, got %d", len(versions))
+	}
+	for i, v := range versions {
+		if v.Version != int64(len(versions)-i) {
+			t.Fatalf("versions are not sequential: %+v", versions)
+		}
+	}
+	cfgs, err := s.ListConfigurations(context.Background(), &ListConfigurationsArgs{Namespace: "ide"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(cfgs))
+	}
+}
+
+func isNotFound(err error) bool {
+	var nf *NotFoundError
+	return errors.As(err, &nf)
+}
+
+func errorType(err error) string {
+	switch err.(type) {
+	case *ValidationError:
+		return "validation"
+	case *NotFoundError:
+		return "notfound"
+	}
+	return "other"
+}
@@ -54,4 +54,57 @@ type NotFoundError struct {
 */
 func (e *NotFoundError) Error() string {
 	return e.Message
-}
\ No newline at end of file
+}
+
+/**
+ * TooLargeError represents an upload exceeding the size limit
+ * @description
+ * - Contains error message
+ */
+type TooLargeError struct {
+	Message string
+}
+
+/**
+ * Error returns the error message
+ * @returns {string} Error message
+ */
+func (e *TooLargeError) Error() string {
+	return e.Message
+}
+
+/**
+ * UnsupportedMediaTypeError represents an upload of a forbidden content type
+ * @description
+ * - Contains the detected content type and error message
+ */
+type UnsupportedMediaTypeError struct {
+	ContentType string
+	Message     string
+}
+
+/**
+ * Error returns the error message
+ * @returns {string} Error message
+ */
+func (e *UnsupportedMediaTypeError) Error() string {
+	return e.Message
+}
+
+/**
+ * ForbiddenError represents a request without the required permission
+ * @description
+ * - Used for invalid or expired signed URLs
+ * - Contains error message
+ */
+type ForbiddenError struct {
+	Message string
+}
+
+/**
+ * Error returns the error message
+ * @returns {string} Error message
+ */
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
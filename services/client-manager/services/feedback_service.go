@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zgsm-ai/client-manager/dao"
+	"github.com/zgsm-ai/client-manager/internal"
+	"github.com/zgsm-ai/client-manager/models"
+	"github.com/zgsm-ai/client-manager/utils"
+)
+
+// maxFeedbackBatch 一次上报的反馈数上限
+const maxFeedbackBatch = 100
+
+// maxFeedbackExport 一次导出的反馈数上限
+const maxFeedbackExport = 100000
+
+// feedbackTypes are the accepted feedback types
+var feedbackTypes = []string{
+	models.FeedbackThumbsUp,
+	models.FeedbackThumbsDown,
+	models.FeedbackCopy,
+	models.FeedbackAccept,
+	models.FeedbackEvaluate,
+}
+
+/**
+ * FeedbackService handles business logic for feedback operations
+ * @description
+ * - Validates and records the feedback posted by the IDE clients
+ * - Queries, aggregates and exports the feedback for quality reviews
+ */
+type FeedbackService struct {
+	feedbackDAO *dao.FeedbackDAO
+	log         *logrus.Logger
+}
+
+type FeedbackArgs struct {
+	Type           string          `json:"type"`
+	RequestID      string          `json:"request_id"`    //chat-rag request ID
+	CompletionID   string          `json:"completion_id"` //code completion ID
+	ConversationID string          `json:"conversation_id"`
+	ClientID       string          `json:"client_id"`
+	ClientVersion  string          `json:"client_version"`
+	Score          int             `json:"score"` //1-5, for evaluate feedbacks
+	Content        string          `json:"content"`
+	Metadata       json.RawMessage `json:"metadata"`
+}
+
+type QueryFeedbacksArgs struct {
+	Type           string `form:"type"`
+	UserID         string `form:"user_id"`
+	ClientID       string `form:"client_id"`
+	ClientVersion  string `form:"client_version"`
+	ConversationID string `form:"conversation_id"`
+	RequestID      string `form:"request_id"`
+	CompletionID   string `form:"completion_id"`
+	StartDate      string `form:"start_date"` //YYYY-MM-DD
+	EndDate        string `form:"end_date"`   //YYYY-MM-DD, included
+}
+
+type ListFeedbacksArgs struct {
+	QueryFeedbacksArgs
+	Page     int `form:"page,default=1"`
+	PageSize int `form:"page_size,default=20"`
+}
+
+type FeedbackStatsArgs struct {
+	QueryFeedbacksArgs
+	GroupBy string `form:"group_by,default=type"` //comma separated: type, day, client_version
+}
+
+/**
+ * NewFeedbackService creates a new FeedbackService instance
+ * @param {dao.FeedbackDAO} feedbackDAO - Feedback data access object
+ * @param {logrus.Logger} log - Logger instance
+ * @returns {*FeedbackService} New FeedbackService instance
+ */
+func NewFeedbackService(feedbackDAO *dao.FeedbackDAO, log *logrus.Logger) *FeedbackService {
+	return &FeedbackService{
+		feedbackDAO: feedbackDAO,
+		log:         log,
+	}
+}
+
+/**
+ * CreateFeedbacks records feedbacks posted by a client
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {[]FeedbackArgs} args - Feedbacks, all recorded or none
+ * @param {string} userID - User posting the feedbacks
+ * @returns {[]*models.Feedback, error} Recorded feedbacks
+ * @throws
+ * - Validation errors for invalid feedbacks, the field is prefixed by the feedback index
+ * - Database creation errors
+ */
+func (s *FeedbackService) CreateFeedbacks(ctx context.Context, args []FeedbackArgs, userID string) ([]*models.Feedback, error) {
+	if len(args) == 0 {
+		return nil, &ValidationError{Field: "feedbacks", Message: "no feedback"}
+	}
+	if len(args) > maxFeedbackBatch {
+		return nil, &ValidationError{Field: "feedbacks", Message: fmt.Sprintf("at most %d feedbacks can be posted at once", maxFeedbackBatch)}
+	}
+	feedbacks := make([]*models.Feedback, 0, len(args))
+	for i := range args {
+		if err := s.validate(&args[i]); err != nil {
+			if len(args) > 1 {
+				err.Field = fmt.Sprintf("[%d].%s", i, err.Field)
+			}
+			return nil, err
+		}
+		a := &args[i]
+		feedbacks = append(feedbacks, &models.Feedback{
+			Type:           a.Type,
+			RequestID:      a.RequestID,
+			CompletionID:   a.CompletionID,
+			ConversationID: a.ConversationID,
+			UserID:         userID,
+			ClientID:       a.ClientID,
+			ClientVersion:  a.ClientVersion,
+			Score:          a.Score,
+			Content:        a.Content,
+			Metadata:       string(a.Metadata),
+		})
+	}
+	if err := s.feedbackDAO.Create(ctx, feedbacks); err != nil {
+		return nil, err
+	}
+	for _, f := range feedbacks {
+		internal.RecordFeedbackReceived(f.Type)
+	}
+	s.log.WithFields(logrus.Fields{
+		"user_id": userID,
+		"count":   len(feedbacks),
+	}).Debug("Feedbacks created successfully")
+	return feedbacks, nil
+}
+
+func (s *FeedbackService) validate(a *FeedbackArgs) *ValidationError {
+	if !utils.ContainsString(feedbackTypes, a.Type) {
+		return &ValidationError{Field: "type", Message: "type must be one of " + strings.Join(feedbackTypes, ", ")}
+	}
+	if a.RequestID == "" && a.CompletionID == "" {
+		return &ValidationError{Field: "request_id", Message: "request_id or completion_id is required"}
+	}
+	if a.Type == models.FeedbackEvaluate && (a.Score < 1 || a.Score > 5) {
+		return &ValidationError{Field: "score", Message: "score must be between 1 and 5"}
+	}
+	if a.Type != models.FeedbackEvaluate && a.Score != 0 {
+		return &ValidationError{Field: "score", Message: "score is only for evaluate feedbacks"}
+	}
+	if string(a.Metadata) == "null" {
+		a.Metadata = nil
+	}
+	return nil
+}
+
+// filter converts the query arguments into a DAO filter
+func (args *QueryFeedbacksArgs) filter() (*dao.FeedbackFilter, error) {
+	f := &dao.FeedbackFilter{
+		Type:           args.Type,
+		UserID:         args.UserID,
+		ClientID:       args.ClientID,
+		ClientVersion:  args.ClientVersion,
+		ConversationID: args.ConversationID,
+		RequestID:      args.RequestID,
+		CompletionID:   args.CompletionID,
+	}
+	if args.StartDate != "" {
+		t, err := utils.ParseTimeString(args.StartDate, "2006-01-02")
+		if err != nil {
+			return nil, &ValidationError{Field: "start_date", Message: "start_date must be a YYYY-MM-DD date"}
+		}
+		f.Start = utils.GetMidnight(t)
+	}
+	if args.EndDate != "" {
+		t, err := utils.ParseTimeString(args.EndDate, "2006-01-02")
+		if err != nil {
+			return nil, &ValidationError{Field: "end_date", Message: "end_date must be a YYYY-MM-DD date"}
+		}
+		f.End = utils.GetEndOfDay(t)
+	}
+	return f, nil
+}
+
+/**
+ * ListFeedbacks lists feedbacks with filtering and pagination
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*ListFeedbacksArgs} args - Filters and page
+ * @returns {[]models.Feedback, Paginated, error} Feedbacks (latest first) and pagination info
+ */
+func (s *FeedbackService) ListFeedbacks(ctx context.Context, args *ListFeedbacksArgs) (feedbacks []models.Feedback, paging Paginated, err error) {
+	f, err := args.filter()
+	if err != nil {
+		return
+	}
+	if args.Page < 1 {
+		args.Page = 1
+	}
+	if args.PageSize < 1 || args.PageSize > 100 {
+		args.PageSize = 20
+	}
+	var total int64
+	feedbacks, total, err = s.feedbackDAO.List(ctx, f, args.Page, args.PageSize)
+	if err != nil {
+		return
+	}
+	paging.Page = int64(args.Page)
+	paging.PageSize = int64(args.PageSize)
+	paging.Total = total
+	paging.TotalPages = (total + int64(args.PageSize) - 1) / int64(args.PageSize)
+	return
+}
+
+/**
+ * FeedbackStats aggregates feedbacks
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*FeedbackStatsArgs} args - Filters and groups
+ * @returns {[]dao.FeedbackStat, error} Count and average score of each group
+ */
+func (s *FeedbackService) FeedbackStats(ctx context.Context, args *FeedbackStatsArgs) ([]dao.FeedbackStat, error) {
+	f, err := args.filter()
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, g := range strings.Split(args.GroupBy, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || utils.ContainsString(groups, g) {
+			continue
+		}
+		if g != "type" && g != "day" && g != "client_version" {
+			return nil, &ValidationError{Field: "group_by", Message: "group_by must be a list of type, day, client_version"}
+		}
+		groups = append(groups, g)
+	}
+	return s.feedbackDAO.Stats(ctx, f, groups)
+}
+
+/**
+ * ExportFeedbacks retrieves the feedbacks matching the filters, in batches
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {*QueryFeedbacksArgs} args - Filters
+ * @param {func([]models.Feedback) error} fn - Called with each batch, in creation order
+ * @returns {error} Validation, query or fn error
+ * @description
+ * - Exports at most maxFeedbackExport feedbacks
+ */
+func (s *FeedbackService) ExportFeedbacks(ctx context.Context, args *QueryFeedbacksArgs, fn func([]models.Feedback) error) error {
+	f, err := args.filter()
+	if err != nil {
+		return err
+	}
+	return s.feedbackDAO.Export(ctx, f, maxFeedbackExport, fn)
+}
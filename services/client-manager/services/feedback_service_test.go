@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zgsm-ai/client-manager/dao"
+	"github.com/zgsm-ai/client-manager/models"
+)
+
+func newTestFeedbackService(t *testing.T) (*FeedbackService, *dao.FeedbackDAO) {
+	log := newTestLogger()
+	feedbackDAO := dao.NewFeedbackDAO(newTestDB(t), log)
+	return NewFeedbackService(feedbackDAO, log), feedbackDAO
+}
+
+func TestFeedbackStats(t *testing.T) {
+	s, feedbackDAO := newTestFeedbackService(t)
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	feedbacks := []*models.Feedback{
+		{Type: models.FeedbackThumbsUp, RequestID: "r1", ClientVersion: "1.0", CreatedAt: day1},
+		{Type: models.FeedbackThumbsUp, RequestID: "r2", ClientVersion: "1.1", CreatedAt: day2},
+		{Type: models.FeedbackEvaluate, RequestID: "r3", ClientVersion: "1.0", Score: 4, CreatedAt: day1},
+		{Type: models.FeedbackEvaluate, RequestID: "r4", ClientVersion: "1.1", Score: 1, CreatedAt: day2},
+		{Type: models.FeedbackCopy, CompletionID: "c1", ClientVersion: "1.1", CreatedAt: day2},
+	}
+	if err := feedbackDAO.Create(context.Background(), feedbacks); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    FeedbackStatsArgs
+		want    []dao.FeedbackStat
+		wantErr bool
+	}{
+		{
+			name: "by type",
+			args: FeedbackStatsArgs{GroupBy: "type"},
+			want: []dao.FeedbackStat{
+				{Type: models.FeedbackCopy, Count: 1},
+				{Type: models.FeedbackEvaluate, Count: 2, AvgScore: 2.5},
+				{Type: models.FeedbackThumbsUp, Count: 2},
+			},
+		},
+		{
+			name: "by day and version",
+			args: FeedbackStatsArgs{GroupBy: "day, client_version,day"},
+			want: []dao.FeedbackStat{
+				{Day: "2026-03-01", ClientVersion: "1.0", Count: 2, AvgScore: 4},
+				{Day: "2026-03-02", ClientVersion: "1.1", Count: 3, AvgScore: 1},
+			},
+		},
+		{
+			name: "filtered",
+			args: FeedbackStatsArgs{QueryFeedbacksArgs: QueryFeedbacksArgs{Type: models.FeedbackEvaluate, StartDate: "2026-03-02"}, GroupBy: "type"},
+			want: []dao.FeedbackStat{{Type: models.FeedbackEvaluate, Count: 1, AvgScore: 1}},
+		},
+		{
+			name: "no group",
+			args: FeedbackStatsArgs{GroupBy: ""},
+			want: []dao.FeedbackStat{{Count: 5, AvgScore: 2.5}},
+		},
+		{name: "invalid group", args: FeedbackStatsArgs{GroupBy: "user_id"}, wantErr: true},
+		{name: "invalid date", args: FeedbackStatsArgs{QueryFeedbacksArgs: QueryFeedbacksArgs{EndDate: "03/02/2026"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := s.FeedbackStats(context.Background(), &tt.args)
+			if tt.wantErr {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats) != len(tt.want) {
+				t.Fatalf("stats = %+v, want %+v", stats, tt.want)
+			}
+			for i := range tt.want {
+				if stats[i] != tt.want[i] {
+					t.Errorf("stats[%d] = %+v, want %+v", i, stats[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExportFeedbacks(t *testing.T) {
+	s, _ := newTestFeedbackService(t)
+	args := []FeedbackArgs{
+		{Type: models.FeedbackThumbsUp, RequestID: "r1"},
+		{Type: models.FeedbackThumbsDown, RequestID: "r2"},
+		{Type: models.FeedbackCopy, CompletionID: "c1"},
+	}
+	if _, err := s.CreateFeedbacks(context.Background(), args, "u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args QueryFeedbacksArgs
+		want []string
+	}{
+		{"all", QueryFeedbacksArgs{}, []string{"r1", "r2", ""}},
+		{"by type", QueryFeedbacksArgs{Type: models.FeedbackThumbsDown}, []string{"r2"}},
+		{"by user", QueryFeedbacksArgs{UserID: "u2"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := s.ExportFeedbacks(context.Background(), &tt.args, func(batch []models.Feedback) error {
+				for _, f := range batch {
+					got = append(got, f.RequestID)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("exported %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("exported %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -11,15 +11,21 @@ import (
 
 	"github.com/zgsm-ai/client-manager/dao"
 	"github.com/zgsm-ai/client-manager/internal"
+	"github.com/zgsm-ai/client-manager/storage"
 	"github.com/zgsm-ai/client-manager/utils"
 )
 
 // AppContext holds all the core application objects
 type AppContext struct {
-	DB         *gorm.DB
-	Logger     *logrus.Logger
-	LogDAO     *dao.LogDAO
-	LogService *LogService
+	DB                   *gorm.DB
+	Logger               *logrus.Logger
+	Storage              storage.Storage
+	LogDAO               *dao.LogDAO
+	LogService           *LogService
+	ConfigurationDAO     *dao.ConfigurationDAO
+	ConfigurationService *ConfigurationService
+	FeedbackDAO          *dao.FeedbackDAO
+	FeedbackService      *FeedbackService
 }
 
 // InitializeApp initializes all core application objects and returns AppContext
@@ -29,11 +35,13 @@ type AppContext struct {
  * @description
  * - Initializes database connection
  * - Initializes Prometheus metrics
+ * - Initializes the storage of the uploaded files
  * - Creates all DAO objects
  * - Creates all service objects
  * - Creates all controller objects
  * @throws
  * - Database initialization error
+ * - Storage configuration error
  */
 func InitializeApp() (*AppContext, error) {
 	// Initialize logger
@@ -51,18 +59,40 @@ func InitializeApp() (*AppContext, error) {
 	// Initialize Prometheus metrics
 	internal.InitMetrics()
 
+	// Initialize storage
+	store, err := storage.New(internal.GetStorageConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %v", err)
+	}
+
 	// Initialize DAOs
 	logDAO := dao.NewLogDAO(db, logger)
+	configurationDAO := dao.NewConfigurationDAO(db, logger)
+	feedbackDAO := dao.NewFeedbackDAO(db, logger)
 
 	// Initialize services
-	logService := NewLogService(logDAO, logger)
+	logService := NewLogService(logDAO, store, LogLimits{
+		MaxSize:      internal.GetLogMaxSize(),
+		AllowedTypes: internal.GetLogAllowedTypes(),
+	}, logger)
+	configurationService := NewConfigurationService(configurationDAO, logger)
+	feedbackService := NewFeedbackService(feedbackDAO, logger)
+
+	if internal.GetAdminToken() == "" {
+		logger.Warn("admin.token is not set, the admin APIs are disabled")
+	}
 
 	// Create and return app context
 	appContext := &AppContext{
-		DB:         db,
-		Logger:     logger,
-		LogDAO:     logDAO,
-		LogService: logService,
+		DB:                   db,
+		Logger:               logger,
+		Storage:              store,
+		LogDAO:               logDAO,
+		LogService:           logService,
+		ConfigurationDAO:     configurationDAO,
+		ConfigurationService: configurationService,
+		FeedbackDAO:          feedbackDAO,
+		FeedbackService:      feedbackService,
 	}
 
 	return appContext, nil
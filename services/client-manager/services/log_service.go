@@ -1,28 +1,60 @@
 package services
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
-	"path/filepath"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 
 	"github.com/zgsm-ai/client-manager/dao"
 	"github.com/zgsm-ai/client-manager/models"
+	"github.com/zgsm-ai/client-manager/storage"
+	"github.com/zgsm-ai/client-manager/utils"
 )
 
+// sniffLen 检测内容类型读取的字节数
+const sniffLen = 512
+
+// retentionBatch 保留期清理每批处理的日志数
+const retentionBatch = 100
+
 /**
  * LogService handles business logic for log operations
  * @description
  * - Implements log processing business rules
  * - Validates log data
  * - Handles different log types
+ * - Stores the uploaded files compressed, and deletes them after the retention period
  */
 type LogService struct {
 	logDAO *dao.LogDAO
+	store  storage.Storage
+	limits LogLimits
 	log    *logrus.Logger
 }
 
+/**
+ * LogLimits restricts the uploaded log files
+ * @description
+ * - MaxSize is the maximum size of a file, in bytes, 0 for no limit
+ * - AllowedTypes are the accepted content types, as detected from the file content
+ */
+type LogLimits struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
 type UploadLogArgs struct {
 	ClientID    string `json:"client_id"`
 	UserID      string `json:"user_id"`
@@ -60,30 +92,42 @@ type Paginated struct {
 /**
  * NewLogService creates a new LogService instance
  * @param {dao.LogDAO} logDAO - Log data access object
+ * @param {storage.Storage} store - Storage of the uploaded files
+ * @param {LogLimits} limits - Restrictions of the uploaded files
  * @param {logrus.Logger} log - Logger instance
  * @returns {*LogService} New LogService instance
  */
-func NewLogService(logDAO *dao.LogDAO, log *logrus.Logger) *LogService {
+func NewLogService(logDAO *dao.LogDAO, store storage.Storage, limits LogLimits, log *logrus.Logger) *LogService {
 	return &LogService{
 		logDAO: logDAO,
+		store:  store,
+		limits: limits,
 		log:    log,
 	}
 }
 
+// MaxSize returns the maximum size of an uploaded log file, 0 for no limit
+func (s *LogService) MaxSize() int64 {
+	return s.limits.MaxSize
+}
+
 /**
- * CreateLog creates a new log record
+ * UploadLog stores an uploaded log file and records it
  * @param {context.Context} ctx - Context for request cancellation
- * @param {map[string]interface{}} data - Log data
+ * @param {*UploadLogArgs} args - Log data
+ * @param {io.Reader} r - File content
  * @returns {*models.Log, error} Created log and error if any
  * @description
- * - Validates log data
- * - Creates log record
- * - Logs creation operation
+ * - Validates log data, file size and content type
+ * - Stores the file gzipped, files uploaded gzipped are stored as is
+ * - Replaces the previous file of the same client and file name
  * @throws
  * - Validation errors for invalid data
- * - Database creation errors
+ * - TooLargeError for files above the size limit
+ * - UnsupportedMediaTypeError for files of a forbidden content type
+ * - Storage and database errors
  */
-func (s *LogService) CreateLog(ctx context.Context, args *UploadLogArgs) (*models.Log, error) {
+func (s *LogService) UploadLog(ctx context.Context, args *UploadLogArgs, r io.Reader) (*models.Log, error) {
 	// Validate and extract log data
 	err := s.validate(args)
 	if err != nil {
@@ -95,17 +139,48 @@ func (s *LogService) CreateLog(ctx context.Context, args *UploadLogArgs) (*model
 		return nil, err
 	}
 
-	// Create log
+	// Compress the file into a temporary file, the storage needs its size
+	tmp, size, err := s.compress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	stored, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s.%d.gz", args.ClientID, args.FileName, time.Now().UnixNano())
+	if err := s.store.Put(ctx, key, tmp, stored); err != nil {
+		s.log.WithError(err).WithField("key", key).Error("Failed to store log file")
+		return nil, err
+	}
+
+	old, err := s.logDAO.GetLog(ctx, args.ClientID, args.FileName)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.store.Delete(ctx, key)
+		return nil, err
+	}
 	log := &models.Log{
-		ClientID:  args.ClientID,
-		UserID:    args.UserID,
-		FileName:  args.FileName,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ClientID:    args.ClientID,
+		UserID:      args.UserID,
+		FileName:    args.FileName,
+		FirstLineNo: args.FirstLineNo,
+		LastLineNo:  args.LastLineNo,
+		StorageKey:  key,
+		Size:        size,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if old != nil {
+		log.CreatedAt = old.CreatedAt
 	}
-	// Create log
 	err = s.logDAO.Upsert(ctx, log)
 	if err != nil {
+		s.store.Delete(ctx, key)
 		s.log.WithError(err).WithFields(logrus.Fields{
 			"client_id": log.ClientID,
 			"user_id":   log.UserID,
@@ -113,49 +188,202 @@ func (s *LogService) CreateLog(ctx context.Context, args *UploadLogArgs) (*model
 		}).Error("Failed to create log")
 		return nil, err
 	}
+	if old != nil {
+		s.deleteFile(ctx, old)
+	}
 
 	s.log.WithFields(logrus.Fields{
 		"client_id": log.ClientID,
 		"user_id":   log.UserID,
 		"file_name": log.FileName,
+		"size":      size,
+		"stored":    stored,
 	}).Info("Log created successfully")
 
 	return log, nil
 }
 
+// compress checks the size and content type of r, and writes it gzipped into a temporary file
+func (s *LogService) compress(r io.Reader) (tmp *os.File, size int64, err error) {
+	if s.limits.MaxSize > 0 {
+		r = io.LimitReader(r, s.limits.MaxSize+1)
+	}
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if !utils.ContainsString(s.limits.AllowedTypes, contentType) {
+		return nil, 0, &UnsupportedMediaTypeError{
+			ContentType: contentType,
+			Message:     fmt.Sprintf("content type %s is not allowed, expected one of %s", contentType, strings.Join(s.limits.AllowedTypes, ", ")),
+		}
+	}
+
+	tmp, err = os.CreateTemp("", "log-upload-*.gz")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			tmp = nil
+		}
+	}()
+	if contentType == "application/x-gzip" {
+		size, err = io.Copy(tmp, br)
+	} else {
+		zw := gzip.NewWriter(tmp)
+		size, err = io.Copy(zw, br)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return
+	}
+	if s.limits.MaxSize > 0 && size > s.limits.MaxSize {
+		err = &TooLargeError{Message: fmt.Sprintf("log file exceeds the size limit of %d bytes", s.limits.MaxSize)}
+	}
+	return
+}
+
+// storageKey returns the key of the file of a log, and whether the file is gzipped
+func storageKey(log *models.Log) (string, bool) {
+	if log.StorageKey == "" {
+		// 旧版本直接按原文件名保存在本地存储中
+		return log.ClientID + "/" + log.FileName, false
+	}
+	return log.StorageKey, true
+}
+
+// deleteFile deletes the stored file of a log, failures are only logged
+func (s *LogService) deleteFile(ctx context.Context, log *models.Log) {
+	key, _ := storageKey(log)
+	if err := s.store.Delete(ctx, key); err != nil {
+		s.log.WithError(err).WithField("key", key).Error("Failed to delete log file")
+	}
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying file
+type gzipReadCloser struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+func (s *LogService) getLog(ctx context.Context, clientID, fname string) (*models.Log, error) {
+	if clientID == "" {
+		return nil, &ValidationError{Field: "client_id", Message: "client_id is required"}
+	}
+	if fname == "" {
+		return nil, &ValidationError{Field: "file_name", Message: "file_name is required"}
+	}
+	log, err := s.logDAO.GetLog(ctx, clientID, fname)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &NotFoundError{Message: fmt.Sprintf("log %s of client %s not found", fname, clientID)}
+	}
+	if err != nil {
+		s.log.WithError(err).WithFields(logrus.Fields{
+			"client_id": clientID,
+			"file_name": fname,
+		}).Error("Failed to get logs by client")
+		return nil, err
+	}
+	return log, nil
+}
+
 /**
- * GetLogs retrieves logs for a specific client
+ * GetLogs opens the log file of a client
  * @param {context.Context} ctx - Context for request cancellation
  * @param {string} clientID - Client identifier
- * @param {int} page - Page number
- * @param {int} pageSize - Number of items per page
- * @returns {map[string]interface{}, error} Response containing logs and pagination info
+ * @param {string} fname - File name
+ * @returns {io.ReadCloser, error} Decompressed content of the file
  * @description
- * - Validates client ID and pagination parameters
- * - Retrieves logs from database
- * - Returns structured response with pagination metadata
+ * - Validates client ID and file name
+ * - Retrieves the log from database, then its file from the storage
  * @throws
  * - Validation errors for invalid parameters
- * - Database query errors
+ * - Not found errors for unknown logs or missing files
+ * - Database and storage errors
  */
-func (s *LogService) GetLogs(ctx context.Context, clientID, fname string) (string, error) {
-	if clientID == "" {
-		return "", &ValidationError{Field: "client_id", Message: "client_id is required"}
+func (s *LogService) GetLogs(ctx context.Context, clientID, fname string) (io.ReadCloser, error) {
+	log, err := s.getLog(ctx, clientID, fname)
+	if err != nil {
+		return nil, err
 	}
-	if fname == "" {
-		return "", &ValidationError{Field: "file_name", Message: "file_name is required"}
+	key, compressed := storageKey(log)
+	f, err := s.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, &NotFoundError{Message: fmt.Sprintf("file of log %s of client %s not found", fname, clientID)}
+	}
+	if err != nil {
+		s.log.WithError(err).WithField("key", key).Error("Failed to open log file")
+		return nil, err
+	}
+	if !compressed {
+		return f, nil
 	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: zr, file: f}, nil
+}
 
-	_, _, err := s.logDAO.ListLogs(ctx, clientID, "", fname, 1, 10)
+/**
+ * GetLogURL returns a signed URL downloading the stored file of a log
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {string} clientID - Client identifier
+ * @param {string} fname - File name
+ * @param {time.Duration} expires - Validity of the URL
+ * @returns {string, error} Signed URL, the file is downloaded gzipped (.gz)
+ */
+func (s *LogService) GetLogURL(ctx context.Context, clientID, fname string, expires time.Duration) (string, error) {
+	log, err := s.getLog(ctx, clientID, fname)
 	if err != nil {
-		s.log.WithError(err).WithFields(logrus.Fields{
-			"client_id": clientID,
-			"file_name": fname,
-		}).Error("Failed to get logs by client")
 		return "", err
 	}
+	key, compressed := storageKey(log)
+	if compressed {
+		fname += ".gz"
+	}
+	return s.store.SignedURL(key, fname, expires)
+}
 
-	return filepath.Join("/data", clientID, fname), nil
+/**
+ * OpenSignedURL opens the file of a signed URL of the local storage
+ * @param {context.Context} ctx - Context for request cancellation
+ * @param {url.Values} q - Query of the signed URL
+ * @returns {io.ReadCloser, string, error} Stored file and its download name
+ * @throws
+ * - Forbidden errors for invalid or expired URLs
+ * - Not found errors for missing files, or when the storage isn't local
+ */
+func (s *LogService) OpenSignedURL(ctx context.Context, q url.Values) (io.ReadCloser, string, error) {
+	ls, ok := s.store.(*storage.LocalStorage)
+	if !ok {
+		return nil, "", &NotFoundError{Message: "downloads are served by the storage"}
+	}
+	key, fname, err := ls.Verify(q)
+	if err != nil {
+		return nil, "", &ForbiddenError{Message: err.Error()}
+	}
+	f, err := ls.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "", &NotFoundError{Message: "file not found"}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return f, path.Base(fname), nil
 }
 
 func (s *LogService) ListLogs(ctx context.Context, args *ListLogsArgs) (logs []models.Log, paging Paginated, err error) {
@@ -195,7 +423,7 @@ func (s *LogService) ListLogs(ctx context.Context, args *ListLogsArgs) (logs []m
  * @returns {int64, error} Number of deleted records and error if any
  * @description
  * - Validates date parameter
- * - Performs cleanup of old log records
+ * - Deletes the log records last updated before the date, and their files
  * - Returns count of deleted records
  * @throws
  * - Validation errors for invalid date
@@ -206,12 +434,16 @@ func (s *LogService) DeleteOldLogs(ctx context.Context, beforeDate string) (int6
 	if beforeDate == "" {
 		return 0, &ValidationError{Field: "before_date", Message: "before_date is required"}
 	}
+	before, err := time.ParseInLocation("2006-01-02", beforeDate, time.Local)
+	if err != nil {
+		return 0, &ValidationError{Field: "before_date", Message: "before_date must be a YYYY-MM-DD date"}
+	}
 
 	// Delete old logs
-	count, err := s.logDAO.DeleteOldLogs(ctx, beforeDate)
+	count, err := s.deleteLogsBefore(ctx, before)
 	if err != nil {
 		s.log.WithError(err).WithField("before_date", beforeDate).Error("Failed to delete old logs")
-		return 0, err
+		return count, err
 	}
 
 	s.log.WithFields(logrus.Fields{
@@ -222,6 +454,59 @@ func (s *LogService) DeleteOldLogs(ctx context.Context, beforeDate string) (int6
 	return count, nil
 }
 
+// deleteLogsBefore deletes the logs last updated before a time, the record then the file
+func (s *LogService) deleteLogsBefore(ctx context.Context, before time.Time) (int64, error) {
+	var count int64
+	for {
+		logs, err := s.logDAO.ListLogsBefore(ctx, before, retentionBatch)
+		if err != nil {
+			return count, err
+		}
+		for i := range logs {
+			// 先删记录，保证记录引用的文件都存在；删除失败的文件成为孤儿文件
+			deleted, err := s.logDAO.DeleteLogBefore(ctx, logs[i].ID, before)
+			if err != nil {
+				return count, err
+			}
+			if deleted {
+				s.deleteFile(ctx, &logs[i])
+				count++
+			}
+		}
+		if len(logs) < retentionBatch {
+			return count, nil
+		}
+	}
+}
+
+/**
+ * RunRetention deletes the expired logs periodically, until ctx is done
+ * @param {context.Context} ctx - Context stopping the job
+ * @param {time.Duration} retention - Time the logs are kept after their last upload, 0 to keep them
+ * @param {time.Duration} interval - Interval of the deletions, the first one runs at once
+ */
+func (s *LogService) RunRetention(ctx context.Context, retention, interval time.Duration) {
+	if retention <= 0 {
+		s.log.Info("Log retention is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := s.deleteLogsBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			s.log.WithError(err).Error("Failed to delete expired logs")
+		} else if count > 0 {
+			s.log.WithField("deleted_count", count).Info("Expired logs deleted successfully")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 /**
  * validateAndExtractLog validates and extracts log data
  * @param {map[string]interface{}} data - Log data
@@ -243,6 +528,18 @@ func (s *LogService) validate(args *UploadLogArgs) error {
 	if args.FileName == "" {
 		return &ValidationError{Field: "file_name", Message: "file_name is required and must be a string"}
 	}
+	// 二者组成存储的键，不能包含路径
+	if !isPlainName(args.ClientID) {
+		return &ValidationError{Field: "client_id", Message: "client_id must not contain path separators"}
+	}
+	if !isPlainName(args.FileName) {
+		return &ValidationError{Field: "file_name", Message: "file_name must not contain path separators"}
+	}
 
 	return nil
 }
+
+// isPlainName reports whether name can be used as a path element
+func isPlainName(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/zgsm-ai/client-manager/dao"
+	"github.com/zgsm-ai/client-manager/models"
+	"github.com/zgsm-ai/client-manager/storage"
+)
+
+func newTestLogService(t *testing.T) (*LogService, *gorm.DB, *storage.LocalStorage) {
+	db := newTestDB(t)
+	store, err := storage.NewLocalStorage(t.TempDir(), "https://host/download", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := LogLimits{MaxSize: 1024, AllowedTypes: []string{"text/plain", "application/x-gzip"}}
+	return NewLogService(dao.NewLogDAO(db, newTestLogger()), store, limits, newTestLogger()), db, store
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func uploadLog(s *LogService, clientID, fileName string, content []byte) (*models.Log, error) {
+	args := &UploadLogArgs{ClientID: clientID, UserID: "u1", FileName: fileName, FirstLineNo: 1, LastLineNo: 2}
+	return s.UploadLog(context.Background(), args, bytes.NewReader(content))
+}
+
+func readAll(t *testing.T, r io.ReadCloser) string {
+	t.Helper()
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUploadLog(t *testing.T) {
+	s, _, store := newTestLogService(t)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr string
+	}{
+		{name: "plain text", content: []byte("line 1\nline 2\n"), want: "line 1\nline 2\n"},
+		{name: "gzipped", content: gzipped(t, "line 1\n"), want: "line 1\n"},
+		{name: "forbidden type", content: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), wantErr: "media"},
+		{name: "too large", content: []byte(strings.Repeat("x", 1025)), wantErr: "large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := uploadLog(s, "c1", "app.log", tt.content)
+			switch tt.wantErr {
+			case "media":
+				var e *UnsupportedMediaTypeError
+				if !errors.As(err, &e) {
+					t.Fatalf("expected an unsupported media type error, got %v", err)
+				}
+				return
+			case "large":
+				var e *TooLargeError
+				if !errors.As(err, &e) {
+					t.Fatalf("expected a too large error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 存储的文件是压缩的，读取时解压
+			f, err := store.Get(context.Background(), log.StorageKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				t.Fatal(err)
+			}
+			if got := readAll(t, &gzipReadCloser{Reader: zr, file: f}); got != tt.want {
+				t.Fatalf("stored %q, want %q", got, tt.want)
+			}
+			r, err := s.GetLogs(context.Background(), "c1", "app.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readAll(t, r); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadLogReplacesFile(t *testing.T) {
+	s, _, store := newTestLogService(t)
+	first, err := uploadLog(s, "c1", "app.log", []byte("first\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := uploadLog(s, "c1", "app.log", []byte("second\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.StorageKey == second.StorageKey {
+		t.Fatal("expected a new storage key")
+	}
+	if _, err := store.Get(context.Background(), first.StorageKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected the previous file to be deleted, got %v", err)
+	}
+}
+
+func TestDeleteLogsBefore(t *testing.T) {
+	s, db, store := newTestLogService(t)
+	old, err := uploadLog(s, "c1", "old.log", []byte("old\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := uploadLog(s, "c1", "recent.log", []byte("recent\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&models.Log{}).Where("id = ?", old.ID).UpdateColumn("updated_at", time.Now().Add(-48*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := s.deleteLogsBefore(context.Background(), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 deleted log, got %d", count)
+	}
+	if _, err := s.GetLogs(context.Background(), "c1", "old.log"); !isNotFound(err) {
+		t.Fatalf("expected the old log to be deleted, got %v", err)
+	}
+	if _, err := store.Get(context.Background(), old.StorageKey); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected the old file to be deleted, got %v", err)
+	}
+	r, err := s.GetLogs(context.Background(), "c1", "recent.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, r); got != "recent\n" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := store.Get(context.Background(), recent.StorageKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.DeleteOldLogs(context.Background(), "2026/01/01"); errorType(err) != "validation" {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+func TestOpenSignedURL(t *testing.T) {
+	s, _, _ := newTestLogService(t)
+	if _, err := uploadLog(s, "c1", "app.log", []byte("line 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.GetLogURL(context.Background(), "c1", "app.log", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, name, err := s.OpenSignedURL(context.Background(), u.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	if got := readAll(t, &gzipReadCloser{Reader: zr, file: f}); got != "line 1\n" || name != "app.log.gz" {
+		t.Fatalf("got %q as %s", got, name)
+	}
+
+	q := u.Query()
+	q.Set("key", "c2/"+strings.TrimPrefix(q.Get("key"), "c1/"))
+	var forbidden *ForbiddenError
+	if _, _, err := s.OpenSignedURL(context.Background(), q); !errors.As(err, &forbidden) {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+/**
+ * LocalStorage stores the objects as files under a root directory
+ * @description
+ * - Files are written to a temporary file, then renamed, so readers never see partial files
+ * - Signed URLs point to the download endpoint of the service, which calls Verify
+ */
+type LocalStorage struct {
+	root      string
+	urlPrefix string
+	signKey   []byte
+}
+
+// signKeyFile 未配置签名密钥时，生成的密钥保存在根目录下的这个文件中
+const signKeyFile = ".sign_key"
+
+/**
+ * NewLocalStorage creates a local disk storage
+ * @param {string} root - Root directory of the objects
+ * @param {string} urlPrefix - URL of the download endpoint, for signed URLs
+ * @param {string} signKey - Key signing the URLs, read from <root>/.sign_key if empty
+ * @returns {*LocalStorage, error} Local storage
+ */
+func NewLocalStorage(root, urlPrefix, signKey string) (*LocalStorage, error) {
+	if root == "" {
+		return nil, errors.New("storage path is required")
+	}
+	key := []byte(signKey)
+	if len(key) == 0 {
+		var err error
+		if key, err = loadSignKey(filepath.Join(root, signKeyFile)); err != nil {
+			return nil, fmt.Errorf("load sign key: %w", err)
+		}
+	}
+	return &LocalStorage{root: root, urlPrefix: urlPrefix, signKey: key}, nil
+}
+
+/**
+ * loadSignKey reads the key signing the URLs from a file, creating it with a random key if missing
+ * @param {string} name - Key file
+ * @returns {[]byte, error} Key, the URLs stay valid across restarts and instances sharing the directory
+ */
+func loadSignKey(name string) ([]byte, error) {
+	key, err := os.ReadFile(name)
+	if err == nil {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%s is empty", name)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key = []byte(hex.EncodeToString(b))
+	// 写完整的临时文件后再链接，其它实例不会读到不完整的密钥
+	f, err := os.CreateTemp(filepath.Dir(name), ".sign_key-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Link(f.Name(), name); errors.Is(err, os.ErrExist) {
+		// 其它实例同时创建了密钥
+		return os.ReadFile(name)
+	} else if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (ls *LocalStorage) path(key string) string {
+	return filepath.Join(ls.root, filepath.FromSlash(key))
+}
+
+func (ls *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	p := ls.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (ls *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(ls.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (ls *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(ls.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (ls *LocalStorage) signature(key, fileName, expires string) string {
+	mac := hmac.New(sha256.New, ls.signKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s", key, fileName, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ls *LocalStorage) SignedURL(key, fileName string, expires time.Duration) (string, error) {
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{}
+	q.Set("key", key)
+	q.Set("name", fileName)
+	q.Set("expires", exp)
+	q.Set("signature", ls.signature(key, fileName, exp))
+	return ls.urlPrefix + "?" + q.Encode(), nil
+}
+
+/**
+ * Verify checks the query of a signed URL
+ * @param {url.Values} q - Query of the URL
+ * @returns {string, string, error} Key and file name of the object
+ */
+func (ls *LocalStorage) Verify(q url.Values) (string, string, error) {
+	key, fileName, exp := q.Get("key"), q.Get("name"), q.Get("expires")
+	if !hmac.Equal([]byte(q.Get("signature")), []byte(ls.signature(key, fileName, exp))) {
+		return "", "", errors.New("invalid signature")
+	}
+	t, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > t {
+		return "", "", errors.New("expired URL")
+	}
+	return key, fileName, nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedQuery(t *testing.T, ls *LocalStorage, expires time.Duration) url.Values {
+	t.Helper()
+	s, err := ls.SignedURL("c1/app.log.1.gz", "app.log.gz", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(s, "https://host/download?") {
+		t.Fatalf("unexpected URL %s", s)
+	}
+	return u.Query()
+}
+
+func TestLocalStorageVerify(t *testing.T) {
+	ls, err := NewLocalStorage(t.TempDir(), "https://host/download", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewLocalStorage(t.TempDir(), "https://host/download", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		store   *LocalStorage
+		expires time.Duration
+		edit    func(q url.Values)
+		wantErr bool
+	}{
+		{name: "valid", store: ls, expires: time.Hour},
+		{name: "tampered signature", store: ls, expires: time.Hour, edit: func(q url.Values) { q.Set("signature", strings.Repeat("0", 64)) }, wantErr: true},
+		{name: "tampered key", store: ls, expires: time.Hour, edit: func(q url.Values) { q.Set("key", "c2/app.log.1.gz") }, wantErr: true},
+		{name: "tampered name", store: ls, expires: time.Hour, edit: func(q url.Values) { q.Set("name", "other.gz") }, wantErr: true},
+		{name: "tampered expires", store: ls, expires: -time.Minute, edit: func(q url.Values) { q.Set("expires", "9999999999") }, wantErr: true},
+		{name: "expired", store: ls, expires: -time.Minute, wantErr: true},
+		{name: "other key", store: other, expires: time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := signedQuery(t, tt.store, tt.expires)
+			if tt.edit != nil {
+				tt.edit(q)
+			}
+			key, name, err := ls.Verify(q)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key != "c1/app.log.1.gz" || name != "app.log.gz" {
+				t.Fatalf("unexpected key %s and name %s", key, name)
+			}
+		})
+	}
+}
+
+func TestLocalStorageSignKeyFile(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "logs")
+	ls, err := NewLocalStorage(root, "https://host/download", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile(filepath.Join(root, signKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 64 {
+		t.Fatalf("unexpected key length %d", len(key))
+	}
+
+	// 重启后，之前签名的URL仍然有效
+	restarted, err := NewLocalStorage(root, "https://host/download", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := restarted.Verify(signedQuery(t, ls, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, signKeyFile), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLocalStorage(root, "https://host/download", ""); err == nil {
+		t.Fatal("expected an error for an empty key file")
+	}
+}
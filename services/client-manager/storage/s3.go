@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+/**
+ * S3Config is the configuration of a S3/MinIO-compatible bucket
+ * @description
+ * - Endpoint is the URL of the service, like https://s3.us-east-1.amazonaws.com or http://minio:9000
+ * - PathStyle addresses the bucket in the path (MinIO), else in the host name (AWS)
+ */
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+/**
+ * S3Storage stores the objects in a S3/MinIO-compatible bucket
+ * @description
+ * - Requests are made and signed by the minio-go client
+ * - Signed URLs are presigned GET requests of the bucket
+ */
+type S3Storage struct {
+	bucket string
+	client *minio.Client
+}
+
+/**
+ * NewS3Storage creates a S3/MinIO-compatible storage
+ * @param {*S3Config} c - Bucket configuration
+ * @returns {*S3Storage, error} S3 storage
+ */
+func NewS3Storage(c *S3Config) (*S3Storage, error) {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and a bucket")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint '%s'", c.Endpoint)
+	}
+	if strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("s3 endpoint '%s' can't have a path", c.Endpoint)
+	}
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	lookup := minio.BucketLookupDNS
+	if c.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure:       u.Scheme == "https",
+		Region:       region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{bucket: c.Bucket, client: client}, nil
+}
+
+// notFound converts the missing object errors of the bucket to ErrNotFound
+func notFound(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: "application/gzip"})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	//GetObject is lazy, stat it to report missing objects now
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, notFound(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return notFound(s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3Storage) SignedURL(key, fileName string, expires time.Duration) (string, error) {
+	q := url.Values{}
+	if fileName != "" {
+		q.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucket, key, expires, q)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3StorageSignedURL(t *testing.T) {
+	s, err := NewS3Storage(&S3Config{
+		Endpoint:  "http://minio:9000",
+		Region:    "us-east-1",
+		Bucket:    "logs",
+		AccessKey: "ak",
+		SecretKey: "sk",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3URL, err := s.SignedURL("c1/app.log.1.gz", "app.log.gz", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "minio:9000" || u.Path != "/logs/c1/app.log.1.gz" {
+		t.Fatalf("unexpected URL %s", s3URL)
+	}
+	if q.Get("X-Amz-Signature") == "" || q.Get("X-Amz-Expires") != "3600" {
+		t.Fatalf("URL is not presigned: %s", s3URL)
+	}
+	if !strings.Contains(q.Get("response-content-disposition"), "app.log.gz") {
+		t.Fatalf("unexpected content disposition in %s", s3URL)
+	}
+
+	if _, err := NewS3Storage(&S3Config{Endpoint: "minio:9000/path", Bucket: "logs"}); err == nil {
+		t.Fatal("expected an error for an invalid endpoint")
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when an object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+/**
+ * Storage stores the uploaded files as objects
+ * @description
+ * - Keys are slash separated paths, like <client_id>/<file_name>
+ * - Implemented by the local disk and S3/MinIO-compatible backends
+ */
+type Storage interface {
+	// Put stores size bytes of r as object key, replacing it if it exists
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens object key, ErrNotFound if it doesn't exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete deletes object key, deleting a missing object isn't an error
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL downloading object key as fileName, valid for expires
+	SignedURL(key, fileName string, expires time.Duration) (string, error)
+}
+
+/**
+ * Config is the configuration of the storage
+ * @description
+ * - Type is local (default) or s3
+ * - The local backend serves its signed URLs from URLPrefix, signed with SignKey,
+ * or with the key kept in <Path>/.sign_key when empty
+ */
+type Config struct {
+	Type      string
+	Path      string
+	URLPrefix string
+	SignKey   string
+	S3        S3Config
+}
+
+/**
+ * New creates the storage backend described by c
+ * @param {*Config} c - Storage configuration
+ * @returns {Storage, error} Storage backend
+ */
+func New(c *Config) (Storage, error) {
+	switch c.Type {
+	case "", "local":
+		return NewLocalStorage(c.Path, c.URLPrefix, c.SignKey)
+	case "s3", "minio":
+		return NewS3Storage(&c.S3)
+	}
+	return nil, fmt.Errorf("unsupported storage type '%s'", c.Type)
+}
@@ -30,6 +30,8 @@ import (
 	_ "code-completion/pkg/logger"
 	"code-completion/pkg/model"
 	"code-completion/pkg/stream_controller"
+	"code-completion/pkg/trace"
+	"code-completion/pkg/usage"
 	"code-completion/server"
 
 	"github.com/gin-gonic/gin"
@@ -65,6 +67,16 @@ func PrintVersions() {
 }
 
 func main() {
+	// 子命令: 离线工具，不启动服务
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "refit-scores":
+			os.Exit(refitScores(os.Args[2:]))
+		case "replay":
+			os.Exit(replayCorpus(os.Args[2:]))
+		}
+	}
+
 	PrintVersions()
 	// 初始化时区设置，使程序能够识别容器的TZ环境变量
 	initTimeZone()
@@ -73,9 +85,15 @@ func main() {
 	var (
 		port = flag.String("port", "8080", "服务器端口")
 		mode = flag.String("mode", "release", "运行模式 (debug/release)")
+		conf = flag.String("config", config.ConfigFile, "配置文件")
 	)
 	flag.Parse()
 
+	if err := config.Init(*conf); err != nil {
+		fmt.Fprintf(os.Stderr, "加载配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
 	// 设置Gin运行模式
 	if *mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
@@ -85,6 +103,8 @@ func main() {
 
 	initModels()
 	initStreamController()
+	initConfigWatcher()
+	initUsage()
 
 	// 创建路由
 	r := server.SetupRouter()
@@ -98,6 +118,10 @@ func main() {
 		logger.Fatal("服务器运行失败", zap.Error(err))
 		os.Exit(1)
 	}
+	if err := usage.Default.Flush(); err != nil {
+		zap.L().Error("Save usage failed", zap.Error(err))
+	}
+	trace.Flush()
 }
 
 /**
@@ -192,11 +216,44 @@ func setFixedOffset(tz string) {
  */
 func initModels() {
 	zap.L().Info("Initialize model instances")
-	if err := model.Init(config.Config.Models); err != nil {
+	if err := model.Init(config.Get().Models); err != nil {
 		panic(err)
 	}
 }
 
+/**
+ * 启动配置文件监听
+ * @description
+ * - 配置文件变化后重新加载，校验通过的配置通过流控管理器立即生效
+ * - 配置admin.disableWatch为true时不监听
+ */
+func initConfigWatcher() {
+	if config.Get().Admin.DisableWatch {
+		return
+	}
+	config.Watch(config.ConfigFile, config.Get().Admin.WatchInterval, stream_controller.Controller.ApplyConfig)
+}
+
+/**
+ * 初始化用量统计
+ * @description
+ * - 加载usage.path中已有的统计数据，定期保存，服务退出时再保存一次
+ * - 统计数据文件无法解析时不覆盖它，只在内存中统计
+ */
+func initUsage() {
+	cfg := config.Get().Usage
+	if cfg.Disabled {
+		return
+	}
+	r, err := usage.NewRecorder(cfg.Path)
+	if err != nil {
+		zap.L().Error("Load usage failed, usage is not persisted", zap.String("path", cfg.Path), zap.Error(err))
+		r, _ = usage.NewRecorder("")
+	}
+	r.StartFlushRoutine(cfg.FlushInterval)
+	usage.Default = r
+}
+
 func initStreamController() {
 	zap.L().Info("Initialize the stream-controller")
 
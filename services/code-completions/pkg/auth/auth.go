@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"code-completion/pkg/config"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 鉴权方式
+const (
+	MethodNone   = "none"
+	MethodJWT    = "jwt"
+	MethodAPIKey = "apikey"
+)
+
+// 未启用鉴权时使用的用户名
+const Anonymous = "anonymous"
+
+// 校验exp/nbf时允许的时钟偏差
+const clockLeeway = 60 * time.Second
+
+// 请求者身份
+type Identity struct {
+	User   string `json:"user"`
+	Tenant string `json:"tenant,omitempty"`
+	Admin  bool   `json:"admin"`
+	Method string `json:"method"`
+}
+
+// 按JWKS地址缓存的公钥
+var (
+	keySetMutex sync.Mutex
+	keySets     = make(map[string]*keySet)
+)
+
+func getKeySet(url string) *keySet {
+	keySetMutex.Lock()
+	defer keySetMutex.Unlock()
+	s, ok := keySets[url]
+	if !ok {
+		s = &keySet{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+		keySets[url] = s
+	}
+	return s
+}
+
+/**
+ * 从请求头中取出凭据
+ * @param {http.Header} header - 请求头
+ * @returns {string} 返回Authorization: Bearer或X-API-Key中的凭据
+ */
+func Credential(header http.Header) string {
+	if v := header.Get("Authorization"); v != "" {
+		if len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+			return strings.TrimSpace(v[7:])
+		}
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(header.Get("X-API-Key"))
+}
+
+/**
+ * 验证凭据
+ * @param {*config.AuthConfig} cfg - 鉴权配置
+ * @param {string} credential - API密钥或JWT
+ * @returns {*Identity, error} 返回请求者身份
+ * @description
+ * - 先匹配静态API密钥(常量时间比较)，再按JWT验证
+ * - JWT使用JWKS中的公钥验证签名，支持RS256/384/512和ES256/384/512
+ * - 必须有exp，校验exp、nbf(允许60秒偏差)，配置了issuer/audience时校验iss/aud
+ */
+func Authenticate(cfg *config.AuthConfig, credential string) (*Identity, error) {
+	if credential == "" {
+		return nil, fmt.Errorf("missing credential")
+	}
+	for _, k := range cfg.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(k.Key)) == 1 {
+			return &Identity{User: k.User, Tenant: k.Tenant, Admin: k.Admin, Method: MethodAPIKey}, nil
+		}
+	}
+	if strings.Count(credential, ".") != 2 {
+		return nil, fmt.Errorf("invalid api key")
+	}
+	if cfg.JwksUrl == "" {
+		return nil, fmt.Errorf("jwt is not accepted")
+	}
+	claims, err := verifyJWT(cfg, credential)
+	if err != nil {
+		return nil, err
+	}
+	return identityFromClaims(cfg, claims)
+}
+
+// 接受的JWT签名算法，拒绝none和HMAC
+var validMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+/**
+ * 验证JWT
+ * @param {*config.AuthConfig} cfg - 鉴权配置
+ * @param {string} token - JWT
+ * @returns {jwt.MapClaims, error} 返回JWT中的声明
+ * @description
+ * - 按JWT头中的kid从JWKS缓存中取公钥，公钥声明了alg时必须与JWT的算法一致
+ */
+func verifyJWT(cfg *config.AuthConfig, token string) (jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := getKeySet(cfg.JwksUrl).get(kid, cfg.JwksRefresh)
+		if err != nil {
+			return nil, err
+		}
+		if key.Algorithm != "" && key.Algorithm != t.Method.Alg() {
+			return nil, fmt.Errorf("key '%s' does not accept algorithm '%s'", kid, t.Method.Alg())
+		}
+		return key.Key, nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func identityFromClaims(cfg *config.AuthConfig, claims jwt.MapClaims) (*Identity, error) {
+	claim := cfg.UserClaim
+	if claim == "" {
+		claim = "name"
+	}
+	user, _ := claims[claim].(string)
+	if user == "" {
+		user, _ = claims.GetSubject()
+	}
+	if user == "" {
+		return nil, fmt.Errorf("token has no user")
+	}
+	tenant, _ := claims["owner"].(string)
+	isAdmin, _ := claims["isAdmin"].(bool)
+	return &Identity{
+		User:   user,
+		Tenant: tenant,
+		Admin:  isAdmin || slices.Contains(cfg.AdminUsers, user),
+		Method: MethodJWT,
+	}, nil
+}
+
+// 请求上下文中保存请求者身份的键
+const ContextKey = "auth.identity"
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"code-completion/pkg/config"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signWith(t *testing.T, method jwt.SigningMethod, key any, kid string, claims map[string]any) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims(claims))
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	return signWith(t, jwt.SigningMethodRS256, key, kid, claims)
+}
+
+func serveJWKS(t *testing.T, keys ...map[string]string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAuthenticate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveJWKS(t, map[string]string{
+		"kid": "k1",
+		"kty": "RSA",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	})
+
+	cfg := &config.AuthConfig{
+		Enabled:     true,
+		Issuer:      "https://casdoor.example.com",
+		JwksUrl:     srv.URL,
+		Audience:    "code-completion",
+		JwksRefresh: time.Hour,
+		UserClaim:   "name",
+		AdminUsers:  []string{"bob"},
+		APIKeys:     []config.APIKeyConfig{{Key: "sk-test", User: "ci", Tenant: "built-in"}},
+	}
+	valid := map[string]any{
+		"iss":   "https://casdoor.example.com",
+		"aud":   []string{"code-completion"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"name":  "alice",
+		"owner": "built-in",
+	}
+	with := func(k string, v any) map[string]any {
+		c := make(map[string]any)
+		for kk, vv := range valid {
+			c[kk] = vv
+		}
+		c[k] = v
+		return c
+	}
+
+	id, err := Authenticate(cfg, sign(t, key, "k1", valid))
+	if err != nil || id.User != "alice" || id.Tenant != "built-in" || id.Admin || id.Method != MethodJWT {
+		t.Fatalf("valid token: %+v, %v", id, err)
+	}
+	if id, err = Authenticate(cfg, sign(t, key, "k1", with("name", "bob"))); err != nil || !id.Admin {
+		t.Fatalf("admin user: %+v, %v", id, err)
+	}
+	if id, err = Authenticate(cfg, "sk-test"); err != nil || id.User != "ci" || id.Method != MethodAPIKey {
+		t.Fatalf("api key: %+v, %v", id, err)
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	invalid := map[string]string{
+		"expired":        sign(t, key, "k1", with("exp", time.Now().Add(-time.Hour).Unix())),
+		"wrong issuer":   sign(t, key, "k1", with("iss", "https://evil.example.com")),
+		"wrong audience": sign(t, key, "k1", with("aud", "other")),
+		"wrong key":      sign(t, other, "k1", valid),
+		"no expiry":      sign(t, key, "k1", with("exp", nil)),
+		"wrong alg":      signWith(t, jwt.SigningMethodRS384, key, "k1", valid),
+		"alg none":       signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "k1", valid),
+		"unknown kid":    sign(t, key, "k2", valid),
+		"unknown key":    "sk-unknown",
+		"empty":          "",
+	}
+	for name, token := range invalid {
+		if id, err := Authenticate(cfg, token); err == nil {
+			t.Errorf("%s: expected error, got %+v", name, id)
+		}
+	}
+}
+
+func TestCredential(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "bearer abc")
+	if got := Credential(h); got != "abc" {
+		t.Errorf("bearer: got %q", got)
+	}
+	h = http.Header{}
+	h.Set("X-API-Key", "sk-1")
+	if got := Credential(h); got != "sk-1" {
+		t.Errorf("x-api-key: got %q", got)
+	}
+}
+
+func TestAuthenticateECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveJWKS(t, map[string]string{
+		"kid": "e1",
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	})
+	cfg := &config.AuthConfig{Enabled: true, JwksUrl: srv.URL, JwksRefresh: time.Hour}
+	claims := map[string]any{"exp": time.Now().Add(time.Hour).Unix(), "sub": "alice"}
+
+	if id, err := Authenticate(cfg, signWith(t, jwt.SigningMethodES256, key, "e1", claims)); err != nil || id.User != "alice" {
+		t.Fatalf("valid token: %+v, %v", id, err)
+	}
+	// 公钥不能当作HMAC密钥，P-256公钥也不能验证ES384
+	hmacKey, _ := json.Marshal(map[string]string{"x": key.X.String()})
+	other, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	invalid := map[string]string{
+		"hmac":  signWith(t, jwt.SigningMethodHS256, hmacKey, "e1", claims),
+		"es384": signWith(t, jwt.SigningMethodES384, other, "e1", claims),
+	}
+	for name, token := range invalid {
+		if id, err := Authenticate(cfg, token); err == nil {
+			t.Errorf("%s: expected error, got %+v", name, id)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// 未知kid触发刷新的最小间隔，防止伪造的kid导致频繁请求JWKS
+const minRefreshInterval = 30 * time.Second
+
+// JWKS公钥缓存，按kid索引
+type keySet struct {
+	url     string
+	client  *http.Client
+	mutex   sync.Mutex
+	keys    map[string]*jose.JSONWebKey
+	fetched time.Time
+	tried   time.Time
+}
+
+/**
+ * 获取kid对应的公钥
+ * @param {string} kid - JWT头中的kid，为空时只有一个公钥才能匹配
+ * @param {time.Duration} refresh - 刷新间隔
+ * @returns {*jose.JSONWebKey, error} 返回公钥
+ * @description
+ * - 超过刷新间隔或kid不存在时重新拉取JWKS，拉取失败时沿用旧的公钥
+ */
+func (s *keySet) get(kid string, refresh time.Duration) (*jose.JSONWebKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key, ok := s.lookup(kid)
+	if (!ok || time.Since(s.fetched) > refresh) && time.Since(s.tried) > minRefreshInterval {
+		s.tried = time.Now()
+		keys, err := s.fetch()
+		if err != nil && !ok {
+			return nil, err
+		}
+		if err == nil {
+			s.keys = keys
+			s.fetched = time.Now()
+			key, ok = s.lookup(kid)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	return key, nil
+}
+
+func (s *keySet) lookup(kid string) (*jose.JSONWebKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (s *keySet) fetch() (map[string]*jose.JSONWebKey, error) {
+	rsp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %v", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: status %d", rsp.StatusCode)
+	}
+	var body struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode jwks: %v", err)
+	}
+	keys := make(map[string]*jose.JSONWebKey, len(body.Keys))
+	for _, raw := range body.Keys {
+		// 逐个解析，忽略不支持或无效的公钥，以及非签名用途的公钥
+		var k jose.JSONWebKey
+		if err := k.UnmarshalJSON(raw); err != nil {
+			continue
+		}
+		if !k.IsPublic() || !k.Valid() || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		keys[k.KeyID] = &k
+	}
+	return keys, nil
+}
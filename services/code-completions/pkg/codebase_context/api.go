@@ -3,6 +3,7 @@ package codebase_context
 import (
 	"bytes"
 	"code-completion/pkg/config"
+	"code-completion/pkg/trace"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -27,7 +28,7 @@ type APIClient struct {
 func NewAPIClient() *APIClient {
 	return &APIClient{
 		client: &http.Client{
-			Timeout: config.Context.RequestTimeout,
+			Timeout: config.Get().Context.RequestTimeout,
 		},
 	}
 }
@@ -120,6 +121,7 @@ func (c *APIClient) DoRequest(ctx context.Context, requestURL string, params Req
 	req.Header.Set("Authorization", headers.Get("Authorization"))
 	req.Header.Set("X-Costrict-Version", headers.Get("X-Costrict-Version"))
 	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(ctx, req.Header)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -0,0 +1,322 @@
+package codebase_context
+
+import (
+	"sort"
+	"strings"
+)
+
+// 上下文来源
+const (
+	SourceDefinition = "definition"
+	SourceSemantic   = "semantic"
+	SourceRelation   = "relation"
+	SourceLocal      = "local"
+)
+
+// ContextItem 一个候选的上下文代码片段
+type ContextItem struct {
+	Source   string  `json:"source"`         // 来源: definition/semantic/relation/local
+	Name     string  `json:"name,omitempty"` // 符号名(定义检索)
+	FilePath string  `json:"file_path"`      // 片段所在文件
+	Content  string  `json:"content"`        // 代码内容
+	Score    float64 `json:"score"`          // 来源给出的相关性分数，不同来源的分数不可直接比较
+}
+
+// 拼接后的文本，与GetContext的格式一致: 文件路径一行，然后是代码
+func (it *ContextItem) text() string {
+	return it.FilePath + "\n" + it.Content
+}
+
+// 常见的关键字和类型名，不作为片段的标识符
+var commonWords = map[string]bool{
+	"func": true, "function": true, "def": true, "class": true, "struct": true, "interface": true,
+	"type": true, "return": true, "if": true, "else": true, "for": true, "while": true,
+	"var": true, "let": true, "const": true, "public": true, "private": true, "protected": true,
+	"static": true, "void": true, "int": true, "string": true, "bool": true, "true": true,
+	"false": true, "nil": true, "null": true, "none": true, "self": true, "this": true,
+	"import": true, "from": true, "package": true, "new": true, "err": true, "error": true,
+}
+
+/**
+ * 片段的标识符，用于计算与光标的距离
+ * @returns {[]string} 定义检索返回符号名，其他来源返回片段第一个非空行(通常是签名)中的标识符
+ */
+func (it *ContextItem) keys() []string {
+	line := it.Name
+	if line == "" {
+		for _, l := range strings.Split(it.Content, "\n") {
+			if strings.TrimSpace(l) != "" {
+				line = l
+				break
+			}
+		}
+	}
+	var keys []string
+	for _, t := range tokenize(line) {
+		if !commonWords[t] {
+			keys = append(keys, t)
+		}
+	}
+	return keys
+}
+
+// 把上下文片段拼接为注释形式的上下文
+func renderContextItems(items []*ContextItem, filePath string) string {
+	var allCodes []string
+	for _, item := range items {
+		allCodes = append(allCodes, item.FilePath, item.Content)
+	}
+	return getComment(filePath, strings.Join(allCodes, "\n"))
+}
+
+// SourceAllocation 单个来源的预算分配结果
+type SourceAllocation struct {
+	Budget  int `json:"budget"`  // 分配给该来源的token数
+	Used    int `json:"used"`    // 实际使用的token数(可以借用其他来源剩余的预算)
+	Items   int `json:"items"`   // 选中的片段数
+	Dropped int `json:"dropped"` // 因预算不足丢弃的片段数
+}
+
+// ContextAllocation 上下文预算分配结果，用于性能统计和调试输出
+type ContextAllocation struct {
+	Budget       int                          `json:"budget"`       // 上下文总预算(token)
+	Used         int                          `json:"used"`         // 组装后的上下文token数
+	Deduplicated int                          `json:"deduplicated"` // 与其他片段重复而去掉的片段数
+	Sources      map[string]*SourceAllocation `json:"sources"`
+}
+
+// ContextAssembler 上下文组装器：按来源分配token预算，去重、排序后选取上下文片段
+type ContextAssembler struct {
+	Ratios         map[string]float64 // 各来源占总预算的比例(只在有片段的来源之间归一化)
+	DistanceWeight float64            // 与光标距离在排序中的权重[0,1]，其余为来源分数的权重
+	CountTokens    func(string) int   // 计算token数的函数
+}
+
+// 候选片段及其排序值
+type rankedItem struct {
+	*ContextItem
+	value  float64
+	tokens int
+	lines  map[string]bool
+	taken  bool
+}
+
+/**
+ * 组装上下文
+ * @param {[]*ContextItem} items - 各来源检索到的上下文片段
+ * @param {string} filePath - 当前文件路径，用于选择注释风格
+ * @param {string} prefix - 光标前的代码
+ * @param {string} suffix - 光标后的代码
+ * @param {int} budget - 上下文可用的token数
+ * @returns {string, *ContextAllocation} 返回组装好的上下文和预算分配结果
+ * @description
+ * - 排序值 = (1-DistanceWeight)*来源内归一化的分数 + DistanceWeight*与光标的接近程度
+ * - 内容相同、互相包含或大部分行重叠的片段只保留排序值最高的一个
+ * - 先按各来源的预算选取，剩余的预算再按排序值分给其他片段，放不下的片段从排序值最低的开始丢弃
+ * - 排序值高的片段放在后面(靠近前缀)，后续截断时先截掉排序值低的片段
+ */
+func (a *ContextAssembler) Assemble(items []*ContextItem, filePath, prefix, suffix string, budget int) (string, *ContextAllocation) {
+	alloc := &ContextAllocation{
+		Budget:  max(budget, 0),
+		Sources: make(map[string]*SourceAllocation),
+	}
+	if len(items) == 0 {
+		return "", alloc
+	}
+	ranked := a.rank(items, prefix, suffix)
+	ranked, alloc.Deduplicated = dedupItems(ranked)
+
+	// 只在有片段的来源之间分配预算
+	ratioSum := 0.0
+	for _, it := range ranked {
+		if alloc.Sources[it.Source] == nil {
+			alloc.Sources[it.Source] = &SourceAllocation{}
+			ratioSum += a.ratio(it.Source)
+		}
+	}
+	for source, sa := range alloc.Sources {
+		sa.Budget = int(float64(alloc.Budget) * a.ratio(source) / ratioSum)
+	}
+
+	// 第一轮: 每个来源在自己的预算内按排序值选取
+	total := 0
+	for _, it := range ranked {
+		sa := alloc.Sources[it.Source]
+		if sa.Used+it.tokens <= sa.Budget {
+			it.taken = true
+			sa.Used += it.tokens
+			total += it.tokens
+		}
+	}
+	// 第二轮: 剩余预算按排序值分给还没选中的片段
+	for _, it := range ranked {
+		if !it.taken && total+it.tokens <= alloc.Budget {
+			it.taken = true
+			alloc.Sources[it.Source].Used += it.tokens
+			total += it.tokens
+		}
+	}
+
+	var selected []*ContextItem
+	for i := len(ranked) - 1; i >= 0; i-- {
+		sa := alloc.Sources[ranked[i].Source]
+		if ranked[i].taken {
+			sa.Items++
+			selected = append(selected, ranked[i].ContextItem)
+		} else {
+			sa.Dropped++
+		}
+	}
+	result := renderContextItems(selected, filePath)
+	alloc.Used = a.CountTokens(result)
+	return result, alloc
+}
+
+func (a *ContextAssembler) ratio(source string) float64 {
+	if r, ok := a.Ratios[source]; ok && r > 0 {
+		return r
+	}
+	return 1
+}
+
+// 计算每个片段的排序值，并按排序值从高到低排序
+func (a *ContextAssembler) rank(items []*ContextItem, prefix, suffix string) []*rankedItem {
+	maxScores := make(map[string]float64)
+	for _, it := range items {
+		maxScores[it.Source] = max(maxScores[it.Source], it.Score)
+	}
+	window := newCursorWindow(prefix, suffix)
+	weight := min(max(a.DistanceWeight, 0), 1)
+
+	ranked := make([]*rankedItem, 0, len(items))
+	for _, it := range items {
+		if strings.TrimSpace(it.Content) == "" {
+			continue
+		}
+		// 没有分数的来源(例如定义检索)视为完全相关
+		score := 1.0
+		if maxScores[it.Source] > 0 {
+			score = it.Score / maxScores[it.Source]
+		}
+		ranked = append(ranked, &rankedItem{
+			ContextItem: it,
+			value:       (1-weight)*score + weight*window.proximity(it.keys()),
+			tokens:      a.CountTokens(getComment(it.FilePath, it.text())) + 1,
+			lines:       lineSet(it.Content),
+		})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].value > ranked[j].value
+	})
+	return ranked
+}
+
+// 光标附近的一行代码
+type windowLine struct {
+	distance int             // 与光标的距离(行)
+	tokens   map[string]bool // 该行的标识符
+}
+
+// 光标附近的代码窗口，用于计算片段与光标的距离
+type cursorWindow struct {
+	lines []windowLine // 按距离从近到远排列
+}
+
+const (
+	cursorWindowLines   = 60 // 光标前参与计算的行数
+	suffixWindowLines   = 15 // 光标后参与计算的行数
+	suffixDistanceRatio = 2  // 光标后的代码，距离按倍数计算(相关性低于光标前同样距离的代码)
+)
+
+func newCursorWindow(prefix, suffix string) *cursorWindow {
+	w := &cursorWindow{}
+	before := strings.Split(prefix, "\n")
+	for d := 0; d < len(before) && d < cursorWindowLines; d++ {
+		w.lines = append(w.lines, windowLine{distance: d, tokens: tokenSet(before[len(before)-1-d])})
+	}
+	after := strings.Split(suffix, "\n")
+	for i := 1; i < len(after) && i <= suffixWindowLines; i++ {
+		w.lines = append(w.lines, windowLine{distance: i * suffixDistanceRatio, tokens: tokenSet(after[i])})
+	}
+	sort.SliceStable(w.lines, func(i, j int) bool {
+		return w.lines[i].distance < w.lines[j].distance
+	})
+	return w
+}
+
+/**
+ * 计算片段与光标的接近程度
+ * @param {[]string} keys - 片段的标识符(定义检索为符号名，其他为片段内容)
+ * @returns {float64} 返回[0,1]，光标所在行引用了片段的标识符时为1，窗口内都没有引用时为0
+ */
+func (w *cursorWindow) proximity(keys []string) float64 {
+	for _, line := range w.lines {
+		for _, k := range keys {
+			if line.tokens[k] {
+				return 1 - float64(line.distance)/float64(cursorWindowLines)
+			}
+		}
+	}
+	return 0
+}
+
+func tokenSet(line string) map[string]bool {
+	set := make(map[string]bool)
+	for _, t := range tokenize(line) {
+		set[t] = true
+	}
+	return set
+}
+
+// 片段中非空行的集合，用于判断片段是否重叠
+func lineSet(content string) map[string]bool {
+	set := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			set[line] = true
+		}
+	}
+	return set
+}
+
+// 重叠行占较短片段的比例达到该值时视为重复
+const overlapThreshold = 0.6
+
+// 去掉重复的片段，ranked已按排序值从高到低排列，保留排序值高的片段
+func dedupItems(ranked []*rankedItem) ([]*rankedItem, int) {
+	kept := make([]*rankedItem, 0, len(ranked))
+	for _, it := range ranked {
+		duplicated := false
+		for _, k := range kept {
+			if isOverlapped(k, it) {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			kept = append(kept, it)
+		}
+	}
+	return kept, len(ranked) - len(kept)
+}
+
+func isOverlapped(a, b *rankedItem) bool {
+	if strings.Contains(a.Content, strings.TrimSpace(b.Content)) ||
+		strings.Contains(b.Content, strings.TrimSpace(a.Content)) {
+		return true
+	}
+	small, large := a.lines, b.lines
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	if len(small) == 0 {
+		return false
+	}
+	overlap := 0
+	for line := range small {
+		if large[line] {
+			overlap++
+		}
+	}
+	return float64(overlap)/float64(len(small)) >= overlapThreshold
+}
@@ -0,0 +1,41 @@
+package codebase_context
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ContextAssembler(t *testing.T) {
+	a := &ContextAssembler{
+		Ratios:         map[string]float64{SourceDefinition: 0.5, SourceSemantic: 0.5},
+		DistanceWeight: 0.5,
+		CountTokens:    func(s string) int { return len(strings.Fields(s)) },
+	}
+	items := []*ContextItem{
+		{Source: SourceDefinition, Name: "LoadUser", FilePath: "user.go", Content: "func LoadUser(id int) *User {\n\treturn nil\n}"},
+		{Source: SourceSemantic, FilePath: "user.go", Content: "func LoadUser(id int) *User {", Score: 0.9},
+		{Source: SourceSemantic, FilePath: "cache.go", Content: "func PutCache(key string, value any) {\n\tcache[key] = value\n}", Score: 0.8},
+		{Source: SourceSemantic, FilePath: "log.go", Content: "func Printf(format string, args ...any) {\n\twriter.Write(format)\n}", Score: 0.1},
+	}
+	prefix := "func main() {\n\tPutCache(\"k\", 1)\n\tu := LoadUser("
+
+	result, alloc := a.Assemble(items, "main.go", prefix, "", 1000)
+	if alloc.Deduplicated != 1 {
+		t.Errorf("expected 1 deduplicated item, got %d", alloc.Deduplicated)
+	}
+	if strings.Index(result, "Printf") > strings.Index(result, "PutCache") ||
+		strings.Index(result, "PutCache") > strings.Index(result, "LoadUser") {
+		t.Errorf("items should be ordered from lowest to highest value:\n%s", result)
+	}
+
+	result, alloc = a.Assemble(items, "main.go", prefix, "", 25)
+	if alloc.Used > 25 {
+		t.Errorf("context uses %d tokens, exceeds budget 25", alloc.Used)
+	}
+	if strings.Contains(result, "Printf") || !strings.Contains(result, "LoadUser") {
+		t.Errorf("lowest value item should be dropped first:\n%s", result)
+	}
+	if alloc.Sources[SourceSemantic].Dropped == 0 {
+		t.Error("expected dropped semantic items to be reported")
+	}
+}
@@ -2,6 +2,7 @@ package codebase_context
 
 import (
 	"code-completion/pkg/config"
+	"code-completion/pkg/trace"
 	"context"
 	"fmt"
 	"net/http"
@@ -50,24 +51,28 @@ type SearchResult struct {
  * @param {string} codeSnippet - Code snippet to search for definitions
  * @param {http.Header} headers - HTTP headers for the request
  * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {sync.Mutex} mu - Mutex guarding the results slices
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous definition search for code snippet
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
+ * - Stores the result only when the search succeeds, a failed search leaves the slot nil
+ * - Writes under mu, the caller may return partial results before all searches finish
  * - Signals completion via done() on wait group
  * @example
  * wg.Add(1)
- * go client.searchDefinitionAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, results, 0)
+ * go client.searchDefinitionAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, &mu, results, 0)
  */
 func (c *ContextClient) searchDefinitionAsync(ctx context.Context, clientID, codebasePath, filePath, codeSnippet string,
-	headers http.Header, wg *sync.WaitGroup, results []*ResponseData, idx int) {
+	headers http.Header, wg *sync.WaitGroup, mu *sync.Mutex, results []*ResponseData, idx int) {
 	defer wg.Done()
 
 	data, err := c.searchDefinition(ctx, clientID, codebasePath, filePath, codeSnippet, headers)
-	if err != nil {
+	if err == nil {
+		mu.Lock()
 		results[idx] = data
+		mu.Unlock()
 	}
 }
 
@@ -80,24 +85,28 @@ func (c *ContextClient) searchDefinitionAsync(ctx context.Context, clientID, cod
  * @param {string} codeSnippet - Code snippet to search for relations
  * @param {http.Header} headers - HTTP headers for the request
  * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {sync.Mutex} mu - Mutex guarding the results slices
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous relation search for code snippet
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
+ * - Stores the result only when the search succeeds, a failed search leaves the slot nil
+ * - Writes under mu, the caller may return partial results before all searches finish
  * - Signals completion via done() on wait group
  * @example
  * wg.Add(1)
- * go client.searchRelationAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, results, 1)
+ * go client.searchRelationAsync(ctx, "client-id", "/codebase", "file.go", "func test()", headers, &wg, &mu, results, 1)
  */
 func (c *ContextClient) searchRelationAsync(ctx context.Context, clientID, codebasePath, filePath, codeSnippet string,
-	headers http.Header, wg *sync.WaitGroup, results []*ResponseData, idx int) {
+	headers http.Header, wg *sync.WaitGroup, mu *sync.Mutex, results []*ResponseData, idx int) {
 	defer wg.Done()
 
 	data, err := c.searchRelation(ctx, clientID, codebasePath, filePath, codeSnippet, headers)
-	if err != nil {
+	if err == nil {
+		mu.Lock()
 		results[idx] = data
+		mu.Unlock()
 	}
 }
 
@@ -109,24 +118,28 @@ func (c *ContextClient) searchRelationAsync(ctx context.Context, clientID, codeb
  * @param {string} query - Semantic query string to search for
  * @param {http.Header} headers - HTTP headers for the request
  * @param {sync.WaitGroup} wg - Wait group for synchronization
+ * @param {sync.Mutex} mu - Mutex guarding the results slices
  * @param {[]*ResponseData} results - Slice to store search results
  * @param {int} idx - Index in results slice to store the result
  * @description
  * - Performs asynchronous semantic search for code
  * - Updates results slice at specified index with search result
- * - Handles errors by storing error result in results slice
+ * - Stores the result only when the search succeeds, a failed search leaves the slot nil
+ * - Writes under mu, the caller may return partial results before all searches finish
  * - Signals completion via done() on wait group
  * @example
  * wg.Add(1)
- * go client.searchSemanticAsync(ctx, "client-id", "/codebase", "database query", headers, &wg, results, 2)
+ * go client.searchSemanticAsync(ctx, "client-id", "/codebase", "database query", headers, &wg, &mu, results, 2)
  */
 func (c *ContextClient) searchSemanticAsync(ctx context.Context, clientID, codebasePath, query string, headers http.Header,
-	wg *sync.WaitGroup, results []*ResponseData, idx int) {
+	wg *sync.WaitGroup, mu *sync.Mutex, results []*ResponseData, idx int) {
 	defer wg.Done()
 
 	data, err := c.searchSemantic(ctx, clientID, codebasePath, query, headers)
-	if err != nil {
+	if err == nil {
+		mu.Lock()
 		results[idx] = data
+		mu.Unlock()
 	}
 }
 
@@ -156,45 +169,47 @@ func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePa
 		return &SearchResult{}
 	}
 
+	cfg := &config.Get().Context
 	// 创建上下文，设置超时
-	ctx, cancel := context.WithTimeout(ctx, config.Context.TotalTimeout)
+	ctx, cancel := context.WithTimeout(ctx, cfg.TotalTimeout)
 	defer cancel()
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	// 初始化结果数组
 	definitionResults := make([]*ResponseData, len(codeSnippets))
 	relationResults := make([]*ResponseData, len(codeSnippets))
 	semanticResults := make([]*ResponseData, len(queries))
 
 	// 定义检索
-	if len(codeSnippets) > 0 && !config.Context.Definition.Disabled {
+	if len(codeSnippets) > 0 && !cfg.Definition.Disabled {
 		for i, codeSnippet := range codeSnippets {
 			if codeSnippet == "" {
 				continue
 			}
 			wg.Add(1)
-			go c.searchDefinitionAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, definitionResults, i)
+			go c.searchDefinitionAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, &mu, definitionResults, i)
 		}
 	}
 	// 调用链检索
-	if len(codeSnippets) > 0 && !config.Context.Relation.Disabled {
+	if len(codeSnippets) > 0 && !cfg.Relation.Disabled {
 		for i, codeSnippet := range codeSnippets {
 			if codeSnippet == "" {
 				continue
 			}
 			wg.Add(1)
-			go c.searchRelationAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, relationResults, i)
+			go c.searchRelationAsync(ctx, clientID, codebasePath, filePath, codeSnippet, headers, &wg, &mu, relationResults, i)
 		}
 	}
 
 	// 语义检索
-	if len(queries) > 0 && !config.Context.Semantic.Disabled {
+	if len(queries) > 0 && !cfg.Semantic.Disabled {
 		for i, query := range queries {
 			if query == "" {
 				continue
 			}
 			wg.Add(1)
-			go c.searchSemanticAsync(ctx, clientID, codebasePath, query, headers, &wg, semanticResults, i)
+			go c.searchSemanticAsync(ctx, clientID, codebasePath, query, headers, &wg, &mu, semanticResults, i)
 		}
 	}
 
@@ -211,17 +226,29 @@ func (c *ContextClient) RequestContext(ctx context.Context, clientID, codebasePa
 	case <-ctx.Done(): // 上下文取消，直接返回已收集的结果
 		zap.L().Warn("Context timeout, returning partial results", zap.Error(ctx.Err()))
 	}
+	// 超时返回时仍有检索在写入结果，返回副本
+	mu.Lock()
+	defer mu.Unlock()
 	return &SearchResult{
-		DefinitionResults: definitionResults,
-		SemanticResults:   semanticResults,
-		RelationResults:   relationResults,
+		DefinitionResults: append([]*ResponseData(nil), definitionResults...),
+		SemanticResults:   append([]*ResponseData(nil), semanticResults...),
+		RelationResults:   append([]*ResponseData(nil), relationResults...),
 	}
 }
 
-// 获取上下文信息
+// 获取上下文信息，按定义、语义、关系的顺序拼接所有检索结果
 func (c *ContextClient) GetContext(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) string {
+	items := c.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	return renderContextItems(items, filepath.Join(projectPath, filePath))
+}
+
+/**
+ * 获取上下文片段
+ * @returns {[]*ContextItem} 返回定义、语义、关系检索到的代码片段，由ContextAssembler按预算组装
+ */
+func (c *ContextClient) GetContextItems(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) []*ContextItem {
 	if clientID == "" || projectPath == "" || filePath == "" || (prefix == "" && suffix == "") {
-		return ""
+		return nil
 	}
 
 	// 构建完整文件路径
@@ -243,37 +270,36 @@ func (c *ContextClient) GetContext(ctx context.Context, clientID, projectPath, f
 	searchResult := c.RequestContext(ctx, clientID, projectPath, fullFilePath,
 		definitionCodeSnaps, []string{semanticSearchContent}, headers)
 
-	// 解析语义检索结果
-	semanticCodes := parseSemantic(searchResult.SemanticResults)
+	var items []*ContextItem
 
 	// 解析定义检索结果
-	defCodes := parseDefinition(searchResult.DefinitionResults)
-
-	// 解析关系检索结果
-	relationCodes := parseRelation(searchResult.RelationResults)
-
-	var allCodes []string
-
-	// 合并定义检索结果
-	for _, item := range defCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+	for _, item := range parseDefinition(searchResult.DefinitionResults) {
+		items = append(items, &ContextItem{Source: SourceDefinition, Name: item.Name,
+			FilePath: item.FilePath, Content: item.Content})
 	}
 
-	// 合并语义检索结果
-	for _, item := range semanticCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+	// 解析语义检索结果
+	for _, item := range parseSemantic(searchResult.SemanticResults) {
+		items = append(items, &ContextItem{Source: SourceSemantic,
+			FilePath: item.FilePath, Content: item.Content, Score: item.Score})
 	}
 
-	// 合并关系检索结果
-	for _, item := range relationCodes {
-		allCodes = append(allCodes, item.FilePath, item.Content)
+	// 解析关系检索结果
+	for _, item := range parseRelation(searchResult.RelationResults) {
+		items = append(items, &ContextItem{Source: SourceRelation,
+			FilePath: item.FilePath, Content: item.Content, Score: item.Score})
 	}
+	return items
+}
 
-	// 合并所有结果
-	semanticResult := strings.Join(allCodes, "\n")
-
-	// 添加注释
-	return getComment(fullFilePath, semanticResult)
+// 调用上下文服务，每次检索记录为一个span，链路通过请求头传递给上下文服务
+func (c *ContextClient) search(ctx context.Context, name, url string, params RequestParam, headers http.Header, method string) (*ResponseData, error) {
+	ctx, span := trace.Start(ctx, name, trace.KindClient)
+	defer span.End()
+	span.SetAttr("http.url", url)
+	rsp, err := c.apiClient.DoRequest(ctx, url, params, headers, method)
+	span.SetError(err)
+	return rsp, err
 }
 
 // 搜索代码定义
@@ -285,32 +311,34 @@ func (c *ContextClient) searchDefinition(ctx context.Context, clientID, codebase
 		CodeSnippet:  codeSnippet,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Definition.Url, params, headers, "GET")
+	return c.search(ctx, "context.definition", config.Get().Context.Definition.Url, params, headers, "GET")
 }
 
 // 语义搜索
 func (c *ContextClient) searchSemantic(ctx context.Context, clientID, codebasePath, query string, headers http.Header) (*ResponseData, error) {
+	cfg := &config.Get().Context.Semantic
 	params := RequestParam{
 		ClientID:       clientID,
 		CodebasePath:   codebasePath,
 		Query:          query,
-		TopK:           config.Context.Semantic.TopK,
-		ScoreThreshold: config.Context.Semantic.ScoreThreshold,
+		TopK:           cfg.TopK,
+		ScoreThreshold: cfg.ScoreThreshold,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Semantic.Url, params, headers, "POST")
+	return c.search(ctx, "context.semantic", cfg.Url, params, headers, "POST")
 }
 
 // 关系检索
 func (c *ContextClient) searchRelation(ctx context.Context, clientID, codebasePath, filePath, codeSnippet string, headers http.Header) (*ResponseData, error) {
+	cfg := &config.Get().Context.Relation
 	params := RequestParam{
 		ClientID:       clientID,
 		CodebasePath:   codebasePath,
 		FilePath:       filePath,
 		CodeSnippet:    codeSnippet,
-		MaxLayer:       config.Context.Relation.Layer,
-		IncludeContent: config.Context.Relation.IncludeContent,
+		MaxLayer:       cfg.Layer,
+		IncludeContent: cfg.IncludeContent,
 	}
 
-	return c.apiClient.DoRequest(ctx, config.Context.Relation.Url, params, headers, "GET")
+	return c.search(ctx, "context.relation", cfg.Url, params, headers, "GET")
 }
@@ -0,0 +1,53 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/config"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_RequestContextKeepsSuccessfulResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/definition":
+			w.Write([]byte(`{"data":{"list":[{"filePath":"a.go","name":"Foo","content":"func Foo() {}"}]}}`))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"data":{"list":[{"filePath":"b.go","content":"func Bar() {}"}]}}`))
+		default:
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	defer config.Apply(config.Get())
+	c := config.Snapshot()
+	c.Context.TotalTimeout = 100 * time.Millisecond
+	c.Context.RequestTimeout = time.Second
+	c.Context.Definition = config.DefinitionConfig{Url: srv.URL + "/definition"}
+	c.Context.Semantic.Disabled = false
+	c.Context.Semantic.Url = srv.URL + "/semantic"
+	c.Context.Relation.Disabled = false
+	c.Context.Relation.Url = srv.URL + "/slow"
+	config.Apply(c)
+
+	result := NewContextClient().RequestContext(context.Background(), "client", "/project", "/project/main.go",
+		[]string{"foo"}, []string{"query"}, http.Header{})
+	if result.DefinitionResults[0] == nil || len(result.DefinitionResults[0].Data.List) != 1 {
+		t.Errorf("successful definition search should be kept: %+v", result.DefinitionResults[0])
+	}
+	if result.SemanticResults[0] != nil {
+		t.Error("failed semantic search should leave the result empty")
+	}
+	if result.RelationResults[0] != nil {
+		t.Error("search finishing after the total timeout should not be returned")
+	}
+	// 超时后仍在写入的检索不能修改已返回的结果
+	time.Sleep(300 * time.Millisecond)
+	if result.RelationResults[0] != nil {
+		t.Error("returned results should not change after timeout")
+	}
+}
@@ -0,0 +1,438 @@
+package codebase_context
+
+import (
+	"bufio"
+	"code-completion/pkg/config"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ContextProvider 上下文提供者，远程索引服务和本地检索都实现该接口
+type ContextProvider interface {
+	// 获取拼接好的上下文
+	GetContext(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) string
+	// 获取上下文片段，由ContextAssembler按token预算组装
+	GetContextItems(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) []*ContextItem
+}
+
+// NeighborFile 客户端上传的邻近文件片段(最近打开、编辑的文件等)
+type NeighborFile struct {
+	FilePath string `json:"file_path"`
+	Content  string `json:"content"`
+}
+
+type neighborFilesKey struct{}
+
+// WithNeighborFiles 把邻近文件片段附加到上下文中，供本地上下文提供者使用
+func WithNeighborFiles(ctx context.Context, files []NeighborFile) context.Context {
+	if len(files) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, neighborFilesKey{}, files)
+}
+
+func neighborFilesFrom(ctx context.Context) []NeighborFile {
+	files, _ := ctx.Value(neighborFilesKey{}).([]NeighborFile)
+	return files
+}
+
+type tokenCounterKey struct{}
+
+// WithTokenCounter 附加token计数函数，本地上下文提供者按该函数限制上下文的token数
+func WithTokenCounter(ctx context.Context, countTokens func(string) int) context.Context {
+	if countTokens == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenCounterKey{}, countTokens)
+}
+
+// 获取token计数函数，没有附加时按4个字符一个token估算
+func tokenCounterFrom(ctx context.Context) func(string) int {
+	if countTokens, ok := ctx.Value(tokenCounterKey{}).(func(string) int); ok {
+		return countTokens
+	}
+	return func(s string) int { return (len(s) + 3) / 4 }
+}
+
+// 候选代码块
+type codeChunk struct {
+	FilePath string
+	Content  string
+	tokens   []string
+}
+
+// 带相似度的候选代码块
+type scoredChunk struct {
+	*codeChunk
+	Score float64
+}
+
+// LocalContextClient 本地上下文客户端，不依赖外部索引服务
+type LocalContextClient struct {
+	index *localIndex
+}
+
+/**
+ * Create local context client
+ * @returns {LocalContextClient} Returns initialized local context client instance
+ * @description
+ * - Ranks code chunks from neighbor files sent by client and the optional local index file
+ * - Uses BM25 or Jaccard similarity between the cursor window and each chunk
+ * - Used when codebase-indexer services are not deployed
+ * @example
+ * client := NewLocalContextClient()
+ * ctx = WithNeighborFiles(ctx, files)
+ * result := client.GetContext(ctx, "client-id", "/path", "file.go", prefix, suffix, "", headers)
+ */
+func NewLocalContextClient() *LocalContextClient {
+	return &LocalContextClient{
+		index: &localIndex{},
+	}
+}
+
+// 获取本地上下文信息，最相关的代码块放在最后(最靠近前缀)
+func (c *LocalContextClient) GetContext(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) string {
+	items := c.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	fullFilePath := filePath
+	if projectPath != "" {
+		fullFilePath = filepath.Join(projectPath, filePath)
+	}
+	return renderContextItems(items, fullFilePath)
+}
+
+/**
+ * 获取本地上下文片段
+ * @returns {[]*ContextItem} 返回按相似度从高到低排列的代码块
+ * @description
+ * - 查询: 前缀最后windowLines行 + 后缀前几行
+ * - 候选: 邻近文件片段和本地索引中同一代码库的代码块，排除当前文件
+ * - 按相似度取topK，总token数不超过maxTokens
+ */
+func (c *LocalContextClient) GetContextItems(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) []*ContextItem {
+	if prefix == "" && suffix == "" {
+		return nil
+	}
+	cfg := &config.Get().Context.Local
+
+	query := tokenize(strings.Join(getCodeLastNLines(prefix, cfg.WindowLines), "\n") + "\n" +
+		strings.Join(getCodeFirstNLines(suffix, cfg.WindowLines/4), "\n"))
+	if len(query) == 0 {
+		return nil
+	}
+
+	var chunks []*codeChunk
+	for _, f := range neighborFilesFrom(ctx) {
+		if isSameFile(f.FilePath, filePath) {
+			continue
+		}
+		chunks = append(chunks, splitChunks(f.FilePath, f.Content, cfg.ChunkLines)...)
+	}
+	for _, ch := range c.index.Chunks(cfg.IndexFile, projectPath, cfg.ChunkLines) {
+		if !isSameFile(ch.FilePath, filePath) {
+			chunks = append(chunks, ch)
+		}
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var scored []scoredChunk
+	if cfg.Similarity == "jaccard" {
+		scored = rankJaccard(query, chunks)
+	} else {
+		scored = rankBM25(query, chunks)
+	}
+	var items []*ContextItem
+	for _, s := range selectChunks(scored, cfg.TopK, cfg.MinScore, cfg.MaxTokens, tokenCounterFrom(ctx)) {
+		items = append(items, &ContextItem{Source: SourceLocal, FilePath: s.FilePath,
+			Content: s.Content, Score: s.Score})
+	}
+	return items
+}
+
+// 按相似度从高到低选取代码块，去掉内容重复的代码块，总token数不超过maxTokens
+func selectChunks(scored []scoredChunk, topK int, minScore float64, maxTokens int, countTokens func(string) int) []scoredChunk {
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	seen := make(map[string]bool)
+	total := 0
+	var selected []scoredChunk
+	for _, s := range scored {
+		if len(selected) >= topK || s.Score <= minScore {
+			break
+		}
+		key := strings.TrimSpace(s.Content)
+		if seen[key] {
+			continue
+		}
+		size := countTokens(s.FilePath) + countTokens(s.Content) + 2
+		if maxTokens > 0 && total+size > maxTokens {
+			continue
+		}
+		seen[key] = true
+		total += size
+		selected = append(selected, s)
+	}
+	return selected
+}
+
+// 判断两个路径是否指向同一文件(客户端可能上传相对路径或绝对路径)
+func isSameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	a = filepath.ToSlash(a)
+	b = filepath.ToSlash(b)
+	return a == b || strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// 把文件内容按行切分为代码块，相邻代码块重叠一半
+func splitChunks(filePath, content string, chunkLines int) []*codeChunk {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	step := max(chunkLines/2, 1)
+	var chunks []*codeChunk
+	for start := 0; start < len(lines); start += step {
+		end := min(start+chunkLines, len(lines))
+		text := trimEmptyLines(strings.Join(lines[start:end], "\n"))
+		if text != "" {
+			chunks = append(chunks, &codeChunk{
+				FilePath: filePath,
+				Content:  text,
+				tokens:   tokenize(text),
+			})
+		}
+		if end == len(lines) {
+			break
+		}
+	}
+	return chunks
+}
+
+var identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// 提取标识符并转为小写，单字符标识符不参与相似度计算
+func tokenize(text string) []string {
+	words := identifierPattern.FindAllString(text, -1)
+	tokens := words[:0]
+	for _, w := range words {
+		if len(w) > 1 {
+			tokens = append(tokens, strings.ToLower(w))
+		}
+	}
+	return tokens
+}
+
+// Jaccard相似度: |Q∩C| / |Q∪C|
+func rankJaccard(query []string, chunks []*codeChunk) []scoredChunk {
+	qset := make(map[string]bool)
+	for _, t := range query {
+		qset[t] = true
+	}
+	scored := make([]scoredChunk, 0, len(chunks))
+	for _, ch := range chunks {
+		cset := make(map[string]bool)
+		for _, t := range ch.tokens {
+			cset[t] = true
+		}
+		inter := 0
+		for t := range cset {
+			if qset[t] {
+				inter++
+			}
+		}
+		union := len(qset) + len(cset) - inter
+		score := 0.0
+		if union > 0 {
+			score = float64(inter) / float64(union)
+		}
+		scored = append(scored, scoredChunk{codeChunk: ch, Score: score})
+	}
+	return scored
+}
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// BM25相似度，以候选代码块集合作为语料统计IDF
+func rankBM25(query []string, chunks []*codeChunk) []scoredChunk {
+	df := make(map[string]int)
+	totalLen := 0
+	tfs := make([]map[string]int, len(chunks))
+	for i, ch := range chunks {
+		tf := make(map[string]int)
+		for _, t := range ch.tokens {
+			tf[t]++
+		}
+		for t := range tf {
+			df[t]++
+		}
+		tfs[i] = tf
+		totalLen += len(ch.tokens)
+	}
+	n := float64(len(chunks))
+	avgLen := math.Max(float64(totalLen)/n, 1)
+
+	qset := make(map[string]bool)
+	for _, t := range query {
+		qset[t] = true
+	}
+	scored := make([]scoredChunk, 0, len(chunks))
+	for i, ch := range chunks {
+		score := 0.0
+		norm := bm25K1 * (1 - bm25B + bm25B*float64(len(ch.tokens))/avgLen)
+		for t := range qset {
+			f := float64(tfs[i][t])
+			if f == 0 {
+				continue
+			}
+			idf := math.Log(1 + (n-float64(df[t])+0.5)/(float64(df[t])+0.5))
+			score += idf * f * (bm25K1 + 1) / (f + norm)
+		}
+		scored = append(scored, scoredChunk{codeChunk: ch, Score: score})
+	}
+	return scored
+}
+
+// 本地索引文件中的一行
+type indexEntry struct {
+	Codebase string `json:"codebase"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content"`
+}
+
+// 本地索引，按文件修改时间自动重新加载
+type localIndex struct {
+	mutex      sync.RWMutex
+	path       string
+	modTime    time.Time
+	chunkLines int
+	chunks     map[string][]*codeChunk // codebase -> 代码块
+}
+
+/**
+ * 获取本地索引中属于指定代码库的代码块
+ * @param {string} path - 索引文件路径，为空时不使用索引
+ * @param {string} codebase - 代码库路径，索引中codebase为空的条目属于所有代码库
+ * @param {int} chunkLines - 代码块行数
+ * @returns {[]*codeChunk} 返回代码块，索引不可用时返回nil
+ */
+func (x *localIndex) Chunks(path, codebase string, chunkLines int) []*codeChunk {
+	if path == "" {
+		return nil
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	x.mutex.RLock()
+	fresh := x.path == path && x.modTime.Equal(stat.ModTime()) && x.chunkLines == chunkLines
+	x.mutex.RUnlock()
+	if !fresh {
+		x.load(path, stat.ModTime(), chunkLines)
+	}
+
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	chunks := x.chunks[""]
+	if codebase != "" && len(x.chunks[codebase]) > 0 {
+		chunks = append(append([]*codeChunk(nil), chunks...), x.chunks[codebase]...)
+	}
+	return chunks
+}
+
+func (x *localIndex) load(path string, modTime time.Time, chunkLines int) {
+	chunks := make(map[string][]*codeChunk)
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e indexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		chunks[e.Codebase] = append(chunks[e.Codebase], splitChunks(e.FilePath, e.Content, chunkLines)...)
+	}
+	if err := scanner.Err(); err != nil {
+		zap.L().Warn("Load local context index failed", zap.String("path", path), zap.Error(err))
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.path = path
+	x.modTime = modTime
+	x.chunkLines = chunkLines
+	x.chunks = chunks
+	zap.L().Info("Local context index loaded", zap.String("path", path), zap.Int("codebases", len(chunks)))
+}
+
+// 按配置选择上下文提供者
+type providerSelector struct {
+	remote *ContextClient
+	local  *LocalContextClient
+}
+
+/**
+ * Create context provider selected by configuration
+ * @returns {ContextProvider} Returns provider that dispatches to remote or local context client
+ * @description
+ * - remote: only use codebase-indexer services
+ * - local: only use built-in local retrieval
+ * - auto: use local retrieval when remote services return nothing
+ * - Provider is read on every request, so hot reloaded configuration takes effect immediately
+ */
+func NewContextProvider() ContextProvider {
+	return &providerSelector{
+		remote: NewContextClient(),
+		local:  NewLocalContextClient(),
+	}
+}
+
+func (p *providerSelector) GetContext(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) string {
+	switch config.Get().Context.Provider {
+	case "local":
+		return p.local.GetContext(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	case "remote":
+		return p.remote.GetContext(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	}
+	result := p.remote.GetContext(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	if result != "" || ctx.Err() != nil {
+		return result
+	}
+	return p.local.GetContext(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+}
+
+func (p *providerSelector) GetContextItems(ctx context.Context, clientID, projectPath, filePath, prefix, suffix, importContent string, headers http.Header) []*ContextItem {
+	switch config.Get().Context.Provider {
+	case "local":
+		return p.local.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	case "remote":
+		return p.remote.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	}
+	items := p.remote.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+	if len(items) > 0 || ctx.Err() != nil {
+		return items
+	}
+	return p.local.GetContextItems(ctx, clientID, projectPath, filePath, prefix, suffix, importContent, headers)
+}
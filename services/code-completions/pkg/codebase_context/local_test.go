@@ -0,0 +1,65 @@
+package codebase_context
+
+import (
+	"code-completion/pkg/config"
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_LocalContextRanking(t *testing.T) {
+	files := []NeighborFile{
+		{FilePath: "util/strings.go", Content: "func Reverse(s string) string {\n\treturn s\n}"},
+		{FilePath: "db/user.go", Content: "type UserRepository struct{}\n\nfunc (r *UserRepository) FindUserByEmail(email string) (*User, error) {\n\treturn nil, nil\n}"},
+		{FilePath: "main.go", Content: "func main() {\n\trepo.FindUserByEmail(email)\n}"},
+	}
+	prefix := "func handleLogin(repo *UserRepository, email string) {\n\tuser, err := repo.FindUserByEmail("
+
+	for _, tokens := range [][]scoredChunk{
+		rankBM25(tokenize(prefix), splitChunks("db/user.go", files[1].Content, 20)),
+		rankJaccard(tokenize(prefix), splitChunks("db/user.go", files[1].Content, 20)),
+	} {
+		if len(tokens) == 0 || tokens[0].Score <= 0 {
+			t.Fatal("related chunk should have a positive score")
+		}
+	}
+
+	c := config.Snapshot()
+	c.Context.Local = config.LocalContextConfig{
+		Similarity: "bm25", WindowLines: 20, ChunkLines: 20, TopK: 5, MaxTokens: 1000,
+	}
+	defer config.Apply(config.Get())
+	config.Apply(c)
+	client := NewLocalContextClient()
+	ctx := WithNeighborFiles(context.Background(), files)
+	result := client.GetContext(ctx, "client", "/project", "main.go", prefix, "", "", nil)
+	if !strings.Contains(result, "FindUserByEmail(email string)") {
+		t.Errorf("expected related definition in context, got %q", result)
+	}
+	if strings.Contains(result, "repo.FindUserByEmail(email)\n}") {
+		t.Error("current file should be excluded from context")
+	}
+	if strings.Index(result, "Reverse") > strings.Index(result, "UserRepository") {
+		t.Error("most relevant chunk should be placed last")
+	}
+}
+
+func Test_SelectChunksTokenBudget(t *testing.T) {
+	chunk := func(content string, score float64) scoredChunk {
+		return scoredChunk{codeChunk: &codeChunk{FilePath: "a.go", Content: content}, Score: score}
+	}
+	countWords := func(s string) int { return len(strings.Fields(s)) }
+	scored := []scoredChunk{
+		chunk("one two three four five six", 3),
+		chunk("seven eight", 2),
+		chunk("nine ten eleven twelve", 1),
+	}
+	// 每个代码块额外计入路径1个token和分隔2个token
+	selected := selectChunks(scored, 5, 0, 14, countWords)
+	if len(selected) != 2 || selected[0].Content != "one two three four five six" || selected[1].Content != "seven eight" {
+		t.Errorf("unexpected selection under token budget: %+v", selected)
+	}
+	if n := len(selectChunks(scored, 5, 0, 0, countWords)); n != 3 {
+		t.Errorf("zero budget should not limit chunks, got %d", n)
+	}
+}
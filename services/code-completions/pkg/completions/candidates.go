@@ -0,0 +1,220 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+	"context"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 候选补全排序的权重
+const (
+	syntaxWeight     = 0.5 // 语法正确
+	logprobWeight    = 0.3 // 平均token概率
+	lengthWeight     = 0.2 // 补全长度
+	voteBonus        = 0.1 // 每多一个相同的候选的加分
+	lengthSaturation = 200 // 超过该长度(字符)后不再因长度加分
+	unknownLogprob   = 0.5 // 模型没有返回对数概率时使用的概率
+)
+
+// 修剪后存活的一个候选补全
+type candidate struct {
+	text       string  // 修剪后的补全
+	raw        string  // 模型原始输出
+	logprob    float64 // 平均token对数概率
+	hasLogprob bool    // 模型是否返回了对数概率
+	votes      int     // 相同候选的数量
+	score      float64 // 排序分
+}
+
+/**
+ * 计算一个选择的平均token对数概率
+ * @param {interface{}} logprobs - OpenAI v1/completions协议的logprobs字段
+ * @returns {float64, bool} 返回平均对数概率，没有对数概率时返回false
+ */
+func meanLogprob(logprobs interface{}) (float64, bool) {
+	m, ok := logprobs.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	values, _ := m["token_logprobs"].([]interface{})
+	sum, count := 0.0, 0
+	for _, v := range values {
+		if f, ok := v.(float64); ok {
+			sum += f
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / float64(count), true
+}
+
+/**
+ * 合并相同的候选并按排序分从高到低排序
+ * @param {[]*candidate} cands - 修剪后存活的候选
+ * @param {string} language - 编程语言，用于语法检查
+ * @param {string} prefix - 代码前缀
+ * @param {string} suffix - 代码后缀
+ * @returns {[]*candidate} 返回排序后的候选
+ * @description
+ * - 去掉首尾空白后相同的候选合并为一个，保留对数概率最高的一个，票数累加
+ * - 排序分 = 语法正确*0.5 + exp(平均对数概率)*0.3 + 长度*0.2 + (票数-1)*0.1
+ * - 分数相同时保持模型返回的顺序
+ */
+func rankCandidates(cands []*candidate, language, prefix, suffix string) []*candidate {
+	var merged []*candidate
+	index := make(map[string]*candidate)
+	for _, c := range cands {
+		key := strings.TrimSpace(c.text)
+		if exist, ok := index[key]; ok {
+			exist.votes += max(c.votes, 1)
+			if c.hasLogprob && (!exist.hasLogprob || c.logprob > exist.logprob) {
+				exist.logprob, exist.hasLogprob = c.logprob, true
+			}
+			continue
+		}
+		c.votes = max(c.votes, 1)
+		index[key] = c
+		merged = append(merged, c)
+	}
+	for _, c := range merged {
+		c.score = 0
+		if isCodeSyntax(language, c.text, prefix, suffix) {
+			c.score += syntaxWeight
+		}
+		prob := unknownLogprob
+		if c.hasLogprob {
+			prob = math.Exp(c.logprob)
+		}
+		c.score += logprobWeight * prob
+		c.score += lengthWeight * float64(min(len(c.text), lengthSaturation)) / lengthSaturation
+		c.score += voteBonus * float64(c.votes-1)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].score > merged[j].score
+	})
+	return merged
+}
+
+/**
+ * 修剪模型返回的所有选择并排序
+ * @param {context.Context} ctx - 请求上下文
+ * @param {[]model.CompletionChoice} choices - 模型返回的选择
+ * @param {*model.CompletionParameter} para - 补全参数
+ * @returns {[]*candidate, []string} 返回存活的候选(需要排序时已排序)和命中的修剪器
+ * @description
+ * - 修剪后为空的候选被丢弃
+ * - 只有一个选择且不要求排序时不做语法检查和打分
+ */
+func (h *CompletionHandler) collectCandidates(ctx context.Context, choices []model.CompletionChoice, para *model.CompletionParameter) ([]*candidate, []string) {
+	var cands []*candidate
+	var prunerHits []string
+	for _, choice := range choices {
+		if choice.Text == "" {
+			continue
+		}
+		text := choice.Text
+		if !h.cfg.DisablePrune {
+			var hits []string
+			text, hits = h.pruneCompletionCode(ctx, text, para)
+			prunerHits = appendUnique(prunerHits, hits...)
+		}
+		if text == "" {
+			continue
+		}
+		c := &candidate{text: text, raw: choice.Text}
+		c.logprob, c.hasLogprob = meanLogprob(choice.Logprobs)
+		cands = append(cands, c)
+	}
+	if len(choices) > 1 || para.Rerank {
+		cands = rankCandidates(cands, para.Language, para.Prefix, para.Suffix)
+	}
+	return cands, prunerHits
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, s := range list {
+			if s == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+/**
+ * 合并多个模型池返回的候选补全
+ * @param {[]*CompletionResponse} rsps - 各模型池的响应，第一个为主请求的响应
+ * @returns {*CompletionResponse} 返回合并后的响应
+ * @description
+ * - 以排序分最高的成功响应为基础，合并所有成功响应的候选，按排序分从高到低排序
+ * - 不同模型给出的相同候选合并为一个，每多一个模型给出加voteBonus分
+ * - 都没有成功时返回第一个响应
+ */
+func MergeResponses(rsps []*CompletionResponse) *CompletionResponse {
+	var base *CompletionResponse
+	var choices []CompletionChoice
+	index := make(map[string]int)
+	var prunerHits []string
+	for _, rsp := range rsps {
+		if rsp == nil {
+			continue
+		}
+		prunerHits = appendUnique(prunerHits, rsp.PrunerHits...)
+		if rsp.Status != model.StatusSuccess {
+			continue
+		}
+		// 没有候选的成功响应也可能先成为基础，之后有候选的响应优先
+		if base == nil || len(base.Choices) == 0 || (len(rsp.Choices) > 0 && rsp.Choices[0].Score > base.Choices[0].Score) {
+			base = rsp
+		}
+		for _, choice := range rsp.Choices {
+			if choice.Model == "" {
+				choice.Model = rsp.Model
+			}
+			key := strings.TrimSpace(choice.Text)
+			if i, ok := index[key]; ok {
+				choices[i].Score = max(choices[i].Score, choice.Score) + voteBonus
+				continue
+			}
+			index[key] = len(choices)
+			choices = append(choices, choice)
+		}
+	}
+	if base == nil {
+		for _, rsp := range rsps {
+			if rsp != nil {
+				return rsp
+			}
+		}
+		return nil
+	}
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].Score > choices[j].Score
+	})
+	merged := *base
+	merged.Choices = choices
+	merged.PrunerHits = prunerHits
+	return &merged
+}
+
+/**
+ * 限制返回给客户端的候选数
+ * @param {*CompletionResponse} rsp - 补全响应
+ * @param {int} n - 客户端请求的候选数，不大于1时只保留最优的一个
+ */
+func LimitChoices(rsp *CompletionResponse, n int) {
+	n = max(n, 1)
+	if len(rsp.Choices) > n {
+		rsp.Choices = rsp.Choices[:n]
+	}
+}
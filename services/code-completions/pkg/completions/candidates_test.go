@@ -0,0 +1,57 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+	"testing"
+)
+
+func Test_rankCandidates(t *testing.T) {
+	cands := []*candidate{
+		{text: "x"},
+		{text: "return a + b", logprob: -0.1, hasLogprob: true},
+		{text: "return a + b\n", logprob: -0.05, hasLogprob: true},
+		{text: "return a - b", logprob: -0.3, hasLogprob: true},
+	}
+	ranked := rankCandidates(cands, "", "", "")
+	if len(ranked) != 3 {
+		t.Fatalf("expected 3 candidates after dedup, got %d", len(ranked))
+	}
+	if ranked[0].text != "return a + b" || ranked[0].votes != 2 || ranked[0].logprob != -0.05 {
+		t.Errorf("unexpected best candidate: %+v", ranked[0])
+	}
+	if ranked[1].text != "return a - b" {
+		t.Errorf("likely candidate should rank above the unknown one, got %q", ranked[1].text)
+	}
+}
+
+func Test_MergeResponses(t *testing.T) {
+	rsps := []*CompletionResponse{
+		{Model: "a", Status: model.StatusEmpty, PrunerHits: []string{"repetition"}},
+		{Model: "b", Status: model.StatusSuccess, Choices: []CompletionChoice{{Text: "foo()", Score: 0.6}, {Text: "bar()", Score: 0.5}}},
+		{Model: "c", Status: model.StatusSuccess, Choices: []CompletionChoice{{Text: "bar()", Score: 0.55}}},
+	}
+	rsp := MergeResponses(rsps)
+	if rsp.Model != "b" || len(rsp.Choices) != 2 {
+		t.Fatalf("unexpected merged response: %+v", rsp)
+	}
+	if rsp.Choices[0].Text != "bar()" || rsp.Choices[0].Model != "b" {
+		t.Errorf("candidate given by two models should rank first, got %+v", rsp.Choices)
+	}
+	if len(rsp.PrunerHits) != 1 {
+		t.Errorf("pruner hits of failed responses should be kept, got %v", rsp.PrunerHits)
+	}
+
+	LimitChoices(rsp, 0)
+	if len(rsp.Choices) != 1 {
+		t.Errorf("expected only the best choice, got %d", len(rsp.Choices))
+	}
+
+	//an empty success followed by one with choices
+	rsp = MergeResponses([]*CompletionResponse{
+		{Model: "a", Status: model.StatusSuccess},
+		{Model: "b", Status: model.StatusSuccess, Choices: []CompletionChoice{{Text: "foo()", Score: 0.6}}},
+	})
+	if rsp.Model != "b" || len(rsp.Choices) != 1 {
+		t.Fatalf("response with choices should be the base, got %+v", rsp)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"code-completion/pkg/config"
 	"code-completion/pkg/model"
+	"code-completion/pkg/trace"
 )
 
 /**
@@ -86,8 +87,9 @@ func NewCompletionHandler(m model.LLM) *CompletionHandler {
 	}
 }
 
-func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParameter {
-	// 3. 补全模型相关的前置处理 （拼接prompt策略，单行/多行补全策略，裁剪过长上下文）
+func (h *CompletionHandler) Adapt(c *CompletionContext, input *CompletionInput) *model.CompletionParameter {
+	// 3. 补全模型相关的前置处理 （拼接prompt策略，单行/多行补全策略，按预算组装上下文，裁剪过长上下文）
+	h.assembleContext(c, input)
 	h.truncatePrompt(h.cfg, &input.Processed)
 
 	// 4. 准备停用词，根据是否单行补全调整停用词
@@ -99,12 +101,18 @@ func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParam
 	para.ClientID = input.ClientID
 	para.CompletionID = input.CompletionID
 	para.Language = input.LanguageID
+	para.FilePath = input.Processed.FileProjectPath
+	para.TriggerMode = input.TriggerMode
 	para.Prefix = input.Processed.Prefix
 	para.Suffix = input.Processed.Suffix
 	para.CodeContext = input.Processed.CodeContext
 	para.Stop = stopWords
 	para.MaxTokens = h.cfg.MaxOutput
 	para.Temperature = float32(input.Temperature)
+	// 客户端需要多个候选时至少采样同样多个
+	cc := &config.Get().Wrapper.Candidates
+	para.N = max(cc.N, min(input.N, cc.MaxChoices))
+	para.Logprobs = cc.Logprobs
 	return &para
 }
 
@@ -131,8 +139,20 @@ func (h *CompletionHandler) Adapt(input *CompletionInput) *model.CompletionParam
  */
 func (h *CompletionHandler) CallLLM(c *CompletionContext, para *model.CompletionParameter) *CompletionResponse {
 	modelStartTime := time.Now().Local()
-	rsp, verbose, completionStatus, err := h.llm.Completions(c.Ctx, para)
+	ctx, span := trace.Start(c.Ctx, "llm.completions", trace.KindClient)
+	span.SetAttr("model", para.Model)
+	rsp, verbose, completionStatus, err := h.llm.Completions(ctx, para)
+	span.SetAttr("status", string(completionStatus))
+	if rsp != nil {
+		span.SetAttr("prompt_tokens", rsp.Usage.PromptTokens)
+		span.SetAttr("completion_tokens", rsp.Usage.CompletionTokens)
+	}
+	span.SetError(err)
+	span.End()
 	modelEndTime := time.Now().Local()
+	if verbose != nil && c.Perf.ContextAllocation != nil {
+		verbose.Context = c.Perf.ContextAllocation
+	}
 	c.Perf.LLMDuration = modelEndTime.Sub(modelStartTime).Milliseconds()
 
 	if completionStatus != model.StatusSuccess {
@@ -140,26 +160,33 @@ func (h *CompletionHandler) CallLLM(c *CompletionContext, para *model.Completion
 		return ErrorResponse(para.CompletionID, para.Model, completionStatus, c.Perf, verbose, err)
 	}
 
-	var completionText string
+	// 6. 修剪所有候选，多个候选时按语法、对数概率、长度排序
+	cands, prunerHits := h.collectCandidates(c.Ctx, rsp.Choices, para)
+	var rawText string
 	if len(rsp.Choices) > 0 {
-		completionText = rsp.Choices[0].Text
-	}
-	if completionText != "" {
-
-		if !h.cfg.DisablePrune {
-			completionText = h.pruneCompletionCode(completionText, para.Prefix, para.Suffix, para.Language)
-		}
+		rawText = rsp.Choices[0].Text
 	}
 	c.Perf.PromptTokens = rsp.Usage.PromptTokens
 	c.Perf.CompletionTokens = rsp.Usage.CompletionTokens
 	c.Perf.TotalTokens = c.Perf.CompletionTokens + c.Perf.PromptTokens
 
-	if completionText == "" {
-		return ErrorResponse(para.CompletionID, para.Model, model.StatusEmpty, c.Perf, verbose, fmt.Errorf("empty"))
+	var result *CompletionResponse
+	if len(cands) == 0 {
+		result = ErrorResponse(para.CompletionID, para.Model, model.StatusEmpty, c.Perf, verbose, fmt.Errorf("empty"))
+	} else {
+		// 7. 构建响应
+		result = SuccessResponse(para.CompletionID, para.Model, cands[0].text, c.Perf, verbose)
+		rawText = cands[0].raw
+		if len(rsp.Choices) > 1 || para.Rerank {
+			result.Choices = make([]CompletionChoice, 0, len(cands))
+			for _, cand := range cands {
+				result.Choices = append(result.Choices, CompletionChoice{Text: cand.text, Score: cand.score})
+			}
+		}
 	}
-
-	// 7. 构建响应
-	return SuccessResponse(para.CompletionID, para.Model, completionText, c.Perf, verbose)
+	result.RawText = rawText
+	result.PrunerHits = prunerHits
+	return result
 }
 
 /**
@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"code-completion/pkg/config"
@@ -42,6 +43,7 @@ type FilterChain struct {
  * - Creates a chain of filters to evaluate completion requests
  * - Adds hidden score filter if not disabled in configuration
  * - Adds language feature filter if not disabled in configuration
+ * - Appends rules defined in wrapper.filters, a hit rejects with the rule name
  * - Filters are executed in the order they are added
  * @example
  * chain := NewFilterChain(config)
@@ -61,6 +63,11 @@ func NewFilterChain(cfg *config.WrapperConfig) *FilterChain {
 		handlers = append(handlers, NewSyntaxFilter(&cfg.Syntax))
 	}
 
+	// 配置定义的过滤规则
+	for _, r := range filterRules.get(cfg.Filters) {
+		handlers = append(handlers, r)
+	}
+
 	return &FilterChain{
 		filters: handlers,
 	}
@@ -441,7 +448,14 @@ func (h *HiddenScoreFilter) Judge(in *CompletionInput) RejectCode {
 
 	score := 0.0
 	if in.HideScores.DocumentLength != 0 {
-		score = h.CalculateHideScore(in.HideScores, in.Processed.Prefix, in.LanguageID)
+		// 优先使用按语言重新拟合的系数
+		scorer := h
+		if lf := loadHiddenScoreFilter(LanguageScoreFile(in.LanguageID)); lf != nil {
+			scorer = lf
+		}
+		features := scorer.GetFeaturesAt(in.HideScores, in.Processed.Prefix, in.LanguageID, in.now())
+		score = scorer.Score(features)
+		rememberFeatures(in.CompletionID, in.LanguageID, in.Model, features)
 	}
 
 	// 将分数更新到请求数据中（问题4修复）
@@ -464,7 +478,61 @@ func (h *HiddenScoreFilter) Judge(in *CompletionInput) RejectCode {
 	return Accepted
 }
 
+// 隐藏分系数文件检查修改的最小间隔，避免每个请求都访问文件系统
+var scoreFileCheckInterval = 10 * time.Second
+
+// 已加载的隐藏分系数文件，文件修改后自动重新加载
+type cachedScoreFilter struct {
+	checked time.Time          // 上次检查文件的时间
+	modTime time.Time          // 文件的修改时间
+	filter  *HiddenScoreFilter // 文件不存在或内容无效时为nil
+}
+
+var scoreFilterCache sync.Map
+
+/**
+ * 加载隐藏分系数文件
+ * @param {string} configPath - 系数文件路径
+ * @returns {*HiddenScoreFilter} 返回系数的副本，文件不存在或内容无效时返回nil
+ * @description
+ * - 文件内容只在修改时间变化后重新读取
+ * - 同一文件每scoreFileCheckInterval最多检查一次，不存在的文件同样缓存
+ */
 func loadHiddenScoreFilter(configPath string) *HiddenScoreFilter {
+	if configPath == "" {
+		return nil
+	}
+	now := time.Now()
+	var cached *cachedScoreFilter
+	if v, ok := scoreFilterCache.Load(configPath); ok {
+		cached = v.(*cachedScoreFilter)
+		if now.Sub(cached.checked) < scoreFileCheckInterval {
+			return cached.copyFilter()
+		}
+	}
+	next := &cachedScoreFilter{checked: now}
+	if stat, err := os.Stat(configPath); err == nil {
+		next.modTime = stat.ModTime()
+		if cached != nil && cached.filter != nil && cached.modTime.Equal(next.modTime) {
+			next.filter = cached.filter
+		} else {
+			next.filter = readHiddenScoreFilter(configPath)
+		}
+	}
+	scoreFilterCache.Store(configPath, next)
+	return next.copyFilter()
+}
+
+// 返回缓存系数的副本，调用方可以修改阈值，系数本身只读
+func (c *cachedScoreFilter) copyFilter() *HiddenScoreFilter {
+	if c.filter == nil {
+		return nil
+	}
+	filter := *c.filter
+	return &filter
+}
+
+func readHiddenScoreFilter(configPath string) *HiddenScoreFilter {
 	bytes, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil
@@ -549,114 +617,143 @@ func NewHiddenScoreFilter(configPath string, thresholdScore float64) *HiddenScor
  * }
  */
 func (h *HiddenScoreFilter) CalculateHideScore(scores *HiddenScoreOptions, prefix, language string) float64 {
+	return h.Score(h.GetFeatures(scores, prefix, language))
+}
+
+/**
+ * 隐藏分特征
+ * @description
+ * - 由HiddenScoreOptions、前缀和语言计算得出
+ * - 补全时记录下来，和用户反馈(接受/拒绝)一起用于重新拟合隐藏分系数
+ */
+type HiddenScoreFeatures struct {
+	PreviousLabel             float64 `json:"previous_label"`                // 上个请求是否被接受
+	WhitespaceAfterCursor     float64 `json:"whitespace_after_cursor"`       // 当前行光标后是否为空
+	TimeSincePreviousLabelLog float64 `json:"time_since_previous_label_log"` // 距上次触发时间间隔(取对数)
+	PrefixLengthLog           float64 `json:"prefix_length_log"`             // 前缀尾行长度(取对数)
+	SuffixLengthLog           float64 `json:"suffix_length_log"`             // 前缀去除尾部空白后尾行长度(取对数)
+	DocumentLengthLog         float64 `json:"document_length_log"`           // 文档长度(取对数)
+	PromptEndPosLog           float64 `json:"prompt_end_pos_log"`            // 光标所在文档位置(取对数)
+	PromptEndPosRatio         float64 `json:"prompt_end_pos_ratio"`          // 光标位置与文档长度的比值
+	LanguageIndex             int     `json:"language_index"`                // 语言在ContextualFilterLanguageMap中的序号
+	PrefixLastCharIndex       int     `json:"prefix_last_char_index"`        // 前缀最后一个字符的序号
+	SuffixLastCharIndex       int     `json:"suffix_last_char_index"`        // 前缀最后一个有效行最后一个字符的序号
+}
+
+// 隐藏分权重向量的布局：8个数值特征，从第8个位置开始是语言权重，第29个开始是前缀字符权重，第125个开始是后缀字符权重
+const (
+	hiddenScoreLanguageOffset   = 8
+	hiddenScorePrefixCharOffset = 29
+	hiddenScoreSuffixCharOffset = 125
+)
+
+/**
+ * Calculate contextual features used by the hidden score
+ * @param {HiddenScoreOptions} scores - Score calculation parameters
+ * @param {string} prefix - Code before cursor
+ * @param {string} language - Programming language identifier
+ * @returns {HiddenScoreFeatures} Returns the features of the completion request
+ */
+func (h *HiddenScoreFilter) GetFeatures(scores *HiddenScoreOptions, prefix, language string) HiddenScoreFeatures {
+	return h.GetFeaturesAt(scores, prefix, language, time.Now())
+}
+
+/**
+ * Calculate contextual features at a given time
+ * @param {time.Time} now - Time the request is judged at, the time since the previous label is relative to it
+ * @description
+ * - Replay passes the capture time of the request so the results are reproducible
+ */
+func (h *HiddenScoreFilter) GetFeaturesAt(scores *HiddenScoreOptions, prefix, language string, now time.Time) HiddenScoreFeatures {
+	var f HiddenScoreFeatures
+	f.PreviousLabel = float64(scores.PreviousLabel)
+
 	// 判断光标权重
-	whitespaceAfterCursor := 0.0
 	if scores.IsWhitespaceAfterCursor {
-		whitespaceAfterCursor = 1.0
+		f.WhitespaceAfterCursor = 1.0
 	}
 
 	// 触发时间间隔
-	timeSincePreviousLabel := float64(time.Now().Unix()*1000-scores.PreviousLabelTimestamp) / 1000.0
+	timeSincePreviousLabel := float64(now.Unix()*1000-scores.PreviousLabelTimestamp) / 1000.0
 
 	// 3.6最小值参考copilot的设置
-	timeSincePreviousLabelLog := math.Log(1.0 + math.Max(3.6, timeSincePreviousLabel))
+	f.TimeSincePreviousLabelLog = math.Log(1.0 + math.Max(3.6, timeSincePreviousLabel))
 
-	prefixLengthLog := 0.0
-	prefixLastCharWeight := 0
 	prefixStr := prefix
-
 	if prefixStr != "" {
-		prefixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(prefixStr)))
+		f.PrefixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(prefixStr)))
 		prefixLastChar := prefixStr[len(prefixStr)-1:]
 		if weight, exists := h.ContextualFilterCharacterMap[prefixLastChar]; exists {
-			prefixLastCharWeight = weight
+			f.PrefixLastCharIndex = weight
 		}
 	}
 
-	suffixLengthLog := 0.0
-	suffixLastCharWeight := 0
-
 	// 参考const g = h.trimEnd(); 应该把换行符号也删掉
 	trimmedSuffixStr := strings.TrimRight(prefixStr, " \t\n\r")
 	if trimmedSuffixStr != "" {
-		suffixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(trimmedSuffixStr)))
+		f.SuffixLengthLog = math.Log(1.0 + float64(h.getLastLineLength(trimmedSuffixStr)))
 		suffixLastChar := trimmedSuffixStr[len(trimmedSuffixStr)-1:]
 		if weight, exists := h.ContextualFilterCharacterMap[suffixLastChar]; exists {
-			suffixLastCharWeight = weight
+			f.SuffixLastCharIndex = weight
 		}
 	}
 
-	documentLengthLog := math.Log(1.0 + math.Max(float64(scores.DocumentLength), 0.0))
-	promptEndPosLog := math.Log(1.0 + math.Max(float64(scores.PromptEndPos), 0.0))
-	promptEndPosRatio := (float64(scores.PromptEndPos) + 0.5) / (1.0 + float64(scores.DocumentLength))
+	f.DocumentLengthLog = math.Log(1.0 + math.Max(float64(scores.DocumentLength), 0.0))
+	f.PromptEndPosLog = math.Log(1.0 + math.Max(float64(scores.PromptEndPos), 0.0))
+	f.PromptEndPosRatio = (float64(scores.PromptEndPos) + 0.5) / (1.0 + float64(scores.DocumentLength))
 
 	// 若不支持该语言，默认走python
-	languageWeight := 4 // python的默认值
+	f.LanguageIndex = 4 // python的默认值
 	if weight, exists := h.ContextualFilterLanguageMap[language]; exists {
-		languageWeight = weight
-	}
-
-	// 初始值-0.3
-	score := h.ContextualFilterIntercept
-
-	// 上一个标签的权重(上一次接受的话，下一次基本都会给予补全) +0.99
-	if len(h.ContextualFilterWeights) > 0 {
-		score += h.ContextualFilterWeights[0] * float64(scores.PreviousLabel)
-	}
-
-	// 当前行光标后为空的话倾向补全 + 0.7
-	if len(h.ContextualFilterWeights) > 1 {
-		score += h.ContextualFilterWeights[1] * whitespaceAfterCursor
-	}
-
-	// 时间间隔的权重，上一次触发的时间越久越不补全 - 0.17
-	if len(h.ContextualFilterWeights) > 2 {
-		score += h.ContextualFilterWeights[2] * timeSincePreviousLabelLog
-	}
-
-	// 前缀尾行长度的权重，尾行越长越不补全 - 0.22
-	if len(h.ContextualFilterWeights) > 3 {
-		score += h.ContextualFilterWeights[3] * prefixLengthLog
-	}
-
-	// 前缀去除空行或者空格后尾行长度的权重（去除空格或空行后的尾行），后缀越长越补全 + 0.13
-	if len(h.ContextualFilterWeights) > 4 {
-		score += h.ContextualFilterWeights[4] * suffixLengthLog
-	}
-
-	// 文档长度的权重，越长越不补 - 0.007
-	if len(h.ContextualFilterWeights) > 5 {
-		score += h.ContextualFilterWeights[5] * documentLengthLog
-	}
-
-	// 光标所在文档位置的权重，越靠后越补 + 0.005
-	if len(h.ContextualFilterWeights) > 6 {
-		score += h.ContextualFilterWeights[6] * promptEndPosLog
-	}
-
-	// 光标位置与文档长度的比值的权重，越靠后越补 + 0.41
-	if len(h.ContextualFilterWeights) > 7 {
-		score += h.ContextualFilterWeights[7] * promptEndPosRatio
-	}
-
-	// 语言权重
-	languageWeightIndex := 8 + languageWeight
-	if len(h.ContextualFilterWeights) > int(languageWeightIndex) {
-		score += h.ContextualFilterWeights[languageWeightIndex]
+		f.LanguageIndex = weight
 	}
+	return f
+}
 
-	// 前缀的最后一个字符的权重
-	prefixCharWeightIndex := 29 + prefixLastCharWeight
-	if len(h.ContextualFilterWeights) > int(prefixCharWeightIndex) {
-		score += h.ContextualFilterWeights[prefixCharWeightIndex]
+/**
+ * Convert features into a dense vector matching ContextualFilterWeights
+ * @param {HiddenScoreFeatures} f - Features of a completion request
+ * @param {int} size - Length of the vector
+ * @returns {[]float64} Returns the feature vector, features beyond size are dropped
+ */
+func (f *HiddenScoreFeatures) Vector(size int) []float64 {
+	x := make([]float64, size)
+	values := []float64{
+		f.PreviousLabel,             // 上一个标签的权重(上一次接受的话，下一次基本都会给予补全) +0.99
+		f.WhitespaceAfterCursor,     // 当前行光标后为空的话倾向补全 + 0.7
+		f.TimeSincePreviousLabelLog, // 时间间隔的权重，上一次触发的时间越久越不补全 - 0.17
+		f.PrefixLengthLog,           // 前缀尾行长度的权重，尾行越长越不补全 - 0.22
+		f.SuffixLengthLog,           // 前缀去除空行或者空格后尾行长度的权重，后缀越长越补全 + 0.13
+		f.DocumentLengthLog,         // 文档长度的权重，越长越不补 - 0.007
+		f.PromptEndPosLog,           // 光标所在文档位置的权重，越靠后越补 + 0.005
+		f.PromptEndPosRatio,         // 光标位置与文档长度的比值的权重，越靠后越补 + 0.41
+	}
+	copy(x, values)
+	// 语言权重、前缀的最后一个字符的权重、前缀最后一个有效行的最后一个字符的权重
+	for _, idx := range []int{
+		hiddenScoreLanguageOffset + f.LanguageIndex,
+		hiddenScorePrefixCharOffset + f.PrefixLastCharIndex,
+		hiddenScoreSuffixCharOffset + f.SuffixLastCharIndex,
+	} {
+		if idx < size {
+			x[idx] = 1.0
+		}
 	}
+	return x
+}
 
-	// 前缀最后一个有效行的最后一个字符的权重
-	suffixCharWeightIndex := 125 + suffixLastCharWeight
-	if len(h.ContextualFilterWeights) > int(suffixCharWeightIndex) {
-		score += h.ContextualFilterWeights[suffixCharWeightIndex]
+/**
+ * Calculate hide score from features
+ * @param {HiddenScoreFeatures} f - Features of a completion request
+ * @returns {float64} Returns probability of completion acceptance between 0 and 1
+ */
+func (h *HiddenScoreFilter) Score(f HiddenScoreFeatures) float64 {
+	// 初始值-0.3
+	score := h.ContextualFilterIntercept
+	x := f.Vector(len(h.ContextualFilterWeights))
+	for i, w := range h.ContextualFilterWeights {
+		score += w * x[i]
 	}
-
 	probabilityAccept := 1.0 / (1.0 + math.Exp(-score))
 	return probabilityAccept
 }
@@ -4,6 +4,7 @@ import (
 	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/config"
 	"code-completion/pkg/model"
+	"code-completion/pkg/trace"
 	"net/http"
 	"time"
 )
@@ -24,20 +25,30 @@ import (
  * response := input.Preprocess(ctx)
  */
 type CompletionInput struct {
-	CompletionRequest               //原始请求中的BODY
-	Headers           http.Header   //原始请求中的头部
-	Processed         PromptOptions //加工过的提示词
+	CompletionRequest                                 //原始请求中的BODY
+	Headers           http.Header                     //原始请求中的头部
+	Processed         PromptOptions                   //加工过的提示词
+	ContextItems      []*codebase_context.ContextItem //检索到的上下文片段，选定模型后按token预算组装
+	Now               time.Time                       //计算隐藏分时间特征的当前时间，为空时取系统时间，回放时为采集请求的时间
+}
+
+// 计算时间特征使用的当前时间
+func (in *CompletionInput) now() time.Time {
+	if in.Now.IsZero() {
+		return time.Now()
+	}
+	return in.Now
 }
 
 /**
- * 代码上下文客户端实例
+ * 代码上下文提供者实例
  * @description
  * - 全局单例，用于获取代码上下文信息
  * - 在GetContext方法中延迟初始化
- * - 提供代码库上下文查询功能
+ * - 按context.provider配置使用远程索引服务或本地检索
  * - 用于增强补全请求的上下文信息
  */
-var contextClient *codebase_context.ContextClient
+var contextClient codebase_context.ContextProvider
 
 /**
  * 处理补全请求
@@ -62,7 +73,10 @@ var contextClient *codebase_context.ContextClient
  */
 func (in *CompletionInput) Preprocess(c *CompletionContext) *CompletionResponse {
 	// 0. 补全拒绝规则链处理
-	err := NewFilterChain(config.Wrapper).Handle(in)
+	_, span := trace.Start(c.Ctx, "filter_chain", trace.KindInternal)
+	err := NewFilterChain(&config.Get().Wrapper).Handle(in)
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		return CancelRequest(in.CompletionID, in.Model, c.Perf, model.StatusRejected, err)
 	}
@@ -79,19 +93,21 @@ func (in *CompletionInput) Preprocess(c *CompletionContext) *CompletionResponse
  * @description
  * - 如果代码上下文已存在，直接返回
  * - 延迟初始化上下文客户端
- * - 调用上下文客户端获取代码上下文
+ * - 调用上下文客户端获取代码上下文片段，选定模型后由Adapt按token预算组装
  * - 记录获取上下文的耗时
  * - 用于增强补全请求的上下文信息
  */
 func (in *CompletionInput) GetContext(c *CompletionContext) {
-	if in.Processed.CodeContext != "" {
+	// 已有上下文，或者上下文片段已经给定(例如回放采集的请求)
+	if in.Processed.CodeContext != "" || len(in.ContextItems) > 0 {
 		return
 	}
 	if contextClient == nil {
-		contextClient = codebase_context.NewContextClient()
+		contextClient = codebase_context.NewContextProvider()
 	}
-	in.Processed.CodeContext = contextClient.GetContext(
-		c.Ctx,
+	in.ContextItems = contextClient.GetContextItems(
+		codebase_context.WithTokenCounter(
+			codebase_context.WithNeighborFiles(c.Ctx, in.Processed.NeighborFiles), contextTokenCounter()),
 		in.ClientID,
 		in.Processed.ProjectPath,
 		in.Processed.FileProjectPath,
@@ -103,6 +119,19 @@ func (in *CompletionInput) GetContext(c *CompletionContext) {
 	c.Perf.ContextDuration = time.Since(c.Perf.ReceiveTime).Milliseconds()
 }
 
+/**
+ * 获取检索上下文时使用的token计数函数
+ * @returns {func(string) int} 返回第一个可用模型分词器的计数函数，没有分词器时返回nil
+ * @description
+ * - 检索上下文时尚未选定模型，组装上下文时还会按选定模型的分词器重新计数
+ */
+func contextTokenCounter() func(string) int {
+	if models := model.GetModels(); len(models) > 0 && models[0].Tokenizer() != nil {
+		return models[0].Tokenizer().GetTokenCount
+	}
+	return nil
+}
+
 /**
  * 解析提示词
  * @description
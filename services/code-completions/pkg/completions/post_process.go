@@ -2,50 +2,61 @@ package completions
 
 import (
 	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"context"
 
 	"go.uber.org/zap"
 )
 
 /**
  * 修剪补全结果
+ * @param {context.Context} ctx - 请求上下文，用于记录每个处理器的span
  * @param {string} completionText - 原始补全文本内容
- * @param {string} prefix - 代码前缀文本
- * @param {string} suffix - 代码后缀文本
- * @param {string} lang - 编程语言标识符
- * @returns {string} 返回修剪后的补全文本
+ * @param {*model.CompletionParameter} para - 补全参数，提供前后缀、语言等修剪规则使用的信息
+ * @returns {string, []string} 返回修剪后的补全文本和命中的修剪器
  * @description
  * - 使用后置处理器链修剪补全结果
- * - 如果配置了自定义修剪器，使用自定义链
- * - 否则使用默认的后置处理器链
+ * - 模型配置了customPruners时优先使用，其次是wrapper.prune.pruners，名称可以引用配置定义的规则
+ * - 否则使用默认的后置处理器链，并追加所有配置定义的修剪规则
  * - 记录修剪过程的调试信息
  * - 用于优化补全结果的质量和格式
  * @example
- * result := handler.pruneCompletionCode(
+ * result, hits := handler.pruneCompletionCode(
+ *     ctx,
  *     "function test() {\n    return;\n}\nfunction test2() {}",
- *     "function test() {",
- *     "}",
- *     "javascript"
+ *     &model.CompletionParameter{Prefix: "function test() {", Suffix: "}", Language: "javascript"},
  * )
  * // 结果可能移除重复的函数定义
  */
-func (h *CompletionHandler) pruneCompletionCode(completionText, prefix, suffix, lang string) string {
+func (h *CompletionHandler) pruneCompletionCode(ctx context.Context, completionText string, para *model.CompletionParameter) (string, []string) {
 	prunerContext := &PrunerContext{
-		Language:       lang,
+		Ctx:            ctx,
+		Language:       para.Language,
 		CompletionCode: completionText,
-		Prefix:         prefix,
-		Suffix:         suffix,
+		Prefix:         para.Prefix,
+		Suffix:         para.Suffix,
+		FilePath:       para.FilePath,
+		TriggerMode:    para.TriggerMode,
+		Model:          para.Model,
+	}
+	prune := &config.Get().Wrapper.Prune
+	rules := pruneRules.get(prune.Rules)
+	names := prune.Pruners
+	if len(h.cfg.CustomPruners) > 0 {
+		names = h.cfg.CustomPruners
 	}
 	var chain *PrunerChain
 	var err error
-	if len(config.Wrapper.Prune.Pruners) > 0 {
-		chain, err = NewPrunerChainByNames(config.Wrapper.Prune.Pruners)
+	if len(names) > 0 {
+		chain, err = NewPrunerChainByNames(names, rules...)
 		if err != nil {
-			zap.L().Error("Invalid config: 'wrapper.prune.pruners' contains invalid pruner names",
-				zap.Any("pruners", config.Wrapper.Prune.Pruners))
+			zap.L().Error("Invalid config: pruners contains invalid pruner names",
+				zap.Any("pruners", names), zap.Error(err))
 		}
 	}
 	if chain == nil {
 		chain = NewDefaultPrunerChain()
+		chain.AddRules(rules...)
 	}
 	if chain.Process(prunerContext) {
 		zap.L().Info("Prune by Pruners",
@@ -53,5 +64,5 @@ func (h *CompletionHandler) pruneCompletionCode(completionText, prefix, suffix,
 			zap.String("post", prunerContext.CompletionCode),
 			zap.Any("hits", chain.GetHitProcessors()))
 	}
-	return prunerContext.CompletionCode
+	return prunerContext.CompletionCode, chain.GetHitProcessors()
 }
@@ -1,10 +1,48 @@
 package completions
 
 import (
+	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/config"
 	"strings"
 )
 
+/**
+ * 按token预算组装上下文
+ * @param {*CompletionContext} c - 补全上下文，预算分配结果记录到性能统计中
+ * @param {*CompletionInput} input - 补全输入，包含检索到的上下文片段
+ * @description
+ * - 客户端已经提供了上下文，或者没有检索到上下文片段时不处理
+ * - 上下文预算 = 模型MaxPrefix - 前缀token数，配置了context.budget.maxTokens时不超过该值
+ * - 组装后的上下文一般不会再被truncatePrompt截断，即使截断也是先截掉排序值低的片段
+ */
+func (h *CompletionHandler) assembleContext(c *CompletionContext, input *CompletionInput) {
+	if input.Processed.CodeContext != "" || len(input.ContextItems) == 0 {
+		return
+	}
+	countTokens := h.getTokensCount
+	if h.llm.Tokenizer() == nil {
+		// 没有分词器时按4个字符一个token估算
+		countTokens = func(s string) int { return (len(s) + 3) / 4 }
+	}
+	cfg := &config.Get().Context.Budget
+	budget := h.cfg.MaxPrefix - countTokens(input.Processed.Prefix)
+	if cfg.MaxTokens > 0 {
+		budget = min(budget, cfg.MaxTokens)
+	}
+	assembler := &codebase_context.ContextAssembler{
+		Ratios: map[string]float64{
+			codebase_context.SourceDefinition: cfg.Definition,
+			codebase_context.SourceSemantic:   cfg.Semantic,
+			codebase_context.SourceRelation:   cfg.Relation,
+			codebase_context.SourceLocal:      cfg.Local,
+		},
+		DistanceWeight: cfg.DistanceWeight,
+		CountTokens:    countTokens,
+	}
+	input.Processed.CodeContext, c.Perf.ContextAllocation = assembler.Assemble(input.ContextItems,
+		input.Processed.FileProjectPath, input.Processed.Prefix, input.Processed.Suffix, budget)
+}
+
 /**
  * 截断超长的提示词(前缀，后缀，上下文)
  * @param {*config.ModelConfig} cfg - 模型配置，包含最大前缀和后缀token限制
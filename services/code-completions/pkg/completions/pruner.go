@@ -2,6 +2,8 @@ package completions
 
 import (
 	"code-completion/pkg/parser"
+	"code-completion/pkg/trace"
+	"context"
 	"fmt"
 	"strings"
 )
@@ -86,6 +88,11 @@ type PrunerContext struct {
 	CompletionCode string `json:"completion_code"`
 	Prefix         string `json:"prefix"`
 	Suffix         string `json:"suffix"`
+	FilePath       string `json:"file_path"`
+	TriggerMode    string `json:"trigger_mode"`
+	Model          string `json:"model"`
+
+	Ctx context.Context `json:"-"` //请求上下文，用于记录每个处理器的span，可以为空
 }
 
 /**
@@ -168,9 +175,10 @@ func NewPrunerChain(discarders, cutters []Pruner) *PrunerChain {
 /**
  * 根据名称创建后置处理器链
  * @param {[]string} names - 处理器名称列表
+ * @param {[]*Rule} rules - 配置定义的修剪规则，可以按名称引用
  * @returns {*PrunerChain, error} 返回处理器链和错误信息
  * @description
- * - 根据处理器名称查找对应的处理器实例
+ * - 根据处理器名称查找对应的处理器实例，内置处理器优先
  * - 将处理器按类型分组到丢弃器和裁剪器
  * - 如果遇到无效的处理器名称，返回错误
  * - 使用查找到的处理器创建处理器链
@@ -183,12 +191,15 @@ func NewPrunerChain(discarders, cutters []Pruner) *PrunerChain {
  *     log.Fatal("创建处理器链失败:", err)
  * }
  */
-func NewPrunerChainByNames(names []string) (*PrunerChain, error) {
+func NewPrunerChainByNames(names []string, rules ...*Rule) (*PrunerChain, error) {
 	dicarders := make([]Pruner, 0)
 	cutters := make([]Pruner, 0)
 	for _, name := range names {
 		p, exists := prunerDefs[name]
 		if !exists {
+			p = findRule(rules, name)
+		}
+		if p == nil {
 			return nil, fmt.Errorf("Invalid Pruner: %s", name)
 		}
 		if p.Type() == TypeDiscarder {
@@ -200,6 +211,29 @@ func NewPrunerChainByNames(names []string) (*PrunerChain, error) {
 	return NewPrunerChain(dicarders, cutters), nil
 }
 
+func findRule(rules []*Rule, name string) Pruner {
+	for _, r := range rules {
+		if r.Name() == name {
+			return r
+		}
+	}
+	return nil
+}
+
+/**
+ * 追加配置定义的修剪规则
+ * @param {[]*Rule} rules - 修剪规则，按类型追加到丢弃器或裁剪器之后
+ */
+func (c *PrunerChain) AddRules(rules ...*Rule) {
+	for _, r := range rules {
+		if r.Type() == TypeDiscarder {
+			c.discarders = append(c.discarders, r)
+		} else {
+			c.cutters = append(c.cutters, r)
+		}
+	}
+}
+
 /**
  * 创建默认的后置处理器链
  * @returns {*PrunerChain} 返回默认配置的处理器链
@@ -244,7 +278,7 @@ func NewDefaultPrunerChain() *PrunerChain {
 */
 func (c *PrunerChain) processDiscard(ctx *PrunerContext) bool {
 	for _, dicarder := range c.discarders {
-		if dicarder.Process(ctx) {
+		if runPruner(ctx, dicarder) {
 			c.hitProcessors = append(c.hitProcessors, dicarder.Name())
 			return true
 		}
@@ -269,7 +303,7 @@ func (c *PrunerChain) processDiscard(ctx *PrunerContext) bool {
 func (c *PrunerChain) processCut(ctx *PrunerContext) bool {
 	result := false
 	for _, cutter := range c.cutters {
-		if cutter.Process(ctx) {
+		if runPruner(ctx, cutter) {
 			c.hitProcessors = append(c.hitProcessors, cutter.Name())
 			result = true
 		}
@@ -277,6 +311,18 @@ func (c *PrunerChain) processCut(ctx *PrunerContext) bool {
 	return result
 }
 
+// 执行一个处理器，请求上下文存在时记录为一个span
+func runPruner(ctx *PrunerContext, p Pruner) bool {
+	if ctx.Ctx == nil {
+		return p.Process(ctx)
+	}
+	_, span := trace.Start(ctx.Ctx, "pruner."+p.Name(), trace.KindInternal)
+	hit := p.Process(ctx)
+	span.SetAttr("hit", hit)
+	span.End()
+	return hit
+}
+
 /**
  * 执行完整的后置处理流程
  * @param {*PrunerContext} ctx - 后置处理器上下文
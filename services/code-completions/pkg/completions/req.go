@@ -1,5 +1,7 @@
 package completions
 
+import "code-completion/pkg/codebase_context"
+
 // 补全请求结构
 type CompletionRequest struct {
 	Model           string                 `json:"model,omitempty"`
@@ -16,6 +18,7 @@ type CompletionRequest struct {
 	ParentID        string                 `json:"parent_id,omitempty"`
 	Stop            []string               `json:"stop,omitempty"`
 	Verbose         bool                   `json:"verbose,omitempty"`
+	N               int                    `json:"n,omitempty"` //需要返回的候选数，不大于1时只返回最优的一个
 	Extra           map[string]interface{} `json:"extra,omitempty"`
 	Prompts         *PromptOptions         `json:"prompt_options,omitempty"`
 	HideScores      *HiddenScoreOptions    `json:"calculate_hide_score,omitempty"`
@@ -29,6 +32,8 @@ type PromptOptions struct {
 	ProjectPath     string `json:"project_path,omitempty"`
 	FileProjectPath string `json:"file_project_path,omitempty"`
 	ImportContent   string `json:"import_content,omitempty"`
+
+	NeighborFiles []codebase_context.NeighborFile `json:"neighbor_files,omitempty"` //邻近文件片段，用于本地上下文检索
 }
 
 // 计算隐藏分数配置
@@ -1,6 +1,7 @@
 package completions
 
 import (
+	"code-completion/pkg/codebase_context"
 	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
 	"fmt"
@@ -30,7 +31,9 @@ type CompletionUsage struct {
  * - 用于向客户端返回补全建议
  */
 type CompletionChoice struct {
-	Text string `json:"text"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score,omitempty"` //候选排序分，只有多个候选时给出
+	Model string  `json:"model,omitempty"` //生成该候选的模型，多模型池并发请求时给出
 }
 
 /**
@@ -51,6 +54,8 @@ type CompletionPerformance struct {
 	PromptTokens     int       `json:"prompt_tokens"`
 	CompletionTokens int       `json:"completion_tokens"`
 	TotalTokens      int       `json:"total_tokens"`
+
+	ContextAllocation *codebase_context.ContextAllocation `json:"context_allocation,omitempty"` //上下文预算分配结果
 }
 
 /**
@@ -71,6 +76,10 @@ type CompletionResponse struct {
 	Status  model.CompletionStatus   `json:"status"`
 	Error   string                   `json:"error"`
 	Verbose *model.CompletionVerbose `json:"verbose,omitempty"`
+	TraceID string                   `json:"trace_id,omitempty"` //链路ID，启用链路追踪时给出
+
+	RawText    string   `json:"-"` //模型返回的原始补全(修剪前)，用于采集和回放评估
+	PrunerHits []string `json:"-"` //命中的修剪器
 }
 
 /**
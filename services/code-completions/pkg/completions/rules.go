@@ -0,0 +1,232 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/expr"
+	"code-completion/pkg/metrics"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// 规则的动作
+const (
+	RuleDiscard = "discard" // 丢弃整个补全
+	RuleCut     = "cut"     // 从匹配处截断补全
+	RuleReject  = "reject"  // 拒绝补全请求
+)
+
+/**
+ * 编译后的配置规则
+ * @description
+ * - 由wrapper.prune.rules/wrapper.filters中的一项编译而来
+ * - 同时实现Pruner和Filter接口，分别用于修剪器链和过滤器链
+ * - 规则命中时上报completion_rule_hits_total指标
+ */
+type Rule struct {
+	cfg       config.RuleConfig
+	languages map[string]bool
+	pattern   *regexp.Regexp
+	when      *expr.Program
+}
+
+/**
+ * 编译配置规则
+ * @param {config.RuleConfig} cfg - 规则配置
+ * @returns {*Rule, error} 返回编译后的规则，正则或条件表达式不合法时返回错误
+ */
+func NewRule(cfg config.RuleConfig) (*Rule, error) {
+	r := &Rule{cfg: cfg}
+	if len(cfg.Languages) > 0 {
+		r.languages = make(map[string]bool)
+		for _, lang := range cfg.Languages {
+			r.languages[strings.ToLower(lang)] = true
+		}
+	}
+	var err error
+	if cfg.Pattern != "" {
+		if r.pattern, err = regexp.Compile(cfg.Pattern); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.When != "" {
+		if r.when, err = expr.Compile(cfg.When); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Rule) Name() string {
+	return r.cfg.Name
+}
+
+func (r *Rule) Type() PrunerType {
+	if r.cfg.Action == RuleCut {
+		return TypeCutter
+	}
+	return TypeDiscarder
+}
+
+/**
+ * 作为修剪器处理补全
+ * @param {*PrunerContext} ctx - 修剪器上下文
+ * @returns {bool} 返回规则是否命中
+ * @description
+ * - discard规则命中时清空补全，cut规则从正则匹配的位置截断补全
+ * - 条件表达式中可以使用expr.Env定义的全部变量
+ */
+func (r *Rule) Process(ctx *PrunerContext) bool {
+	if !r.matchLanguage(ctx.Language) {
+		return false
+	}
+	env := &expr.Env{
+		Language:    ctx.Language,
+		Completion:  ctx.CompletionCode,
+		Prefix:      ctx.Prefix,
+		Suffix:      ctx.Suffix,
+		LinePrefix:  lastLine(ctx.Prefix),
+		LineSuffix:  firstLine(ctx.Suffix),
+		TriggerMode: ctx.TriggerMode,
+		FilePath:    ctx.FilePath,
+		Model:       ctx.Model,
+	}
+	loc := r.match(env, "completion")
+	if loc == nil {
+		return false
+	}
+	if r.cfg.Action == RuleCut {
+		if loc[0] >= len(ctx.CompletionCode) {
+			return false
+		}
+		ctx.CompletionCode = ctx.CompletionCode[:loc[0]]
+	} else {
+		ctx.CompletionCode = ""
+	}
+	metrics.IncrementRuleHits("pruner", r.cfg.Name)
+	return true
+}
+
+/**
+ * 作为过滤器判断补全请求
+ * @param {*CompletionInput} in - 补全输入
+ * @returns {RejectCode} 规则命中时返回规则名作为拒绝原因
+ * @description
+ * - 条件表达式中可以使用expr.Env定义的变量，completion为空
+ */
+func (r *Rule) Judge(in *CompletionInput) RejectCode {
+	if !r.matchLanguage(in.LanguageID) {
+		return Accepted
+	}
+	prefix, suffix := in.Prompt, ""
+	filePath := in.FileProjectPath
+	if in.Prompts != nil {
+		prefix, suffix = in.Prompts.Prefix, in.Prompts.Suffix
+		if in.Prompts.FileProjectPath != "" {
+			filePath = in.Prompts.FileProjectPath
+		}
+	}
+	env := &expr.Env{
+		Language:    in.LanguageID,
+		Prefix:      prefix,
+		Suffix:      suffix,
+		LinePrefix:  lastLine(prefix),
+		LineSuffix:  firstLine(suffix),
+		TriggerMode: in.TriggerMode,
+		FilePath:    filePath,
+		Model:       in.Model,
+	}
+	if r.match(env, "linePrefix") == nil {
+		return Accepted
+	}
+	metrics.IncrementRuleHits("filter", r.cfg.Name)
+	return RejectCode(r.cfg.Name)
+}
+
+func (r *Rule) matchLanguage(language string) bool {
+	return r.languages == nil || r.languages[strings.ToLower(language)]
+}
+
+func (r *Rule) target(def string) string {
+	if r.cfg.Target != "" {
+		return r.cfg.Target
+	}
+	return def
+}
+
+// 判断规则是否命中，返回正则在目标文本中匹配的位置(没有正则时为[0,0])
+func (r *Rule) match(env *expr.Env, defTarget string) []int {
+	if r.when != nil {
+		ok, err := r.when.EvalBool(env)
+		if err != nil {
+			zap.L().Warn("Evaluate rule condition failed", zap.String("rule", r.cfg.Name), zap.Error(err))
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+	}
+	if r.pattern == nil {
+		return []int{0, 0}
+	}
+	return r.pattern.FindStringIndex(targetText(env, r.target(defTarget)))
+}
+
+// 规则正则匹配的目标文本
+func targetText(env *expr.Env, target string) string {
+	switch target {
+	case "completion":
+		return env.Completion
+	case "prefix":
+		return env.Prefix
+	case "suffix":
+		return env.Suffix
+	case "linePrefix":
+		return env.LinePrefix
+	case "lineSuffix":
+		return env.LineSuffix
+	}
+	return ""
+}
+
+func lastLine(s string) string {
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// 编译后的规则缓存，配置变化(热加载)后重新编译
+type ruleCache struct {
+	mutex sync.Mutex
+	cfgs  []config.RuleConfig
+	rules []*Rule
+}
+
+var pruneRules, filterRules ruleCache
+
+// 获取编译后的规则，编译失败的规则被忽略(配置校验已经拦截了不合法的规则)
+func (c *ruleCache) get(cfgs []config.RuleConfig) []*Rule {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.rules != nil && reflect.DeepEqual(c.cfgs, cfgs) {
+		return c.rules
+	}
+	rules := make([]*Rule, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		r, err := NewRule(cfg)
+		if err != nil {
+			zap.L().Error("Invalid rule", zap.String("rule", cfg.Name), zap.Error(err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	c.cfgs = append([]config.RuleConfig(nil), cfgs...)
+	c.rules = rules
+	return rules
+}
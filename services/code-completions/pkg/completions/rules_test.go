@@ -0,0 +1,55 @@
+package completions
+
+import (
+	"code-completion/pkg/config"
+	"testing"
+)
+
+func Test_Rule(t *testing.T) {
+	cut, err := NewRule(config.RuleConfig{Name: "cut-vue_script", Languages: []string{"Vue"}, Action: RuleCut, Pattern: `<script[\s>]`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &PrunerContext{Language: "vue", CompletionCode: "<p>hi</p>\n<script>\nx\n</script>"}
+	if !cut.Process(ctx) || ctx.CompletionCode != "<p>hi</p>\n" {
+		t.Errorf("expected completion to be cut, got %q", ctx.CompletionCode)
+	}
+	ctx = &PrunerContext{Language: "html", CompletionCode: "<script>"}
+	if cut.Process(ctx) {
+		t.Error("rule should not apply to other languages")
+	}
+
+	discard, _ := NewRule(config.RuleConfig{Name: "discard-sql_drop", Action: RuleDiscard, Pattern: `(?i)drop\s+table`,
+		When: `linePrefix contains "\"" && model == "deepseek"`})
+	chain := NewPrunerChain(nil, nil)
+	chain.AddRules(cut, discard)
+	ctx = &PrunerContext{Language: "go", Prefix: "db.Exec(\"", CompletionCode: "DROP TABLE users\")", Model: "other"}
+	if chain.Process(ctx) {
+		t.Error("rule condition should check the model")
+	}
+	ctx = &PrunerContext{Language: "go", Prefix: "db.Exec(\"", CompletionCode: "DROP TABLE users\")", Model: "deepseek"}
+	if !chain.Process(ctx) || ctx.CompletionCode != "" || chain.GetHitProcessors()[0] != "discard-sql_drop" {
+		t.Errorf("expected completion to be discarded, got %q %v", ctx.CompletionCode, chain.GetHitProcessors())
+	}
+
+	reject, _ := NewRule(config.RuleConfig{Name: "reject-comment", Action: RuleReject, Pattern: `^\s*//`})
+	in := &CompletionInput{CompletionRequest: CompletionRequest{Prompts: &PromptOptions{Prefix: "func a() {\n  // todo"}}}
+	if code := reject.Judge(in); code != "reject-comment" {
+		t.Errorf("expected rejection, got %s", code)
+	}
+
+	manual, err := NewRule(config.RuleConfig{Name: "reject-auto_tests", Action: RuleReject,
+		When: `triggerMode == "auto" && filePath endsWith "_test.go"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = &CompletionInput{CompletionRequest: CompletionRequest{TriggerMode: "auto",
+		Prompts: &PromptOptions{Prefix: "func a() {", FileProjectPath: "pkg/a_test.go"}}}
+	if code := manual.Judge(in); code != "reject-auto_tests" {
+		t.Errorf("expected rejection by trigger mode and file path, got %s", code)
+	}
+	in.TriggerMode = "manual"
+	if code := manual.Judge(in); code != Accepted {
+		t.Errorf("manual trigger should be accepted, got %s", code)
+	}
+}
@@ -0,0 +1,186 @@
+package completions
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//	隐藏分在线学习：记录补全时的特征，根据用户反馈重新拟合系数
+//------------------------------------------------------------------------------
+
+// 补全时记录的隐藏分特征
+type RecordedFeatures struct {
+	Language string
+	Model    string
+	Features HiddenScoreFeatures
+	Time     time.Time
+}
+
+const (
+	featuresTTL      = 10 * time.Minute // 补全后等待反馈的最长时间
+	featuresCapacity = 100000           // 最多缓存的补全数，防止内存无限增长
+)
+
+var (
+	recordedFeatures = make(map[string]*RecordedFeatures)
+	featuresMutex    sync.Mutex
+)
+
+// 记录补全请求的隐藏分特征，等待客户端反馈
+func rememberFeatures(completionID, language, model string, f HiddenScoreFeatures) {
+	if completionID == "" {
+		return
+	}
+	featuresMutex.Lock()
+	defer featuresMutex.Unlock()
+
+	now := time.Now()
+	if len(recordedFeatures) >= featuresCapacity {
+		for id, r := range recordedFeatures {
+			if now.Sub(r.Time) > featuresTTL {
+				delete(recordedFeatures, id)
+			}
+		}
+		if len(recordedFeatures) >= featuresCapacity {
+			return
+		}
+	}
+	recordedFeatures[completionID] = &RecordedFeatures{
+		Language: language,
+		Model:    model,
+		Features: f,
+		Time:     now,
+	}
+}
+
+/**
+ * 取出补全请求时记录的隐藏分特征
+ * @param {string} completionID - 补全请求ID
+ * @returns {*RecordedFeatures} 返回记录的特征，不存在或已过期返回nil
+ * @description
+ * - 每个补全只能取一次，取出后即删除
+ */
+func TakeFeatures(completionID string) *RecordedFeatures {
+	featuresMutex.Lock()
+	defer featuresMutex.Unlock()
+
+	r, ok := recordedFeatures[completionID]
+	if !ok {
+		return nil
+	}
+	delete(recordedFeatures, completionID)
+	if time.Since(r.Time) > featuresTTL {
+		return nil
+	}
+	return r
+}
+
+var languageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_+\-]+$`)
+
+// 语言标识是否合法，合法的标识才能用于拼接隐藏分系数文件名
+func ValidLanguage(language string) bool {
+	return languageNamePattern.MatchString(language)
+}
+
+// 语言是否在默认隐藏分系数的语言表中，用于限制指标标签等外部输入的取值范围
+func KnownLanguage(language string) bool {
+	_, ok := NewHiddenScoreFilter("", 0).ContextualFilterLanguageMap[language]
+	return ok
+}
+
+/**
+ * 获取按语言拟合的隐藏分系数文件名，格式与hidden-scores.json相同
+ * @param {string} language - 语言标识
+ * @returns {string} 返回文件名，语言标识不合法时返回空字符串
+ */
+func LanguageScoreFile(language string) string {
+	if !ValidLanguage(language) {
+		return ""
+	}
+	return "hidden-scores." + language + ".json"
+}
+
+// 重新拟合使用的样本
+type ScoreSample struct {
+	Features HiddenScoreFeatures
+	Label    float64 // 1表示接受，0表示拒绝，部分接受可以介于两者之间
+}
+
+// 重新拟合的参数
+type RefitOptions struct {
+	Epochs       int     // 梯度下降轮数
+	LearningRate float64 // 学习率
+	L2           float64 // L2正则系数，防止样本少时系数偏离默认值太远
+}
+
+/**
+ * 根据反馈样本重新拟合隐藏分系数
+ * @param {*HiddenScoreFilter} base - 作为初始值的隐藏分过滤器(通常是默认系数)
+ * @param {[]ScoreSample} samples - 反馈样本
+ * @param {RefitOptions} opts - 拟合参数
+ * @returns {*HiddenScoreFilter, float64} 返回拟合后的过滤器和拟合后的对数损失
+ * @description
+ * - 使用带L2正则的批量梯度下降拟合逻辑回归，正则项把系数拉向base的系数
+ * - 特征向量布局与CalculateHideScore一致，输出可以直接被loadHiddenScoreFilter加载
+ */
+func RefitHiddenScoreFilter(base *HiddenScoreFilter, samples []ScoreSample, opts RefitOptions) (*HiddenScoreFilter, float64) {
+	size := hiddenScoreSuffixCharOffset + len(base.ContextualFilterCharacterMap)
+	size = max(size, len(base.ContextualFilterWeights))
+
+	prior := make([]float64, size)
+	copy(prior, base.ContextualFilterWeights)
+	weights := append([]float64(nil), prior...)
+	intercept := base.ContextualFilterIntercept
+
+	xs := make([][]float64, len(samples))
+	for i := range samples {
+		xs[i] = samples[i].Features.Vector(size)
+	}
+	n := float64(len(samples))
+	grad := make([]float64, size)
+	for epoch := 0; epoch < opts.Epochs && len(samples) > 0; epoch++ {
+		for j := range grad {
+			grad[j] = 0
+		}
+		gradIntercept := 0.0
+		for i, x := range xs {
+			diff := predict(weights, intercept, x) - samples[i].Label
+			for j, v := range x {
+				if v != 0 {
+					grad[j] += diff * v
+				}
+			}
+			gradIntercept += diff
+		}
+		for j := range weights {
+			weights[j] -= opts.LearningRate * (grad[j]/n + opts.L2*(weights[j]-prior[j]))
+		}
+		intercept -= opts.LearningRate * gradIntercept / n
+	}
+
+	loss := 0.0
+	for i, x := range xs {
+		p := math.Min(math.Max(predict(weights, intercept, x), 1e-12), 1-1e-12)
+		y := samples[i].Label
+		loss -= y*math.Log(p) + (1-y)*math.Log(1-p)
+	}
+	if n > 0 {
+		loss /= n
+	}
+
+	filter := *base
+	filter.ContextualFilterWeights = weights
+	filter.ContextualFilterIntercept = intercept
+	return &filter, loss
+}
+
+func predict(weights []float64, intercept float64, x []float64) float64 {
+	z := intercept
+	for j, v := range x {
+		z += weights[j] * v
+	}
+	return 1.0 / (1.0 + math.Exp(-z))
+}
@@ -0,0 +1,87 @@
+package completions
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_RefitHiddenScoreFilter(t *testing.T) {
+	base := NewHiddenScoreFilter("", 0)
+	opts := &HiddenScoreOptions{IsWhitespaceAfterCursor: true, DocumentLength: 100, PromptEndPos: 50}
+	accepted := base.GetFeatures(opts, "func main() {\n\t", "go")
+	rejected := base.GetFeatures(opts, "// comment ", "go")
+
+	var samples []ScoreSample
+	for i := 0; i < 50; i++ {
+		samples = append(samples, ScoreSample{Features: accepted, Label: 1}, ScoreSample{Features: rejected, Label: 0})
+	}
+	filter, loss := RefitHiddenScoreFilter(base, samples, RefitOptions{Epochs: 500, LearningRate: 0.5, L2: 0.001})
+	if filter.Score(accepted) <= filter.Score(rejected) {
+		t.Errorf("accepted sample should score higher: %f <= %f", filter.Score(accepted), filter.Score(rejected))
+	}
+	if loss > 0.3 {
+		t.Errorf("loss too high: %f", loss)
+	}
+	if &filter.ContextualFilterWeights[0] == &base.ContextualFilterWeights[0] {
+		t.Error("refit should not modify base weights in place")
+	}
+}
+
+func Test_LoadHiddenScoreFilterCache(t *testing.T) {
+	old := scoreFileCheckInterval
+	defer func() { scoreFileCheckInterval = old }()
+	scoreFileCheckInterval = time.Hour
+
+	if f := LanguageScoreFile("../../x"); f != "" {
+		t.Fatalf("invalid language should have no score file, got %s", f)
+	}
+	path := filepath.Join(t.TempDir(), LanguageScoreFile("go"))
+	if loadHiddenScoreFilter(path) != nil {
+		t.Fatal("missing file should load nothing")
+	}
+	base := NewHiddenScoreFilter("", 0)
+	base.ContextualFilterIntercept = 1.5
+	data, _ := json.Marshal(base)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loadHiddenScoreFilter(path) != nil {
+		t.Error("file should not be checked again within the check interval")
+	}
+
+	scoreFileCheckInterval = 0
+	filter := loadHiddenScoreFilter(path)
+	if filter == nil || filter.ContextualFilterIntercept != 1.5 {
+		t.Fatalf("file should be loaded after the check interval: %+v", filter)
+	}
+	filter.ThresholdScore = 0.9
+	if loadHiddenScoreFilter(path).ThresholdScore == 0.9 {
+		t.Error("callers should get a copy of the cached filter")
+	}
+}
+
+func Test_HiddenScoreTimeFeature(t *testing.T) {
+	h := NewHiddenScoreFilter("", 0)
+	captured := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	in := &CompletionInput{Now: captured}
+	in.LanguageID = "go"
+	in.HideScores = &HiddenScoreOptions{DocumentLength: 100, PreviousLabelTimestamp: captured.Add(-10 * time.Second).UnixMilli()}
+	in.Processed.Prefix = "func main() {\n\t"
+	h.Judge(in)
+	first := in.Extra["score"]
+
+	//the time feature is relative to the time of the request, not to the time it is judged at
+	f := h.GetFeaturesAt(in.HideScores, in.Processed.Prefix, "go", captured)
+	if want := math.Log(1 + 10.0); math.Abs(f.TimeSincePreviousLabelLog-want) > 1e-9 {
+		t.Errorf("time feature = %f, want %f", f.TimeSincePreviousLabelLog, want)
+	}
+	in.Extra = nil
+	h.Judge(in)
+	if in.Extra["score"] != first {
+		t.Errorf("score changed between two judgments: %v != %v", in.Extra["score"], first)
+	}
+}
@@ -3,10 +3,17 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"code-completion/pkg/expr"
+
+	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
@@ -30,6 +37,7 @@ type ModelConfig struct {
 	MaxConcurrent  int           `json:"maxConcurrent" yaml:"maxConcurrent"`   // 每种模型的最大并发数，防止模型过载
 	DisablePrune   bool          `json:"disablePrune" yaml:"disablePrune"`     // 禁止后期修剪
 	CustomPruners  []string      `json:"customPruners" yaml:"customPruners"`   // 自定义的后期修剪工具
+	Weight         int           `json:"weight" yaml:"weight"`                 // 模型池权重，权重越大分到的请求越多，默认1
 }
 
 /**
@@ -93,6 +101,59 @@ type DefinitionConfig struct {
 	Url      string `json:"url" yaml:"url"`           // 定义查询服务地址
 }
 
+/**
+ * 本地上下文配置结构体，定义了内置上下文检索的相关参数
+ * @description
+ * - 不依赖外部索引服务，从客户端上传的邻近文件片段或本地索引文件中检索上下文
+ * - 以光标附近的代码窗口为查询，按BM25或Jaccard相似度对候选代码块排序
+ * - 索引文件为JSONL格式，每行一个{"codebase","file_path","content"}，文件变化后自动重新加载
+ * - 上下文长度按补全模型的分词器计数，组装时还会受模型前缀预算限制
+ * @example
+ * {
+ *   "indexFile": "",
+ *   "similarity": "bm25",
+ *   "windowLines": 20,
+ *   "chunkLines": 20,
+ *   "topK": 5,
+ *   "minScore": 0,
+ *   "maxTokens": 1000
+ * }
+ */
+type LocalContextConfig struct {
+	IndexFile   string  `json:"indexFile" yaml:"indexFile"`     // 本地索引文件(可选)
+	Similarity  string  `json:"similarity" yaml:"similarity"`   // 相似度算法: bm25/jaccard
+	WindowLines int     `json:"windowLines" yaml:"windowLines"` // 光标前作为查询的行数
+	ChunkLines  int     `json:"chunkLines" yaml:"chunkLines"`   // 候选代码块的行数
+	TopK        int     `json:"topK" yaml:"topK"`               // 返回的代码块数量上限
+	MinScore    float64 `json:"minScore" yaml:"minScore"`       // 代码块的最低相似度
+	MaxTokens   int     `json:"maxTokens" yaml:"maxTokens"`     // 上下文的最大token数
+}
+
+/**
+ * 上下文预算配置结构体，定义了组装上下文时的token分配规则
+ * @description
+ * - 上下文总预算 = 模型MaxPrefix - 前缀token数，配置了maxTokens时不超过maxTokens
+ * - 总预算按比例分给有检索结果的来源，某个来源用不完的预算分给其他来源
+ * - 片段按来源分数和与光标的距离排序，预算不足时先丢弃排序靠后的片段
+ * @example
+ * {
+ *   "maxTokens": 0,
+ *   "definition": 0.4,
+ *   "semantic": 0.3,
+ *   "relation": 0.3,
+ *   "local": 0.3,
+ *   "distanceWeight": 0.3
+ * }
+ */
+type ContextBudgetConfig struct {
+	MaxTokens      int     `json:"maxTokens" yaml:"maxTokens"`           // 上下文最大token数，0表示只受MaxPrefix限制
+	Definition     float64 `json:"definition" yaml:"definition"`         // 定义检索的预算比例
+	Semantic       float64 `json:"semantic" yaml:"semantic"`             // 语义检索的预算比例
+	Relation       float64 `json:"relation" yaml:"relation"`             // 关系检索的预算比例
+	Local          float64 `json:"local" yaml:"local"`                   // 本地检索的预算比例
+	DistanceWeight float64 `json:"distanceWeight" yaml:"distanceWeight"` // 与光标距离在排序中的权重[0,1]
+}
+
 /**
  * 上下文配置结构体，定义了代码补全的上下文获取配置
  * @description
@@ -119,15 +180,21 @@ type DefinitionConfig struct {
  *     "includeContent": true
  *   },
  *   "requestTimeout": "5s",
- *   "totalTimeout": "15s"
+ *   "totalTimeout": "15s",
+ *   "provider": "remote",
+ *   "local": {...},
+ *   "budget": {...}
  * }
  */
 type ContextConfig struct {
-	Definition     DefinitionConfig `json:"definition" yaml:"definition"`         // 定义查询配置
-	Semantic       SemanticConfig   `json:"semantic" yaml:"semantic"`             // 语义相关性查询配置
-	Relation       RelationConfig   `json:"relation" yaml:"relation"`             // 关系链查询配置
-	RequestTimeout time.Duration    `json:"requestTimeout" yaml:"requestTimeout"` // 单个请求超时时间
-	TotalTimeout   time.Duration    `json:"totalTimeout" yaml:"totalTimeout"`     // 上下文获取总超时时间
+	Definition     DefinitionConfig    `json:"definition" yaml:"definition"`         // 定义查询配置
+	Semantic       SemanticConfig      `json:"semantic" yaml:"semantic"`             // 语义相关性查询配置
+	Relation       RelationConfig      `json:"relation" yaml:"relation"`             // 关系链查询配置
+	RequestTimeout time.Duration       `json:"requestTimeout" yaml:"requestTimeout"` // 单个请求超时时间
+	TotalTimeout   time.Duration       `json:"totalTimeout" yaml:"totalTimeout"`     // 上下文获取总超时时间
+	Provider       string              `json:"provider" yaml:"provider"`             // 上下文来源: remote/local/auto(远程没有结果时使用本地)
+	Local          LocalContextConfig  `json:"local" yaml:"local"`                   // 本地上下文配置
+	Budget         ContextBudgetConfig `json:"budget" yaml:"budget"`                 // 上下文预算配置
 }
 
 /**
@@ -186,8 +253,36 @@ type SyntaxFilterConfig struct {
  * }
  */
 type PruneConfig struct {
-	Disabled bool     `json:"disabled" yaml:"disabled"` // 是否禁用后期修剪
-	Pruners  []string `json:"pruners" yaml:"pruners"`   // 自定义的后期修剪工具列表
+	Disabled bool         `json:"disabled" yaml:"disabled"` // 是否禁用后期修剪
+	Pruners  []string     `json:"pruners" yaml:"pruners"`   // 自定义的后期修剪工具列表
+	Rules    []RuleConfig `json:"rules" yaml:"rules"`       // 配置定义的修剪规则，没有指定修剪器列表时追加在默认修剪器之后，否则按名称引用
+}
+
+/**
+ * 配置定义的规则结构体，用于在不修改代码的情况下增加修剪器和过滤器
+ * @description
+ * - 修剪规则的action为discard(丢弃整个补全)或cut(从匹配处截断补全)
+ * - 过滤规则的action为reject(拒绝补全请求)
+ * - languages为空时对所有语言生效
+ * - pattern为正则表达式，匹配target指定的文本(修剪规则默认completion，过滤规则默认linePrefix)
+ * - when为条件表达式(expr-lang语法)，可以使用language、prefix、suffix、completion、filePath、model、triggerMode等变量
+ * - pattern和when都配置时，两者都满足规则才命中；命中的规则名通过GetHitProcessors和指标上报
+ * @example
+ * {
+ *   "name": "discard-vue_script_in_template",
+ *   "languages": ["vue"],
+ *   "action": "discard",
+ *   "pattern": "<script[\\s>]",
+ *   "when": "prefix contains \"<template>\" && !(prefix contains \"</template>\")"
+ * }
+ */
+type RuleConfig struct {
+	Name      string   `json:"name" yaml:"name"`           // 规则名称
+	Languages []string `json:"languages" yaml:"languages"` // 生效的语言
+	Action    string   `json:"action" yaml:"action"`       // 动作: discard/cut/reject
+	Target    string   `json:"target" yaml:"target"`       // 正则匹配的文本: completion/prefix/suffix/linePrefix/lineSuffix
+	Pattern   string   `json:"pattern" yaml:"pattern"`     // 正则表达式
+	When      string   `json:"when" yaml:"when"`           // 条件表达式
 }
 
 /**
@@ -222,16 +317,237 @@ type PruneConfig struct {
  * }
  */
 type WrapperConfig struct {
-	Score  ScoreFilterConfig  `json:"score" yaml:"score"`   // 隐藏分过滤器配置
-	Syntax SyntaxFilterConfig `json:"syntax" yaml:"syntax"` // 语法过滤器配置
-	Prune  PruneConfig        `json:"prune" yaml:"prune"`   // 后期修剪配置
+	Score      ScoreFilterConfig  `json:"score" yaml:"score"`           // 隐藏分过滤器配置
+	Syntax     SyntaxFilterConfig `json:"syntax" yaml:"syntax"`         // 语法过滤器配置
+	Prune      PruneConfig        `json:"prune" yaml:"prune"`           // 后期修剪配置
+	Candidates CandidatesConfig   `json:"candidates" yaml:"candidates"` // 多候选补全配置
+	Filters    []RuleConfig       `json:"filters" yaml:"filters"`       // 配置定义的过滤规则，在内置过滤器之后执行
+}
+
+/**
+ * 多候选补全配置结构体，定义了候选补全的采样和排序参数
+ * @description
+ * - 每次模型请求采样n个候选，或同时请求同标签的多个模型池
+ * - 所有候选都经过后期修剪，丢弃的候选不参与排序
+ * - 存活的候选按语法正确性、对数概率(模型支持时)、长度排序，相同的候选合并计票
+ * - 客户端请求的n大于1时返回排序后的候选列表，否则只返回最优的一个
+ * @example
+ * {
+ *   "n": 3,
+ *   "fanOut": 2,
+ *   "logprobs": true,
+ *   "maxChoices": 5
+ * }
+ */
+type CandidatesConfig struct {
+	N          int  `json:"n" yaml:"n"`                   // 每次模型请求的采样数，默认1
+	FanOut     int  `json:"fanOut" yaml:"fanOut"`         // 同时请求的模型池数量，默认1
+	Logprobs   bool `json:"logprobs" yaml:"logprobs"`     // 是否向模型请求对数概率，用于候选排序
+	MaxChoices int  `json:"maxChoices" yaml:"maxChoices"` // 最多返回给客户端的候选数，默认5
+}
+
+/**
+ * 模型池健康检查配置结构体，定义了主动探测和被动摘除的相关参数
+ * @description
+ * - 控制是否启用主动健康探测
+ * - 设置健康模型池的探测间隔和被摘除模型池的恢复探测间隔
+ * - 设置连续失败多少次后摘除模型池
+ * - 被摘除的模型池不再参与调度，探测成功后自动恢复
+ * @example
+ * {
+ *   "disabled": false,
+ *   "interval": "30s",
+ *   "recoveryInterval": "10s",
+ *   "timeout": "3s",
+ *   "failureThreshold": 3
+ * }
+ */
+type HealthCheckConfig struct {
+	Disabled         bool          `json:"disabled" yaml:"disabled"`                 // 是否禁用主动探测(被动摘除始终生效)
+	Interval         time.Duration `json:"interval" yaml:"interval"`                 // 健康模型池的主动探测间隔
+	RecoveryInterval time.Duration `json:"recoveryInterval" yaml:"recoveryInterval"` // 被摘除模型池的恢复探测间隔
+	Timeout          time.Duration `json:"timeout" yaml:"timeout"`                   // 单次探测的超时时间
+	FailureThreshold int           `json:"failureThreshold" yaml:"failureThreshold"` // 连续失败多少次后摘除模型池
 }
 
 type StreamControllerConfig struct {
-	MaintainInterval  time.Duration `json:"maintainInterval" yaml:"maintainInterval"`   // 定时维护的间隔
-	CleanOlderThan    time.Duration `json:"cleanOlderThan" yaml:"cleanOlderThan"`       // 清理过期客户端的最大间隔
-	CompletionTimeout time.Duration `json:"completionTimeout" yaml:"completionTimeout"` // 一个补全请求的最大超时
-	QueueTimeout      time.Duration `json:"queueTimeout" yaml:"queueTimeout"`           // 排队超时
+	MaintainInterval  time.Duration     `json:"maintainInterval" yaml:"maintainInterval"`   // 定时维护的间隔
+	CleanOlderThan    time.Duration     `json:"cleanOlderThan" yaml:"cleanOlderThan"`       // 清理过期客户端的最大间隔
+	CompletionTimeout time.Duration     `json:"completionTimeout" yaml:"completionTimeout"` // 一个补全请求的最大超时
+	QueueTimeout      time.Duration     `json:"queueTimeout" yaml:"queueTimeout"`           // 排队超时
+	MaxFailover       int               `json:"maxFailover" yaml:"maxFailover"`             // 模型出错时最多切换到同标签其它模型池的次数，默认1，负数表示禁用
+	HealthCheck       HealthCheckConfig `json:"healthCheck" yaml:"healthCheck"`             // 模型池健康检查配置
+}
+
+/**
+ * 管理接口配置结构体，定义了运行时管理接口和配置热加载的相关参数
+ * @description
+ * - 设置管理接口的访问令牌，为空时禁用管理接口
+ * - 配置了令牌或启用了鉴权时，调试接口(/api/logs、/api/stats、/api/details)也只允许管理员访问
+ * - 控制是否监听配置文件变化并自动重新加载
+ * - 设置检查配置文件变化的间隔
+ * @example
+ * {
+ *   "token": "change-me",
+ *   "disableWatch": false,
+ *   "watchInterval": "10s"
+ * }
+ */
+type AdminConfig struct {
+	Token         string        `json:"token" yaml:"token"`                 // 管理接口访问令牌，为空时禁用管理接口
+	DisableWatch  bool          `json:"disableWatch" yaml:"disableWatch"`   // 禁止监听配置文件变化
+	WatchInterval time.Duration `json:"watchInterval" yaml:"watchInterval"` // 检查配置文件变化的间隔
+}
+
+/**
+ * 补全反馈配置结构体，定义了用户反馈(接受/拒绝)的存储参数
+ * @description
+ * - 控制是否启用反馈接口
+ * - 设置反馈事件的存储文件(JSONL格式，每行一个事件)
+ * - 反馈数据用于离线重新拟合隐藏分系数
+ * @example
+ * {
+ *   "disabled": false,
+ *   "path": "feedback.jsonl"
+ * }
+ */
+type FeedbackConfig struct {
+	Disabled bool   `json:"disabled" yaml:"disabled"` // 是否禁用反馈接口
+	Path     string `json:"path" yaml:"path"`         // 反馈事件存储文件
+}
+
+/**
+ * 请求采集配置结构体，定义了补全请求的抽样采集参数
+ * @description
+ * - 默认不采集，sampleRate大于0时按比例抽样
+ * - 采集的记录包含请求、检索到的上下文片段、模型原始输出和最终补全
+ * - 采集文件(JSONL格式)作为replay子命令的语料，用于离线评估配置改动
+ * @example
+ * {
+ *   "sampleRate": 0.01,
+ *   "path": "capture.jsonl"
+ * }
+ */
+type CaptureConfig struct {
+	SampleRate float64 `json:"sampleRate" yaml:"sampleRate"` // 抽样比例[0,1]，0表示不采集
+	Path       string  `json:"path" yaml:"path"`             // 采集文件
+}
+
+/**
+ * 下一处编辑预测配置结构体，定义了next-edit接口使用的对话模型和提示词预算
+ * @description
+ * - 使用OpenAI chat/completions协议的对话模型，根据最近的编辑历史预测下一处编辑
+ * - 没有配置chatUrl时接口不可用
+ * - maxInputTokens为提示词(文件窗口、编辑历史、检索的上下文)的总token预算
+ * - 没有配置tokenizerPath时使用第一个补全模型的分词器，都没有时按字符数估算
+ * @example
+ * {
+ *   "chatUrl": "http://localhost:8000/v1/chat/completions",
+ *   "modelName": "qwen2.5-coder-7b-instruct",
+ *   "timeout": "10s",
+ *   "maxConcurrent": 8,
+ *   "maxInputTokens": 6000,
+ *   "maxOutput": 1024,
+ *   "maxHistory": 10,
+ *   "maxEdits": 5
+ * }
+ */
+type NextEditConfig struct {
+	ChatUrl        string        `json:"chatUrl" yaml:"chatUrl"`               // 对话模型地址
+	ModelName      string        `json:"modelName" yaml:"modelName"`           // 模型名称
+	Authorization  string        `json:"authorization" yaml:"authorization"`   // 认证信息
+	Timeout        time.Duration `json:"timeout" yaml:"timeout"`               // 单个请求的超时时间，默认10s
+	MaxConcurrent  int           `json:"maxConcurrent" yaml:"maxConcurrent"`   // 最大并发数，默认8
+	MaxInputTokens int           `json:"maxInputTokens" yaml:"maxInputTokens"` // 提示词的最大token数，默认6000
+	MaxOutput      int           `json:"maxOutput" yaml:"maxOutput"`           // 最大输出token数，默认1024
+	MaxHistory     int           `json:"maxHistory" yaml:"maxHistory"`         // 最多使用的最近编辑数，默认10
+	MaxEdits       int           `json:"maxEdits" yaml:"maxEdits"`             // 最多返回的编辑数，默认5
+	Temperature    float64       `json:"temperature" yaml:"temperature"`       // 温度
+	TokenizerPath  string        `json:"tokenizerPath" yaml:"tokenizerPath"`   // 分词器路径(可选)
+}
+
+/**
+ * API密钥配置结构体
+ * @description
+ * - 请求头Authorization: Bearer <key>或X-API-Key携带
+ * - 用于没有接入Casdoor的客户端或服务间调用
+ */
+type APIKeyConfig struct {
+	Key    string `json:"key" yaml:"key"`       // 密钥
+	User   string `json:"user" yaml:"user"`     // 密钥所属用户，用于用量统计
+	Tenant string `json:"tenant" yaml:"tenant"` // 密钥所属租户
+	Admin  bool   `json:"admin" yaml:"admin"`   // 是否有管理权限(调试、日志、用量报表)
+}
+
+/**
+ * 鉴权配置结构体，定义了服务内置的鉴权规则
+ * @description
+ * - 未启用时不做鉴权，依赖前置网关(例如APISIX)
+ * - 支持Casdoor签发的JWT(通过JWKS验证签名)和静态API密钥
+ * - jwksUrl为空时使用issuer + "/.well-known/jwks"
+ * - JWT中isAdmin为true或用户在adminUsers中时有管理权限
+ * - 调试和日志接口(/api/logs、/api/stats、/api/details)只允许有管理权限的用户访问
+ * @example
+ * {
+ *   "enabled": true,
+ *   "issuer": "https://casdoor.example.com",
+ *   "audience": "code-completion",
+ *   "adminUsers": ["admin"],
+ *   "apiKeys": [{"key": "sk-xxx", "user": "ci", "tenant": "built-in"}]
+ * }
+ */
+type AuthConfig struct {
+	Enabled     bool           `json:"enabled" yaml:"enabled"`         // 是否启用鉴权
+	Issuer      string         `json:"issuer" yaml:"issuer"`           // JWT签发者(Casdoor地址)，须与iss完全一致，为空时不校验iss
+	JwksUrl     string         `json:"jwksUrl" yaml:"jwksUrl"`         // JWKS地址
+	Audience    string         `json:"audience" yaml:"audience"`       // JWT受众(Casdoor应用的clientId)，为空时不校验aud
+	JwksRefresh time.Duration  `json:"jwksRefresh" yaml:"jwksRefresh"` // JWKS刷新间隔，默认1h
+	UserClaim   string         `json:"userClaim" yaml:"userClaim"`     // 作为用户名的JWT声明，默认name，缺失时使用sub
+	AdminUsers  []string       `json:"adminUsers" yaml:"adminUsers"`   // 有管理权限的用户
+	APIKeys     []APIKeyConfig `json:"apiKeys" yaml:"apiKeys"`         // 静态API密钥
+}
+
+/**
+ * 用量统计配置结构体
+ * @description
+ * - 按天、用户、模型累计请求数和token数
+ * - 统计数据定期保存到文件，重启后继续累计
+ * @example
+ * {
+ *   "disabled": false,
+ *   "path": "usage.json",
+ *   "flushInterval": "1m"
+ * }
+ */
+type UsageConfig struct {
+	Disabled      bool          `json:"disabled" yaml:"disabled"`           // 是否禁用用量统计
+	Path          string        `json:"path" yaml:"path"`                   // 统计数据文件
+	FlushInterval time.Duration `json:"flushInterval" yaml:"flushInterval"` // 保存到文件的间隔，默认1m
+}
+
+/**
+ * 链路追踪配置结构体
+ * @description
+ * - 按W3C traceparent请求头延续上游的链路，并传递给上下文服务和补全模型
+ * - 使用OpenTelemetry SDK，采样的span通过OTLP/HTTP批量上报，endpoint为空时只传播不上报
+ * - 配置热加载后按新的上报地址、采样率、批量大小和上报间隔重新创建上报器
+ * - 上游已经决定采样时沿用上游的决定，否则按sampleRate采样
+ * - 响应头X-Trace-Id返回链路ID
+ * @example
+ * {
+ *   "enabled": true,
+ *   "endpoint": "http://otel-collector:4318/v1/traces",
+ *   "sampleRate": 0.1
+ * }
+ */
+type TracingConfig struct {
+	Enabled       bool              `json:"enabled" yaml:"enabled"`             // 是否启用链路追踪
+	Endpoint      string            `json:"endpoint" yaml:"endpoint"`           // OTLP/HTTP traces地址
+	Headers       map[string]string `json:"headers" yaml:"headers"`             // 上报时附加的请求头，例如鉴权
+	ServiceName   string            `json:"serviceName" yaml:"serviceName"`     // 服务名，默认code-completion
+	SampleRate    float64           `json:"sampleRate" yaml:"sampleRate"`       // 采样率[0,1]
+	BatchSize     int               `json:"batchSize" yaml:"batchSize"`         // 每批上报的span数，默认512
+	FlushInterval time.Duration     `json:"flushInterval" yaml:"flushInterval"` // 上报间隔，默认5s
 }
 
 type SoftwareConfig struct {
@@ -239,11 +555,39 @@ type SoftwareConfig struct {
 	Context          ContextConfig          `json:"context" yaml:"context"`                   // 上下文获取配置
 	Wrapper          WrapperConfig          `json:"wrapper" yaml:"wrapper"`                   // 补全前后处理配置
 	StreamController StreamControllerConfig `json:"streamController" yaml:"streamController"` // 全局流控配置
+	Admin            AdminConfig            `json:"admin" yaml:"admin"`                       // 管理接口配置
+	Feedback         FeedbackConfig         `json:"feedback" yaml:"feedback"`                 // 补全反馈配置
+	Capture          CaptureConfig          `json:"capture" yaml:"capture"`                   // 请求采集配置
+	NextEdit         NextEditConfig         `json:"nextEdit" yaml:"nextEdit"`                 // 下一处编辑预测配置
+	Auth             AuthConfig             `json:"auth" yaml:"auth"`                         // 鉴权配置
+	Usage            UsageConfig            `json:"usage" yaml:"usage"`                       // 用量统计配置
+	Tracing          TracingConfig          `json:"tracing" yaml:"tracing"`                   // 链路追踪配置
 }
 
-var Config = &SoftwareConfig{}
-var Context *ContextConfig = &Config.Context
-var Wrapper *WrapperConfig = &Config.Wrapper
+// 配置文件路径，启动时由-config参数指定，热加载、管理接口重新加载和回放的默认配置都使用该文件
+var ConfigFile = "config.yaml"
+
+// 当前生效的配置，发布后不再修改，新配置整体替换
+var current atomic.Pointer[SoftwareConfig]
+
+/**
+ * 获取当前生效的配置
+ * @returns {*SoftwareConfig} 返回只读的配置快照，调用方不得修改
+ * @description
+ * - 配置热加载时整体替换，已经取得的快照不受影响
+ * - 一次处理过程中多次用到配置时，应该只取一次快照，保证前后一致
+ */
+func Get() *SoftwareConfig {
+	return current.Load()
+}
+
+// 模型请求池的唯一标识，优先使用ModelTitle
+func (c *ModelConfig) ID() string {
+	if c.ModelTitle != "" {
+		return c.ModelTitle
+	}
+	return c.ModelName + "@" + c.CompletionsUrl
+}
 
 func resetDefValues(c *SoftwareConfig) {
 	if c.StreamController.QueueTimeout == 0 {
@@ -255,24 +599,395 @@ func resetDefValues(c *SoftwareConfig) {
 	if c.StreamController.CleanOlderThan == 0 {
 		c.StreamController.CleanOlderThan = 1 * time.Hour
 	}
+	if c.StreamController.MaxFailover == 0 {
+		c.StreamController.MaxFailover = 1
+	}
+	hc := &c.StreamController.HealthCheck
+	if hc.Interval == 0 {
+		hc.Interval = 30 * time.Second
+	}
+	if hc.RecoveryInterval == 0 {
+		hc.RecoveryInterval = 10 * time.Second
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = 3 * time.Second
+	}
+	if hc.FailureThreshold == 0 {
+		hc.FailureThreshold = 3
+	}
+	for i := range c.Models {
+		if c.Models[i].Weight <= 0 {
+			c.Models[i].Weight = 1
+		}
+	}
+	cc := &c.Wrapper.Candidates
+	if cc.N == 0 {
+		cc.N = 1
+	}
+	if cc.FanOut == 0 {
+		cc.FanOut = 1
+	}
+	if cc.MaxChoices == 0 {
+		cc.MaxChoices = 5
+	}
+	if c.Admin.WatchInterval == 0 {
+		c.Admin.WatchInterval = 10 * time.Second
+	}
+	if c.Feedback.Path == "" {
+		c.Feedback.Path = "feedback.jsonl"
+	}
+	if c.Capture.Path == "" {
+		c.Capture.Path = "capture.jsonl"
+	}
+	if c.Auth.JwksRefresh == 0 {
+		c.Auth.JwksRefresh = time.Hour
+	}
+	if c.Auth.JwksUrl == "" && c.Auth.Issuer != "" {
+		c.Auth.JwksUrl = strings.TrimSuffix(c.Auth.Issuer, "/") + "/.well-known/jwks"
+	}
+	if c.Auth.UserClaim == "" {
+		c.Auth.UserClaim = "name"
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = "code-completion"
+	}
+	if c.Tracing.BatchSize == 0 {
+		c.Tracing.BatchSize = 512
+	}
+	if c.Tracing.FlushInterval == 0 {
+		c.Tracing.FlushInterval = 5 * time.Second
+	}
+	if c.Usage.Path == "" {
+		c.Usage.Path = "usage.json"
+	}
+	if c.Usage.FlushInterval == 0 {
+		c.Usage.FlushInterval = time.Minute
+	}
+	ne := &c.NextEdit
+	if ne.Timeout == 0 {
+		ne.Timeout = 10 * time.Second
+	}
+	if ne.MaxConcurrent == 0 {
+		ne.MaxConcurrent = 8
+	}
+	if ne.MaxInputTokens == 0 {
+		ne.MaxInputTokens = 6000
+	}
+	if ne.MaxOutput == 0 {
+		ne.MaxOutput = 1024
+	}
+	if ne.MaxHistory == 0 {
+		ne.MaxHistory = 10
+	}
+	if ne.MaxEdits == 0 {
+		ne.MaxEdits = 5
+	}
+	if c.Context.Provider == "" {
+		c.Context.Provider = "remote"
+	}
+	lc := &c.Context.Local
+	if lc.Similarity == "" {
+		lc.Similarity = "bm25"
+	}
+	if lc.WindowLines == 0 {
+		lc.WindowLines = 20
+	}
+	if lc.ChunkLines == 0 {
+		lc.ChunkLines = 20
+	}
+	if lc.TopK == 0 {
+		lc.TopK = 5
+	}
+	if lc.MaxTokens == 0 {
+		lc.MaxTokens = 1000
+	}
+	bc := &c.Context.Budget
+	if bc.Definition == 0 {
+		bc.Definition = 0.4
+	}
+	if bc.Semantic == 0 {
+		bc.Semantic = 0.3
+	}
+	if bc.Relation == 0 {
+		bc.Relation = 0.3
+	}
+	if bc.Local == 0 {
+		bc.Local = 0.3
+	}
+	if bc.DistanceWeight == 0 {
+		bc.DistanceWeight = 0.3
+	}
 }
 
-func init() {
-	// 读取配置文件
-	configFile, err := os.ReadFile("config.yaml")
+/**
+ * 校验配置是否合法
+ * @returns {error} 配置不合法时返回错误，说明第一个不合法的配置项
+ * @description
+ * - 至少需要一个模型，且模型标识(ModelTitle或ModelName+CompletionsUrl)不能重复
+ * - 每个模型必须指定补全地址，最大并发数必须大于0
+ * - 隐藏分阈值必须在[0,1]之间
+ * - 热加载和管理接口提交配置时，校验失败的配置不会生效
+ */
+func (c *SoftwareConfig) Validate() error {
+	if len(c.Models) == 0 {
+		return fmt.Errorf("'models' is missing")
+	}
+	ids := make(map[string]bool)
+	for i, m := range c.Models {
+		if m.CompletionsUrl == "" {
+			return fmt.Errorf("models[%d]: 'completionsUrl' is missing", i)
+		}
+		if m.MaxConcurrent <= 0 {
+			return fmt.Errorf("models[%d]: 'maxConcurrent' must be greater than 0", i)
+		}
+		if m.Timeout < 0 {
+			return fmt.Errorf("models[%d]: 'timeout' must not be negative", i)
+		}
+		id := m.ID()
+		if ids[id] {
+			return fmt.Errorf("models[%d]: duplicated model '%s'", i, id)
+		}
+		ids[id] = true
+	}
+	if c.Wrapper.Score.Threshold < 0 || c.Wrapper.Score.Threshold > 1 {
+		return fmt.Errorf("'wrapper.score.threshold' must be between 0 and 1")
+	}
+	if cc := c.Wrapper.Candidates; cc.N < 1 || cc.N > 16 || cc.FanOut < 1 || cc.MaxChoices < 1 {
+		return fmt.Errorf("'wrapper.candidates' n must be between 1 and 16, fanOut and maxChoices must be positive")
+	}
+	if err := validateRules("wrapper.prune.rules", c.Wrapper.Prune.Rules, "discard", "cut"); err != nil {
+		return err
+	}
+	if err := validateRules("wrapper.filters", c.Wrapper.Filters, "reject"); err != nil {
+		return err
+	}
+	if c.StreamController.CompletionTimeout < 0 || c.StreamController.QueueTimeout < 0 {
+		return fmt.Errorf("'streamController' timeouts must not be negative")
+	}
+	if c.Capture.SampleRate < 0 || c.Capture.SampleRate > 1 {
+		return fmt.Errorf("'capture.sampleRate' must be between 0 and 1")
+	}
+	if ne := c.NextEdit; ne.MaxConcurrent < 0 || ne.MaxInputTokens < 0 || ne.MaxOutput < 0 || ne.MaxEdits < 0 {
+		return fmt.Errorf("'nextEdit' limits must not be negative")
+	}
+	if c.Auth.Enabled && c.Auth.JwksUrl == "" && len(c.Auth.APIKeys) == 0 {
+		return fmt.Errorf("'auth' requires 'issuer', 'jwksUrl' or 'apiKeys' when enabled")
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		return fmt.Errorf("'tracing.sampleRate' must be in [0, 1]")
+	}
+	if c.Tracing.BatchSize < 0 {
+		return fmt.Errorf("'tracing.batchSize' must not be negative")
+	}
+	for i, k := range c.Auth.APIKeys {
+		if k.Key == "" || k.User == "" {
+			return fmt.Errorf("auth.apiKeys[%d]: 'key' and 'user' are required", i)
+		}
+	}
+	switch c.Context.Provider {
+	case "remote", "local", "auto":
+	default:
+		return fmt.Errorf("'context.provider' must be remote, local or auto")
+	}
+	switch c.Context.Local.Similarity {
+	case "bm25", "jaccard":
+	default:
+		return fmt.Errorf("'context.local.similarity' must be bm25 or jaccard")
+	}
+	return nil
+}
+
+// 校验配置定义的规则：名称唯一，动作合法，正则和条件表达式可以编译
+func validateRules(key string, rules []RuleConfig, actions ...string) error {
+	names := make(map[string]bool)
+	for i, r := range rules {
+		if r.Name == "" {
+			return fmt.Errorf("%s[%d]: 'name' is missing", key, i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("%s[%d]: duplicated rule '%s'", key, i, r.Name)
+		}
+		names[r.Name] = true
+		if !slices.Contains(actions, r.Action) {
+			return fmt.Errorf("%s[%d]: 'action' must be one of %s", key, i, strings.Join(actions, "/"))
+		}
+		switch r.Target {
+		case "", "completion", "prefix", "suffix", "linePrefix", "lineSuffix":
+		default:
+			return fmt.Errorf("%s[%d]: invalid target '%s'", key, i, r.Target)
+		}
+		if r.Target == "completion" && r.Action == "reject" {
+			return fmt.Errorf("%s[%d]: filters can't match the completion", key, i)
+		}
+		if r.Target != "" && r.Target != "completion" && r.Action == "cut" {
+			return fmt.Errorf("%s[%d]: cut rules must match the completion", key, i)
+		}
+		if r.Pattern == "" && (r.When == "" || r.Action == "cut") {
+			return fmt.Errorf("%s[%d]: 'pattern' is missing", key, i)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%s[%d]: invalid pattern: %v", key, i, err)
+		}
+		if r.When != "" {
+			if _, err := expr.Compile(r.When); err != nil {
+				return fmt.Errorf("%s[%d]: invalid condition: %v", key, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * 解析配置内容
+ * @param {[]byte} data - YAML格式的配置内容(JSON是YAML的子集，同样支持)
+ * @returns {*SoftwareConfig, error} 返回填充了默认值的配置
+ */
+func Parse(data []byte) (*SoftwareConfig, error) {
+	str := strings.ReplaceAll(string(data), "\r\n", "\n")
+	c := &SoftwareConfig{}
+	if err := yaml.Unmarshal([]byte(str), c); err != nil {
+		return nil, err
+	}
+	resetDefValues(c)
+	return c, nil
+}
+
+/**
+ * 从文件加载并校验配置
+ * @param {string} path - 配置文件路径
+ * @returns {*SoftwareConfig, error} 返回校验通过的配置
+ */
+func Load(path string) (*SoftwareConfig, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("读取配置文件失败: %v\n", err)
-		return
+		return nil, err
 	}
-	configFileStr := strings.ReplaceAll(string(configFile), "\r\n", "\n")
+	c, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/**
+ * 生成当前配置的副本
+ * @returns {*SoftwareConfig} 返回配置副本，模型列表、API密钥列表和上报请求头是独立的，修改副本不影响当前配置
+ */
+func Snapshot() *SoftwareConfig {
+	return clone(Get())
+}
+
+func clone(cur *SoftwareConfig) *SoftwareConfig {
+	c := *cur
+	c.Models = append([]ModelConfig(nil), cur.Models...)
+	c.Auth.APIKeys = append([]APIKeyConfig(nil), cur.Auth.APIKeys...)
+	c.Tracing.Headers = maps.Clone(cur.Tracing.Headers)
+	return &c
+}
+
+// 输出配置时，敏感信息用该掩码替换
+const SecretMask = "******"
 
-	// 解析 YAML 配置
-	err = yaml.Unmarshal([]byte(configFileStr), Config)
+/**
+ * 生成去掉敏感信息的配置副本
+ * @param {*SoftwareConfig} cur - 配置
+ * @returns {*SoftwareConfig} 返回副本，模型和下一步编辑的Authorization、管理令牌、API密钥和上报请求头替换为掩码
+ */
+func Redact(cur *SoftwareConfig) *SoftwareConfig {
+	c := clone(cur)
+	for i := range c.Models {
+		if c.Models[i].Authorization != "" {
+			c.Models[i].Authorization = SecretMask
+		}
+	}
+	if c.Admin.Token != "" {
+		c.Admin.Token = SecretMask
+	}
+	if c.NextEdit.Authorization != "" {
+		c.NextEdit.Authorization = SecretMask
+	}
+	for i := range c.Auth.APIKeys {
+		c.Auth.APIKeys[i].Key = SecretMask
+	}
+	for k := range c.Tracing.Headers {
+		c.Tracing.Headers[k] = SecretMask
+	}
+	return c
+}
+
+/**
+ * 让新配置生效
+ * @param {*SoftwareConfig} c - 已经校验通过的新配置，发布后调用方不得再修改
+ * @description
+ * - 原子地发布新配置，不会和正在读取配置的请求产生数据竞争
+ * - 每个请求都会重新读取配置，因此新配置对后续请求生效
+ */
+func Apply(c *SoftwareConfig) {
+	current.Store(c)
+}
+
+/**
+ * 监听配置文件变化
+ * @param {string} path - 配置文件路径
+ * @param {time.Duration} interval - 检查文件变化的间隔
+ * @param {func(*SoftwareConfig) error} apply - 配置变化时的回调，负责让新配置生效
+ * @description
+ * - 定期检查文件的修改时间和大小，发生变化时重新加载
+ * - 解析或校验失败的配置会被拒绝，服务继续使用旧配置
+ */
+func Watch(path string, interval time.Duration, apply func(*SoftwareConfig) error) {
+	stat, err := os.Stat(path)
 	if err != nil {
-		fmt.Printf("解析配置文件失败: %v\n", err)
-		panic(err)
+		zap.L().Warn("Config file is not watchable", zap.String("path", path), zap.Error(err))
 	}
-	resetDefValues(Config)
-	data, _ := json.MarshalIndent(Config, "", "  ")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			latest, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if stat != nil && latest.ModTime().Equal(stat.ModTime()) && latest.Size() == stat.Size() {
+				continue
+			}
+			stat = latest
+			c, err := Load(path)
+			if err != nil {
+				zap.L().Error("Reject invalid config file", zap.String("path", path), zap.Error(err))
+				continue
+			}
+			if err := apply(c); err != nil {
+				zap.L().Error("Failed to apply config file", zap.String("path", path), zap.Error(err))
+				continue
+			}
+			zap.L().Info("Config file reloaded", zap.String("path", path))
+		}
+	}()
+	zap.L().Info("Start watching config file", zap.String("path", path), zap.Duration("interval", interval))
+}
+
+/**
+ * 启动时加载配置文件并发布为当前配置
+ * @param {string} path - 配置文件路径
+ * @returns {error} 文件不存在、解析或校验失败时返回错误，当前配置不变
+ */
+func Init(path string) error {
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	ConfigFile = path
+	Apply(c)
+	data, _ := json.MarshalIndent(Redact(c), "", "  ")
 	fmt.Printf("配置文件加载成功:\n%s\n", string(data))
+	return nil
+}
+
+func init() {
+	current.Store(&SoftwareConfig{})
 }
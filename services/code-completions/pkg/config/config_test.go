@@ -0,0 +1,87 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ApplyWhileReading(t *testing.T) {
+	old := Get()
+	defer Apply(old)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c := Get()
+				if c.StreamController.CompletionTimeout != c.StreamController.QueueTimeout {
+					t.Error("a snapshot must never be seen partially applied")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		c := Snapshot()
+		c.StreamController.CompletionTimeout = time.Duration(i)
+		c.StreamController.QueueTimeout = time.Duration(i)
+		Apply(c)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func Test_SnapshotIsIndependent(t *testing.T) {
+	old := Get()
+	defer Apply(old)
+
+	c := Snapshot()
+	c.Models = append(c.Models, ModelConfig{ModelTitle: "added"})
+	if len(Get().Models) == len(c.Models) {
+		t.Error("modifying a snapshot must not modify the current configuration")
+	}
+	Apply(c)
+	if Get() != c {
+		t.Error("Apply should publish the new configuration")
+	}
+}
+
+func Test_RedactIsIndependent(t *testing.T) {
+	c := &SoftwareConfig{
+		Models:  []ModelConfig{{ModelTitle: "m1", Authorization: "Bearer sk-model"}},
+		Admin:   AdminConfig{Token: "admin-token"},
+		Auth:    AuthConfig{APIKeys: []APIKeyConfig{{Key: "sk-user", User: "u1"}}},
+		Tracing: TracingConfig{Headers: map[string]string{"Authorization": "Basic xx"}},
+	}
+	c.NextEdit.Authorization = "Bearer sk-next"
+
+	r := Redact(c)
+	if r.Models[0].Authorization != SecretMask || r.Admin.Token != SecretMask || r.NextEdit.Authorization != SecretMask ||
+		r.Auth.APIKeys[0].Key != SecretMask || r.Tracing.Headers["Authorization"] != SecretMask {
+		t.Errorf("secrets should be masked: %+v", r)
+	}
+	if c.Models[0].Authorization != "Bearer sk-model" || c.Auth.APIKeys[0].Key != "sk-user" || c.Tracing.Headers["Authorization"] != "Basic xx" {
+		t.Error("redacting must not modify the configuration")
+	}
+}
+
+func Test_InitMissingFile(t *testing.T) {
+	old := Get()
+	path := ConfigFile
+	if err := Init(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("a missing config file should be an error")
+	}
+	if Get() != old || ConfigFile != path {
+		t.Error("a failed load must not change the configuration")
+	}
+}
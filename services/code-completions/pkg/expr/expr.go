@@ -0,0 +1,96 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+/**
+ * 配置规则条件表达式的求值环境
+ * @description
+ * - 表达式只能引用这里定义的变量，编译时检查变量名和类型
+ * - 修剪规则中prefix、suffix为补全请求的前后缀，completion为模型的补全
+ * - 过滤规则在请求模型之前求值，completion为空
+ */
+type Env struct {
+	Language    string `expr:"language"`    // 语言
+	Completion  string `expr:"completion"`  // 补全内容
+	Prefix      string `expr:"prefix"`      // 前缀
+	Suffix      string `expr:"suffix"`      // 后缀
+	LinePrefix  string `expr:"linePrefix"`  // 光标所在行的前缀
+	LineSuffix  string `expr:"lineSuffix"`  // 光标所在行的后缀
+	TriggerMode string `expr:"triggerMode"` // 触发方式
+	FilePath    string `expr:"filePath"`    // 文件在工程中的路径
+	Model       string `expr:"model"`       // 请求的模型
+}
+
+/**
+ * 配置规则使用的条件表达式
+ * @description
+ * - 语法为expr-lang(github.com/expr-lang/expr)，变量由Env定义
+ * - 支持运算符: && || ! == != < <= > >= + - * / %，contains startsWith endsWith matches in，括号
+ * - 支持expr-lang的内置函数(len lower upper trim hasPrefix hasSuffix等)，以及lines count firstLine lastLine
+ * @example
+ * p, err := expr.Compile(`language == "vue" && prefix contains "<template>" && lines(completion) > 10`)
+ * hit, err := p.EvalBool(&expr.Env{Language: "vue", ...})
+ */
+type Program struct {
+	src     string
+	program *vm.Program
+}
+
+// 补充的字符串函数
+var functions = []expr.Option{
+	expr.Function("lines", func(params ...any) (any, error) {
+		s := params[0].(string)
+		if s == "" {
+			return 0, nil
+		}
+		return strings.Count(s, "\n") + 1, nil
+	}, new(func(string) int)),
+	expr.Function("count", func(params ...any) (any, error) {
+		return strings.Count(params[0].(string), params[1].(string)), nil
+	}, new(func(string, string) int)),
+	expr.Function("firstLine", func(params ...any) (any, error) {
+		line, _, _ := strings.Cut(params[0].(string), "\n")
+		return line, nil
+	}, new(func(string) string)),
+	expr.Function("lastLine", func(params ...any) (any, error) {
+		s := params[0].(string)
+		return s[strings.LastIndex(s, "\n")+1:], nil
+	}, new(func(string) string)),
+}
+
+/**
+ * 编译条件表达式
+ * @param {string} src - 表达式源码
+ * @returns {*Program, error} 返回编译后的表达式，语法错误、未知变量或函数、字面量正则不合法、结果不是布尔值时返回错误
+ */
+func Compile(src string) (*Program, error) {
+	options := append([]expr.Option{expr.Env(Env{}), expr.AsBool()}, functions...)
+	program, err := expr.Compile(src, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{src: src, program: program}, nil
+}
+
+func (p *Program) String() string {
+	return p.src
+}
+
+// 求值表达式，Program可以被并发使用
+func (p *Program) EvalBool(env *Env) (bool, error) {
+	v, err := expr.Run(p.program, env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition '%s' is not a boolean expression", p.src)
+	}
+	return b, nil
+}
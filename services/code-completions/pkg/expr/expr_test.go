@@ -0,0 +1,44 @@
+package expr
+
+import "testing"
+
+func Test_Eval(t *testing.T) {
+	env := &Env{
+		Language:    "vue",
+		Prefix:      "<template>\n  <div>",
+		Completion:  "a\nb\nc",
+		FilePath:    "src/App.vue",
+		Model:       "deepseek",
+		TriggerMode: "auto",
+	}
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`language == "vue" && prefix contains "<template>"`, true},
+		{`lines(completion) > 2`, true},
+		{`!hasSuffix(lastLine(prefix), "<div>")`, false},
+		{`completion matches "^a\\s"`, true},
+		{`count(completion, "\n") * 2 + len("ab") - 1 == 5`, true},
+		{`upper(language) + "!" == "VUE!"`, true},
+		{`filePath endsWith ".vue" && model == "deepseek" && triggerMode in ["auto", "manual"]`, true},
+		{`firstLine(prefix) == "<template>"`, true},
+	}
+	for _, c := range cases {
+		p, err := Compile(c.src)
+		if err != nil {
+			t.Fatalf("compile %s: %v", c.src, err)
+		}
+		got, err := p.EvalBool(env)
+		if err != nil || got != c.want {
+			t.Errorf("%s = %v (%v), want %v", c.src, got, err, c.want)
+		}
+	}
+
+	for _, src := range []string{`os.Exit(1)`, `unknown(prefix)`, `lines(prefix, prefix)`, `missing == "x"`,
+		`prefix matches "("`, `lines(prefix)`, `language +`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("expected compile error for %s", src)
+		}
+	}
+}
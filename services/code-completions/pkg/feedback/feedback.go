@@ -0,0 +1,106 @@
+package feedback
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code-completion/pkg/completions"
+)
+
+// 反馈事件类型
+type EventType string
+
+const (
+	EventAccept        EventType = "accept"         //补全被完整接受
+	EventReject        EventType = "reject"         //补全被拒绝(展示后未接受)
+	EventPartialAccept EventType = "partial_accept" //补全被部分接受
+)
+
+// 判断事件类型是否合法
+func (t EventType) Valid() bool {
+	switch t {
+	case EventAccept, EventReject, EventPartialAccept:
+		return true
+	}
+	return false
+}
+
+// 事件对应的训练标签：接受和部分接受都视为接受
+func (t EventType) Label() float64 {
+	if t == EventReject {
+		return 0
+	}
+	return 1
+}
+
+// 反馈事件，存储为JSONL的一行
+type Event struct {
+	CompletionID   string                           `json:"completion_id"`
+	ClientID       string                           `json:"client_id,omitempty"`
+	Language       string                           `json:"language,omitempty"`
+	Model          string                           `json:"model,omitempty"`
+	Event          EventType                        `json:"event"`
+	AcceptedLength int                              `json:"accepted_length,omitempty"` //部分接受时接受的字符数
+	Features       *completions.HiddenScoreFeatures `json:"features,omitempty"`        //补全时的隐藏分特征
+	Time           time.Time                        `json:"time"`
+}
+
+// 反馈事件存储(JSONL文件，追加写)
+type Store struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// 追加一个反馈事件
+func (s *Store) Append(e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+/**
+ * 读取反馈事件文件
+ * @param {string} path - JSONL文件路径
+ * @param {func(*Event)} fn - 每个事件的回调
+ * @returns {error} 文件无法读取时返回错误，格式错误的行被跳过
+ */
+func ReadEvents(path string, fn func(*Event)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(&e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	return nil
+}
@@ -55,10 +55,81 @@ var (
 		[]string{"model"},
 	)
 
+	// 瞬时值指标：模型池是否健康(1健康，0已摘除)
+	poolHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_pool_healthy",
+			Help: "Whether the model pool is healthy (1) or ejected (0)",
+		},
+		[]string{"model", "title"},
+	)
+
+	// 模型池被摘除的次数 (Counter)
+	poolEjectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_pool_ejections_total",
+			Help: "Total number of model pool ejections caused by consecutive failures",
+		},
+		[]string{"model", "title"},
+	)
+
+	// 模型出错后切换模型池的次数 (Counter)
+	poolFailoversTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_pool_failovers_total",
+			Help: "Total number of requests failed over to another model pool",
+		},
+		[]string{"selector"},
+	)
+
+	// 客户端反馈的补全事件数 (Counter)
+	completionFeedbackTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_feedback_total",
+			Help: "Total number of completion feedback events reported by clients",
+		},
+		[]string{"model", "language", "event"},
+	)
+
+	// 瞬时值指标：客户端反馈的补全接受率(接受+部分接受)/总反馈数
+	completionAcceptanceRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "completion_acceptance_rate",
+			Help: "Ratio of accepted completions to all completion feedback events",
+		},
+		[]string{"model", "language"},
+	)
+
+	// 计算接受率使用的累计反馈数，键为model和language
+	feedbackCounts = map[[2]string]*feedbackCount{}
+
+	// 采集队列满时丢弃的补全记录数 (Counter)
+	captureDroppedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "completion_capture_dropped_total",
+			Help: "Total number of captured completion records dropped because the write queue was full",
+		},
+	)
+
+	// 配置定义的规则命中次数 (Counter)
+	completionRuleHitsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "completion_rule_hits_total",
+			Help: "Total number of hits of configured pruner and filter rules",
+		},
+		[]string{"kind", "rule"},
+	)
+
 	// 互斥锁，确保线程安全
 	metricsMutex sync.Mutex
 )
 
+// 累计的反馈数
+type feedbackCount struct {
+	accepted int64
+	total    int64
+}
+
 // 定义token类型
 type TokenType string
 
@@ -110,6 +181,78 @@ func UpdateCompletionConcurrentByModel(model string, count int) {
 	completionConcurrentByModel.WithLabelValues(model).Set(float64(count))
 }
 
+// 更新模型池健康状态
+func UpdatePoolHealthy(model, title string, healthy bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	value := 0.0
+	if healthy {
+		value = 1.0
+	}
+	poolHealthy.WithLabelValues(model, title).Set(value)
+}
+
+// 模型池被移除时清理相关指标
+func RemovePool(model, title string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	poolHealthy.DeleteLabelValues(model, title)
+}
+
+// 记录模型池被摘除
+func IncrementPoolEjections(model, title string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	poolEjectionsTotal.WithLabelValues(model, title).Inc()
+}
+
+// 记录请求切换模型池
+func IncrementPoolFailovers(selector string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	poolFailoversTotal.WithLabelValues(selector).Inc()
+}
+
+// 记录客户端反馈(接受/拒绝/部分接受)，并更新接受率
+func IncrementCompletionFeedback(model, language, event string, accepted bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	completionFeedbackTotal.WithLabelValues(model, language, event).Inc()
+
+	key := [2]string{model, language}
+	count := feedbackCounts[key]
+	if count == nil {
+		count = &feedbackCount{}
+		feedbackCounts[key] = count
+	}
+	count.total++
+	if accepted {
+		count.accepted++
+	}
+	completionAcceptanceRate.WithLabelValues(model, language).Set(float64(count.accepted) / float64(count.total))
+}
+
+// 记录因采集队列满丢弃的补全记录
+func IncrementCaptureDropped() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	captureDroppedTotal.Inc()
+}
+
+// 记录配置定义的规则命中(kind: pruner/filter)
+func IncrementRuleHits(kind, rule string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	completionRuleHitsTotal.WithLabelValues(kind, rule).Inc()
+}
+
 // 返回Prometheus指标数据的HTTP处理器
 func GetMetricsHandler() http.Handler {
 	return promhttp.Handler()
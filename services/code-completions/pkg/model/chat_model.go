@@ -0,0 +1,96 @@
+package model
+
+import (
+	"bytes"
+	"code-completion/pkg/trace"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 对话消息
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// OpenAI v1/chat/completions协议的响应(只解析用到的字段)
+type ChatResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage CompletionUsage `json:"usage"`
+}
+
+/**
+ * 对话模型，使用OpenAI v1/chat/completions协议
+ * @description
+ * - 用于补全之外需要指令能力的场景，例如下一处编辑预测
+ * - 错误状态的判断与补全模型一致
+ */
+type ChatModel struct {
+	Url           string
+	ModelName     string
+	Authorization string
+	Timeout       time.Duration
+}
+
+/**
+ * 调用对话模型
+ * @param {context.Context} ctx - 请求上下文
+ * @param {[]ChatMessage} messages - 对话消息
+ * @param {int} maxTokens - 最大输出token数
+ * @param {float32} temperature - 温度
+ * @returns {*ChatResponse, *CompletionVerbose, CompletionStatus, error} 返回模型响应、调试信息、状态和错误
+ */
+func (m *ChatModel) Chat(ctx context.Context, messages []ChatMessage, maxTokens int, temperature float32) (*ChatResponse, *CompletionVerbose, CompletionStatus, error) {
+	data := map[string]interface{}{
+		"model":       m.ModelName,
+		"messages":    messages,
+		"max_tokens":  maxTokens,
+		"temperature": temperature,
+		"stream":      false,
+	}
+	var verbose CompletionVerbose
+	verbose.Id = m.ModelName
+	verbose.Input = data
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", m.Url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, &verbose, StatusReqError, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(ctx, req.Header)
+	if m.Authorization != "" {
+		req.Header.Set("Authorization", m.Authorization)
+	}
+
+	client := &http.Client{Timeout: m.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &verbose, requestErrorStatus(err), err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	json.Unmarshal(body, &verbose.Output)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &verbose, StatusModelError, fmt.Errorf("Invalid StatusCode(%d)", resp.StatusCode)
+	}
+	var rsp ChatResponse
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return nil, &verbose, StatusServerError, err
+	}
+	return &rsp, &verbose, StatusSuccess, nil
+}
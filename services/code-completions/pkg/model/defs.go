@@ -5,6 +5,8 @@ type CompletionParameter struct {
 	CompletionID string   `json:"completionID"` // 补全请求ID，用于唯一标识一次补全请求
 	ClientID     string   `json:"clientID"`     // 用户ID，唯一标识发起补全请求的用户
 	Language     string   `json:"language"`     // 编程语言
+	FilePath     string   `json:"filePath"`     // 文件在工程中的路径
+	TriggerMode  string   `json:"triggerMode"`  // 触发方式
 	Model        string   `json:"model"`        // 模型
 	MaxTokens    int      `json:"max_tokens"`   // 回复内容的最大token数
 	Temperature  float32  `json:"temperature"`  // 温度
@@ -13,12 +15,16 @@ type CompletionParameter struct {
 	Suffix       string   `json:"suffix"`       // 后缀
 	CodeContext  string   `json:"context"`      // 上下文
 	Verbose      bool     `json:"verbose"`      // 是否需要更详细的回复，帮助调试
+	N            int      `json:"n,omitempty"`  // 采样的候选数，不大于1时只采样一个
+	Logprobs     bool     `json:"logprobs"`     // 是否请求对数概率，用于候选排序
+	Rerank       bool     `json:"-"`            // 是否对候选打分排序(多模型池并发请求时需要合并排序)
 }
 
 type CompletionVerbose struct {
-	Id     string                 `json:"id"`
-	Input  map[string]interface{} `json:"input"`
-	Output map[string]interface{} `json:"output,omitempty"`
+	Id      string                 `json:"id"`
+	Input   map[string]interface{} `json:"input"`
+	Output  map[string]interface{} `json:"output,omitempty"`
+	Context interface{}            `json:"context,omitempty"` // 上下文预算分配结果
 }
 
 type CompletionStatus string
@@ -10,9 +10,9 @@ import (
 )
 
 type OpenAIModelManager struct {
-	models []LLM
-	mutex  sync.Mutex
-	index  int
+	models  []LLM
+	mutex   sync.Mutex
+	current []int // 平滑加权轮询的当前权重
 }
 
 type NewLLM func(*config.ModelConfig, *tokenizers.Tokenizer) LLM
@@ -29,16 +29,22 @@ func GetAutoModel() LLM {
 	if modelLen == 0 {
 		panic(manager)
 	}
-	// 采用轮转法选择模型进行响应
-	var model LLM
-	if manager.index < modelLen {
-		model = manager.models[manager.index]
-		manager.index++
-	} else {
-		manager.index = 1
-		model = manager.models[0]
+	// 采用平滑加权轮询法选择模型进行响应(权重都为1时退化为普通轮转)
+	if len(manager.current) != modelLen {
+		manager.current = make([]int, modelLen)
 	}
-	return model
+	total := 0
+	selected := 0
+	for i, m := range manager.models {
+		weight := max(m.Config().Weight, 1)
+		total += weight
+		manager.current[i] += weight
+		if manager.current[i] > manager.current[selected] {
+			selected = i
+		}
+	}
+	manager.current[selected] -= total
+	return manager.models[selected]
 }
 
 func GetModel(idx int) LLM {
@@ -53,24 +59,52 @@ func GetModel(idx int) LLM {
 
 var manager = &OpenAIModelManager{}
 
+/**
+ * 根据配置创建模型实例
+ * @param {*config.ModelConfig} c - 模型配置
+ * @returns {LLM, error} 返回模型实例，tokenizer加载失败时返回错误
+ */
+func NewModel(c *config.ModelConfig) (LLM, error) {
+	token, err := tokenizers.NewTokenizer(c.TokenizerPath)
+	if err != nil {
+		return nil, fmt.Errorf("init tokenizer '%s' error: %w", c.TokenizerPath, err)
+	}
+	newLLM, exists := modelDefs[c.Provider]
+	if !exists {
+		newLLM = NewOpenAIModel
+	}
+	return newLLM(c, token), nil
+}
+
+// 获取当前所有可用模型
+func GetModels() []LLM {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return append([]LLM(nil), manager.models...)
+}
+
+// 替换当前可用模型(配置热加载时使用)
+func SetModels(models []LLM) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.models = models
+	manager.current = nil
+}
+
 func Init(cfgModels []config.ModelConfig) error {
 	models := make([]LLM, 0)
-	for _, c := range cfgModels {
-		token, err := tokenizers.NewTokenizer(c.TokenizerPath)
+	for i := range cfgModels {
+		m, err := NewModel(&cfgModels[i])
 		if err != nil {
-			zap.L().Error("init tokenizer error", zap.String("tokenizerPath", c.TokenizerPath), zap.Error(err))
+			zap.L().Error("init model error", zap.String("model", cfgModels[i].ID()), zap.Error(err))
 			continue
 		}
-		newLLM, exists := modelDefs[c.Provider]
-		if !exists {
-			newLLM = NewOpenAIModel
-		}
-		models = append(models, newLLM(&c, token))
+		models = append(models, m)
 	}
 	if len(models) == 0 {
 		zap.L().Fatal("No models available")
 		return fmt.Errorf("no models available")
 	}
-	manager.models = models
+	SetModels(models)
 	return nil
 }
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"code-completion/pkg/config"
 	"code-completion/pkg/tokenizers"
+	"code-completion/pkg/trace"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -80,6 +83,12 @@ func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (
 	if !m.cfg.FimMode && p.Suffix != "" {
 		data["suffix"] = p.Suffix
 	}
+	if p.N > 1 {
+		data["n"] = p.N
+	}
+	if p.Logprobs {
+		data["logprobs"] = 1
+	}
 	var verbose CompletionVerbose
 	verbose.Id = m.cfg.ModelTitle
 	verbose.Input = data
@@ -97,6 +106,7 @@ func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(ctx, req.Header)
 	req.Header.Set("Authorization", m.cfg.Authorization)
 
 	// 发送请求
@@ -105,14 +115,7 @@ func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		status := StatusServerError
-		switch err {
-		case context.Canceled:
-			status = StatusCanceled
-		case context.DeadlineExceeded:
-			status = StatusTimeout
-		}
-		return nil, &verbose, status, err
+		return nil, &verbose, requestErrorStatus(err), err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
@@ -129,3 +132,19 @@ func (m *OpenAIModel) Completions(ctx context.Context, p *CompletionParameter) (
 	}
 	return &rsp, &verbose, StatusSuccess, nil
 }
+
+// 根据HTTP请求的错误判断补全状态，连接被拒绝、DNS解析失败、连接重置等都是模型服务的问题
+func requestErrorStatus(err error) CompletionStatus {
+	// client.Do返回的是*url.Error，需要用errors.Is判断包装的原因
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return StatusTimeout
+	}
+	return StatusModelError
+}
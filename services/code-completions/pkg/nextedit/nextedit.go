@@ -0,0 +1,170 @@
+package nextedit
+
+import (
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+	"code-completion/pkg/trace"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 上下文提供者，首次使用时创建
+var (
+	contextOnce   sync.Once
+	contextClient codebase_context.ContextProvider
+)
+
+// 按路径缓存的分词器，加载失败时缓存nil
+var (
+	tokenizerMutex sync.Mutex
+	tokenizerCache = make(map[string]*tokenizers.Tokenizer)
+)
+
+/**
+ * 获取token计数函数
+ * @param {*config.NextEditConfig} cfg - 下一处编辑预测配置
+ * @returns {func(string) int} 返回token计数函数
+ * @description
+ * - 优先使用配置的分词器，其次使用第一个补全模型的分词器
+ * - 都没有时按4个字符一个token估算
+ */
+func tokenCounter(cfg *config.NextEditConfig) func(string) int {
+	var tokenizer *tokenizers.Tokenizer
+	if cfg.TokenizerPath != "" {
+		tokenizerMutex.Lock()
+		t, ok := tokenizerCache[cfg.TokenizerPath]
+		if !ok {
+			var err error
+			if t, err = tokenizers.NewTokenizer(cfg.TokenizerPath); err != nil {
+				zap.L().Warn("Load next edit tokenizer failed", zap.String("path", cfg.TokenizerPath), zap.Error(err))
+			}
+			tokenizerCache[cfg.TokenizerPath] = t
+		}
+		tokenizerMutex.Unlock()
+		tokenizer = t
+	} else if models := model.GetModels(); len(models) > 0 {
+		tokenizer = models[0].Tokenizer()
+	}
+	if tokenizer == nil {
+		return func(s string) int { return (len(s) + 3) / 4 }
+	}
+	return tokenizer.GetTokenCount
+}
+
+/**
+ * 预测下一处编辑
+ * @param {context.Context} ctx - 请求上下文，被同一客户端的新请求取消时停止
+ * @param {*Request} req - 预测请求
+ * @param {*completions.CompletionPerformance} perf - 性能统计
+ * @returns {*Response} 返回预测的编辑列表
+ * @description
+ * - 提示词由检索的上下文、编辑历史、光标周围的文件窗口组成，按nextEdit.maxInputTokens分配预算
+ * - 对话模型按行号给出替换，转换为LSP风格的范围替换返回
+ * - 模型输出无法解析时返回empty状态
+ */
+func Predict(ctx context.Context, req *Request, perf *completions.CompletionPerformance) *Response {
+	cfg := config.Get().NextEdit
+	if cfg.ChatUrl == "" {
+		return ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusRejected, perf, nil,
+			fmt.Errorf("next edit is not configured"))
+	}
+	doc := newDocument(req.Content)
+	if req.Cursor.Line < 0 || req.Cursor.Line >= len(doc.lines) {
+		return ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusReqError, perf, nil,
+			fmt.Errorf("cursor line %d is out of the file", req.Cursor.Line))
+	}
+	countTokens := tokenCounter(&cfg)
+	prefix, suffix := doc.split(req.Cursor)
+
+	// 检索上下文
+	contextOnce.Do(func() {
+		contextClient = codebase_context.NewContextProvider()
+	})
+	items := contextClient.GetContextItems(
+		codebase_context.WithNeighborFiles(ctx, req.NeighborFiles),
+		req.ClientID, req.ProjectPath, req.FilePath, prefix, suffix, "", nil)
+	perf.ContextDuration = time.Since(perf.ReceiveTime).Milliseconds()
+
+	budget := cfg.MaxInputTokens - countTokens(systemPrompt)
+	var codeContext string
+	if len(items) > 0 {
+		bc := &config.Get().Context.Budget
+		assembler := &codebase_context.ContextAssembler{
+			Ratios: map[string]float64{
+				codebase_context.SourceDefinition: bc.Definition,
+				codebase_context.SourceSemantic:   bc.Semantic,
+				codebase_context.SourceRelation:   bc.Relation,
+				codebase_context.SourceLocal:      bc.Local,
+			},
+			DistanceWeight: bc.DistanceWeight,
+			CountTokens:    countTokens,
+		}
+		codeContext, perf.ContextAllocation = assembler.Assemble(items, req.FilePath, prefix, suffix,
+			int(float64(budget)*contextRatio))
+	}
+	history := req.History
+	if len(history) > cfg.MaxHistory {
+		history = history[len(history)-cfg.MaxHistory:]
+	}
+	historyText := renderHistory(history, int(float64(budget)*historyRatio), countTokens)
+	var selection string
+	if s := req.Selection; s != nil && s.Start != s.End {
+		selection = fmt.Sprintf("Selected: line %d column %d to line %d column %d.",
+			s.Start.Line+1, s.Start.Character+1, s.End.Line+1, s.End.Character+1)
+	}
+	winBudget := budget - countTokens(codeContext) - countTokens(historyText) - countTokens(selection)
+	winStart, winEnd := doc.window(req.Cursor, winBudget, countTokens)
+	messages := []model.ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: buildUserPrompt(req, codeContext, historyText, doc.render(winStart, winEnd, req.Cursor), selection)},
+	}
+
+	chat := &model.ChatModel{
+		Url:           cfg.ChatUrl,
+		ModelName:     cfg.ModelName,
+		Authorization: cfg.Authorization,
+		Timeout:       cfg.Timeout,
+	}
+	start := time.Now()
+	chatCtx, span := trace.Start(ctx, "llm.chat", trace.KindClient)
+	span.SetAttr("model", cfg.ModelName)
+	rsp, verbose, status, err := chat.Chat(chatCtx, messages, cfg.MaxOutput, float32(cfg.Temperature))
+	span.SetAttr("status", string(status))
+	span.SetError(err)
+	span.End()
+	perf.LLMDuration = time.Since(start).Milliseconds()
+	if !req.Verbose {
+		verbose = nil
+	} else if verbose != nil && perf.ContextAllocation != nil {
+		verbose.Context = perf.ContextAllocation
+	}
+	if status != model.StatusSuccess {
+		return ErrorResponse(req.CompletionID, cfg.ModelName, status, perf, verbose, err)
+	}
+	perf.PromptTokens = rsp.Usage.PromptTokens
+	perf.CompletionTokens = rsp.Usage.CompletionTokens
+	perf.TotalTokens = rsp.Usage.TotalTokens
+	if len(rsp.Choices) == 0 {
+		return ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusEmpty, perf, verbose, nil)
+	}
+	lineEdits, err := parseLineEdits(rsp.Choices[0].Message.Content)
+	if err != nil {
+		zap.L().Warn("Invalid next edit output", zap.String("completionID", req.CompletionID),
+			zap.String("output", rsp.Choices[0].Message.Content), zap.Error(err))
+		return ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusEmpty, perf, verbose, err)
+	}
+	edits := doc.toTextEdits(lineEdits, winStart, winEnd, cfg.MaxEdits)
+	if len(edits) == 0 {
+		return ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusEmpty, perf, verbose, nil)
+	}
+	result := newResponse(req.CompletionID, cfg.ModelName, model.StatusSuccess, perf, verbose)
+	result.Edits = edits
+	return result
+}
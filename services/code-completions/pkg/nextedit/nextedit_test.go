@@ -0,0 +1,72 @@
+package nextedit
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_toTextEdits(t *testing.T) {
+	doc := newDocument("a\nb\nc\nd")
+	edits := doc.toTextEdits([]lineEdit{
+		{StartLine: 2, EndLine: 2, Text: "B"},
+		{StartLine: 2, EndLine: 3, Text: "overlap"},
+		{StartLine: 4, EndLine: 3, Text: "inserted"},
+		{StartLine: 1, EndLine: 1, Text: "a"}, // 没有变化
+		{StartLine: 4, EndLine: 4, Text: ""},  // 删除最后一行
+		{StartLine: 9, EndLine: 9, Text: "x"}, // 超出窗口
+	}, 0, 4, 5)
+	want := []TextEdit{
+		{Range: Range{Start: Position{Line: 1}, End: Position{Line: 2}}, Text: "B\n"},
+		{Range: Range{Start: Position{Line: 3}, End: Position{Line: 3}}, Text: "inserted\n"},
+		{Range: Range{Start: Position{Line: 2, Character: 1}, End: Position{Line: 3, Character: 1}}, Text: ""},
+	}
+	// 删除最后一行时，替换范围从上一行行尾开始
+	if len(edits) != len(want) || edits[0] != want[0] || edits[1] != want[1] || edits[2] != want[2] {
+		t.Errorf("unexpected edits: %+v", edits)
+	}
+}
+
+func Test_Predict(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []model.ChatMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if !strings.Contains(body.Messages[1].Content, "2| total := <|cursor|>") ||
+			!strings.Contains(body.Messages[1].Content, "+count := 0") {
+			t.Errorf("unexpected prompt: %s", body.Messages[1].Content)
+		}
+		w.Write([]byte("{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":" +
+			"\"```json\\n{\\\"edits\\\":[{\\\"start_line\\\":3,\\\"end_line\\\":3,\\\"text\\\":\\\"count++\\\"}]}\\n```\"}}]}"))
+	}))
+	defer server.Close()
+
+	c := config.Snapshot()
+	c.NextEdit = config.NextEditConfig{ChatUrl: server.URL, ModelName: "chat", Timeout: time.Second,
+		MaxInputTokens: 1000, MaxOutput: 100, MaxHistory: 5, MaxEdits: 5}
+	c.Context.Provider = "local"
+	defer config.Apply(config.Get())
+	config.Apply(c)
+	req := &Request{
+		ClientID:     "c1",
+		CompletionID: "e1",
+		FilePath:     "main.go",
+		Content:      "count := 0\ntotal := \ntotal++\n",
+		Cursor:       Position{Line: 1, Character: 9},
+		History:      []Edit{{FilePath: "main.go", OldText: "total := 0", Text: "count := 0"}},
+	}
+	perf := &completions.CompletionPerformance{ReceiveTime: time.Now()}
+	rsp := Predict(context.Background(), req, perf)
+	if rsp.Status != model.StatusSuccess || len(rsp.Edits) != 1 || rsp.Edits[0].Text != "count++\n" ||
+		rsp.Edits[0].Range.Start.Line != 2 {
+		t.Errorf("unexpected response: %+v", rsp)
+	}
+}
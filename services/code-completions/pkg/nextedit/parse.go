@@ -0,0 +1,104 @@
+package nextedit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 模型输出的按行编辑
+type lineEdit struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Text      string `json:"text"`
+}
+
+/**
+ * 解析模型输出的编辑
+ * @param {string} content - 模型输出，允许包含markdown代码块或前后的说明文字
+ * @returns {[]lineEdit, error} 返回模型给出的按行编辑
+ */
+func parseLineEdits(content string) ([]lineEdit, error) {
+	begin := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if begin < 0 || end < begin {
+		return nil, fmt.Errorf("no json object in model output")
+	}
+	var out struct {
+		Edits []lineEdit `json:"edits"`
+	}
+	if err := json.Unmarshal([]byte(content[begin:end+1]), &out); err != nil {
+		return nil, err
+	}
+	return out.Edits, nil
+}
+
+/**
+ * 把按行编辑转换为范围替换
+ * @param {[]lineEdit} edits - 模型给出的按行编辑，行号从1开始
+ * @param {int} winStart - 文件窗口的起始行(从0开始)
+ * @param {int} winEnd - 文件窗口的结束行(从0开始，不包含)
+ * @param {int} maxEdits - 最多返回的编辑数
+ * @returns {[]TextEdit} 返回按位置排序、互不重叠的范围替换
+ * @description
+ * - 超出文件窗口、互相重叠、替换前后内容相同的编辑被丢弃
+ * - 行被替换时范围为[start_line行首, end_line下一行行首)，替换文本以换行结尾
+ * - 编辑到文件最后一行时范围不包含不存在的下一行，改为从上一行的行尾开始
+ */
+func (d *document) toTextEdits(edits []lineEdit, winStart, winEnd, maxEdits int) []TextEdit {
+	valid := make([]lineEdit, 0, len(edits))
+	for _, e := range edits {
+		// 插入时end_line = start_line - 1，允许在窗口最后一行之后插入
+		if e.StartLine < winStart+1 || e.EndLine < e.StartLine-1 || e.EndLine > winEnd || e.StartLine > winEnd+1 {
+			continue
+		}
+		e.Text = strings.TrimSuffix(strings.ReplaceAll(e.Text, "\r\n", "\n"), "\n")
+		if e.EndLine < e.StartLine && e.Text == "" {
+			continue // 插入空内容
+		}
+		if e.EndLine >= e.StartLine && strings.Join(d.lines[e.StartLine-1:e.EndLine], "\n") == e.Text {
+			continue
+		}
+		valid = append(valid, e)
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].StartLine < valid[j].StartLine })
+
+	result := make([]TextEdit, 0, len(valid))
+	lastEnd := 0
+	for _, e := range valid {
+		if e.StartLine <= lastEnd || len(result) >= maxEdits {
+			continue
+		}
+		lastEnd = max(e.EndLine, e.StartLine-1)
+		result = append(result, d.textEdit(e))
+	}
+	return result
+}
+
+func (d *document) textEdit(e lineEdit) TextEdit {
+	last := len(d.lines)
+	if e.EndLine < last {
+		// 下一行存在，替换到下一行行首
+		text := e.Text
+		if text != "" || e.EndLine < e.StartLine {
+			text += "\n" // 删除行时不需要换行
+		}
+		return TextEdit{
+			Range: Range{Start: Position{Line: e.StartLine - 1}, End: Position{Line: e.EndLine}},
+			Text:  text,
+		}
+	}
+	end := Position{Line: last - 1, Character: utf16Len(d.lines[last-1])}
+	if e.StartLine == 1 {
+		return TextEdit{Range: Range{End: end}, Text: e.Text}
+	}
+	// 编辑到文件末尾: 从上一行的行尾开始，避免留下多余的空行
+	prev := e.StartLine - 2
+	start := Position{Line: prev, Character: utf16Len(d.lines[prev])}
+	text := ""
+	if e.Text != "" || e.EndLine < e.StartLine {
+		text = "\n" + e.Text
+	}
+	return TextEdit{Range: Range{Start: start, End: end}, Text: text}
+}
@@ -0,0 +1,182 @@
+package nextedit
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// 提示词预算在编辑历史和检索上下文之间的分配比例，剩余的给文件窗口
+const (
+	historyRatio = 0.3
+	contextRatio = 0.2
+)
+
+const systemPrompt = `You are a code editing assistant. Based on the developer's recent edits, predict the next edits they will make in the current file.
+
+The current file is shown with 1-based line numbers ("12| code"). The cursor is marked with <|cursor|>, it is not part of the code.
+
+Respond with JSON only, in the format:
+{"edits":[{"start_line":12,"end_line":13,"text":"replacement code"}]}
+
+- Lines start_line..end_line (inclusive) are replaced by text, text must not contain line numbers or the cursor marker.
+- To insert lines before line N, use start_line N and end_line N-1. To delete lines, use an empty text.
+- Only edit lines shown in the file window, edits must not overlap.
+- Return {"edits":[]} if no further edit is needed.`
+
+// 按行拆分的文档，行内容不包含换行符
+type document struct {
+	lines []string
+}
+
+func newDocument(content string) *document {
+	lines := strings.Split(content, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return &document{lines: lines}
+}
+
+// UTF-16列偏移转换为字节偏移，超出行尾时返回行尾
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+// 行的UTF-16长度
+func utf16Len(line string) int {
+	return len(utf16.Encode([]rune(line)))
+}
+
+// 光标前后的文本，用于检索上下文
+func (d *document) split(cursor Position) (string, string) {
+	line := min(max(cursor.Line, 0), len(d.lines)-1)
+	col := byteOffset(d.lines[line], cursor.Character)
+	before := strings.Join(d.lines[:line], "\n")
+	if line > 0 {
+		before += "\n"
+	}
+	before += d.lines[line][:col]
+	after := d.lines[line][col:]
+	if line+1 < len(d.lines) {
+		after += "\n" + strings.Join(d.lines[line+1:], "\n")
+	}
+	return before, after
+}
+
+/**
+ * 选择光标周围的文件窗口
+ * @param {Position} cursor - 光标位置
+ * @param {int} budget - 窗口的token预算
+ * @param {func(string) int} countTokens - token计数函数
+ * @returns {int, int} 返回窗口的起止行号(从0开始，左闭右开)
+ * @description
+ * - 从光标所在行开始交替向上、向下扩展，直到用完预算
+ * - 至少包含光标所在行
+ */
+func (d *document) window(cursor Position, budget int, countTokens func(string) int) (int, int) {
+	line := min(max(cursor.Line, 0), len(d.lines)-1)
+	start, end := line, line+1
+	used := countTokens(d.lines[line]) + 2
+	for start > 0 || end < len(d.lines) {
+		grown := false
+		if start > 0 {
+			if cost := countTokens(d.lines[start-1]) + 2; used+cost <= budget {
+				start--
+				used += cost
+				grown = true
+			}
+		}
+		if end < len(d.lines) {
+			if cost := countTokens(d.lines[end]) + 2; used+cost <= budget {
+				end++
+				used += cost
+				grown = true
+			}
+		}
+		if !grown {
+			break
+		}
+	}
+	return start, end
+}
+
+// 带行号和光标标记的文件窗口
+func (d *document) render(start, end int, cursor Position) string {
+	var sb strings.Builder
+	for i := start; i < end; i++ {
+		line := d.lines[i]
+		if i == cursor.Line {
+			col := byteOffset(line, cursor.Character)
+			line = line[:col] + "<|cursor|>" + line[col:]
+		}
+		fmt.Fprintf(&sb, "%d| %s\n", i+1, line)
+	}
+	return sb.String()
+}
+
+/**
+ * 渲染编辑历史
+ * @param {[]Edit} history - 最近的编辑，按时间从早到晚排列
+ * @param {int} budget - token预算
+ * @param {func(string) int} countTokens - token计数函数
+ * @returns {string} 返回diff格式的编辑历史，超出预算时丢弃最早的编辑
+ */
+func renderHistory(history []Edit, budget int, countTokens func(string) int) string {
+	var blocks []string
+	used := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i]
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "--- %s:%d\n", e.FilePath, e.Range.Start.Line+1)
+		for _, line := range splitLines(e.OldText) {
+			sb.WriteString("-" + line + "\n")
+		}
+		for _, line := range splitLines(e.Text) {
+			sb.WriteString("+" + line + "\n")
+		}
+		block := sb.String()
+		cost := countTokens(block)
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		blocks = append([]string{block}, blocks...)
+	}
+	return strings.Join(blocks, "")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+}
+
+// 组装用户消息
+func buildUserPrompt(req *Request, codeContext, history, window string, selection string) string {
+	var sb strings.Builder
+	if codeContext != "" {
+		sb.WriteString("Related code from the project:\n```\n" + codeContext + "\n```\n\n")
+	}
+	if history != "" {
+		sb.WriteString("Recent edits (oldest first):\n```diff\n" + history + "```\n\n")
+	} else {
+		sb.WriteString("There are no recent edits.\n\n")
+	}
+	fmt.Fprintf(&sb, "Current file %s", req.FilePath)
+	if req.LanguageID != "" {
+		fmt.Fprintf(&sb, " (%s)", req.LanguageID)
+	}
+	sb.WriteString(":\n```\n" + window + "```\n")
+	if selection != "" {
+		sb.WriteString("\n" + selection + "\n")
+	}
+	return sb.String()
+}
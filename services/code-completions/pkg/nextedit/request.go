@@ -0,0 +1,100 @@
+package nextedit
+
+import (
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+	"fmt"
+	"time"
+)
+
+// 文档中的位置，与LSP一致: 行号从0开始，列为UTF-16编码单元的偏移
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// 文档中的范围，左闭右开
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// 编辑历史中的一次编辑: range范围内的old_text被替换为text
+type Edit struct {
+	FilePath string `json:"file_path"`
+	Range    Range  `json:"range"`
+	OldText  string `json:"old_text"`
+	Text     string `json:"text"`
+}
+
+// 预测出的一处编辑: 把range范围内的文本替换为text(范围为空时表示插入)
+type TextEdit struct {
+	Range Range  `json:"range"`
+	Text  string `json:"text"`
+}
+
+// 下一处编辑预测请求
+type Request struct {
+	ClientID      string                          `json:"client_id"`
+	CompletionID  string                          `json:"completion_id"` // 请求ID
+	LanguageID    string                          `json:"language_id,omitempty"`
+	ProjectPath   string                          `json:"project_path,omitempty"`
+	FilePath      string                          `json:"file_path"`           // 当前文件在项目中的路径
+	Content       string                          `json:"content"`             // 当前文件的完整内容
+	Cursor        Position                        `json:"cursor"`              // 光标位置
+	Selection     *Range                          `json:"selection,omitempty"` // 选中的范围
+	History       []Edit                          `json:"history,omitempty"`   // 最近的编辑，按时间从早到晚排列
+	NeighborFiles []codebase_context.NeighborFile `json:"neighbor_files,omitempty"`
+	Verbose       bool                            `json:"verbose,omitempty"`
+}
+
+// 下一处编辑预测响应
+type Response struct {
+	ID      string                            `json:"id"`
+	Model   string                            `json:"model"`
+	Object  string                            `json:"object"`
+	Edits   []TextEdit                        `json:"edits"` // 按位置排序、互不重叠的编辑
+	Created int                               `json:"created"`
+	Usage   completions.CompletionPerformance `json:"usage"`
+	Status  model.CompletionStatus            `json:"status"`
+	Error   string                            `json:"error"`
+	Verbose *model.CompletionVerbose          `json:"verbose,omitempty"`
+	TraceID string                            `json:"trace_id,omitempty"` // 链路ID，启用链路追踪时给出
+}
+
+/**
+ * 创建错误响应
+ * @param {string} id - 请求ID
+ * @param {string} modelName - 模型名称
+ * @param {model.CompletionStatus} status - 失败状态
+ * @param {*completions.CompletionPerformance} perf - 性能统计
+ * @param {*model.CompletionVerbose} verbose - 调试信息
+ * @param {error} err - 错误，为nil时使用状态作为错误信息
+ * @returns {*Response} 返回没有编辑的响应
+ */
+func ErrorResponse(id, modelName string, status model.CompletionStatus, perf *completions.CompletionPerformance,
+	verbose *model.CompletionVerbose, err error) *Response {
+	if err == nil {
+		err = fmt.Errorf("%s", string(status))
+	}
+	rsp := newResponse(id, modelName, status, perf, verbose)
+	rsp.Error = err.Error()
+	return rsp
+}
+
+func newResponse(id, modelName string, status model.CompletionStatus, perf *completions.CompletionPerformance,
+	verbose *model.CompletionVerbose) *Response {
+	perf.TotalDuration = time.Since(perf.ReceiveTime).Milliseconds()
+	completions.Metrics(modelName, string(status), perf)
+	return &Response{
+		ID:      id,
+		Model:   modelName,
+		Object:  "next_edit",
+		Edits:   []TextEdit{},
+		Created: int(perf.ReceiveTime.Unix()),
+		Usage:   *perf,
+		Status:  status,
+		Verbose: verbose,
+	}
+}
@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"bufio"
+	"code-completion/pkg/codebase_context"
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Record 一条采集的补全请求，JSONL文件的一行
+type Record struct {
+	Request      completions.CompletionRequest   `json:"request"`                 // 原始请求
+	ContextItems []*codebase_context.ContextItem `json:"context_items,omitempty"` // 检索到的上下文片段，回放时不再访问检索服务
+	Model        string                          `json:"model"`                   // 实际使用的模型
+	Status       model.CompletionStatus          `json:"status"`
+	RawText      string                          `json:"raw_text"`           // 模型原始输出(修剪前)，recorded后端回放时作为模型输出
+	Text         string                          `json:"text"`               // 返回给客户端的补全
+	Expected     string                          `json:"expected,omitempty"` // 期望的补全(例如用户最终写下的代码)，为空时以text为参照
+	LLMDuration  int64                           `json:"llm_duration"`       // 调用模型的时长(毫秒)
+	Time         time.Time                       `json:"time"`
+}
+
+// 参照补全，用于计算精确匹配率和编辑相似度
+func (r *Record) Reference() string {
+	if r.Expected != "" {
+		return r.Expected
+	}
+	return r.Text
+}
+
+// 采集记录的缓冲队列长度，队列满时丢弃记录，不阻塞补全请求
+const captureQueueSize = 1024
+
+// 待写入的采集记录，done不为空时表示等待之前的记录写完
+type captureItem struct {
+	path string
+	data []byte
+	done chan struct{}
+}
+
+var (
+	captureOnce    sync.Once
+	captureQueue   chan captureItem
+	captureDropped atomic.Int64
+)
+
+/**
+ * 按配置的比例抽样采集补全请求
+ * @param {*completions.CompletionInput} input - 补全输入
+ * @param {*completions.CompletionResponse} rsp - 补全响应
+ * @description
+ * - capture.sampleRate为0时不采集
+ * - 被过滤器拒绝的请求也采集，用于评估过滤规则的改动
+ * - 记录由后台协程写入文件，队列满时丢弃并计数，不影响补全请求
+ */
+func Capture(input *completions.CompletionInput, rsp *completions.CompletionResponse) {
+	cfg := &config.Get().Capture
+	if cfg.SampleRate <= 0 || rand.Float64() >= cfg.SampleRate {
+		return
+	}
+	r := &Record{
+		Request:      input.CompletionRequest,
+		ContextItems: input.ContextItems,
+		Model:        rsp.Model,
+		Status:       rsp.Status,
+		RawText:      rsp.RawText,
+		LLMDuration:  rsp.Usage.LLMDuration,
+		Time:         input.Now,
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if len(rsp.Choices) > 0 {
+		r.Text = rsp.Choices[0].Text
+	}
+	// 在请求协程中序列化，写入协程不再引用请求数据
+	data, err := json.Marshal(r)
+	if err != nil {
+		zap.L().Warn("Capture completion failed", zap.String("completionID", rsp.ID), zap.Error(err))
+		return
+	}
+	startCaptureWriter()
+	select {
+	case captureQueue <- captureItem{path: cfg.Path, data: data}:
+	default:
+		captureDropped.Add(1)
+		metrics.IncrementCaptureDropped()
+	}
+}
+
+// 采集队列满时丢弃的记录数
+func Dropped() int64 {
+	return captureDropped.Load()
+}
+
+func startCaptureWriter() {
+	captureOnce.Do(func() {
+		captureQueue = make(chan captureItem, captureQueueSize)
+		go writeCaptures(captureQueue)
+	})
+}
+
+// 等待已进入队列的采集记录写完
+func flushCaptures() {
+	startCaptureWriter()
+	done := make(chan struct{})
+	captureQueue <- captureItem{done: done}
+	<-done
+}
+
+// 采集记录的写入协程，写文件失败只记录日志
+func writeCaptures(queue <-chan captureItem) {
+	for item := range queue {
+		if item.done != nil {
+			close(item.done)
+			continue
+		}
+		if err := appendRecord(item.path, item.data); err != nil {
+			zap.L().Warn("Write capture failed", zap.String("path", item.path), zap.Error(err))
+		}
+	}
+}
+
+func appendRecord(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+/**
+ * 读取采集的语料
+ * @param {string} path - JSONL文件路径
+ * @param {int} limit - 最多读取的记录数，0表示不限制
+ * @returns {[]*Record, error} 返回记录，格式错误的行被跳过
+ */
+func LoadRecords(path string, limit int) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, &r)
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,53 @@
+package replay
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CaptureWritesAsync(t *testing.T) {
+	defer config.Apply(config.Get())
+	c := config.Snapshot()
+	c.Capture = config.CaptureConfig{SampleRate: 1, Path: filepath.Join(t.TempDir(), "capture.jsonl")}
+	config.Apply(c)
+
+	before := Dropped()
+	for i := 0; i < 10; i++ {
+		rsp := &completions.CompletionResponse{ID: "c", Model: "m", Choices: []completions.CompletionChoice{{Text: "text"}}}
+		Capture(&completions.CompletionInput{}, rsp)
+	}
+	flushCaptures()
+	records, err := LoadRecords(c.Capture.Path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped := Dropped() - before; int64(len(records))+dropped != 10 {
+		t.Errorf("got %d records and %d drops, want 10 in total", len(records), dropped)
+	}
+	if len(records) == 0 || records[0].Text != "text" || records[0].Model != "m" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func Test_CaptureDropsWhenQueueFull(t *testing.T) {
+	defer config.Apply(config.Get())
+	c := config.Snapshot()
+	c.Capture = config.CaptureConfig{SampleRate: 1, Path: filepath.Join(t.TempDir(), "capture.jsonl")}
+	config.Apply(c)
+
+	// 换成没有写入协程的队列，模拟写入跟不上
+	startCaptureWriter()
+	queue := captureQueue
+	captureQueue = make(chan captureItem, 1)
+	defer func() { captureQueue = queue }()
+
+	before := Dropped()
+	for i := 0; i < 3; i++ {
+		Capture(&completions.CompletionInput{}, &completions.CompletionResponse{ID: "c"})
+	}
+	if Dropped()-before != 2 {
+		t.Errorf("dropped %d records, want 2", Dropped()-before)
+	}
+}
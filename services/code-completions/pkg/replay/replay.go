@@ -0,0 +1,289 @@
+package replay
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 回放使用的模型后端
+const (
+	BackendReal     = "real"     // 调用配置中的真实模型
+	BackendRecorded = "recorded" // 使用采集记录中的模型原始输出
+)
+
+// 回放采集记录中的模型输出，用于在不调用模型的情况下评估过滤、截断、修剪的改动
+type recordedModel struct {
+	cfg       *config.ModelConfig
+	tokenizer *tokenizers.Tokenizer
+	text      string
+}
+
+func (m *recordedModel) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	rsp := &model.CompletionResponse{
+		Model:   m.cfg.ModelName,
+		Choices: []model.CompletionChoice{{Text: m.text}},
+	}
+	return rsp, &model.CompletionVerbose{Id: "recorded"}, model.StatusSuccess, nil
+}
+
+func (m *recordedModel) Config() *config.ModelConfig {
+	return m.cfg
+}
+
+func (m *recordedModel) Tokenizer() *tokenizers.Tokenizer {
+	return m.tokenizer
+}
+
+// Options 回放参数
+type Options struct {
+	Backend string        // 模型后端: real/recorded
+	Model   string        // 使用的模型(modelTitle或modelName)，为空时使用第一个模型
+	Timeout time.Duration // 单个请求的超时时间(real后端)
+}
+
+// Result 一份配置的回放结果
+type Result struct {
+	Name         string
+	Total        int            // 回放的记录数
+	Rejected     map[string]int // 被过滤器拒绝的数量(按原因)
+	Failed       map[string]int // 模型调用失败的数量(按状态)
+	Completed    int            // 模型返回了补全的数量
+	Discarded    int            // 被丢弃类修剪器整个丢弃的数量
+	Cut          int            // 被裁剪类修剪器改动的数量
+	PrunerHits   map[string]int // 各修剪器命中的次数
+	ExactMatch   int            // 与参照补全完全一致的数量
+	EditSimSum   float64        // 编辑相似度之和
+	Compared     int            // 参与比较的数量(参照补全不为空)
+	LLMLatency   []int64        // 模型调用耗时(毫秒)
+	TotalLatency []int64        // 总耗时(毫秒)
+}
+
+func newResult(name string) *Result {
+	return &Result{
+		Name:       name,
+		Rejected:   make(map[string]int),
+		Failed:     make(map[string]int),
+		PrunerHits: make(map[string]int),
+	}
+}
+
+/**
+ * 使用指定配置回放语料
+ * @param {string} name - 配置名称，用于报告
+ * @param {*config.SoftwareConfig} cfg - 回放使用的配置，会被设置为全局配置
+ * @param {[]*Record} records - 采集的语料
+ * @param {Options} opts - 回放参数
+ * @returns {*Result, error} 返回回放结果
+ * @description
+ * - 按线上流程处理每条记录: 过滤 → 组装上下文、截断 → 模型 → 修剪
+ * - 上下文使用采集时检索到的片段，不访问检索服务
+ * - 记录之间串行处理，延迟数据不受并发影响
+ */
+func Run(name string, cfg *config.SoftwareConfig, records []*Record, opts Options) (*Result, error) {
+	config.Apply(cfg)
+	mc, err := selectModel(cfg, opts.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	var llm model.LLM
+	var recorded *recordedModel
+	if opts.Backend == BackendRecorded {
+		// 没有分词器时不截断提示词，上下文按字符数估算token
+		tokenizer, _ := tokenizers.NewTokenizer(mc.TokenizerPath)
+		recorded = &recordedModel{cfg: mc, tokenizer: tokenizer}
+		llm = recorded
+	} else {
+		if llm, err = model.NewModel(mc); err != nil {
+			return nil, err
+		}
+	}
+
+	result := newResult(name)
+	for _, r := range records {
+		if recorded != nil {
+			if r.RawText == "" {
+				continue // 采集时没有拿到模型输出，无法回放
+			}
+			recorded.text = r.RawText
+		}
+		result.Total++
+		rsp := replayOne(llm, r, opts.Timeout)
+		result.add(r, rsp)
+	}
+	return result, nil
+}
+
+// 选择回放使用的模型配置
+func selectModel(cfg *config.SoftwareConfig, name string) (*config.ModelConfig, error) {
+	for i := range cfg.Models {
+		if name == "" || cfg.Models[i].ModelTitle == name || cfg.Models[i].ModelName == name {
+			return &cfg.Models[i], nil
+		}
+	}
+	return nil, fmt.Errorf("model '%s' not found", name)
+}
+
+// 回放一条记录，隐藏分的时间特征以采集请求的时间计算，结果与回放的时间无关
+func replayOne(llm model.LLM, r *Record, timeout time.Duration) *completions.CompletionResponse {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var perf completions.CompletionPerformance
+	perf.ReceiveTime = time.Now().Local()
+	c := completions.NewCompletionContext(ctx, &perf)
+
+	input := &completions.CompletionInput{
+		CompletionRequest: r.Request,
+		ContextItems:      r.ContextItems,
+		Now:               r.Time,
+	}
+	input.Model = llm.Config().ModelName
+	if rsp := input.Preprocess(c); rsp != nil {
+		return rsp
+	}
+	handler := completions.NewCompletionHandler(llm)
+	para := handler.Adapt(c, input)
+	return handler.CallLLM(c, para)
+}
+
+// 累计一条记录的回放结果
+func (res *Result) add(r *Record, rsp *completions.CompletionResponse) {
+	res.TotalLatency = append(res.TotalLatency, rsp.Usage.TotalDuration)
+	switch rsp.Status {
+	case model.StatusRejected:
+		res.Rejected[rsp.Error]++
+		return
+	case model.StatusSuccess, model.StatusEmpty:
+	default:
+		res.Failed[string(rsp.Status)]++
+		return
+	}
+	res.LLMLatency = append(res.LLMLatency, rsp.Usage.LLMDuration)
+	if rsp.RawText == "" {
+		res.Failed[string(model.StatusEmpty)]++
+		return
+	}
+	res.Completed++
+	for _, hit := range rsp.PrunerHits {
+		res.PrunerHits[hit]++
+	}
+	text := ""
+	if len(rsp.Choices) > 0 {
+		text = rsp.Choices[0].Text
+	}
+	if text == "" {
+		res.Discarded++
+	} else if text != strings.TrimRight(rsp.RawText, " \t\n\r") {
+		res.Cut++
+	}
+
+	ref := r.Reference()
+	if ref == "" {
+		return
+	}
+	res.Compared++
+	if strings.TrimSpace(text) == strings.TrimSpace(ref) {
+		res.ExactMatch++
+	}
+	res.EditSimSum += EditSimilarity(text, ref)
+}
+
+// 精确匹配率
+func (res *Result) ExactMatchRate() float64 {
+	return ratio(res.ExactMatch, res.Compared)
+}
+
+// 平均编辑相似度
+func (res *Result) EditSimilarity() float64 {
+	if res.Compared == 0 {
+		return 0
+	}
+	return res.EditSimSum / float64(res.Compared)
+}
+
+func (res *Result) RejectRate() float64 {
+	return ratio(sumCounts(res.Rejected), res.Total)
+}
+
+func (res *Result) DiscardRate() float64 {
+	return ratio(res.Discarded, res.Completed)
+}
+
+func (res *Result) CutRate() float64 {
+	return ratio(res.Cut, res.Completed)
+}
+
+func (res *Result) PrunerHitRate(name string) float64 {
+	return ratio(res.PrunerHits[name], res.Completed)
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func sumCounts(m map[string]int) int {
+	n := 0
+	for _, v := range m {
+		n += v
+	}
+	return n
+}
+
+// 计算百分位数，p取值[0,100]
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[idx]
+}
+
+// 最多比较的字符数，避免超长补全的编辑距离计算过慢
+const maxEditRunes = 2000
+
+/**
+ * 计算编辑相似度
+ * @returns {float64} 返回1 - 编辑距离/较长字符串的长度，两个字符串都为空时返回1
+ */
+func EditSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	ra = ra[:min(len(ra), maxEditRunes)]
+	rb = rb[:min(len(rb), maxEditRunes)]
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
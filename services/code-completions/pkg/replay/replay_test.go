@@ -0,0 +1,21 @@
+package replay
+
+import "testing"
+
+func Test_EditSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"abc", "abc", 1},
+		{"abc", "", 0},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"返回值", "返回", 1 - 1.0/3},
+	}
+	for _, c := range cases {
+		if got := EditSimilarity(c.a, c.b); got-c.want > 1e-9 || c.want-got > 1e-9 {
+			t.Errorf("EditSimilarity(%q, %q) = %f, want %f", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// 报告中的一行指标
+type reportRow struct {
+	name    string
+	value   func(*Result) float64
+	percent bool
+}
+
+/**
+ * 输出回放报告
+ * @param {io.Writer} w - 输出目标
+ * @param {[]*Result} results - 各配置的回放结果，有两份时输出第二份相对第一份的变化
+ * @description
+ * - 总体指标: 拒绝率、精确匹配率、编辑相似度、丢弃率、裁剪率、延迟
+ * - 每个修剪器的命中率，每种拒绝原因和失败状态的数量
+ */
+func PrintReport(w io.Writer, results ...*Result) {
+	if len(results) == 0 {
+		return
+	}
+	rows := []reportRow{
+		{"records", func(r *Result) float64 { return float64(r.Total) }, false},
+		{"completed", func(r *Result) float64 { return float64(r.Completed) }, false},
+		{"reject rate", (*Result).RejectRate, true},
+		{"exact match", (*Result).ExactMatchRate, true},
+		{"edit similarity", (*Result).EditSimilarity, true},
+		{"discard rate", (*Result).DiscardRate, true},
+		{"cut rate", (*Result).CutRate, true},
+		{"llm latency p50 (ms)", func(r *Result) float64 { return float64(percentile(r.LLMLatency, 50)) }, false},
+		{"llm latency p95 (ms)", func(r *Result) float64 { return float64(percentile(r.LLMLatency, 95)) }, false},
+		{"total latency p50 (ms)", func(r *Result) float64 { return float64(percentile(r.TotalLatency, 50)) }, false},
+		{"total latency p95 (ms)", func(r *Result) float64 { return float64(percentile(r.TotalLatency, 95)) }, false},
+	}
+	for _, name := range unionKeys(results, func(r *Result) map[string]int { return r.PrunerHits }) {
+		name := name
+		rows = append(rows, reportRow{"pruner " + name, func(r *Result) float64 { return r.PrunerHitRate(name) }, true})
+	}
+	for _, reason := range unionKeys(results, func(r *Result) map[string]int { return r.Rejected }) {
+		reason := reason
+		rows = append(rows, reportRow{"rejected: " + reason, func(r *Result) float64 { return float64(r.Rejected[reason]) }, false})
+	}
+	for _, status := range unionKeys(results, func(r *Result) map[string]int { return r.Failed }) {
+		status := status
+		rows = append(rows, reportRow{"failed: " + status, func(r *Result) float64 { return float64(r.Failed[status]) }, false})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"metric"}
+	for _, r := range results {
+		header = append(header, r.Name)
+	}
+	if len(results) == 2 {
+		header = append(header, "delta")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cols := []string{row.name}
+		for _, r := range results {
+			cols = append(cols, formatValue(row.value(r), row.percent))
+		}
+		if len(results) == 2 {
+			delta := row.value(results[1]) - row.value(results[0])
+			sign := ""
+			if delta > 0 {
+				sign = "+"
+			}
+			cols = append(cols, sign+formatValue(delta, row.percent))
+		}
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+	tw.Flush()
+}
+
+func formatValue(v float64, percent bool) string {
+	if percent {
+		return fmt.Sprintf("%.2f%%", v*100)
+	}
+	if v == float64(int64(v)) {
+		return fmt.Sprintf("%d", int64(v))
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// 多份结果中某个计数表的所有键，排序后返回
+func unionKeys(results []*Result, get func(*Result) map[string]int) []string {
+	set := make(map[string]bool)
+	for _, r := range results {
+		for k := range get(r) {
+			set[k] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,217 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/config"
+	"code-completion/pkg/metrics"
+	"code-completion/pkg/model"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//
+//	模型池健康状态: 主动探测 + 被动摘除
+//
+
+// 模型池健康状态
+type PoolHealth struct {
+	mutex               sync.RWMutex
+	healthy             bool
+	consecutiveFailures int
+	ejections           int
+	lastError           string
+	lastCheckTime       time.Time
+	lastProbeTime       time.Time
+	ejectedTime         time.Time
+	probing             bool // 有探测正在进行，上游很慢或不可用时不堆积探测
+}
+
+func newPoolHealth() *PoolHealth {
+	return &PoolHealth{healthy: true}
+}
+
+// 模型池是否健康，不健康的模型池不参与调度
+func (h *PoolHealth) IsHealthy() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.healthy
+}
+
+/**
+ * 记录一次成功的调用或探测
+ * @returns {bool} 如果模型池因此从摘除状态恢复，返回true
+ */
+func (h *PoolHealth) RecordSuccess() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.consecutiveFailures = 0
+	h.lastCheckTime = time.Now()
+	if h.healthy {
+		return false
+	}
+	h.healthy = true
+	return true
+}
+
+/**
+ * 记录一次失败的调用或探测
+ * @param {error} err - 失败原因
+ * @param {int} threshold - 连续失败多少次后摘除
+ * @returns {bool} 如果模型池因此被摘除，返回true
+ */
+func (h *PoolHealth) RecordFailure(err error, threshold int) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.consecutiveFailures++
+	h.lastCheckTime = time.Now()
+	if err != nil {
+		h.lastError = err.Error()
+	}
+	if !h.healthy || h.consecutiveFailures < threshold {
+		return false
+	}
+	h.healthy = false
+	h.ejections++
+	h.ejectedTime = h.lastCheckTime
+	return true
+}
+
+/**
+ * 开始一次主动探测
+ * @param {time.Duration} interval - 健康模型池的探测间隔
+ * @returns {bool} 需要探测时返回true，调用方探测结束后须调用endProbe
+ * @description
+ * - 被摘除的每次都探测，健康的按interval探测
+ * - 上一次探测还没结束时不探测
+ */
+func (h *PoolHealth) beginProbe(interval time.Duration) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.probing || (h.healthy && time.Since(h.lastProbeTime) < interval) {
+		return false
+	}
+	h.probing = true
+	h.lastProbeTime = time.Now()
+	return true
+}
+
+func (h *PoolHealth) endProbe() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.probing = false
+}
+
+func (h *PoolHealth) GetSummary() map[string]interface{} {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	summary := map[string]interface{}{
+		"healthy":              h.healthy,
+		"consecutive_failures": h.consecutiveFailures,
+		"ejections":            h.ejections,
+		"last_error":           h.lastError,
+	}
+	if !h.lastCheckTime.IsZero() {
+		summary["last_check_time"] = h.lastCheckTime
+	}
+	if !h.healthy {
+		summary["ejected_time"] = h.ejectedTime
+	}
+	return summary
+}
+
+// 判断补全状态是否说明模型本身出了问题(需要计入被动摘除，并尝试切换模型池)
+func isModelFailure(status model.CompletionStatus) bool {
+	return status == model.StatusModelError || status == model.StatusTimeout
+}
+
+// 根据补全状态更新模型池的健康状态
+func (m *PoolManager) recordOutcome(pool *ModelPool, status model.CompletionStatus, err error) {
+	switch {
+	case status == model.StatusSuccess || status == model.StatusEmpty:
+		if pool.health.RecordSuccess() {
+			zap.L().Info("Model pool recovered", zap.String("model", pool.cfg.ModelName),
+				zap.String("title", pool.cfg.ModelTitle))
+		}
+	case isModelFailure(status):
+		threshold := config.Get().StreamController.HealthCheck.FailureThreshold
+		if pool.health.RecordFailure(err, threshold) {
+			zap.L().Warn("Model pool ejected", zap.String("model", pool.cfg.ModelName),
+				zap.String("title", pool.cfg.ModelTitle),
+				zap.String("status", string(status)), zap.Error(err))
+			metrics.IncrementPoolEjections(pool.cfg.ModelName, pool.cfg.ModelTitle)
+		}
+	default:
+		return
+	}
+	metrics.UpdatePoolHealthy(pool.cfg.ModelName, pool.cfg.ModelTitle, pool.health.IsHealthy())
+}
+
+/**
+ * 对模型池做一次主动探测
+ * @param {*ModelPool} pool - 被探测的模型池
+ * @description
+ * - 发送一个极小的补全请求(max_tokens=1)
+ * - 探测结果与被动统计合并，连续失败达到阈值后摘除，成功一次即恢复
+ */
+func (m *PoolManager) probePool(pool *ModelPool) {
+	cfg := &config.Get().StreamController.HealthCheck
+	defer pool.health.endProbe()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	para := &model.CompletionParameter{
+		CompletionID: fmt.Sprintf("health-check-%d", time.Now().UnixNano()),
+		ClientID:     "health-check",
+		Model:        pool.cfg.ModelName,
+		MaxTokens:    1,
+		Prefix:       "\n",
+	}
+	_, _, status, err := pool.llm.Completions(ctx, para)
+	if status != model.StatusSuccess && status != model.StatusEmpty {
+		// 主动探测失败不区分失败类型，都计入失败
+		status = model.StatusModelError
+		if err == nil {
+			err = fmt.Errorf("health check failed")
+		}
+	}
+	m.recordOutcome(pool, status, err)
+}
+
+/**
+ * 启动模型池健康探测协程
+ * @description
+ * - 每个RecoveryInterval检查一遍所有模型池
+ * - 被摘除的模型池每次都探测，用于尽快恢复
+ * - 健康的模型池距离上次探测超过Interval才探测
+ * - 同一模型池同时只有一个探测
+ */
+func (m *PoolManager) StartHealthCheckRoutine() {
+	cfg := &config.Get().StreamController.HealthCheck
+	if cfg.Disabled {
+		zap.L().Info("Model pool health check is disabled")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.RecoveryInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			// 每次重新读取配置，热加载的探测间隔立即生效
+			interval := config.Get().StreamController.HealthCheck.Interval
+			for _, pool := range m.snapshot() {
+				if pool.health.beginProbe(interval) {
+					go m.probePool(pool)
+				}
+			}
+		}
+	}()
+	zap.L().Info("Start model pool health check routine",
+		zap.Duration("interval", cfg.Interval),
+		zap.Duration("recoveryInterval", cfg.RecoveryInterval))
+}
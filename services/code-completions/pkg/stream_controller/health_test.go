@@ -0,0 +1,187 @@
+package stream_controller
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/model"
+	"code-completion/pkg/tokenizers"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeLLM struct {
+	cfg *config.ModelConfig
+}
+
+func (f *fakeLLM) Completions(ctx context.Context, p *model.CompletionParameter) (*model.CompletionResponse, *model.CompletionVerbose, model.CompletionStatus, error) {
+	return nil, nil, model.StatusSuccess, nil
+}
+
+func (f *fakeLLM) Config() *config.ModelConfig { return f.cfg }
+
+func (f *fakeLLM) Tokenizer() *tokenizers.Tokenizer { return nil }
+
+func newTestPool(title string, maxConcurrent, weight int) *ModelPool {
+	return &ModelPool{
+		cfg: &config.ModelConfig{
+			ModelName:     "test",
+			ModelTitle:    title,
+			MaxConcurrent: maxConcurrent,
+			Weight:        weight,
+		},
+		runnings: make(map[string]*ClientRequest),
+		health:   newPoolHealth(),
+	}
+}
+
+func Test_PoolHealthEjectAndRecover(t *testing.T) {
+	h := newPoolHealth()
+	if h.RecordFailure(fmt.Errorf("e1"), 2) {
+		t.Error("pool should not be ejected before reaching threshold")
+	}
+	if !h.RecordFailure(fmt.Errorf("e2"), 2) {
+		t.Error("pool should be ejected when reaching threshold")
+	}
+	if h.IsHealthy() {
+		t.Error("ejected pool should be unhealthy")
+	}
+	if h.RecordFailure(fmt.Errorf("e3"), 2) {
+		t.Error("an ejected pool should not be ejected twice")
+	}
+	if !h.RecordSuccess() {
+		t.Error("pool should recover after a success")
+	}
+	if !h.IsHealthy() {
+		t.Error("recovered pool should be healthy")
+	}
+}
+
+func Test_DeadUpstreamIsModelFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	llm := model.NewOpenAIModel(&config.ModelConfig{
+		ModelName:      "test",
+		CompletionsUrl: "http://" + addr + "/v1/completions",
+		MaxOutput:      16,
+		Timeout:        time.Second,
+	}, nil)
+	_, _, status, err := llm.Completions(context.Background(), &model.CompletionParameter{Prefix: "x", MaxTokens: 16})
+	if err == nil {
+		t.Fatal("completion against a closed port should fail")
+	}
+	if !isModelFailure(status) {
+		t.Errorf("status %s of a refused connection should count as a model failure", status)
+	}
+}
+
+func Test_FindIdlestPoolWeightAndHealth(t *testing.T) {
+	m := NewPoolManager()
+	light := newTestPool("light", 4, 1)
+	heavy := newTestPool("heavy", 4, 3)
+	pools := []*ModelPool{light, heavy}
+
+	if p := m.findIdlestPool(pools, nil); p != heavy {
+		t.Errorf("expected pool with larger weight, got %s", p.cfg.ModelTitle)
+	}
+
+	heavy.health.RecordFailure(fmt.Errorf("down"), 1)
+	if p := m.findIdlestPool(pools, nil); p != light {
+		t.Errorf("expected healthy pool, got %s", p.cfg.ModelTitle)
+	}
+
+	if p := m.findIdlestPool(pools, map[*ModelPool]bool{light: true}); p != heavy {
+		t.Error("expected unhealthy pool to be used when it is the only candidate left")
+	}
+
+	light.health.RecordFailure(fmt.Errorf("down"), 1)
+	if p := m.findIdlestPool(pools, nil); p != heavy {
+		t.Error("expected fallback to all pools when every pool is unhealthy")
+	}
+}
+
+func Test_PoolHealthProbeInFlight(t *testing.T) {
+	h := newPoolHealth()
+	if !h.beginProbe(time.Hour) {
+		t.Fatal("first probe should start")
+	}
+	if h.beginProbe(0) {
+		t.Error("a probe should not start while another one is running")
+	}
+	h.endProbe()
+	if h.beginProbe(time.Hour) {
+		t.Error("a healthy pool should not be probed before the interval")
+	}
+	h.RecordFailure(fmt.Errorf("down"), 1)
+	if !h.beginProbe(time.Hour) {
+		t.Error("an ejected pool should be probed at once")
+	}
+}
+
+func Test_AdaptRequestPerPool(t *testing.T) {
+	m := NewPoolManager()
+	a := newTestPool("a", 1, 1)
+	a.llm = &fakeLLM{cfg: &config.ModelConfig{ModelName: "a", MaxOutput: 10}}
+	b := newTestPool("b", 1, 1)
+	b.cfg.ModelName = "b"
+	b.llm = &fakeLLM{cfg: &config.ModelConfig{ModelName: "b", MaxOutput: 20}}
+
+	adapts := 0
+	req := &ClientRequest{
+		Para: &model.CompletionParameter{Rerank: true},
+		Perf: &completions.CompletionPerformance{},
+		Adapt: func(llm model.LLM, perf *completions.CompletionPerformance) *model.CompletionParameter {
+			adapts++
+			return &model.CompletionParameter{MaxTokens: llm.Config().MaxOutput}
+		},
+	}
+	req.adapted = a.llm
+	m.adaptRequest(a, req)
+	if adapts != 0 || req.Para.Model != "test" {
+		t.Errorf("request adapted for the pool should be kept, adapts=%d model=%s", adapts, req.Para.Model)
+	}
+	m.adaptRequest(b, req)
+	if adapts != 1 || req.Para.MaxTokens != 20 || req.Para.Model != "b" || !req.Para.Rerank {
+		t.Errorf("request should be adapted for the failover pool, got %+v", req.Para)
+	}
+	m.adaptRequest(b, req)
+	if adapts != 1 {
+		t.Error("request should not be adapted twice for the same pool")
+	}
+}
+
+func Test_FanOutRequestAdaptsPerPool(t *testing.T) {
+	m := NewPoolManager()
+	a := newTestPool("a", 1, 1)
+	a.llm = &fakeLLM{cfg: &config.ModelConfig{ModelName: "a", MaxOutput: 10}}
+	b := newTestPool("b", 1, 1)
+	b.cfg.ModelName = "b"
+	b.llm = &fakeLLM{cfg: &config.ModelConfig{ModelName: "b", MaxOutput: 20}}
+
+	var perfs []*completions.CompletionPerformance
+	req := &ClientRequest{
+		Para: &model.CompletionParameter{MaxTokens: 10, Rerank: true},
+		Perf: &completions.CompletionPerformance{},
+		Adapt: func(llm model.LLM, perf *completions.CompletionPerformance) *model.CompletionParameter {
+			perfs = append(perfs, perf)
+			return &model.CompletionParameter{MaxTokens: llm.Config().MaxOutput}
+		},
+	}
+	req.adapted = a.llm
+	r := req.fork()
+	m.adaptRequest(a, req)
+	m.adaptRequest(b, r)
+	if req.Para.MaxTokens != 10 || r.Para.MaxTokens != 20 || r.Para.Model != "b" || !r.Para.Rerank {
+		t.Errorf("each fan-out request should be adapted for its own pool: %+v, %+v", req.Para, r.Para)
+	}
+	if len(perfs) != 1 || perfs[0] != r.Perf || r.Perf == req.Perf {
+		t.Error("fan-out request should be adapted with its own performance record")
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"code-completion/pkg/config"
 	"code-completion/pkg/metrics"
 	"code-completion/pkg/model"
+	"code-completion/pkg/trace"
 	"context"
 	"fmt"
+	"math"
+	"reflect"
 	"sync"
 	"time"
 
@@ -21,10 +24,14 @@ type ModelPool struct {
 	mutex    sync.RWMutex
 	waits    chan *ClientRequest
 	runnings map[string]*ClientRequest
+	health   *PoolHealth
+	stop     chan struct{} // 关闭后处理协程退出
+	draining bool          // 正在排空，不再接收新请求
 }
 
 // 模型请求池管理器
 type PoolManager struct {
+	mutex sync.RWMutex
 	pools map[string][]*ModelPool
 	all   []*ModelPool
 }
@@ -38,87 +45,223 @@ func NewPoolManager() *PoolManager {
 }
 
 func (m *PoolManager) Init() {
-	for i, cfg := range config.Config.Models {
-		modelName := cfg.ModelName
-		if modelName == "" {
-			modelName = "default"
-		}
-		m.initPool(modelName, model.GetModel(i), &config.Config.Models[i])
+	for _, llm := range model.GetModels() {
+		pool := newModelPool(llm, llm.Config())
+		m.all = append(m.all, pool)
+		m.startPool(pool)
 	}
+	m.rebuildIndex()
 	if len(m.all) == 0 {
 		zap.L().Error("Initialize model error, 'models' is missing",
-			zap.Int("modelCount", len(config.Config.Models)))
+			zap.Int("modelCount", len(config.Get().Models)))
 		panic("config missing 'models'")
 	}
 }
 
-// initPool 初始化模型请求池
-func (m *PoolManager) initPool(model string, llm model.LLM, cfg *config.ModelConfig) *ModelPool {
-	pool := &ModelPool{
+func newModelPool(llm model.LLM, cfg *config.ModelConfig) *ModelPool {
+	return &ModelPool{
 		cfg:      cfg,
 		llm:      llm,
 		runnings: make(map[string]*ClientRequest),
 		waits:    make(chan *ClientRequest, cfg.MaxConcurrent*2), // 缓冲区设为最大并发数的2倍
+		health:   newPoolHealth(),
+		stop:     make(chan struct{}),
 	}
-	m.all = append(m.all, pool)
+}
 
+// startPool 启动模型请求池的处理协程
+func (m *PoolManager) startPool(pool *ModelPool) {
 	// 启动MaxConcurrent个协程处理请求
-	for i := 0; i < cfg.MaxConcurrent; i++ {
+	for i := 0; i < pool.cfg.MaxConcurrent; i++ {
 		go m.LoopDoRequest(pool)
 	}
+	metrics.UpdatePoolHealthy(pool.cfg.ModelName, pool.cfg.ModelTitle, true)
+	zap.L().Info("Initialize model pool",
+		zap.String("model", pool.cfg.ModelName),
+		zap.String("title", pool.cfg.ModelTitle),
+		zap.Int("maxConcurrent", pool.cfg.MaxConcurrent),
+		zap.Int("weight", pool.cfg.Weight))
+}
+
+// rebuildIndex 根据m.all重建模型名/标签到模型池的索引，调用方需持有写锁(或处于初始化阶段)
+func (m *PoolManager) rebuildIndex() {
+	pools := make(map[string][]*ModelPool)
+	for _, pool := range m.all {
+		modelName := pool.cfg.ModelName
+		if modelName == "" {
+			modelName = "default"
+		}
+		// 将池添加到对应的模型名下
+		pools[modelName] = append(pools[modelName], pool)
+		// 为每个标签也添加相同的池
+		for _, t := range pool.cfg.Tags {
+			pools[t] = append(pools[t], pool)
+		}
+	}
+	m.pools = pools
+}
 
-	// 将池添加到对应的模型名下
-	if _, exists := m.pools[model]; !exists {
-		m.pools[model] = make([]*ModelPool, 0)
+// 获取当前所有模型池的快照
+func (m *PoolManager) snapshot() []*ModelPool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]*ModelPool(nil), m.all...)
+}
+
+/**
+ * 按新的模型配置调整模型池
+ * @param {[]config.ModelConfig} cfgs - 新的模型配置列表
+ * @returns {error} 新模型创建失败时返回错误，此时现有模型池保持不变
+ * @description
+ * - 以ModelConfig.ID()识别模型池，配置未变化的模型池原样保留(包括健康状态)
+ * - 新增或配置发生变化的模型先全部创建成功，再统一切换
+ * - 被移除或被替换的旧模型池不再接收新请求，排空已接收的请求后停止处理协程
+ */
+func (m *PoolManager) ApplyModels(cfgs []config.ModelConfig) error {
+	existing := make(map[string]*ModelPool)
+	for _, pool := range m.snapshot() {
+		existing[pool.cfg.ID()] = pool
 	}
-	m.pools[model] = append(m.pools[model], pool)
 
-	// 为每个标签也添加相同的池
-	for _, t := range cfg.Tags {
-		if _, exists := m.pools[t]; !exists {
-			m.pools[t] = make([]*ModelPool, 0)
+	next := make([]*ModelPool, 0, len(cfgs))
+	created := make([]*ModelPool, 0)
+	kept := make(map[*ModelPool]bool)
+	for i := range cfgs {
+		c := &cfgs[i]
+		if pool, ok := existing[c.ID()]; ok && reflect.DeepEqual(*pool.cfg, *c) {
+			next = append(next, pool)
+			kept[pool] = true
+			continue
+		}
+		llm, err := model.NewModel(c)
+		if err != nil {
+			return fmt.Errorf("model '%s': %w", c.ID(), err)
 		}
-		m.pools[t] = append(m.pools[t], pool)
+		pool := newModelPool(llm, c)
+		next = append(next, pool)
+		created = append(created, pool)
 	}
 
-	zap.L().Info("Initialize model pool",
-		zap.String("model", model),
-		zap.Int("maxConcurrent", cfg.MaxConcurrent))
-	return pool
+	for _, pool := range created {
+		m.startPool(pool)
+	}
+	m.mutex.Lock()
+	old := m.all
+	m.all = next
+	m.rebuildIndex()
+	m.mutex.Unlock()
+
+	llms := make([]model.LLM, 0, len(next))
+	for _, pool := range next {
+		llms = append(llms, pool.llm)
+	}
+	model.SetModels(llms)
+
+	for _, pool := range old {
+		if !kept[pool] {
+			go m.drainPool(pool)
+		}
+	}
+	return nil
+}
+
+/**
+ * 排空并停止模型池
+ * @param {*ModelPool} pool - 已经从调度索引中移除的模型池
+ * @description
+ * - 等待排队中和执行中的请求处理完，最多等待两个补全超时周期
+ * - 停止处理协程，残留在队列中的请求以busy状态返回
+ */
+func (m *PoolManager) drainPool(pool *ModelPool) {
+	pool.mutex.Lock()
+	pool.draining = true
+	pool.mutex.Unlock()
+	zap.L().Info("Draining model pool", zap.String("model", pool.cfg.ModelName),
+		zap.String("title", pool.cfg.ModelTitle))
+
+	deadline := time.Now().Add(2 * config.Get().StreamController.CompletionTimeout)
+	for time.Now().Before(deadline) {
+		pool.mutex.RLock()
+		idle := len(pool.waits) == 0 && len(pool.runnings) == 0
+		pool.mutex.RUnlock()
+		if idle {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	close(pool.stop)
+
+	for {
+		select {
+		case req := <-pool.waits:
+			if req == nil || req.Canceled {
+				continue
+			}
+			rsp := completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy,
+				fmt.Errorf("model pool removed, request rejected"))
+			select {
+			case req.rspChan <- rsp:
+			default:
+			}
+		default:
+			metrics.RemovePool(pool.cfg.ModelName, pool.cfg.ModelTitle)
+			zap.L().Info("Model pool removed", zap.String("model", pool.cfg.ModelName),
+				zap.String("title", pool.cfg.ModelTitle))
+			return
+		}
+	}
 }
 
 /**
-* Find the model pool with the lowest load rate from a list of pools
+* Find the model pool with the lowest weighted load from a list of pools
 * @param {[]*ModelPool} pools - List of model pools to search
-* @returns {ModelPool} Returns the model pool with the lowest load rate
+* @param {map[*ModelPool]bool} excludes - Pools that must not be selected (e.g. already failed), may be nil
+* @returns {ModelPool} Returns the model pool with the lowest weighted load
 * @description
-* - Iterates through the provided pools to find the one with the lowest load rate
-* - Load rate is calculated as: active_requests / max_concurrent
-* - If multiple pools have the same load rate, returns the first one found
-* - If the list is empty, returns nil
+* - Ejected (unhealthy) pools are skipped; if every candidate is unhealthy, they are all
+*   considered again so that a misjudged health state can't take the whole tag down
+* - Weighted load is calculated as: (active_requests + 1) / (max_concurrent * weight)
+* - Pools that are fully loaded are skipped
+* - If multiple pools have the same weighted load, returns the first one found
+* - If no pool is available, returns nil
 * @example
-* pool := manager.findLowestLoadPool(pools)
+* pool := manager.findIdlestPool(pools, nil)
  */
-func (m *PoolManager) findIdlestPool(pools []*ModelPool) *ModelPool {
+func (m *PoolManager) findIdlestPool(pools []*ModelPool, excludes map[*ModelPool]bool) *ModelPool {
 	if len(pools) == 0 {
 		return nil
 	}
+	candidates := make([]*ModelPool, 0, len(pools))
+	for _, pool := range pools {
+		if !excludes[pool] && pool.health.IsHealthy() {
+			candidates = append(candidates, pool)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, pool := range pools {
+			if !excludes[pool] {
+				candidates = append(candidates, pool)
+			}
+		}
+	}
 
-	lowestLoadRate := float64(1.0)
+	lowestLoad := math.MaxFloat64
 	var selectedPool *ModelPool
-	for _, pool := range pools {
+	for _, pool := range candidates {
 		pool.mutex.RLock()
 		activeRequests := len(pool.runnings)
 		maxConcurrent := pool.cfg.MaxConcurrent
+		weight := max(pool.cfg.Weight, 1)
+		draining := pool.draining
 		pool.mutex.RUnlock()
 
-		if maxConcurrent <= 0 || activeRequests >= maxConcurrent {
+		if draining || maxConcurrent <= 0 || activeRequests >= maxConcurrent {
 			continue
 		}
-		// Calculate load rate (0.0 to 1.0, where 0.0 is idle and 1.0 is fully loaded)
-		loadRate := float64(activeRequests) / float64(maxConcurrent)
-		if loadRate < lowestLoadRate {
-			lowestLoadRate = loadRate
+		// 计入即将分配的请求，使空闲时权重同样生效
+		load := float64(activeRequests+1) / float64(maxConcurrent*weight)
+		if load < lowestLoad {
+			lowestLoad = load
 			selectedPool = pool
 		}
 	}
@@ -126,24 +269,137 @@ func (m *PoolManager) findIdlestPool(pools []*ModelPool) *ModelPool {
 }
 
 func (m *PoolManager) SelectIdlestPool(modelName string) *ModelPool {
-	var pool *ModelPool
+	return m.selectPool(modelName, nil)
+}
+
+// selectPool 按模型名/标签选择负载最低的健康模型池，excludes中的模型池不参与选择
+func (m *PoolManager) selectPool(modelName string, excludes map[*ModelPool]bool) *ModelPool {
+	m.mutex.RLock()
 	pools, exists := m.pools[modelName]
 	if !exists || len(pools) == 0 {
-		pool = m.findIdlestPool(m.all)
-	} else {
-		pool = m.findIdlestPool(pools)
+		pools = m.all
 	}
-	return pool
+	m.mutex.RUnlock()
+	return m.findIdlestPool(pools, excludes)
 }
 
-// 等待模型池空闲处理请求
+/**
+ * 等待模型池空闲处理请求
+ * @param {*ClientRequest} req - 客户端请求
+ * @returns {*completions.CompletionResponse} 补全结果
+ * @description
+ * - 按请求指定的模型名/标签选择模型池
+ * - 如果模型出错(modelError/timeout)且请求仍在超时预算内，切换到同标签的其它模型池重试
+ * - 最多切换StreamController.MaxFailover次
+ */
 func (m *PoolManager) WaitDoRequest(req *ClientRequest) *completions.CompletionResponse {
-	pool := m.SelectIdlestPool(req.Para.Model)
-	if pool == nil {
-		req.Canceled = true
-		return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy, fmt.Errorf("model pool busy, request rejected"))
+	selector := req.Selector
+	if selector == "" {
+		selector = req.Para.Model
+	}
+	tried := make(map[*ModelPool]bool)
+	var rsp *completions.CompletionResponse
+	var last *ModelPool
+	for attempt := 0; ; attempt++ {
+		pool := m.selectPool(selector, tried)
+		if pool == nil {
+			if rsp != nil {
+				return rsp
+			}
+			req.Canceled = true
+			return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy, fmt.Errorf("model pool busy, request rejected"))
+		}
+		if rsp != nil {
+			zap.L().Warn("Failover to another model pool",
+				zap.String("completionID", req.Para.CompletionID),
+				zap.String("selector", selector),
+				zap.String("from", last.cfg.ModelTitle),
+				zap.String("to", pool.cfg.ModelTitle),
+				zap.String("status", string(rsp.Status)))
+			metrics.IncrementPoolFailovers(selector)
+		}
+		rsp = m.waitPool(pool, req)
+		last = pool
+		if !isModelFailure(rsp.Status) || req.ctx.Err() != nil ||
+			attempt >= config.Get().StreamController.MaxFailover {
+			return rsp
+		}
+		tried[pool] = true
+	}
+}
+
+/**
+ * 把请求同时投递到多个模型池，合并各模型池的候选补全
+ * @param {*ClientRequest} req - 客户端请求
+ * @param {int} fanOut - 最多同时请求的模型池数量
+ * @returns {*completions.CompletionResponse} 合并排序后的补全结果
+ * @description
+ * - 按请求指定的模型名/标签依次选择负载最低的不同模型池
+ * - 只有一个模型池可用时退化为WaitDoRequest(支持故障切换)
+ * - 并发请求之间互为备份，不再做故障切换
+ * - 附加请求共享主请求的上下文，客户端的新请求取消主请求时一并取消
+ * - 每个附加请求按各自的模型池重新适配prompt，不沿用主请求模型池的适配结果
+ */
+func (m *PoolManager) FanOutRequest(req *ClientRequest, fanOut int) *completions.CompletionResponse {
+	selector := req.Selector
+	if selector == "" {
+		selector = req.Para.Model
+	}
+	chosen := make(map[*ModelPool]bool)
+	var pools []*ModelPool
+	for len(pools) < fanOut {
+		pool := m.selectPool(selector, chosen)
+		if pool == nil {
+			break
+		}
+		chosen[pool] = true
+		pools = append(pools, pool)
+	}
+	if len(pools) <= 1 {
+		return m.WaitDoRequest(req)
+	}
+
+	rsps := make([]*completions.CompletionResponse, len(pools))
+	var wg sync.WaitGroup
+	for i, pool := range pools {
+		r := req
+		if i > 0 {
+			r = req.fork()
+		}
+		wg.Add(1)
+		go func(i int, pool *ModelPool, r *ClientRequest) {
+			defer wg.Done()
+			rsps[i] = m.waitPool(pool, r)
+		}(i, pool, r)
+	}
+	wg.Wait()
+	return completions.MergeResponses(rsps)
+}
+
+/**
+ * 按模型池适配请求参数
+ * @param {*ModelPool} pool - 将要处理请求的模型池
+ * @param {*ClientRequest} req - 客户端请求
+ * @description
+ * - 前后缀/上下文的token预算、分词器、输出长度、FIM模板都因模型而异
+ * - 故障切换到其它模型池时，从预处理的结果重新适配，而不是沿用上一个模型池的prompt
+ */
+func (m *PoolManager) adaptRequest(pool *ModelPool, req *ClientRequest) {
+	if req.Adapt != nil && req.adapted != pool.llm {
+		para := req.Adapt(pool.llm, req.Perf)
+		para.Rerank = req.Para.Rerank
+		req.Para = para
+		req.adapted = pool.llm
 	}
 	req.Para.Model = pool.cfg.ModelName
+}
+
+// 把请求投递到指定模型池并等待结果
+func (m *PoolManager) waitPool(pool *ModelPool, req *ClientRequest) *completions.CompletionResponse {
+	m.adaptRequest(pool, req)
+	_, req.waitSpan = trace.Start(req.ctx, "queue_wait", trace.KindInternal)
+	req.waitSpan.SetAttr("model", pool.cfg.ModelTitle)
+	defer req.waitSpan.End()
 	// 尝试将请求发送到ModelPool的waits通道，如果不能立即发送则失败
 	select {
 	case pool.waits <- req: // 成功将请求发送到waits通道
@@ -152,6 +408,7 @@ func (m *PoolManager) WaitDoRequest(req *ClientRequest) *completions.CompletionR
 		case rsp := <-req.rspChan:
 			return rsp
 		case <-req.ctx.Done():
+			req.waitSpan.SetError(req.ctx.Err())
 			status := model.StatusTimeout
 			if req.ctx.Err() == context.Canceled {
 				status = model.StatusCanceled
@@ -166,6 +423,7 @@ func (m *PoolManager) WaitDoRequest(req *ClientRequest) *completions.CompletionR
 			zap.String("completionID", req.Para.CompletionID))
 		req.Perf.QueueDuration = time.Since(req.Perf.EnqueueTime).Milliseconds()
 		req.Canceled = true
+		req.waitSpan.SetAttr("busy", true)
 		return completions.CancelRequest(req.Para.CompletionID, req.Para.Model, req.Perf, model.StatusBusy,
 			fmt.Errorf("model pool busy, request rejected"))
 	}
@@ -174,8 +432,13 @@ func (m *PoolManager) WaitDoRequest(req *ClientRequest) *completions.CompletionR
 // LoopDoRequest 循环处理ModelPool的waits通道中的请求
 func (m *PoolManager) LoopDoRequest(pool *ModelPool) {
 	for {
-		// 从waits通道获取请求
-		req := <-pool.waits
+		// 从waits通道获取请求，模型池被移除后退出
+		var req *ClientRequest
+		select {
+		case req = <-pool.waits:
+		case <-pool.stop:
+			return
+		}
 		if req == nil || req.Canceled {
 			continue
 		}
@@ -193,6 +456,7 @@ func (m *PoolManager) LoopDoRequest(pool *ModelPool) {
 // 执行请求，调用补全模型
 func (m *PoolManager) doRequest(pool *ModelPool, req *ClientRequest) *completions.CompletionResponse {
 	req.Perf.QueueDuration = time.Since(req.Perf.EnqueueTime).Milliseconds()
+	req.waitSpan.End()
 
 	// 增加活跃请求计数
 	pool.mutex.Lock()
@@ -206,6 +470,10 @@ func (m *PoolManager) doRequest(pool *ModelPool, req *ClientRequest) *completion
 	handler := completions.NewCompletionHandler(pool.llm)
 	c := completions.NewCompletionContext(req.ctx, req.Perf)
 	rsp := handler.CallLLM(c, req.Para)
+	// 请求方主动取消/超时导致的失败不能算到模型头上
+	if req.ctx.Err() == nil {
+		m.recordOutcome(pool, rsp.Status, fmt.Errorf("%s", rsp.Error))
+	}
 
 	pool.mutex.Lock()
 	delete(pool.runnings, req.Para.CompletionID)
@@ -221,13 +489,17 @@ func (m *PoolManager) doRequest(pool *ModelPool, req *ClientRequest) *completion
 func (m *PoolManager) GetStats() map[string]interface{} {
 	stats := make(map[string]interface{})
 
-	stats["count"] = len(m.all)
+	all := m.snapshot()
+	stats["count"] = len(all)
 	poolDetails := make([]map[string]interface{}, 0)
-	for _, pool := range m.all {
+	for _, pool := range all {
 		pool.mutex.RLock()
 		poolInfo := map[string]interface{}{
-			"name": pool.cfg.ModelName,
-			"tags": pool.cfg.Tags,
+			"name":   pool.cfg.ModelName,
+			"title":  pool.cfg.ModelTitle,
+			"tags":   pool.cfg.Tags,
+			"weight": pool.cfg.Weight,
+			"health": pool.health.IsHealthy(),
 			"requests": map[string]interface{}{
 				"max_concurrent": pool.cfg.MaxConcurrent,
 				"running":        len(pool.runnings),
@@ -244,17 +516,22 @@ func (m *PoolManager) GetStats() map[string]interface{} {
 func (m *PoolManager) GetDetails() map[string]interface{} {
 	details := make(map[string]interface{})
 
-	details["count"] = len(m.all)
+	all := m.snapshot()
+	details["count"] = len(all)
 	poolDetails := make([]map[string]interface{}, 0)
-	for _, pool := range m.all {
+	for _, pool := range all {
 		runnings := []map[string]interface{}{}
 		pool.mutex.RLock()
 		for _, req := range pool.runnings {
 			runnings = append(runnings, req.GetSummary())
 		}
 		poolInfo := map[string]interface{}{
-			"name": pool.cfg.ModelName,
-			"tags": pool.cfg.Tags,
+			"name":     pool.cfg.ModelName,
+			"title":    pool.cfg.ModelTitle,
+			"tags":     pool.cfg.Tags,
+			"weight":   pool.cfg.Weight,
+			"health":   pool.health.GetSummary(),
+			"draining": pool.draining,
 			"requests": map[string]interface{}{
 				"max_concurrent": pool.cfg.MaxConcurrent,
 				"running":        len(pool.runnings),
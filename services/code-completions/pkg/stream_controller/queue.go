@@ -40,11 +40,17 @@ func NewQueueManager() *QueueManager {
 
 // 添加请求到等待队列
 func (m *QueueManager) AddRequest(ctx context.Context, para *model.CompletionParameter, perf *completions.CompletionPerformance) *ClientRequest {
-	reqCtx, cancel := context.WithTimeout(ctx, config.Config.StreamController.CompletionTimeout)
+	return m.addRequest(ctx, para, perf, config.Get().StreamController.CompletionTimeout)
+}
+
+// 添加请求到等待队列，使用指定的请求超时
+func (m *QueueManager) addRequest(ctx context.Context, para *model.CompletionParameter, perf *completions.CompletionPerformance, timeout time.Duration) *ClientRequest {
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
 	req := &ClientRequest{
 		Para:     para,
 		Perf:     perf,
 		Canceled: false,
+		Selector: para.Model,
 		ctx:      reqCtx,
 		cancel:   cancel,
 		rspChan:  make(chan *completions.CompletionResponse, 1),
@@ -108,7 +114,7 @@ func (m *QueueManager) Cleanup() {
 	// 清理长时间没有活动的客户端
 	currentTime := time.Now()
 	for _, client := range m.clients {
-		if currentTime.Sub(client.LatestTime) > config.Config.StreamController.CleanOlderThan {
+		if currentTime.Sub(client.LatestTime) > config.Get().StreamController.CleanOlderThan {
 			delete(m.clients, client.ClientID)
 			zap.L().Info("Removed client", zap.String("clientID", client.ClientID),
 				zap.Time("latestTime", client.LatestTime))
@@ -3,6 +3,7 @@ package stream_controller
 import (
 	"code-completion/pkg/completions"
 	"code-completion/pkg/model"
+	"code-completion/pkg/trace"
 	"context"
 	"strings"
 )
@@ -12,9 +13,32 @@ type ClientRequest struct {
 	Para     *model.CompletionParameter           // 补全请求参数
 	Perf     *completions.CompletionPerformance   // 性能统计
 	Canceled bool                                 // 请求是否被取消
+	Selector string                               // 客户端指定的模型名或标签，用于选择/切换模型池
+	Adapt    AdaptFunc                            // 按模型池重新适配请求参数，为nil时各模型池沿用Para
+	adapted  model.LLM                            // Para已经适配的模型
 	ctx      context.Context                      // 请求关联的协程上下文
 	cancel   context.CancelFunc                   // 可以取消执行请求的协程
 	rspChan  chan *completions.CompletionResponse // 响应通道
+	waitSpan *trace.Span                          // 在模型池中排队等待的span，开始执行时结束
+}
+
+// 针对模型llm适配请求参数，perf记录适配过程(上下文预算分配)
+type AdaptFunc func(llm model.LLM, perf *completions.CompletionPerformance) *model.CompletionParameter
+
+// 复制请求用于同时投递到其它模型池，复制的请求有独立的参数和性能统计，共享上下文
+func (r *ClientRequest) fork() *ClientRequest {
+	para := *r.Para
+	perf := *r.Perf
+	return &ClientRequest{
+		Para:     &para,
+		Perf:     &perf,
+		Selector: r.Selector,
+		Adapt:    r.Adapt,
+		adapted:  r.adapted,
+		ctx:      r.ctx,
+		cancel:   r.cancel,
+		rspChan:  make(chan *completions.CompletionResponse, 1),
+	}
 }
 
 func (r *ClientRequest) GetDetails() map[string]interface{} {
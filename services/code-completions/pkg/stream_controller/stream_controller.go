@@ -4,8 +4,12 @@ import (
 	"code-completion/pkg/completions"
 	"code-completion/pkg/config"
 	"code-completion/pkg/model"
+	"code-completion/pkg/nextedit"
+	"code-completion/pkg/replay"
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -16,10 +20,15 @@ var Controller *StreamController
 
 // 流控管理器,对补全模型的访问做流控，防止补全模型失去响应
 type StreamController struct {
-	queues *QueueManager //请求等待队列管理（在等待调度到模型请求池）
-	pools  *PoolManager  //模型请求池管理（正在调用模型的请求）
+	queues      *QueueManager //请求等待队列管理（在等待调度到模型请求池）
+	pools       *PoolManager  //模型请求池管理（正在调用模型的请求）
+	applyMutex  sync.Mutex    //串行化配置变更
+	nextEditRun atomic.Int32  //正在执行的下一处编辑预测请求数
 }
 
+// 下一处编辑预测请求在等待队列中使用的客户端标识前缀，与补全请求分开取消
+const nextEditClientPrefix = "next-edit:"
+
 func NewStreamController() *StreamController {
 	return &StreamController{
 		queues: NewQueueManager(),
@@ -29,11 +38,12 @@ func NewStreamController() *StreamController {
 
 func (sc *StreamController) Init() {
 	sc.pools.Init()
+	sc.pools.StartHealthCheckRoutine()
 
 	var maintainInterval time.Duration
 	maintainInterval = time.Duration(300) * time.Second // 默认清理间隔（秒）
-	if config.Config.StreamController.MaintainInterval > 0 {
-		maintainInterval = config.Config.StreamController.MaintainInterval
+	if interval := config.Get().StreamController.MaintainInterval; interval > 0 {
+		maintainInterval = interval
 	}
 	sc.StartMaintainRoutine(maintainInterval)
 
@@ -41,18 +51,48 @@ func (sc *StreamController) Init() {
 		zap.Duration("maintainInterval", maintainInterval))
 }
 
+/**
+ * ApplyConfig makes a new configuration effective without restarting the service
+ * @param {*config.SoftwareConfig} c - New configuration, usually from config.Load or the admin API
+ * @returns {error} Returns error if the configuration is invalid or a new model can't be created
+ * @description
+ * - Validates the configuration first, invalid configurations are rejected as a whole
+ * - Adjusts model pools: unchanged pools are kept, new/changed pools are created, removed pools are drained
+ * - Replaces the global configuration only after the model pools were adjusted successfully
+ * - Concurrent calls are serialized
+ */
+func (sc *StreamController) ApplyConfig(c *config.SoftwareConfig) error {
+	sc.applyMutex.Lock()
+	defer sc.applyMutex.Unlock()
+
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if err := sc.pools.ApplyModels(c.Models); err != nil {
+		return err
+	}
+	config.Apply(c)
+	zap.L().Info("Configuration applied", zap.Int("modelCount", len(c.Models)))
+	return nil
+}
+
 /**
  * 处理V1接口版本的补全请求
  */
 func (sc *StreamController) ProcessCompletionV1(ctx context.Context, input *completions.CompletionInput) *completions.CompletionResponse {
 	var perf completions.CompletionPerformance
 	perf.ReceiveTime = time.Now().Local()
+	// 隐藏分的时间特征以接收请求的时间计算，采集的记录也以此为时间，回放时可以重现
+	if input.Now.IsZero() {
+		input.Now = perf.ReceiveTime
+	}
 	// 如果无法获取到clientID和completionID，拒掉
 	if input.ClientID == "" || input.CompletionID == "" {
 		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusRejected, fmt.Errorf("missing client id or completion id"))
 	}
 	//	预选模型池
-	pool := sc.pools.SelectIdlestPool(input.Model)
+	selector := input.Model
+	pool := sc.pools.SelectIdlestPool(selector)
 	if pool == nil {
 		return completions.CancelRequest(input.CompletionID, input.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request"))
 	}
@@ -62,18 +102,73 @@ func (sc *StreamController) ProcessCompletionV1(ctx context.Context, input *comp
 	c := completions.NewCompletionContext(ctx, &perf)
 	rsp := input.Preprocess(c)
 	if rsp != nil {
+		replay.Capture(input, rsp)
 		return rsp
 	}
-	//	请求数据针对模型进行适应性改造
-	handler := completions.NewCompletionHandler(pool.llm)
-	para := handler.Adapt(input)
+	//	请求数据针对模型进行适应性改造，切换到其它模型池时从预处理的结果重新适配
+	processed := input.Processed
+	adapt := func(llm model.LLM, perf *completions.CompletionPerformance) *model.CompletionParameter {
+		in := *input
+		in.Processed = processed
+		return completions.NewCompletionHandler(llm).Adapt(completions.NewCompletionContext(ctx, perf), &in)
+	}
+	para := adapt(pool.llm, &perf)
+	cc := &config.Get().Wrapper.Candidates
+	fanOut := cc.FanOut
+	para.Rerank = fanOut > 1
 
 	// 将请求添加到客户端队列，获取包含响应通道的ClientRequest
 	req := sc.queues.AddRequest(ctx, para, &perf)
+	req.Selector = selector
+	req.Adapt = adapt
+	req.adapted = pool.llm
 	defer func() {
 		sc.queues.RemoveRequest(req)
 	}()
-	return sc.pools.WaitDoRequest(req)
+	if fanOut > 1 {
+		rsp = sc.pools.FanOutRequest(req, fanOut)
+	} else {
+		rsp = sc.pools.WaitDoRequest(req)
+	}
+	completions.LimitChoices(rsp, min(input.N, cc.MaxChoices))
+	replay.Capture(input, rsp)
+	return rsp
+}
+
+/**
+ * 处理下一处编辑预测请求
+ * @param {context.Context} ctx - 请求上下文
+ * @param {*nextedit.Request} req - 预测请求
+ * @returns {*nextedit.Response} 返回预测的编辑列表
+ * @description
+ * - 同一客户端的新预测请求会取消尚未完成的旧请求，补全请求不受影响
+ * - 请求超时使用nextEdit.timeout，并发数超过nextEdit.maxConcurrent时返回busy
+ */
+func (sc *StreamController) ProcessNextEdit(ctx context.Context, req *nextedit.Request) *nextedit.Response {
+	var perf completions.CompletionPerformance
+	perf.ReceiveTime = time.Now().Local()
+	cfg := &config.Get().NextEdit
+	if req.ClientID == "" || req.CompletionID == "" {
+		return nextedit.ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusRejected, &perf, nil,
+			fmt.Errorf("missing client id or completion id"))
+	}
+	para := &model.CompletionParameter{
+		CompletionID: req.CompletionID,
+		ClientID:     nextEditClientPrefix + req.ClientID,
+		Language:     req.LanguageID,
+		Model:        cfg.ModelName,
+	}
+	r := sc.queues.addRequest(ctx, para, &perf, cfg.Timeout)
+	defer func() {
+		sc.queues.RemoveRequest(r)
+	}()
+	if int(sc.nextEditRun.Add(1)) > cfg.MaxConcurrent {
+		sc.nextEditRun.Add(-1)
+		return nextedit.ErrorResponse(req.CompletionID, cfg.ModelName, model.StatusBusy, &perf, nil,
+			fmt.Errorf("next edit model busy, request rejected"))
+	}
+	defer sc.nextEditRun.Add(-1)
+	return nextedit.Predict(r.ctx, req, &perf)
 }
 
 /**
@@ -117,13 +212,17 @@ func (sc *StreamController) ProcessCompletionOpenAI(ctx context.Context, r *mode
 	var perf completions.CompletionPerformance
 	perf.ReceiveTime = time.Now().Local()
 
-	pool := sc.pools.findIdlestPool(sc.pools.all)
+	pool := sc.pools.findIdlestPool(sc.pools.snapshot(), nil)
 	if pool == nil {
 		return completions.CancelRequest("", r.Model, &perf, model.StatusBusy, fmt.Errorf("model pool busy, cancel request"))
 	}
 	handler := completions.NewCompletionHandler(pool.llm)
 	c := completions.NewCompletionContext(ctx, &perf)
-	return handler.HandleCompletionOpenAI(c, r)
+	rsp := handler.HandleCompletionOpenAI(c, r)
+	if ctx.Err() == nil {
+		sc.pools.recordOutcome(pool, rsp.Status, fmt.Errorf("%s", rsp.Error))
+	}
+	return rsp
 }
 
 /**
@@ -0,0 +1,106 @@
+package trace
+
+import (
+	"code-completion/pkg/config"
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// 等待上报的span上限，超过时丢弃新的span，避免上报阻塞请求
+const queueSize = 8192
+
+// 关闭旧的TracerProvider时等待上报的最长时间
+const shutdownTimeout = 10 * time.Second
+
+// 按当前追踪配置创建的TracerProvider，配置变化后重新创建
+var (
+	providerMutex sync.Mutex
+	providerCfg   config.TracingConfig
+	provider      *sdktrace.TracerProvider
+)
+
+// 获取当前配置对应的tracer
+func tracer() oteltrace.Tracer {
+	return currentProvider().Tracer("code-completion")
+}
+
+/**
+ * 获取当前配置对应的TracerProvider
+ * @returns {*sdktrace.TracerProvider} 返回TracerProvider
+ * @description
+ * - tracing配置(上报地址、请求头、采样率、批量大小、上报间隔)变化后重新创建，热加载立即生效
+ * - 旧的TracerProvider在后台上报剩余的span后关闭
+ */
+func currentProvider() *sdktrace.TracerProvider {
+	cfg := config.Get().Tracing
+	providerMutex.Lock()
+	defer providerMutex.Unlock()
+	if provider != nil && reflect.DeepEqual(providerCfg, cfg) {
+		return provider
+	}
+	if old := provider; old != nil {
+		go shutdown(old)
+	}
+	provider = newProvider(&cfg)
+	providerCfg = cfg
+	return provider
+}
+
+/**
+ * 按追踪配置创建TracerProvider
+ * @param {*config.TracingConfig} cfg - 追踪配置
+ * @returns {*sdktrace.TracerProvider} 返回TracerProvider，endpoint为空时只传播不上报
+ * @description
+ * - 采样: 上游已经决定采样时沿用上游的决定，否则按sampleRate以链路ID采样
+ * - 上报: OTLP/HTTP批量上报，批量大小为batchSize，间隔为flushInterval
+ */
+func newProvider(cfg *config.TracingConfig) *sdktrace.TracerProvider {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	}
+	if cfg.Endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(cfg.Endpoint),
+			otlptracehttp.WithHeaders(cfg.Headers))
+		if err != nil {
+			zap.L().Warn("Create trace exporter failed", zap.String("endpoint", cfg.Endpoint), zap.Error(err))
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exporter,
+				sdktrace.WithMaxQueueSize(queueSize),
+				sdktrace.WithMaxExportBatchSize(cfg.BatchSize),
+				sdktrace.WithBatchTimeout(cfg.FlushInterval)))
+		}
+	}
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+func shutdown(p *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		zap.L().Warn("Shutdown tracer provider failed", zap.Error(err))
+	}
+}
+
+/**
+ * 上报所有等待中的span，服务退出时调用
+ */
+func Flush() {
+	providerMutex.Lock()
+	p := provider
+	provider = nil
+	providerMutex.Unlock()
+	if p != nil {
+		shutdown(p)
+	}
+}
@@ -0,0 +1,127 @@
+package trace
+
+import (
+	"code-completion/pkg/config"
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// span类型，与OTLP的SpanKind取值一致
+type Kind int
+
+const (
+	KindInternal Kind = Kind(oteltrace.SpanKindInternal)
+	KindServer   Kind = Kind(oteltrace.SpanKindServer)
+	KindClient   Kind = Kind(oteltrace.SpanKindClient)
+)
+
+// W3C trace context传播器
+var propagator = propagation.TraceContext{}
+
+// 链路中的一个span，方法对nil安全，未启用追踪时Start返回nil
+type Span struct {
+	span oteltrace.Span
+}
+
+// 请求上下文中的span
+func FromContext(ctx context.Context) *Span {
+	s := oteltrace.SpanFromContext(ctx)
+	if !s.SpanContext().IsValid() {
+		return nil
+	}
+	return &Span{span: s}
+}
+
+/**
+ * 开始一个span
+ * @param {context.Context} ctx - 父上下文，包含父span时作为子span
+ * @param {string} name - span名称
+ * @param {Kind} kind - span类型
+ * @returns {context.Context, *Span} 返回包含新span的上下文和新span
+ * @description
+ * - 未启用追踪时返回原上下文和nil
+ * - 没有父span时开始新的链路，按tracing.sampleRate采样
+ * - 子span沿用父span的采样决定
+ */
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if !config.Get().Tracing.Enabled {
+		return ctx, nil
+	}
+	ctx, s := tracer().Start(ctx, name, oteltrace.WithSpanKind(oteltrace.SpanKind(kind)))
+	return ctx, &Span{span: s}
+}
+
+/**
+ * 按请求头延续上游链路，开始服务端span
+ * @param {context.Context} ctx - 请求上下文
+ * @param {string} name - span名称
+ * @param {http.Header} header - 请求头，traceparent合法时作为父span
+ * @returns {context.Context, *Span} 返回包含新span的上下文和新span
+ */
+func StartServer(ctx context.Context, name string, header http.Header) (context.Context, *Span) {
+	if !config.Get().Tracing.Enabled {
+		return ctx, nil
+	}
+	return Start(propagator.Extract(ctx, propagation.HeaderCarrier(header)), name, KindServer)
+}
+
+/**
+ * 把上下文中的链路写入请求头，传递给下游服务
+ * @param {context.Context} ctx - 包含当前span的上下文
+ * @param {http.Header} header - 下游请求的请求头
+ */
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// 链路ID(32位十六进制)，span为nil时返回空串
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+// 设置属性，值支持string、bool、整数和浮点数
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+// 记录错误，span状态为error
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// 结束span，采样的span交给上报器，重复调用只生效一次
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"code-completion/pkg/config"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// setTracing applies a tracing configuration, the returned function restores the previous one
+func setTracing(tc config.TracingConfig) func() {
+	old := config.Get()
+	c := config.Snapshot()
+	c.Tracing = tc
+	config.Apply(c)
+	return func() { config.Apply(old) }
+}
+
+func TestPropagation(t *testing.T) {
+	defer setTracing(config.TracingConfig{Enabled: true, SampleRate: 0})()
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := StartServer(context.Background(), "root", in)
+	if root.TraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" || !root.span.SpanContext().IsSampled() {
+		t.Fatalf("remote parent not continued: %s %v", root.TraceID(), root.span.SpanContext().IsSampled())
+	}
+	ctx, child := Start(ctx, "child", KindClient)
+	out := http.Header{}
+	Inject(ctx, out)
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + child.span.SpanContext().SpanID().String() + "-01"
+	if got := out.Get("traceparent"); got != want {
+		t.Fatalf("traceparent = %q, want %q", got, want)
+	}
+
+	// 没有上游链路时按采样率决定
+	_, s := StartServer(context.Background(), "root", http.Header{})
+	if s.span.SpanContext().IsSampled() || s.TraceID() == "" {
+		t.Fatalf("expected unsampled new trace, got %+v", s.span.SpanContext())
+	}
+	for _, bad := range []string{"00-xyz-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		h := http.Header{}
+		h.Set("traceparent", bad)
+		if _, s := StartServer(context.Background(), "root", h); s.TraceID() == "4bf92f3577b34da6a3ce929d0e0e4736" || s.TraceID() == "00000000000000000000000000000000" {
+			t.Errorf("invalid traceparent %q should start a new trace", bad)
+		}
+	}
+
+	setTracing(config.TracingConfig{})
+	if _, s := Start(context.Background(), "x", KindInternal); s != nil || s.TraceID() != "" {
+		t.Fatal("expected nil span when tracing is disabled")
+	}
+}
+
+// 接收OTLP/HTTP上报的span名称
+func collector(t *testing.T) (*httptest.Server, chan string) {
+	got := make(chan string, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("decode spans: %v", err)
+			return
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					got <- s.Name
+				}
+			}
+		}
+	}))
+	return srv, got
+}
+
+func waitSpan(t *testing.T, got chan string, name string) {
+	select {
+	case n := <-got:
+		if n != name {
+			t.Fatalf("exported span %q, want %q", n, name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("span %q was not exported", name)
+	}
+}
+
+func TestExportFollowsReload(t *testing.T) {
+	a, gotA := collector(t)
+	defer a.Close()
+	b, gotB := collector(t)
+	defer b.Close()
+	defer Flush()
+
+	defer setTracing(config.TracingConfig{Enabled: true, Endpoint: a.URL + "/v1/traces", SampleRate: 1,
+		ServiceName: "test", BatchSize: 1, FlushInterval: time.Hour})()
+	_, s := Start(context.Background(), "llm.completions", KindClient)
+	s.SetAttr("model", "m1")
+	s.End()
+	waitSpan(t, gotA, "llm.completions")
+
+	// 热加载后使用新的上报地址和上报间隔
+	setTracing(config.TracingConfig{Enabled: true, Endpoint: b.URL + "/v1/traces", SampleRate: 1,
+		ServiceName: "test", BatchSize: 512, FlushInterval: 50 * time.Millisecond})
+	_, s = Start(context.Background(), "context.semantic", KindClient)
+	s.End()
+	waitSpan(t, gotB, "context.semantic")
+}
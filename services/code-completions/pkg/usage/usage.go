@@ -0,0 +1,244 @@
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 日期格式，用量按天累计
+const DateLayout = "2006-01-02"
+
+// 报表的分组维度
+const (
+	GroupDate  = "date"
+	GroupUser  = "user"
+	GroupModel = "model"
+)
+
+// 用量累计的键
+type Key struct {
+	Date  string `json:"date"`
+	User  string `json:"user"`
+	Model string `json:"model"`
+}
+
+// 累计的用量
+type Counter struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+func (c *Counter) add(o Counter) {
+	c.Requests += o.Requests
+	c.PromptTokens += o.PromptTokens
+	c.CompletionTokens += o.CompletionTokens
+}
+
+// 报表的一行，未参与分组的维度为空
+type Row struct {
+	Key
+	Counter
+	TotalTokens int64 `json:"total_tokens"`
+}
+
+// 用量报表查询条件
+type Query struct {
+	From    string   // 起始日期(包含)，为空时不限
+	To      string   // 结束日期(包含)，为空时不限
+	User    string   // 为空时不限
+	Model   string   // 为空时不限
+	GroupBy []string // 分组维度，为空时按日期、用户、模型分组
+}
+
+// 用量统计器，内存中累计，定期保存到文件
+type Recorder struct {
+	path     string
+	mutex    sync.Mutex
+	counters map[Key]*Counter
+	dirty    bool
+}
+
+// 全局用量统计器，未初始化时不统计
+var Default *Recorder
+
+/**
+ * 创建用量统计器
+ * @param {string} path - 统计数据文件，为空时只在内存中统计
+ * @returns {*Recorder, error} 返回统计器，文件存在时加载已有数据
+ */
+func NewRecorder(path string) (*Recorder, error) {
+	r := &Recorder{path: path, counters: make(map[Key]*Counter)}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("parse usage file %s: %v", path, err)
+	}
+	for _, row := range rows {
+		c := row.Counter
+		r.counters[row.Key] = &c
+	}
+	return r, nil
+}
+
+/**
+ * 记录一次请求的用量
+ * @param {string} user - 请求者
+ * @param {string} model - 模型名称
+ * @param {int} promptTokens - 提示词token数
+ * @param {int} completionTokens - 生成的token数
+ * @description
+ * - 没有模型的请求(在选择模型前被拒绝)不统计，调用方只应记录成功的请求
+ */
+func (r *Recorder) Record(user, model string, promptTokens, completionTokens int) {
+	if r == nil || model == "" {
+		return
+	}
+	k := Key{Date: time.Now().Format(DateLayout), User: user, Model: model}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	c, ok := r.counters[k]
+	if !ok {
+		c = &Counter{}
+		r.counters[k] = c
+	}
+	c.add(Counter{Requests: 1, PromptTokens: int64(promptTokens), CompletionTokens: int64(completionTokens)})
+	r.dirty = true
+}
+
+/**
+ * 查询用量报表
+ * @param {Query} q - 查询条件
+ * @returns {[]Row} 返回按分组维度汇总的用量，按日期、用户、模型排序
+ */
+func (r *Recorder) Report(q Query) []Row {
+	group := map[string]bool{GroupDate: true, GroupUser: true, GroupModel: true}
+	if len(q.GroupBy) > 0 {
+		group = make(map[string]bool)
+		for _, g := range q.GroupBy {
+			group[g] = true
+		}
+	}
+	sums := make(map[Key]*Counter)
+	r.mutex.Lock()
+	for k, c := range r.counters {
+		if (q.From != "" && k.Date < q.From) || (q.To != "" && k.Date > q.To) ||
+			(q.User != "" && k.User != q.User) || (q.Model != "" && k.Model != q.Model) {
+			continue
+		}
+		var g Key
+		if group[GroupDate] {
+			g.Date = k.Date
+		}
+		if group[GroupUser] {
+			g.User = k.User
+		}
+		if group[GroupModel] {
+			g.Model = k.Model
+		}
+		s, ok := sums[g]
+		if !ok {
+			s = &Counter{}
+			sums[g] = s
+		}
+		s.add(*c)
+	}
+	r.mutex.Unlock()
+
+	rows := make([]Row, 0, len(sums))
+	for k, c := range sums {
+		rows = append(rows, Row{Key: k, Counter: *c, TotalTokens: c.PromptTokens + c.CompletionTokens})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Key, rows[j].Key
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		return a.Model < b.Model
+	})
+	return rows
+}
+
+/**
+ * 把统计数据保存到文件
+ * @returns {error} 返回写文件的错误
+ * @description
+ * - 没有新数据时不写文件
+ * - 先写临时文件再重命名，避免进程退出时留下不完整的文件
+ */
+func (r *Recorder) Flush() error {
+	if r == nil || r.path == "" {
+		return nil
+	}
+	r.mutex.Lock()
+	if !r.dirty {
+		r.mutex.Unlock()
+		return nil
+	}
+	rows := make([]Row, 0, len(r.counters))
+	for k, c := range r.counters {
+		rows = append(rows, Row{Key: k, Counter: *c, TotalTokens: c.PromptTokens + c.CompletionTokens})
+	}
+	r.dirty = false
+	r.mutex.Unlock()
+
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".usage-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path)
+	}
+	if err != nil {
+		r.mutex.Lock()
+		r.dirty = true
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+/**
+ * 定期把统计数据保存到文件
+ * @param {time.Duration} interval - 保存间隔
+ */
+func (r *Recorder) StartFlushRoutine(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := r.Flush(); err != nil {
+				zap.L().Warn("Save usage failed", zap.String("path", r.path), zap.Error(err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package usage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record("alice", "m1", 100, 10)
+	r.Record("alice", "m1", 50, 5)
+	r.Record("alice", "m2", 20, 2)
+	r.Record("bob", "m1", 30, 3)
+	r.Record("bob", "", 30, 3)
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后继续累计
+	r, err = NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().Format(DateLayout)
+	rows := r.Report(Query{User: "alice", GroupBy: []string{GroupModel}})
+	if len(rows) != 2 || rows[0].Model != "m1" || rows[0].Requests != 2 || rows[0].TotalTokens != 165 || rows[0].User != "" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+	rows = r.Report(Query{From: today, To: today, GroupBy: []string{GroupUser}})
+	if len(rows) != 2 || rows[1].User != "bob" || rows[1].PromptTokens != 30 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+	if rows = r.Report(Query{To: "2000-01-01"}); len(rows) != 0 {
+		t.Fatalf("expected no rows, got %+v", rows)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"code-completion/pkg/completions"
+	"code-completion/pkg/feedback"
+)
+
+/**
+ * refit-scores子命令：根据反馈数据按语言重新拟合隐藏分系数
+ * @param {[]string} args - 子命令参数
+ * @returns {int} 进程退出码
+ * @description
+ * - 读取反馈文件(JSONL)，只使用带特征的事件
+ * - 按语言分组，样本数不足min-samples的语言跳过
+ * - 以hidden-scores.json(或内置默认系数)为初始值拟合
+ * - 输出hidden-scores.<language>.json，服务检测到文件变化后自动使用
+ * @example
+ * code-completion refit-scores -feedback feedback.jsonl -output .
+ */
+func refitScores(args []string) int {
+	fs := flag.NewFlagSet("refit-scores", flag.ExitOnError)
+	var (
+		feedbackFile = fs.String("feedback", "feedback.jsonl", "反馈事件文件")
+		baseFile     = fs.String("base", "hidden-scores.json", "作为初始值的隐藏分系数文件")
+		outputDir    = fs.String("output", ".", "输出目录")
+		minSamples   = fs.Int("min-samples", 200, "每种语言最少的样本数")
+		epochs       = fs.Int("epochs", 200, "梯度下降轮数")
+		lr           = fs.Float64("lr", 0.1, "学习率")
+		l2           = fs.Float64("l2", 0.01, "L2正则系数")
+	)
+	fs.Parse(args)
+
+	samples := make(map[string][]completions.ScoreSample)
+	err := feedback.ReadEvents(*feedbackFile, func(e *feedback.Event) {
+		if e.Features == nil || e.Language == "" || !e.Event.Valid() {
+			return
+		}
+		samples[e.Language] = append(samples[e.Language], completions.ScoreSample{
+			Features: *e.Features,
+			Label:    e.Event.Label(),
+		})
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read feedback failed: %v\n", err)
+		return 1
+	}
+
+	languages := make([]string, 0, len(samples))
+	for lang := range samples {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	base := completions.NewHiddenScoreFilter(*baseFile, 0)
+	opts := completions.RefitOptions{Epochs: *epochs, LearningRate: *lr, L2: *l2}
+	for _, lang := range languages {
+		if !completions.ValidLanguage(lang) {
+			fmt.Printf("%s: skipped, invalid language\n", lang)
+			continue
+		}
+		if len(samples[lang]) < *minSamples {
+			fmt.Printf("%s: skipped, %d samples\n", lang, len(samples[lang]))
+			continue
+		}
+		filter, loss := completions.RefitHiddenScoreFilter(base, samples[lang], opts)
+		data, err := json.MarshalIndent(filter, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: marshal failed: %v\n", lang, err)
+			return 1
+		}
+		path := filepath.Join(*outputDir, completions.LanguageScoreFile(lang))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: write failed: %v\n", lang, err)
+			return 1
+		}
+		fmt.Printf("%s: %d samples, loss %.4f, saved to %s\n", lang, len(samples[lang]), loss, path)
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code-completion/pkg/config"
+	"code-completion/pkg/replay"
+)
+
+/**
+ * replay子命令：用采集的语料离线回放补全流程，对比两份配置的效果
+ * @param {[]string} args - 子命令参数
+ * @returns {int} 进程退出码
+ * @description
+ * - 语料由capture.sampleRate开启的请求采集生成
+ * - backend=recorded时使用采集的模型原始输出，只评估过滤、截断、修剪的改动
+ * - backend=real时调用配置中的模型，可以评估模型、FIM模板的改动
+ * - 指定了-b时输出两份配置的对比报告
+ * @example
+ * code-completion replay -corpus capture.jsonl -a config.yaml -b config.new.yaml
+ */
+func replayCorpus(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var (
+		corpus  = fs.String("corpus", "capture.jsonl", "采集的语料文件")
+		configA = fs.String("a", config.ConfigFile, "基准配置文件")
+		configB = fs.String("b", "", "对比的配置文件(可选)")
+		backend = fs.String("backend", replay.BackendRecorded, "模型后端 (recorded/real)")
+		model   = fs.String("model", "", "使用的模型(modelTitle或modelName)，默认第一个模型")
+		limit   = fs.Int("limit", 0, "最多回放的记录数，0表示全部")
+		timeout = fs.Duration("timeout", 0, "单个请求的超时时间(real后端)")
+	)
+	fs.Parse(args)
+	if *backend != replay.BackendRecorded && *backend != replay.BackendReal {
+		fmt.Fprintf(os.Stderr, "invalid backend: %s\n", *backend)
+		return 2
+	}
+
+	records, err := replay.LoadRecords(*corpus, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load corpus failed: %v\n", err)
+		return 1
+	}
+	paths := []string{*configA}
+	if *configB != "" {
+		paths = append(paths, *configB)
+	}
+	opts := replay.Options{Backend: *backend, Model: *model, Timeout: *timeout}
+
+	var results []*replay.Result
+	for _, path := range paths {
+		cfg, err := config.Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load config %s failed: %v\n", path, err)
+			return 1
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if len(results) == 1 && results[0].Name == name {
+			name = path
+		}
+		result, err := replay.Run(name, cfg, records, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay with %s failed: %v\n", path, err)
+			return 1
+		}
+		results = append(results, result)
+	}
+	replay.PrintReport(os.Stdout, results...)
+	return 0
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"code-completion/pkg/auth"
+	"code-completion/pkg/config"
+	"code-completion/pkg/stream_controller"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 管理接口鉴权中间件，要求请求携带与admin.token一致的令牌，启用鉴权时也接受管理员的JWT或API密钥
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg := config.Get().Auth; cfg.Enabled {
+			if id, err := auth.Authenticate(&cfg, auth.Credential(c.Request.Header)); err == nil && id.Admin {
+				c.Set(auth.ContextKey, id)
+				c.Next()
+				return
+			}
+		}
+		token := config.Get().Admin.Token
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin api is disabled"})
+			return
+		}
+		got := c.GetHeader("X-Admin-Token")
+		if got == "" {
+			got = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 调试接口鉴权中间件，未启用鉴权且未配置admin.token时保持开放(兼容旧部署)，否则同adminAuth
+func debugAuth() gin.HandlerFunc {
+	admin := adminAuth()
+	return func(c *gin.Context) {
+		if cfg := config.Get(); !cfg.Auth.Enabled && cfg.Admin.Token == "" {
+			c.Next()
+			return
+		}
+		admin(c)
+	}
+}
+
+// 提交的配置中仍为掩码的敏感信息，沿用当前配置中的值
+func restoreSecrets(c *config.SoftwareConfig) {
+	current := config.Snapshot()
+	auths := make(map[string]string)
+	for _, m := range current.Models {
+		auths[m.ID()] = m.Authorization
+	}
+	for i := range c.Models {
+		if c.Models[i].Authorization == config.SecretMask {
+			c.Models[i].Authorization = auths[c.Models[i].ID()]
+		}
+	}
+	if c.Admin.Token == config.SecretMask {
+		c.Admin.Token = current.Admin.Token
+	}
+	if c.NextEdit.Authorization == config.SecretMask {
+		c.NextEdit.Authorization = current.NextEdit.Authorization
+	}
+	for k, v := range c.Tracing.Headers {
+		if v == config.SecretMask {
+			c.Tracing.Headers[k] = current.Tracing.Headers[k]
+		}
+	}
+	// API密钥按用户对应，同一用户有多个密钥时按顺序对应
+	keys := make(map[string][]string)
+	for _, k := range current.Auth.APIKeys {
+		keys[k.User] = append(keys[k.User], k.Key)
+	}
+	for i, k := range c.Auth.APIKeys {
+		if k.Key == config.SecretMask && len(keys[k.User]) > 0 {
+			c.Auth.APIKeys[i].Key = keys[k.User][0]
+			keys[k.User] = keys[k.User][1:]
+		}
+	}
+}
+
+func applyConfig(c *gin.Context, cfg *config.SoftwareConfig) {
+	if err := stream_controller.Controller.ApplyConfig(cfg); err != nil {
+		zap.L().Warn("Reject config from admin api", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    config.Redact(config.Get()),
+	})
+}
+
+// getConfigHandler 获取当前配置
+// @Summary 获取当前配置
+// @Description 获取当前生效的配置，敏感信息已脱敏
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /api/admin/config [get]
+func getConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    config.Redact(config.Get()),
+	})
+}
+
+// putConfigHandler 替换当前配置
+// @Summary 替换当前配置
+// @Description 提交完整配置(YAML或JSON)，校验通过后立即生效，值为掩码的敏感信息沿用当前值
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/admin/config [put]
+func putConfigHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cfg, err := config.Parse(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	restoreSecrets(cfg)
+	applyConfig(c, cfg)
+}
+
+// reloadConfigHandler 从配置文件重新加载配置
+// @Summary 重新加载配置文件
+// @Description 从配置文件重新加载配置，校验失败时保持当前配置
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/admin/config/reload [post]
+func reloadConfigHandler(c *gin.Context) {
+	cfg, err := config.Load(config.ConfigFile)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	applyConfig(c, cfg)
+}
+
+// listModelsHandler 获取模型列表
+// @Summary 获取模型列表
+// @Description 获取当前生效的模型配置，敏感信息已脱敏
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /api/admin/models [get]
+func listModelsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    config.Redact(config.Get()).Models,
+	})
+}
+
+// addModelHandler 添加或更新模型
+// @Summary 添加或更新模型
+// @Description 按modelTitle添加模型，已存在同名模型时替换(旧模型池排空后移除)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body config.ModelConfig true "模型配置"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/admin/models [post]
+func addModelHandler(c *gin.Context) {
+	var m config.ModelConfig
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if m.Weight <= 0 {
+		m.Weight = 1 // 与配置文件加载保持一致的默认值
+	}
+	cfg := config.Snapshot()
+	replaced := false
+	for i := range cfg.Models {
+		if cfg.Models[i].ID() == m.ID() {
+			if m.Authorization == config.SecretMask {
+				m.Authorization = cfg.Models[i].Authorization
+			}
+			cfg.Models[i] = m
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		cfg.Models = append(cfg.Models, m)
+	}
+	applyConfig(c, cfg)
+}
+
+// deleteModelHandler 移除模型
+// @Summary 移除模型
+// @Description 按模型标识移除模型，对应模型池排空已接收的请求后停止
+// @Tags admin
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "模型标识(modelTitle)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/admin/models/{id} [delete]
+func deleteModelHandler(c *gin.Context) {
+	id := c.Param("id")
+	cfg := config.Snapshot()
+	models := make([]config.ModelConfig, 0, len(cfg.Models))
+	for _, m := range cfg.Models {
+		if m.ID() != id {
+			models = append(models, m)
+		}
+	}
+	if len(models) == len(cfg.Models) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model not found"})
+		return
+	}
+	cfg.Models = models
+	applyConfig(c, cfg)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"code-completion/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_DebugAuth(t *testing.T) {
+	old := config.Get()
+	defer config.Apply(old)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/stats", debugAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		cfg    config.SoftwareConfig
+		token  string
+		status int
+	}{
+		{name: "open by default", status: http.StatusOK},
+		{name: "token required", cfg: config.SoftwareConfig{Admin: config.AdminConfig{Token: "secret"}}, status: http.StatusUnauthorized},
+		{name: "valid token", cfg: config.SoftwareConfig{Admin: config.AdminConfig{Token: "secret"}}, token: "secret", status: http.StatusOK},
+		{name: "auth enabled without token", cfg: config.SoftwareConfig{Auth: config.AuthConfig{Enabled: true}}, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			config.Apply(&cfg)
+			req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
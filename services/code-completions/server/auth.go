@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"code-completion/pkg/auth"
+	"code-completion/pkg/config"
+	"code-completion/pkg/usage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 未启用鉴权时的请求者身份，没有管理权限，管理接口需要使用admin.token
+var anonymous = &auth.Identity{User: auth.Anonymous, Method: auth.MethodNone}
+
+// 鉴权中间件，验证JWT或API密钥，把请求者身份保存到请求上下文
+func authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Get().Auth
+		if !cfg.Enabled {
+			c.Set(auth.ContextKey, anonymous)
+			c.Next()
+			return
+		}
+		id, err := auth.Authenticate(&cfg, auth.Credential(c.Request.Header))
+		if err != nil {
+			zap.L().Debug("Authentication failed", zap.String("path", c.Request.URL.Path), zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(auth.ContextKey, id)
+		c.Next()
+	}
+}
+
+func identity(c *gin.Context) *auth.Identity {
+	if v, ok := c.Get(auth.ContextKey); ok {
+		return v.(*auth.Identity)
+	}
+	return nil
+}
+
+// usageHandler 用量报表处理器
+// @Summary 查询用量报表
+// @Description 按天、用户、模型查询请求数和token用量，非管理员只能查询自己的用量
+// @Tags usage
+// @Produce json
+// @Security ApiKeyAuth
+// @Param from query string false "起始日期(包含)，格式2006-01-02"
+// @Param to query string false "结束日期(包含)，格式2006-01-02"
+// @Param user query string false "用户"
+// @Param model query string false "模型"
+// @Param group_by query string false "分组维度，逗号分隔的date/user/model，默认全部"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Router /api/usage [get]
+func usageHandler(c *gin.Context) {
+	if usage.Default == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "usage accounting is disabled"})
+		return
+	}
+	q := usage.Query{
+		From:  c.Query("from"),
+		To:    c.Query("to"),
+		User:  c.Query("user"),
+		Model: c.Query("model"),
+	}
+	for _, d := range []string{q.From, q.To} {
+		if _, err := time.Parse(usage.DateLayout, d); d != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date '" + d + "'"})
+			return
+		}
+	}
+	if g := c.Query("group_by"); g != "" {
+		for _, dim := range strings.Split(g, ",") {
+			dim = strings.TrimSpace(dim)
+			if dim != usage.GroupDate && dim != usage.GroupUser && dim != usage.GroupModel {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group_by '" + dim + "'"})
+				return
+			}
+			q.GroupBy = append(q.GroupBy, dim)
+		}
+	}
+	if id := identity(c); !id.Admin {
+		q.User = id.User
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "OK",
+		"data":    usage.Default.Report(q),
+	})
+}
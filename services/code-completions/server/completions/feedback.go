@@ -0,0 +1,133 @@
+package completions
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/config"
+	"code-completion/pkg/feedback"
+	"code-completion/pkg/metrics"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 补全反馈请求
+type FeedbackRequest struct {
+	CompletionID   string                          `json:"completion_id" binding:"required"`
+	ClientID       string                          `json:"client_id"`
+	LanguageID     string                          `json:"language_id"`
+	Model          string                          `json:"model"`
+	Event          feedback.EventType              `json:"event" binding:"required"` //accept/reject/partial_accept
+	AcceptedLength int                             `json:"accepted_length"`          //部分接受时接受的字符数
+	HideScores     *completions.HiddenScoreOptions `json:"calculate_hide_score"`     //服务端没有记录特征时，用于重新计算特征
+	Prefix         string                          `json:"prefix"`                   //与calculate_hide_score配合使用
+}
+
+var (
+	feedbackStore     *feedback.Store
+	feedbackStorePath string
+	feedbackMutex     sync.Mutex
+)
+
+// 获取反馈存储，配置的存储路径变化后重新创建
+func getFeedbackStore() *feedback.Store {
+	path := config.Get().Feedback.Path
+	feedbackMutex.Lock()
+	defer feedbackMutex.Unlock()
+	if feedbackStore == nil || feedbackStorePath != path {
+		feedbackStore = feedback.NewStore(path)
+		feedbackStorePath = path
+	}
+	return feedbackStore
+}
+
+// @Summary 补全反馈
+// @Description 客户端上报补全结果被接受、拒绝或部分接受，结合补全时记录的隐藏分特征存储，用于重新拟合隐藏分系数
+// @Tags completions
+// @Accept json
+// @Produce json
+// @Param request body FeedbackRequest true "反馈请求"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /code-completion/api/v1/feedback [post]
+func Feedback(c *gin.Context) {
+	if config.Get().Feedback.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "feedback is disabled"})
+		return
+	}
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Event.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event: " + string(req.Event)})
+		return
+	}
+	if req.LanguageID != "" && !completions.ValidLanguage(req.LanguageID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid language_id: " + req.LanguageID})
+		return
+	}
+
+	e := &feedback.Event{
+		CompletionID:   req.CompletionID,
+		ClientID:       req.ClientID,
+		Language:       req.LanguageID,
+		Model:          req.Model,
+		Event:          req.Event,
+		AcceptedLength: req.AcceptedLength,
+		Time:           time.Now(),
+	}
+	if r := completions.TakeFeatures(req.CompletionID); r != nil {
+		e.Features = &r.Features
+		if e.Language == "" {
+			e.Language = r.Language
+		}
+		if e.Model == "" {
+			e.Model = r.Model
+		}
+	} else if req.HideScores != nil {
+		f := completions.NewHiddenScoreFilter("hidden-scores.json", 0).
+			GetFeatures(req.HideScores, req.Prefix, req.LanguageID)
+		e.Features = &f
+	}
+
+	if err := getFeedbackStore().Append(e); err != nil {
+		zap.L().Error("Save feedback failed", zap.String("completionID", req.CompletionID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	model, language := feedbackLabels(e.Model, e.Language)
+	metrics.IncrementCompletionFeedback(model, language, string(e.Event), e.Event.Label() > 0)
+	c.JSON(http.StatusOK, gin.H{"message": "OK"})
+}
+
+// 反馈指标标签中未知取值统一使用的名称
+const otherLabel = "other"
+
+/**
+ * 计算反馈指标的标签
+ * @param {string} model - 客户端上报或补全时记录的模型名
+ * @param {string} language - 客户端上报或补全时记录的语言
+ * @returns {string, string} 返回model和language标签
+ * @description
+ * - 模型和语言来自客户端输入，直接作为标签会导致指标序列无限增长
+ * - 模型只保留配置中的ModelName/ModelTitle，语言只保留隐藏分系数支持的语言，其余归为other
+ */
+func feedbackLabels(model, language string) (string, string) {
+	modelLabel := otherLabel
+	for _, m := range config.Get().Models {
+		if model != "" && (model == m.ModelName || model == m.ModelTitle) {
+			modelLabel = model
+			break
+		}
+	}
+	languageLabel := otherLabel
+	if completions.KnownLanguage(language) {
+		languageLabel = language
+	}
+	return modelLabel, languageLabel
+}
@@ -0,0 +1,125 @@
+package completions
+
+import (
+	"bytes"
+	"code-completion/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func setFeedback(t *testing.T, disabled bool) string {
+	old := config.Get()
+	t.Cleanup(func() { config.Apply(old) })
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	c := config.Snapshot()
+	c.Feedback.Disabled = disabled
+	c.Feedback.Path = path
+	c.Models = []config.ModelConfig{{ModelName: "deepseek-coder", ModelTitle: "deepseek"}}
+	config.Apply(c)
+	return path
+}
+
+func postFeedback(body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/code-completion/api/v1/feedback", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	Feedback(c)
+	return w
+}
+
+func Test_Feedback(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		body     string
+		status   int
+		lines    int
+	}{
+		{"accept", false, `{"completion_id":"c1","event":"accept","model":"deepseek-coder","language_id":"go"}`, http.StatusOK, 1},
+		{"partial accept", false, `{"completion_id":"c2","event":"partial_accept","accepted_length":3}`, http.StatusOK, 1},
+		{"invalid event", false, `{"completion_id":"c3","event":"ignore"}`, http.StatusBadRequest, 0},
+		{"missing completion id", false, `{"event":"reject"}`, http.StatusBadRequest, 0},
+		{"invalid language", false, `{"completion_id":"c5","event":"accept","language_id":"../../x"}`, http.StatusBadRequest, 0},
+		{"malformed body", false, `{"completion_id":`, http.StatusBadRequest, 0},
+		{"disabled", true, `{"completion_id":"c4","event":"accept"}`, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := setFeedback(t, tt.disabled)
+			w := postFeedback(tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			data, _ := os.ReadFile(path)
+			if lines := strings.Count(string(data), "\n"); lines != tt.lines {
+				t.Errorf("stored %d events, want %d", lines, tt.lines)
+			}
+		})
+	}
+}
+
+func Test_FeedbackLabels(t *testing.T) {
+	setFeedback(t, false)
+	tests := []struct {
+		model, language string
+		wantM, wantL    string
+	}{
+		{"deepseek-coder", "go", "deepseek-coder", "go"},
+		{"deepseek", "python", "deepseek", "python"},
+		{"any-model", "go", otherLabel, "go"},
+		{"", "", otherLabel, otherLabel},
+		{"deepseek-coder", "brainfuck", "deepseek-coder", otherLabel},
+		{strings.Repeat("x", 1000), "go\n", otherLabel, otherLabel},
+	}
+	for _, tt := range tests {
+		m, l := feedbackLabels(tt.model, tt.language)
+		if m != tt.wantM || l != tt.wantL {
+			t.Errorf("feedbackLabels(%q, %q) = %q, %q, want %q, %q", tt.model, tt.language, m, l, tt.wantM, tt.wantL)
+		}
+	}
+}
+
+func Test_FeedbackAcceptanceRate(t *testing.T) {
+	setFeedback(t, false)
+	for i, event := range []string{"accept", "reject", "partial_accept", "reject"} {
+		body := `{"completion_id":"r` + string(rune('0'+i)) + `","event":"` + event + `","model":"deepseek","language_id":"rust"}`
+		if w := postFeedback(body); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if rate := acceptanceRate(t, "deepseek", "rust"); rate != 0.5 {
+		t.Errorf("acceptance rate = %v, want 0.5", rate)
+	}
+}
+
+func acceptanceRate(t *testing.T, model, language string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "completion_acceptance_rate" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["model"] == model && labels["language"] == language {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	t.Fatalf("no acceptance rate for %s/%s", model, language)
+	return 0
+}
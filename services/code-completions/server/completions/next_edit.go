@@ -0,0 +1,48 @@
+package completions
+
+import (
+	"code-completion/pkg/model"
+	"code-completion/pkg/nextedit"
+	"code-completion/pkg/stream_controller"
+	"code-completion/pkg/trace"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// @Summary 预测下一处编辑
+// @Description 根据最近的编辑历史、当前文件和光标位置，预测接下来要做的编辑，返回范围替换列表
+// @Tags completions
+// @Accept json
+// @Produce json
+// @Param request body nextedit.Request true "预测请求"
+// @Success 200 {object} nextedit.Response
+// @Failure 400 {object} nextedit.Response
+// @Failure 500 {object} nextedit.Response
+// @Router /code-completion/api/v1/next-edit [post]
+func NextEdit(c *gin.Context) {
+	var req nextedit.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": model.StatusReqError,
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	rsp := stream_controller.Controller.ProcessNextEdit(c.Request.Context(), &req)
+	if rsp.Status != model.StatusSuccess {
+		zap.L().Warn("next edit failed", zap.String("completionID", rsp.ID),
+			zap.String("clientID", req.ClientID),
+			zap.String("status", string(rsp.Status)),
+			zap.String("error", rsp.Error))
+	} else {
+		zap.L().Info("next edit succeeded", zap.String("completionID", rsp.ID),
+			zap.String("clientID", req.ClientID),
+			zap.Int("edits", len(rsp.Edits)))
+	}
+	recordUsage(c, rsp.Status, rsp.Model, &rsp.Usage)
+	rsp.TraceID = trace.FromContext(c.Request.Context()).TraceID()
+	c.JSON(httpStatus(rsp.Status), rsp)
+}
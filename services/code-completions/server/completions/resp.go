@@ -1,8 +1,11 @@
 package completions
 
 import (
+	"code-completion/pkg/auth"
 	"code-completion/pkg/completions"
 	"code-completion/pkg/model"
+	"code-completion/pkg/trace"
+	"code-completion/pkg/usage"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -20,8 +23,27 @@ func respCompletion(c *gin.Context, clientId string, rsp *completions.Completion
 			zap.String("clientID", clientId),
 			zap.Any("response", rsp))
 	}
+	recordUsage(c, rsp.Status, rsp.Model, &rsp.Usage)
+	rsp.TraceID = trace.FromContext(c.Request.Context()).TraceID()
+	c.JSON(httpStatus(rsp.Status), rsp)
+}
+
+// 按请求者和模型累计用量，只统计成功的请求
+func recordUsage(c *gin.Context, status model.CompletionStatus, modelName string, perf *completions.CompletionPerformance) {
+	if status != model.StatusSuccess {
+		return
+	}
+	user := auth.Anonymous
+	if v, ok := c.Get(auth.ContextKey); ok {
+		user = v.(*auth.Identity).User
+	}
+	usage.Default.Record(user, modelName, perf.PromptTokens, perf.CompletionTokens)
+}
+
+// 补全状态对应的HTTP状态码
+func httpStatus(status model.CompletionStatus) int {
 	statusCode := http.StatusOK
-	switch rsp.Status {
+	switch status {
 	case model.StatusSuccess:
 		statusCode = http.StatusOK
 	case model.StatusEmpty:
@@ -38,5 +60,5 @@ func respCompletion(c *gin.Context, clientId string, rsp *completions.Completion
 	default:
 		statusCode = http.StatusInternalServerError
 	}
-	c.JSON(statusCode, rsp)
+	return statusCode
 }
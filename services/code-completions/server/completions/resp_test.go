@@ -0,0 +1,30 @@
+package completions
+
+import (
+	"code-completion/pkg/completions"
+	"code-completion/pkg/model"
+	"code-completion/pkg/usage"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_RecordUsageOnlySuccess(t *testing.T) {
+	old := usage.Default
+	defer func() { usage.Default = old }()
+	usage.Default, _ = usage.NewRecorder("")
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	perf := &completions.CompletionPerformance{PromptTokens: 100, CompletionTokens: 10}
+	recordUsage(c, model.StatusSuccess, "m1", perf)
+	recordUsage(c, model.StatusTimeout, "m1", perf)
+	recordUsage(c, model.StatusRejected, "", perf)
+	recordUsage(c, model.StatusSuccess, "", perf)
+
+	rows := usage.Default.Report(usage.Query{})
+	if len(rows) != 1 || rows[0].Model != "m1" || rows[0].Requests != 1 || rows[0].TotalTokens != 110 {
+		t.Errorf("only the successful request should be recorded: %+v", rows)
+	}
+}
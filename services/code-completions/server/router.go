@@ -39,24 +39,42 @@ func SetupRouter() *gin.Engine {
 
 	// API路由组 - 统一设置JSON响应头
 	api := r.Group("/api")
+	api.Use(tracing())
 	api.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Next()
 	})
-	api.POST("/logs", logHandler)
-	api.GET("/stats", statsHandler)
-	api.GET("/details", detailsHandler)
+	// 调试和日志接口启用鉴权或配置了admin.token时只允许管理员(管理员的JWT/API密钥或admin.token)访问
+	debug := api.Group("", debugAuth())
+	debug.POST("/logs", logHandler)
+	debug.GET("/stats", statsHandler)
+	debug.GET("/details", detailsHandler)
+	api.GET("/usage", authenticate(), usageHandler)
+
+	// 管理接口：配置热加载与模型运行时管理
+	admin := api.Group("/admin")
+	admin.Use(adminAuth())
+	admin.GET("/config", getConfigHandler)
+	admin.PUT("/config", putConfigHandler)
+	admin.POST("/config/reload", reloadConfigHandler)
+	admin.GET("/models", listModelsHandler)
+	admin.POST("/models", addModelHandler)
+	admin.DELETE("/models/:id", deleteModelHandler)
 
 	// 支持OPENAI标准的补全接口，默认并不开放
-	api.POST("/completions", completions.CompletionsOpenAI)
+	api.POST("/completions", authenticate(), completions.CompletionsOpenAI)
 	// 补全接口 - 新版本路径（与客户端脚本保持一致）
 	completionRouter := r.Group("/code-completion")
+	completionRouter.Use(tracing())
 	completionRouter.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Next()
 	})
+	completionRouter.Use(authenticate())
 	completionRouter.POST("/api/v1/completions", completions.Completions)
 	completionRouter.POST("/api/v2/completions", completions.CompletionsV2)
+	completionRouter.POST("/api/v1/feedback", completions.Feedback)
+	completionRouter.POST("/api/v1/next-edit", completions.NextEdit)
 
 	return r
 }
@@ -154,6 +172,7 @@ func ginLogger() gin.HandlerFunc {
 			zap.String("method", method), zap.String("path", path),
 			zap.String("ip", clientIP), zap.Duration("latency", latency),
 			zap.Int("bodySize", bodySize),
+			zap.String("traceID", c.Writer.Header().Get(headerTraceID)),
 		)
 	}
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"code-completion/pkg/trace"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应头中的链路ID
+const headerTraceID = "X-Trace-Id"
+
+// 链路追踪中间件，按traceparent延续上游链路，为每个请求创建服务端span
+func tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := trace.StartServer(c.Request.Context(), c.Request.Method+" "+route, c.Request.Header)
+		if span == nil {
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Writer.Header().Set(headerTraceID, span.TraceID())
+		span.SetAttr("http.method", c.Request.Method)
+		span.SetAttr("http.route", route)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttr("http.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%s", http.StatusText(status)))
+		}
+		span.End()
+	}
+}
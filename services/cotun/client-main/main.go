@@ -16,6 +16,7 @@ import (
 	chclient "github.com/zgsm-ai/cotun/client"
 	chshare "github.com/zgsm-ai/cotun/share"
 	"github.com/zgsm-ai/cotun/share/cos"
+	"github.com/zgsm-ai/cotun/share/settings"
 )
 
 var commonHelp = `
@@ -104,10 +105,13 @@ var clientHelp = `
       socks
       5000:socks
       R:2222:localhost:22
+      R:auto:3000
       R:socks
       R:5000:socks
       stdio:example.com:22
       1.1.1.1:53/udp
+      5353/tcp:1.1.1.1:53/udp
+      5000/udp:example.com:5000
 
     When the cotun server has --socks5 enabled, remotes can
     specify "socks" in place of remote-host and remote-port.
@@ -123,6 +127,19 @@ var clientHelp = `
     default socks port (1080) and terminate the connection at the
     client's internal SOCKS5 proxy.
 
+    Reverse remotes specifying "auto" as their port, e.g. "R:auto:3000",
+    have their port assigned by the server while connecting, from the
+    port range of the user. The client keeps the port when reconnecting.
+    It needs --client-id and --app-name (or the appName of a profile
+    remote), and only one auto remote is allowed per client. The
+    assigned port is reported by the control API and the status file.
+
+    When the local port has a different protocol than the remote,
+    e.g. "5353/tcp:1.1.1.1:53/udp", the tcp side carries datagrams,
+    each prefixed with its length as a 2-byte big-endian integer.
+    Datagrams from each udp source address use their own tcp stream,
+    which is closed after being idle for UDP_DEADLINE (default 15s).
+
     When stdio is used as local-host, the tunnel will connect standard
     input/output of this program with the remote. This is useful when
     combined with ssh ProxyCommand. You can use
@@ -165,6 +182,16 @@ var clientHelp = `
     --header, Set a custom header in the form "HeaderName: HeaderContent".
     Can be used multiple times. (e.g --header "Foo: Bar" --header "Hello: World")
 
+    --transport, How the connection to the server is carried:
+      ws, a websocket (default)
+      h2, a full-duplex HTTP/2 stream (h2c with http:// servers)
+      tls, a raw TLS connection (https:// servers only)
+      poll, HTTP long-poll, for proxies which break or buffer streams
+    A comma separated list is tried in order, and "auto" asks the server
+    for its transports and tries them in turn. After a failure, the next
+    connection starts with the next transport. The tls transport only
+    uses SOCKS or HTTP CONNECT proxies.
+
     --hostname, Optionally set the 'Host' header (defaults to the host
     found in the server url).
 
@@ -193,6 +220,43 @@ var clientHelp = `
     --client-id, Client ID, will be added to HTTP request headers as X-Client-Id.
     --app-name, Application name, will be added to HTTP request headers as X-App-Name.
     --user-id, User ID, will be added to HTTP request headers as X-User-Id.
+
+    --profile, Path of a client profile (YAML, or JSON with a .json
+    extension) holding the server, auth, headers and named remotes:
+
+      server: https://tunnel.example.com/ws
+      auth: user:pass
+      headers:
+        X-App-Name: my-ide
+      remotes:
+        - name: web
+          remote: R:8080:localhost:3000
+          appName: web-preview
+
+    Command line flags take precedence over the profile. The profile
+    is watched and its remotes are applied while running: forward
+    remotes are bound and unbound over the existing connection, changes
+    to reverse remotes are sent to the server over it. The client only
+    reconnects when an auto reverse remote is replaced, or when the
+    server is too old to change the remotes of a connection.
+
+    --control, Address of the local control API, either a loopback
+    address (e.g. 127.0.0.1:7001) or "unix:<path>" for a unix socket.
+    It serves GET /status, GET /remotes, GET /remotes/<name>, POST
+    /remotes (body {"name","remote","appName"}) and DELETE
+    /remotes/<name>, and makes the remotes of the client changeable at
+    runtime. Requests must carry "Authorization: Bearer <token>" with
+    the token of --control-token, and POST bodies must be JSON; requests
+    with a non-local Host or Origin (web pages) are refused.
+
+    --control-token, Token of the local control API, required with
+    --control. It can also be set with the CONTROL_TOKEN environment
+    variable.
+
+    --status-file, Path of a JSON file kept up to date with the status
+    served by GET /status, including the mappingPort of each reverse
+    remote. When the client has an auto reverse remote, it defaults to
+    ~/.costrict/share/cotun-<app-name>.json.
 ` + commonHelp
 
 type AuthConfig struct {
@@ -217,6 +281,20 @@ func GetCostrictDir() string {
 	return filepath.Join(homeDir, ".costrict")
 }
 
+// hasAutoRemote 是否有由服务端分配端口的反向远端(R:auto:...)
+func hasAutoRemote(config *chclient.Config) bool {
+	remotes := config.Remotes
+	for _, nr := range config.NamedRemotes {
+		remotes = append(remotes, nr.Remote)
+	}
+	for _, s := range remotes {
+		if r, err := settings.DecodeRemote(s); err == nil && r.Auto() {
+			return true
+		}
+	}
+	return false
+}
+
 func loadAuth() (AuthConfig, error) {
 	var cfg AuthConfig
 	authPath := filepath.Join(CostrictDir, "share", "auth.json")
@@ -286,6 +364,7 @@ func main() {
 	flag.StringVar(&config.TLS.Key, "tls-key", "", "")
 	flag.Var(&headerFlags{config.Headers}, "header", "")
 	flag.StringVar(&config.Server, "server", "", "")
+	flag.StringVar(&config.Transport, "transport", "", "")
 
 	hostname := flag.String("hostname", "", "")
 	sni := flag.String("sni", "", "")
@@ -294,6 +373,10 @@ func main() {
 	clientId := flag.String("client-id", "", "client machine ID")
 	appName := flag.String("app-name", "", "client application name")
 	userId := flag.String("user-id", "", "client user ID")
+	profilePath := flag.String("profile", "", "client profile")
+	control := flag.String("control", "", "local control API address")
+	controlToken := flag.String("control-token", "", "local control API token")
+	flag.StringVar(&config.StatusFile, "status-file", "", "")
 	flag.Usage = func() {
 		fmt.Print(clientHelp)
 		os.Exit(0)
@@ -325,10 +408,21 @@ func main() {
 	if len(args) > 0 {
 		config.Remotes = append(config.Remotes, args...)
 	}
+	if *profilePath != "" {
+		profile, err := chclient.LoadProfile(*profilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		profile.Apply(&config)
+		config.NamedRemotes = profile.Remotes
+	}
+	if *control != "" {
+		config.Managed = true
+	}
 	if config.Server == "" {
 		log.Fatalf("A server is required")
 	}
-	if len(config.Remotes) == 0 {
+	if len(config.Remotes) == 0 && !config.Managed {
 		log.Fatalf("Remotes is required")
 	}
 	//default auth
@@ -355,6 +449,13 @@ func main() {
 	if *userId != "" {
 		config.Headers.Set("X-User-Id", *userId)
 	}
+	if config.StatusFile == "" && hasAutoRemote(&config) {
+		name := config.Headers.Get("X-App-Name")
+		if name == "" {
+			name = "client"
+		}
+		config.StatusFile = filepath.Join(CostrictDir, "share", "cotun-"+name+".json")
+	}
 
 	//ready
 	c, err := chclient.NewClient(&config)
@@ -370,6 +471,19 @@ func main() {
 	if err := c.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
+	if *profilePath != "" {
+		if err := c.WatchProfile(*profilePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *control != "" {
+		if *controlToken == "" {
+			*controlToken = os.Getenv("CONTROL_TOKEN")
+		}
+		if err := c.ServeControl(ctx, *control, *controlToken); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if err := c.Wait(); err != nil {
 		log.Fatal(err)
 	}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -39,10 +40,19 @@ type Config struct {
 	Server           string
 	Proxy            string
 	Remotes          []string
+	NamedRemotes     []NamedRemote
 	Headers          http.Header
 	TLS              TLSConfig
 	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
 	Verbose          bool
+	//Transport is ws (default), h2, tls, poll, a comma separated
+	//list of them tried in order, or auto to negotiate with the server
+	Transport string
+	//Managed clients may change their remotes at runtime, see SetRemotes
+	Managed bool
+	//StatusFile is written with the status of the client, including
+	//the ports assigned to its auto reverse remotes
+	StatusFile string
 }
 
 // TLSConfig for a Client
@@ -66,7 +76,19 @@ type Client struct {
 	connCount cnet.ConnCount
 	stop      func()
 	eg        *errgroup.Group
+	ctx       context.Context
 	tunnel    *tunnel.Tunnel
+	//remotes by name, reverse remotes are sent to the server on connect
+	updateMut   sync.Mutex
+	remotesMut  sync.Mutex
+	remotes     map[string]*managedRemote
+	remoteOrder []string
+	sshConn     ssh.Conn
+	configured  ssh.Conn //sshConn once the server accepted its config, remotes are updated over it
+	redialCh    chan struct{}
+	//transports to try, nil to negotiate
+	transports   []string
+	transportIdx int
 }
 
 // NewClient creates a new client instance
@@ -103,6 +125,8 @@ func NewClient(c *Config) (*Client, error) {
 		},
 		server:    u.String(),
 		tlsConfig: nil,
+		remotes:   map[string]*managedRemote{},
+		redialCh:  make(chan struct{}, 1),
 	}
 	//set default log level
 	client.Logger.Info = true
@@ -140,10 +164,19 @@ func NewClient(c *Config) (*Client, error) {
 		client.tlsConfig = tc
 	}
 	//validate remotes
+	named := c.NamedRemotes
 	for _, s := range c.Remotes {
-		r, err := settings.DecodeRemote(s)
+		named = append(named, NamedRemote{Remote: s})
+	}
+	for _, nr := range named {
+		m, err := client.decodeRemote(nr)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to decode remote '%s': %s", s, err)
+			return nil, err
+		}
+		r := m.remote
+		//confirm non-reverse tunnel is available
+		if !r.Reverse && !r.Stdio && !r.CanListen() {
+			return nil, fmt.Errorf("Client cannot listen on %s", r.String())
 		}
 		if r.Socks {
 			hasSocks = true
@@ -157,11 +190,20 @@ func NewClient(c *Config) (*Client, error) {
 			}
 			hasStdio = true
 		}
-		//confirm non-reverse tunnel is available
-		if !r.Reverse && !r.Stdio && !r.CanListen() {
-			return nil, fmt.Errorf("Client cannot listen on %s", r.String())
+		//重名的远端加上序号
+		for i, name := 2, m.Name; client.remotes[m.Name] != nil; i++ {
+			m.Name = fmt.Sprintf("%s#%d", name, i)
 		}
-		client.computed.Remotes = append(client.computed.Remotes, r)
+		client.remotes[m.Name] = m
+		client.remoteOrder = append(client.remoteOrder, m.Name)
+	}
+	if err := client.checkAutoRemotes(client.remotes); err != nil {
+		return nil, err
+	}
+	client.computeRemotes()
+	client.transports, err = parseTransport(c.Transport)
+	if err != nil {
+		return nil, err
 	}
 	//outbound proxy
 	if p := c.Proxy; p != "" {
@@ -179,12 +221,13 @@ func NewClient(c *Config) (*Client, error) {
 		HostKeyCallback: client.verifyServer,
 		Timeout:         settings.EnvDuration("SSH_TIMEOUT", 30*time.Second),
 	}
-	//prepare client tunnel
+	//prepare client tunnel, managed clients may add reverse remotes later
+	outbound := hasReverse || c.Managed
 	client.tunnel = tunnel.New(tunnel.Config{
 		Logger:    client.Logger,
 		Inbound:   true, //client always accepts inbound
-		Outbound:  hasReverse,
-		Socks:     hasReverse && hasSocks,
+		Outbound:  outbound,
+		Socks:     outbound && (hasSocks || c.Managed),
 		KeepAlive: client.config.KeepAlive,
 	})
 	return client, nil
@@ -242,6 +285,7 @@ func (c *Client) Start(ctx context.Context) error {
 	c.stop = cancel
 	eg, ctx := errgroup.WithContext(ctx)
 	c.eg = eg
+	c.ctx = ctx
 	via := ""
 	if c.proxyURL != nil {
 		via = " via " + c.proxyURL.String()
@@ -251,14 +295,20 @@ func (c *Client) Start(ctx context.Context) error {
 	eg.Go(func() error {
 		return c.connectionLoop(ctx)
 	})
-	//listen sockets
-	eg.Go(func() error {
-		clientInbound := c.computed.Remotes.Reversed(false)
-		if len(clientInbound) == 0 {
-			return nil
+	//listen sockets, failures of a managed client are only reported in its status
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	for _, name := range c.remoteOrder {
+		m := c.remotes[name]
+		if m.remote.Reverse {
+			continue
 		}
-		return c.tunnel.BindRemotes(ctx, clientInbound)
-	})
+		if bind := c.bindRemote(ctx, m); c.config.Managed {
+			go bind()
+		} else {
+			eg.Go(bind)
+		}
+	}
 	return nil
 }
 
@@ -8,12 +8,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
-	chshare "github.com/zgsm-ai/cotun/share"
-	"github.com/zgsm-ai/cotun/share/cnet"
 	"github.com/zgsm-ai/cotun/share/cos"
-	"github.com/zgsm-ai/cotun/share/settings"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -22,6 +18,11 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 	b := &backoff.Backoff{Max: c.config.MaxRetryInterval}
 	for {
 		connected, err := c.connectionOnce(ctx)
+		//reconnect at once when the remotes changed
+		if c.redialRequested() {
+			b.Reset()
+			continue
+		}
 		//reset backoff after successful connections
 		if connected {
 			b.Reset()
@@ -55,6 +56,9 @@ func (c *Client) connectionLoop(ctx context.Context) error {
 		select {
 		case <-cos.AfterSignal(d):
 			continue //retry now
+		case <-c.redialCh:
+			b.Reset()
+			continue
 		case <-ctx.Done():
 			c.Infof("Cancelled")
 			return nil
@@ -75,28 +79,12 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	//prepare dialer
-	d := websocket.Dialer{
-		HandshakeTimeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second),
-		Subprotocols:     []string{chshare.ProtocolVersion},
-		TLSClientConfig:  c.tlsConfig,
-		ReadBufferSize:   settings.EnvInt("WS_BUFF_SIZE", 0),
-		WriteBufferSize:  settings.EnvInt("WS_BUFF_SIZE", 0),
-		NetDialContext:   c.config.DialContext,
-	}
-	//optional proxy
-	if p := c.proxyURL; p != nil {
-		if err := c.setProxy(p, &d); err != nil {
-			return false, err
-		}
-	}
-	wsConn, _, err := d.DialContext(ctx, c.server, c.config.Headers)
+	conn, transport, err := c.connectTransport(ctx)
 	if err != nil {
 		return false, err
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
 	// perform SSH handshake on net.Conn
-	c.Debugf("Handshaking...")
+	c.Debugf("Handshaking over %s...", transport)
 	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "", c.sshConfig)
 	if err != nil {
 		e := err.Error()
@@ -105,6 +93,8 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 			c.Debugf(e)
 		} else {
 			c.Infof(e)
+			//e.g. a proxy buffering the stream, try the next transport
+			c.transportFailed()
 		}
 		return false, err
 	}
@@ -113,22 +103,79 @@ func (c *Client) connectionOnce(ctx context.Context) (connected bool, err error)
 	// send configuration
 	c.Debugf("Sending config")
 	t0 := time.Now()
+	c.setSSH(sshConn)
+	defer c.setSSH(nil)
+	config, err := c.negotiatePorts(sshConn)
+	if err != nil {
+		c.setReverseState(RemoteError, err)
+		return false, err
+	}
 	_, configerr, err := sshConn.SendRequest(
 		"config",
 		true,
-		settings.EncodeConfig(c.computed),
+		config,
 	)
 	if err != nil {
 		c.Infof("Config verification failed")
 		return false, err
 	}
 	if len(configerr) > 0 {
-		return false, errors.New(string(configerr))
+		err = errors.New(string(configerr))
+		c.setReverseState(RemoteError, err)
+		return false, err
 	}
-	c.Infof("Connected (Latency %s)", time.Since(t0))
+	c.setReverseState(RemoteActive, nil)
+	c.setConfigured(sshConn)
+	c.Infof("Connected over %s (Latency %s)", transport, time.Since(t0))
 	//connected, handover ssh connection for tunnel to use, and block
 	err = c.tunnel.BindSSH(ctx, sshConn, reqs, chans)
+	c.setSSH(nil)
+	c.setReverseState(RemotePending, nil)
 	c.Infof("Disconnected")
 	connected = time.Since(t0) > 5*time.Second
 	return connected, err
 }
+
+func (c *Client) setSSH(conn ssh.Conn) {
+	c.remotesMut.Lock()
+	c.sshConn = conn
+	c.configured = nil
+	c.remotesMut.Unlock()
+}
+
+func (c *Client) setConfigured(conn ssh.Conn) {
+	c.remotesMut.Lock()
+	c.configured = conn
+	c.remotesMut.Unlock()
+}
+
+// Connected tells whether the client is connected to the server
+func (c *Client) Connected() bool {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	return c.sshConn != nil
+}
+
+// redial closes the current connection so the client
+// reconnects at once and sends its new config
+func (c *Client) redial() {
+	select {
+	case c.redialCh <- struct{}{}:
+	default:
+	}
+	c.remotesMut.Lock()
+	conn := c.sshConn
+	c.remotesMut.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (c *Client) redialRequested() bool {
+	select {
+	case <-c.redialCh:
+		return true
+	default:
+		return false
+	}
+}
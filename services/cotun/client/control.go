@@ -0,0 +1,186 @@
+package chclient
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ControlStatus is the state of the client returned by the control API
+type ControlStatus struct {
+	Server    string         `json:"server"`
+	Connected bool           `json:"connected"`
+	Remotes   []RemoteStatus `json:"remotes"`
+}
+
+/**
+ * ServeControl serves the local control API of the client
+ * @param {context.Context} ctx - The API is closed with the context
+ * @param {string} addr - "unix:<path>" for a unix socket, otherwise a loopback TCP address (e.g. 127.0.0.1:7000)
+ * @param {string} token - Requests must carry "Authorization: Bearer <token>"
+ * @description
+ * - GET /status: connection state and status of each remote
+ * - GET /remotes: status of each remote
+ * - GET /remotes/{name}: status of a remote, with the port assigned to an auto reverse remote
+ * - POST /remotes: add (or replace) a remote, body {"name", "remote", "appName"}
+ * - DELETE /remotes/{name}: remove a remote
+ * - Only listens on a unix socket or a loopback address
+ * - Requests from web pages are refused: the Host and Origin must be local, and bodies must be JSON
+ */
+func (c *Client) ServeControl(ctx context.Context, addr, token string) error {
+	if token == "" {
+		return errors.New("the control API needs a token")
+	}
+	var l net.Listener
+	var err error
+	path, unix := strings.CutPrefix(addr, "unix:")
+	if unix {
+		os.Remove(path) //socket left by a previous run
+		l, err = net.Listen("unix", path)
+		if err == nil {
+			os.Chmod(path, 0600)
+		}
+	} else {
+		if host, _, _ := net.SplitHostPort(addr); !isLoopback(host) {
+			return fmt.Errorf("the control API must listen on a loopback address, not '%s'", addr)
+		}
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.Infof("Control API listening on %s", addr)
+	srv := &http.Server{Handler: controlGuard(token, !unix, http.HandlerFunc(c.handleControl))}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.Infof("Control API closed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// isLoopback tells whether host is localhost or a loopback IP
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+/**
+ * controlGuard checks the requests to the control API
+ * @param {string} token - The bearer token of the API
+ * @param {bool} tcp - Whether the API listens on TCP, the Host must then be a loopback name (no DNS rebinding)
+ * @param {http.Handler} next - The API
+ */
+func controlGuard(token string, tcp bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if tcp {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host
+			}
+			if !isLoopback(host) {
+				rError(w, http.StatusForbidden, "foreign host")
+				return
+			}
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !isLoopback(u.Hostname()) {
+				rError(w, http.StatusForbidden, "foreign origin")
+				return
+			}
+		}
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			rError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if r.Method == http.MethodPost {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				rError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Client) handleControl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/status" && r.Method == http.MethodGet:
+		rJSON(w, http.StatusOK, c.status())
+	case path == "/remotes" && r.Method == http.MethodGet:
+		rJSON(w, http.StatusOK, c.RemoteStatus())
+	case strings.HasPrefix(path, "/remotes/") && r.Method == http.MethodGet:
+		name := strings.TrimPrefix(path, "/remotes/")
+		for _, rs := range c.RemoteStatus() {
+			if rs.Name == name {
+				rJSON(w, http.StatusOK, rs)
+				return
+			}
+		}
+		rError(w, http.StatusNotFound, "remote '"+name+"' not found")
+	case path == "/remotes" && r.Method == http.MethodPost:
+		var nr NamedRemote
+		if err := json.NewDecoder(r.Body).Decode(&nr); err != nil {
+			rError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if nr.Remote == "" {
+			rError(w, http.StatusBadRequest, "remote is required")
+			return
+		}
+		if err := c.AddRemote(nr); err != nil {
+			rError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rJSON(w, http.StatusOK, c.RemoteStatus())
+	case strings.HasPrefix(path, "/remotes/") && r.Method == http.MethodDelete:
+		if err := c.RemoveRemote(strings.TrimPrefix(path, "/remotes/")); err != nil {
+			rError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		rJSON(w, http.StatusOK, c.RemoteStatus())
+	default:
+		rError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (c *Client) status() ControlStatus {
+	return ControlStatus{
+		Server:    c.server,
+		Connected: c.Connected(),
+		Remotes:   c.RemoteStatus(),
+	}
+}
+
+func rJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+func rError(w http.ResponseWriter, statusCode int, err string) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err,
+	})
+}
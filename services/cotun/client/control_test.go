@@ -0,0 +1,58 @@
+package chclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestControlGuard(t *testing.T) {
+	api := controlGuard("secret", true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name    string
+		method  string
+		host    string
+		headers map[string]string
+		status  int
+	}{
+		{"ok", "GET", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"localhost", "GET", "localhost:7000", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"no token", "GET", "127.0.0.1:7000", nil, http.StatusUnauthorized},
+		{"wrong token", "GET", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer other"}, http.StatusUnauthorized},
+		{"foreign host", "GET", "evil.example.com:7000", map[string]string{"Authorization": "Bearer secret"}, http.StatusForbidden},
+		{"foreign origin", "GET", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer secret", "Origin": "https://evil.example.com"}, http.StatusForbidden},
+		{"local origin", "GET", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer secret", "Origin": "http://localhost:3000"}, http.StatusOK},
+		{"json post", "POST", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/json; charset=utf-8"}, http.StatusOK},
+		{"form post", "POST", "127.0.0.1:7000", map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/remotes", strings.NewReader("{}"))
+			r.Host = tt.host
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeControlLoopback(t *testing.T) {
+	c := &Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.ServeControl(ctx, "0.0.0.0:0", "secret"); err == nil {
+		t.Fatal("control API listening on all interfaces")
+	}
+	if err := c.ServeControl(ctx, "127.0.0.1:0", ""); err == nil {
+		t.Fatal("control API served without token")
+	}
+}
@@ -0,0 +1,173 @@
+package chclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// checkAutoRemotes validates the auto reverse remotes (R:auto:...), the
+// server assigns one port per connection, to the app of the client
+func (c *Client) checkAutoRemotes(remotes map[string]*managedRemote) error {
+	autos := 0
+	for _, m := range remotes {
+		if m.remote.Auto() {
+			autos++
+		}
+	}
+	if autos == 0 {
+		return nil
+	}
+	if autos > 1 {
+		return errors.New("Only one auto reverse remote is allowed")
+	}
+	if c.config.Headers.Get("X-Client-Id") == "" {
+		return errors.New("Auto reverse remotes need a client id (--client-id)")
+	}
+	return nil
+}
+
+// errUnsupported the server doesn't handle the request
+var errUnsupported = errors.New("request not supported by the server")
+
+/**
+ * negotiatePorts asks the server for the ports of the auto reverse remotes
+ * @param {ssh.Conn} conn - The connection, before its config is sent
+ * @returns {[]byte, error} The encoded config, with the assigned ports
+ * @description
+ * - The port assigned by the previous connection is asked for again, so reconnecting keeps the port
+ * - Auto remotes added while negotiating are left out, the client sends them once connected
+ */
+func (c *Client) negotiatePorts(conn ssh.Conn) ([]byte, error) {
+	autos := map[*managedRemote]settings.PortRequest{}
+	c.remotesMut.Lock()
+	for _, name := range c.remoteOrder {
+		if m := c.remotes[name]; m.remote.Auto() {
+			autos[m] = c.portRequest(m)
+		}
+	}
+	c.remotesMut.Unlock()
+	ports := map[*managedRemote]int{}
+	for m, req := range autos {
+		port, err := c.requestPort(conn, req)
+		if err != nil {
+			return nil, err
+		}
+		if m.port != port {
+			c.Infof("Remote %s assigned port %d", m.Name, port)
+		}
+		ports[m] = port
+	}
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	for _, name := range c.remoteOrder {
+		m := c.remotes[name]
+		if !m.remote.Auto() {
+			continue
+		}
+		//left out until assigned
+		m.port = ports[m]
+	}
+	return c.encodeConfig(c.remotes, c.remoteOrder), nil
+}
+
+// portRequest asks for the port of the auto remote m, the caller holds remotesMut
+func (c *Client) portRequest(m *managedRemote) settings.PortRequest {
+	req := settings.PortRequest{AppName: m.AppName, MappingPort: m.port}
+	if req.AppName == "" {
+		req.AppName = c.config.Headers.Get("X-App-Name")
+	}
+	req.ClientPort, _ = strconv.Atoi(m.remote.RemotePort)
+	return req
+}
+
+// requestPort sends a port request to the server and returns the assigned port
+func (c *Client) requestPort(conn ssh.Conn, req settings.PortRequest) (int, error) {
+	payload, _ := json.Marshal(req)
+	ok, reply, err := sendRequest(conn, "port", payload)
+	if err != nil {
+		return 0, err
+	}
+	if !ok && len(reply) == 0 {
+		return 0, errUnsupported
+	}
+	if !ok {
+		return 0, fmt.Errorf("Port request failed: %s", reply)
+	}
+	var pr settings.PortReply
+	if err := json.Unmarshal(reply, &pr); err != nil || pr.MappingPort == 0 {
+		return 0, errors.New("Invalid port reply")
+	}
+	return pr.MappingPort, nil
+}
+
+/**
+ * encodeConfig encodes the config sent to the server, the caller holds remotesMut
+ * @description
+ * - Auto remotes are sent with their assigned port, and left out while they have none
+ */
+func (c *Client) encodeConfig(remotes map[string]*managedRemote, order []string) []byte {
+	config := settings.Config{Version: c.computed.Version}
+	for _, name := range order {
+		m := remotes[name]
+		r := m.remote
+		if r.Auto() {
+			if m.port == 0 {
+				continue
+			}
+			assigned := *r
+			assigned.LocalPort = strconv.Itoa(m.port)
+			r = &assigned
+		}
+		config.Remotes = append(config.Remotes, r)
+	}
+	return settings.EncodeConfig(config)
+}
+
+// sendRequest sends a global request, old servers never reply to unknown requests
+func sendRequest(conn ssh.Conn, name string, payload []byte) (bool, []byte, error) {
+	type result struct {
+		ok    bool
+		reply []byte
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ok, reply, err := conn.SendRequest(name, true, payload)
+		done <- result{ok, reply, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ok, r.reply, r.err
+	case <-time.After(settings.EnvDuration("CONFIG_TIMEOUT", 10*time.Second)):
+		return false, nil, fmt.Errorf("%s request timed out", name)
+	}
+}
+
+// writeStatus saves the status of the client to its status file, if any
+func (c *Client) writeStatus() {
+	path := c.config.StatusFile
+	if path == "" {
+		return
+	}
+	b, _ := json.MarshalIndent(c.status(), "", "  ")
+	//replace the file at once, readers never see a partial status
+	tmp := path + ".tmp"
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(tmp, b, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		c.Debugf("Failed to write status file: %s", err)
+	}
+}
@@ -0,0 +1,167 @@
+package chclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/zgsm-ai/cotun/share/cio"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a declarative client configuration, it holds the
+// server to connect to and a set of named remotes
+type Profile struct {
+	Server      string            `json:"server" yaml:"server"`
+	Auth        string            `json:"auth,omitempty" yaml:"auth,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty" yaml:"fingerprint,omitempty"`
+	Proxy       string            `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	ClientID    string            `json:"clientId,omitempty" yaml:"clientId,omitempty"`
+	AppName     string            `json:"appName,omitempty" yaml:"appName,omitempty"`
+	UserID      string            `json:"userId,omitempty" yaml:"userId,omitempty"`
+	Remotes     []NamedRemote     `json:"remotes" yaml:"remotes"`
+}
+
+// NamedRemote is a remote with a name, used to add, remove
+// and report the remote while the client is running
+type NamedRemote struct {
+	Name    string `json:"name" yaml:"name"`
+	Remote  string `json:"remote" yaml:"remote"`
+	AppName string `json:"appName,omitempty" yaml:"appName,omitempty"`
+}
+
+/**
+ * LoadProfile reads a client profile
+ * @param {string} filename - Path of the profile, .json files are parsed as JSON, others as YAML
+ * @returns {*Profile, error} The profile, with the remote names filled and checked
+ */
+func LoadProfile(filename string) (*Profile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile %s: %v", filename, err)
+	}
+	p := &Profile{}
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		err = json.Unmarshal(data, p)
+	} else {
+		err = yaml.Unmarshal(data, p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %v", filename, err)
+	}
+	names := map[string]bool{}
+	for i := range p.Remotes {
+		r := &p.Remotes[i]
+		if r.Remote == "" {
+			return nil, fmt.Errorf("profile %s: remote #%d is empty", filename, i+1)
+		}
+		//未命名的远端以其定义作为名称
+		if r.Name == "" {
+			r.Name = r.Remote
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("profile %s: duplicate remote name '%s'", filename, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return p, nil
+}
+
+// Apply sets the connection settings of the profile on c, settings
+// already present in c (e.g. from command line flags) take precedence
+func (p *Profile) Apply(c *Config) {
+	if c.Server == "" {
+		c.Server = p.Server
+	}
+	if c.Auth == "" {
+		c.Auth = p.Auth
+	}
+	if c.Fingerprint == "" {
+		c.Fingerprint = p.Fingerprint
+	}
+	if c.Proxy == "" {
+		c.Proxy = p.Proxy
+	}
+	if c.Headers == nil {
+		c.Headers = http.Header{}
+	}
+	setHeader := func(k, v string) {
+		if v != "" && c.Headers.Get(k) == "" {
+			c.Headers.Set(k, v)
+		}
+	}
+	for k, v := range p.Headers {
+		setHeader(k, v)
+	}
+	setHeader("X-Client-Id", p.ClientID)
+	setHeader("X-App-Name", p.AppName)
+	setHeader("X-User-Id", p.UserID)
+	c.Managed = true
+}
+
+/**
+ * WatchProfile reloads the profile when the file changes and applies its remotes
+ * @param {string} filename - Path of the profile
+ * @description
+ * - Only the remotes are reloaded, connection settings need a restart
+ * - Remotes not in the profile anymore are removed, new or changed ones are bound
+ * - The file is watched through its directory, so editors replacing the file are supported
+ */
+func (c *Client) WatchProfile(filename string) error {
+	if c.stop == nil {
+		return errors.New("client not started")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+	l := c.Logger.Fork("profile")
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				l.Infof("Watch error: %s", err)
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != filepath.Clean(filename) ||
+					e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				c.reloadProfile(l, filename)
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Client) reloadProfile(l *cio.Logger, filename string) {
+	p, err := LoadProfile(filename)
+	if err != nil {
+		//文件可能正在写入，保持当前的远端
+		l.Infof("Failed to reload: %s", err)
+		return
+	}
+	if err := c.SetRemotes(p.Remotes); err != nil {
+		l.Infof("Failed to apply remotes: %s", err)
+		return
+	}
+	l.Debugf("Remotes reloaded from %s", filename)
+}
@@ -0,0 +1,39 @@
+package chclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	yml := filepath.Join(dir, "profile.yaml")
+	os.WriteFile(yml, []byte(`
+server: http://localhost:8080
+auth: foo:bar
+appName: ide
+remotes:
+  - name: web
+    remote: R:8081:localhost:3000
+    appName: preview
+  - remote: 3306
+`), 0600)
+	p, err := LoadProfile(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Remotes) != 2 || p.Remotes[0].AppName != "preview" || p.Remotes[1].Name != "3306" {
+		t.Fatalf("unexpected remotes %+v", p.Remotes)
+	}
+	c := &Config{Auth: "cli:pass"}
+	p.Apply(c)
+	if c.Server != "http://localhost:8080" || c.Auth != "cli:pass" || c.Headers.Get("X-App-Name") != "ide" || !c.Managed {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	js := filepath.Join(dir, "profile.json")
+	os.WriteFile(js, []byte(`{"server":"x","remotes":[{"name":"a","remote":"1"},{"name":"a","remote":"2"}]}`), 0600)
+	if _, err := LoadProfile(js); err == nil {
+		t.Fatal("expected duplicate name error")
+	}
+}
@@ -0,0 +1,385 @@
+package chclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// 远端状态
+const (
+	RemotePending = "pending" //反向远端，等待服务端确认
+	RemoteActive  = "active"  //正向远端正在监听，或反向远端已被服务端接受
+	RemoteError   = "error"
+	RemoteStopped = "stopped"
+)
+
+// RemoteStatus is the state of a remote of a running client, MappingPort
+// is the port the server listens on for a reverse remote
+type RemoteStatus struct {
+	Name        string    `json:"name"`
+	Remote      string    `json:"remote"`
+	AppName     string    `json:"appName,omitempty"`
+	Reverse     bool      `json:"reverse"`
+	MappingPort int       `json:"mappingPort,omitempty"`
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// managedRemote is a remote which can be added and removed at runtime
+type managedRemote struct {
+	NamedRemote
+	remote *settings.Remote
+	state  string
+	err    string
+	since  time.Time
+	//auto reverse remotes only, the port assigned by the server
+	port int
+	//forward remotes only, set while bound
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (m *managedRemote) setState(state string, err error) {
+	m.state = state
+	m.err = ""
+	if err != nil {
+		m.err = err.Error()
+	}
+	m.since = time.Now()
+}
+
+func (m *managedRemote) status() RemoteStatus {
+	port := m.port
+	if m.remote.Reverse && !m.remote.Auto() {
+		port, _ = strconv.Atoi(m.remote.LocalPort)
+	}
+	return RemoteStatus{
+		Name:        m.Name,
+		Remote:      m.remote.String(),
+		AppName:     m.AppName,
+		Reverse:     m.remote.Reverse,
+		MappingPort: port,
+		State:       m.state,
+		Error:       m.err,
+		Since:       m.since,
+	}
+}
+
+// decodeRemote decodes a remote of this client
+func (c *Client) decodeRemote(nr NamedRemote) (*managedRemote, error) {
+	if nr.Name == "" {
+		nr.Name = nr.Remote
+	}
+	r, err := settings.DecodeRemote(nr.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode remote '%s': %s", nr.Remote, err)
+	}
+	m := &managedRemote{NamedRemote: nr, remote: r}
+	m.setState(RemotePending, nil)
+	return m, nil
+}
+
+// computeRemotes refreshes the remotes sent to the server, the caller holds remotesMut
+func (c *Client) computeRemotes() {
+	c.computed.Remotes = nil
+	for _, name := range c.remoteOrder {
+		c.computed.Remotes = append(c.computed.Remotes, c.remotes[name].remote)
+	}
+}
+
+// bindRemote prepares a forward remote and returns the function listening
+// on it until it's removed or fails, the caller holds remotesMut
+func (c *Client) bindRemote(ctx context.Context, m *managedRemote) func() error {
+	ctx, cancel := context.WithCancel(ctx)
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.setState(RemoteActive, nil)
+	return func() error {
+		err := c.tunnel.BindRemotes(ctx, []*settings.Remote{m.remote})
+		c.remotesMut.Lock()
+		if ctx.Err() != nil && err == nil {
+			m.setState(RemoteStopped, nil)
+		} else {
+			if err == nil {
+				err = errors.New("closed")
+			}
+			m.setState(RemoteError, err)
+		}
+		cancel()
+		close(m.done)
+		c.remotesMut.Unlock()
+		return err
+	}
+}
+
+// unbindRemote stops a forward remote and waits for its listener to close
+func (c *Client) unbindRemote(m *managedRemote) {
+	c.remotesMut.Lock()
+	cancel, done := m.cancel, m.done
+	c.remotesMut.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+/**
+ * AddRemote adds a remote to the running client
+ * @param {NamedRemote} nr - The remote, its name defaults to the remote definition
+ * @description
+ * - Forward remotes start listening over the current SSH connection
+ * - Reverse remotes are sent to the server over the current SSH connection, see SetRemotes
+ * - Adding a remote with the name of an existing one replaces it
+ */
+func (c *Client) AddRemote(nr NamedRemote) error {
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	return c.setRemotes(append(c.namedRemotes(), nr))
+}
+
+// RemoveRemote removes the remote named name from the running client
+func (c *Client) RemoveRemote(name string) error {
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	list := c.namedRemotes()
+	for i, nr := range list {
+		if nr.Name == name {
+			return c.setRemotes(append(list[:i], list[i+1:]...))
+		}
+	}
+	return fmt.Errorf("remote '%s' not found", name)
+}
+
+func (c *Client) namedRemotes() []NamedRemote {
+	c.remotesMut.Lock()
+	defer c.remotesMut.Unlock()
+	list := make([]NamedRemote, 0, len(c.remoteOrder))
+	for _, name := range c.remoteOrder {
+		list = append(list, c.remotes[name].NamedRemote)
+	}
+	return list
+}
+
+/**
+ * SetRemotes replaces the remotes of the running client
+ * @param {[]NamedRemote} list - The wanted remotes, later remotes replace earlier ones with the same name
+ * @returns {error} Error when a remote is invalid or rejected by the server, then nothing is changed
+ * @description
+ * - Changes to the reverse remotes are sent to the server over the current connection
+ * - The client reconnects instead when the server can't change the remotes of a connection
+ *   (older servers), or when an auto reverse remote is replaced: a connection has one auto port
+ */
+func (c *Client) SetRemotes(list []NamedRemote) error {
+	c.updateMut.Lock()
+	defer c.updateMut.Unlock()
+	return c.setRemotes(list)
+}
+
+// setRemotes replaces the remotes, the caller holds updateMut
+func (c *Client) setRemotes(list []NamedRemote) error {
+	if c.ctx == nil {
+		return errors.New("client not started")
+	}
+	wanted := map[string]*managedRemote{}
+	order := []string{}
+	for _, nr := range list {
+		m, err := c.decodeRemote(nr)
+		if err != nil {
+			return err
+		}
+		if m.remote.Stdio {
+			return errors.New("stdio remotes can't be changed at runtime")
+		}
+		if _, ok := wanted[m.Name]; !ok {
+			order = append(order, m.Name)
+		}
+		wanted[m.Name] = m
+	}
+	if err := c.checkAutoRemotes(wanted); err != nil {
+		return err
+	}
+	//diff against the current remotes
+	c.remotesMut.Lock()
+	removed := []*managedRemote{}
+	added := []*managedRemote{}
+	reverse := false
+	for _, name := range c.remoteOrder {
+		old := c.remotes[name]
+		if m, ok := wanted[name]; ok && m.remote.String() == old.remote.String() {
+			old.AppName = m.AppName
+			wanted[name] = old
+			continue
+		}
+		if old.remote.Stdio {
+			//保留启动时的stdio远端
+			if _, ok := wanted[name]; !ok {
+				order = append(order, name)
+			}
+			wanted[name] = old
+			continue
+		}
+		removed = append(removed, old)
+		reverse = reverse || old.remote.Reverse
+	}
+	for _, name := range order {
+		if m := wanted[name]; c.remotes[name] != m {
+			added = append(added, m)
+			reverse = reverse || m.remote.Reverse
+		}
+	}
+	//confirm the new forward remotes are available, except
+	//when replacing a remote listening on the same address
+	for _, m := range added {
+		if m.remote.Reverse || replacesListener(removed, m.remote.Local()) {
+			continue
+		}
+		if !m.remote.CanListen() {
+			c.remotesMut.Unlock()
+			return fmt.Errorf("Client cannot listen on %s", m.remote.String())
+		}
+	}
+	conn := c.configured
+	c.remotesMut.Unlock()
+	//send the reverse remotes to the server first, it may reject them
+	redial := reverse
+	if reverse && conn != nil {
+		applied, err := c.updateServer(conn, wanted, order, added, removed)
+		if err != nil {
+			return err
+		}
+		redial = !applied
+	}
+	c.remotesMut.Lock()
+	if reverse && c.configured != conn {
+		//reconnected meanwhile, with the previous remotes
+		redial = true
+	}
+	if !redial {
+		for _, m := range added {
+			if m.remote.Reverse {
+				m.setState(RemoteActive, nil)
+			}
+		}
+	}
+	c.remotes = wanted
+	c.remoteOrder = order
+	c.computeRemotes()
+	binds := []func() error{}
+	for _, m := range added {
+		if !m.remote.Reverse {
+			binds = append(binds, c.bindRemote(c.ctx, m))
+		}
+	}
+	c.remotesMut.Unlock()
+	//apply
+	for _, m := range removed {
+		if !m.remote.Reverse {
+			c.unbindRemote(m)
+		}
+		c.Infof("Removed remote %s (%s)", m.Name, m.remote)
+	}
+	for _, m := range added {
+		c.Infof("Added remote %s (%s)", m.Name, m.remote)
+	}
+	for _, bind := range binds {
+		go bind()
+	}
+	c.writeStatus()
+	if redial {
+		c.Infof("Reverse remotes changed, reconnecting")
+		c.redial()
+	}
+	return nil
+}
+
+/**
+ * updateServer sends the new remotes to the server over the connection
+ * @param {ssh.Conn} conn - The current connection
+ * @returns {bool, error} Whether the server applied the remotes, or the error when it rejected them
+ * @description
+ * - An auto reverse remote added at runtime first asks the server for its port
+ * - Returns false when the remotes must be sent by reconnecting instead
+ */
+func (c *Client) updateServer(conn ssh.Conn, remotes map[string]*managedRemote, order []string, added, removed []*managedRemote) (bool, error) {
+	var auto *managedRemote
+	for _, m := range added {
+		if m.remote.Auto() {
+			auto = m
+		}
+	}
+	if auto != nil {
+		for _, m := range removed {
+			if m.remote.Auto() {
+				return false, nil
+			}
+		}
+		c.remotesMut.Lock()
+		req := c.portRequest(auto)
+		c.remotesMut.Unlock()
+		port, err := c.requestPort(conn, req)
+		if errors.Is(err, errUnsupported) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		c.remotesMut.Lock()
+		auto.port = port
+		c.remotesMut.Unlock()
+	}
+	c.remotesMut.Lock()
+	config := c.encodeConfig(remotes, order)
+	c.remotesMut.Unlock()
+	ok, reply, err := sendRequest(conn, "remotes", config)
+	if err != nil || (!ok && len(reply) == 0) {
+		c.Debugf("Server can't update remotes over the connection (%v)", err)
+		return false, nil
+	}
+	if !ok {
+		return false, errors.New(string(reply))
+	}
+	return true, nil
+}
+
+// replacesListener tells whether one of the removed forward remotes listens on addr
+func replacesListener(removed []*managedRemote, addr string) bool {
+	for _, m := range removed {
+		if !m.remote.Reverse && m.remote.Local() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteStatus reports the state of each remote
+func (c *Client) RemoteStatus() []RemoteStatus {
+	c.remotesMut.Lock()
+	list := make([]RemoteStatus, 0, len(c.remotes))
+	for _, m := range c.remotes {
+		list = append(list, m.status())
+	}
+	c.remotesMut.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// setReverseState updates the state of all reverse remotes after a handshake
+func (c *Client) setReverseState(state string, err error) {
+	c.remotesMut.Lock()
+	for _, m := range c.remotes {
+		if m.remote.Reverse && (m.state != state || err != nil) {
+			m.setState(state, err)
+		}
+	}
+	c.remotesMut.Unlock()
+	c.writeStatus()
+}
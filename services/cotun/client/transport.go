@@ -0,0 +1,349 @@
+package chclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	chshare "github.com/zgsm-ai/cotun/share"
+	"github.com/zgsm-ai/cotun/share/cnet"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
+)
+
+// TransportAuto negotiates the transport with the server, trying
+// each transport it supports until one works
+const TransportAuto = "auto"
+
+// parseTransport returns the transports to try, in order, nil means negotiate
+func parseTransport(s string) ([]string, error) {
+	switch s {
+	case "":
+		return []string{cnet.TransportWebSocket}, nil
+	case TransportAuto:
+		return nil, nil
+	}
+	list, err := cnet.ParseTransports(s)
+	if err == nil && len(list) == 0 {
+		err = errors.New("no transport")
+	}
+	return list, err
+}
+
+// httpURL is the server url with a http(s) scheme
+func (c *Client) httpURL() string {
+	return strings.Replace(c.server, "ws", "http", 1)
+}
+
+// transportHeaders are the client headers, and the headers selecting the transport
+func (c *Client) transportHeaders(transport string) http.Header {
+	h := c.config.Headers.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Set(cnet.ProtocolHeader, chshare.ProtocolVersion)
+	if transport != "" {
+		h.Set(cnet.TransportHeader, transport)
+	}
+	return h
+}
+
+func (c *Client) newRequest(ctx context.Context, method, transport string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.httpURL(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.transportHeaders(transport)
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// statusError reads the error message of a failed response
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return &cnet.StatusError{Code: resp.StatusCode, Msg: strings.TrimSpace(string(b))}
+}
+
+// dialContext opens a TCP connection to addr, through the proxy when set
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.config.DialContext != nil {
+		return c.config.DialContext(ctx, network, addr)
+	}
+	d := &net.Dialer{Timeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second)}
+	u := c.proxyURL
+	if u == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	if strings.HasPrefix(u.Scheme, "socks") {
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pass}
+		}
+		socks, err := proxy.SOCKS5("tcp", u.Host, auth, d)
+		if err != nil {
+			return nil, err
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+	//HTTP CONNECT proxy
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if u.User != nil {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.String())))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+	return &readerConn{Conn: conn, r: br}, nil
+}
+
+// readerConn is a connection whose first bytes may have been buffered
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// httpTransport is used for the plain HTTP requests of the client, they
+// go through HTTP proxies as regular requests
+func (c *Client) httpTransport() *http.Transport {
+	t := &http.Transport{
+		DialContext:     c.dialContext,
+		TLSClientConfig: c.tlsConfig,
+	}
+	if u := c.proxyURL; u != nil && !strings.HasPrefix(u.Scheme, "socks") && c.config.DialContext == nil {
+		t.Proxy = http.ProxyURL(u)
+		t.DialContext = (&net.Dialer{}).DialContext
+	}
+	return t
+}
+
+// negotiate asks the server for its transports, servers
+// without transport negotiation only support websockets
+func (c *Client) negotiate(ctx context.Context) []string {
+	ctx, cancel := context.WithTimeout(ctx, settings.EnvDuration("WS_TIMEOUT", 45*time.Second))
+	defer cancel()
+	req, err := c.newRequest(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return []string{cnet.TransportWebSocket}
+	}
+	resp, err := (&http.Client{Transport: c.httpTransport()}).Do(req)
+	if err != nil {
+		c.Debugf("Transport negotiation failed: %s", err)
+		return []string{cnet.TransportWebSocket}
+	}
+	resp.Body.Close()
+	list, err := cnet.ParseTransports(resp.Header.Get(cnet.TransportsHeader))
+	if err != nil || len(list) == 0 {
+		return []string{cnet.TransportWebSocket}
+	}
+	//the raw tls transport needs a tls server
+	supported := []string{}
+	for _, t := range list {
+		if t != cnet.TransportTLS || c.tlsConfig != nil {
+			supported = append(supported, t)
+		}
+	}
+	c.Debugf("Server transports: %s", strings.Join(supported, ","))
+	return supported
+}
+
+/**
+ * connectTransport opens a connection to the server over the first working transport
+ * @description
+ * - Starts from the transport which worked last time
+ * - Authentication failures are returned at once, other failures try the next transport
+ * - In auto mode, the server is asked for its transports first
+ */
+func (c *Client) connectTransport(ctx context.Context) (net.Conn, string, error) {
+	list := c.transports
+	if list == nil {
+		list = c.negotiate(ctx)
+	}
+	var err error
+	for i := 0; i < len(list); i++ {
+		t := list[(c.transportIdx+i)%len(list)]
+		var conn net.Conn
+		conn, err = c.dialTransport(ctx, t)
+		if err == nil {
+			c.transportIdx = (c.transportIdx + i) % len(list)
+			return conn, t, nil
+		}
+		var se *cnet.StatusError
+		if errors.As(err, &se) && (se.Code == http.StatusUnauthorized || se.Code == http.StatusForbidden) {
+			return nil, t, err
+		}
+		if len(list) > 1 {
+			c.Infof("Transport %s failed: %s", t, err)
+		}
+	}
+	return nil, "", err
+}
+
+// transportFailed makes the next connection start with the next transport
+func (c *Client) transportFailed() {
+	c.transportIdx++
+}
+
+func (c *Client) dialTransport(ctx context.Context, transport string) (net.Conn, error) {
+	switch transport {
+	case cnet.TransportHTTP2:
+		return c.dialHTTP2(ctx)
+	case cnet.TransportTLS:
+		return c.dialTLS(ctx)
+	case cnet.TransportPoll:
+		return c.dialPoll(ctx)
+	}
+	return c.dialWebSocket(ctx)
+}
+
+func (c *Client) dialWebSocket(ctx context.Context) (net.Conn, error) {
+	d := websocket.Dialer{
+		HandshakeTimeout: settings.EnvDuration("WS_TIMEOUT", 45*time.Second),
+		Subprotocols:     []string{chshare.ProtocolVersion},
+		TLSClientConfig:  c.tlsConfig,
+		ReadBufferSize:   settings.EnvInt("WS_BUFF_SIZE", 0),
+		WriteBufferSize:  settings.EnvInt("WS_BUFF_SIZE", 0),
+		NetDialContext:   c.config.DialContext,
+	}
+	//optional proxy
+	if p := c.proxyURL; p != nil {
+		if err := c.setProxy(p, &d); err != nil {
+			return nil, err
+		}
+	}
+	wsConn, resp, err := d.DialContext(ctx, c.server, c.config.Headers)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 {
+			return nil, statusError(resp)
+		}
+		return nil, err
+	}
+	return cnet.NewWebSocketConn(wsConn), nil
+}
+
+// dialHTTP2 opens a full-duplex HTTP/2 stream, the request body
+// carries the client bytes and the response body the server bytes
+func (c *Client) dialHTTP2(ctx context.Context) (net.Conn, error) {
+	t := &http2.Transport{
+		AllowHTTP:       true,
+		TLSClientConfig: c.tlsConfig,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			conn, err := c.dialContext(ctx, network, addr)
+			if err != nil || c.tlsConfig == nil {
+				return conn, err //h2c
+			}
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tc, nil
+		},
+	}
+	//the stream lives as long as the connection
+	sctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := c.newRequest(sctx, http.MethodPost, cnet.TransportHTTP2, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		cancel()
+		return nil, statusError(resp)
+	}
+	closer := func() error {
+		pw.Close()
+		resp.Body.Close()
+		cancel()
+		t.CloseIdleConnections()
+		return nil
+	}
+	return cnet.NewStreamConn(resp.Body, pw, nil, closer, cnet.Addr(""), cnet.Addr(req.URL.Host)), nil
+}
+
+// dialTLS opens a raw TLS connection, sending the client headers
+// in a HTTP/1.1 request before switching to the ssh protocol
+func (c *Client) dialTLS(ctx context.Context) (net.Conn, error) {
+	if c.tlsConfig == nil {
+		return nil, errors.New("tls transport needs a https server")
+	}
+	u, _ := url.Parse(c.server)
+	conn, err := c.dialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.tlsConfig.Clone()
+	cfg.NextProtos = []string{cnet.ALPNProtocol}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	tc := tls.Client(conn, cfg)
+	fail := func(err error) (net.Conn, error) {
+		tc.Close()
+		return nil, err
+	}
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return fail(err)
+	}
+	if tc.ConnectionState().NegotiatedProtocol != cnet.ALPNProtocol {
+		return fail(errors.New("server does not support the tls transport"))
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, cnet.TransportTLS, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if err := req.Write(tc); err != nil {
+		return fail(err)
+	}
+	br := bufio.NewReader(tc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fail(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		err := statusError(resp)
+		return fail(err)
+	}
+	return &readerConn{Conn: tc, r: br}, nil
+}
@@ -0,0 +1,205 @@
+package chclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zgsm-ai/cotun/share/cnet"
+	"github.com/zgsm-ai/cotun/share/settings"
+)
+
+// maxPollBatch bounds the bytes sent in one POST
+const maxPollBatch = 256 * 1024
+
+/**
+ * pollConn is the client side of the long-poll transport
+ * @description
+ * - Writes are batched and pushed in order by one POST at a time
+ * - Reads come from GET requests, held by the server until it has bytes to send
+ * - Every request carries the session id returned when the session was opened
+ */
+type pollConn struct {
+	c      *Client
+	http   *http.Client
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+	pr     *io.PipeReader
+	pw     *io.PipeWriter
+	mu     sync.Mutex
+	cond   *sync.Cond
+	out    []byte
+	err    error
+	remote net.Addr
+}
+
+func (c *Client) dialPoll(ctx context.Context) (net.Conn, error) {
+	client := &http.Client{Transport: c.httpTransport()}
+	req, err := c.newRequest(ctx, http.MethodPost, cnet.TransportPoll, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	resp.Body.Close()
+	id := resp.Header.Get(cnet.SessionHeader)
+	if id == "" {
+		return nil, errors.New("server did not open a poll session")
+	}
+	pc := &pollConn{
+		c:      c,
+		http:   client,
+		id:     id,
+		remote: cnet.Addr(req.URL.Host),
+	}
+	pc.ctx, pc.cancel = context.WithCancel(context.Background())
+	pc.pr, pc.pw = io.Pipe()
+	pc.cond = sync.NewCond(&pc.mu)
+	go pc.pullLoop()
+	go pc.pushLoop()
+	return pc, nil
+}
+
+func (pc *pollConn) request(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	req, err := pc.c.newRequest(ctx, method, cnet.TransportPoll, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(cnet.SessionHeader, pc.id)
+	resp, err := pc.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// pullLoop polls the server for its bytes
+func (pc *pollConn) pullLoop() {
+	timeout := settings.EnvDuration("POLL_WAIT", 20*time.Second) + 30*time.Second
+	for {
+		ctx, cancel := context.WithTimeout(pc.ctx, timeout)
+		resp, err := pc.request(ctx, http.MethodGet, nil)
+		if err == nil {
+			_, err = io.Copy(pc.pw, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		if err != nil {
+			pc.fail(err)
+			return
+		}
+	}
+}
+
+// pushLoop sends the written bytes, in order
+func (pc *pollConn) pushLoop() {
+	for {
+		pc.mu.Lock()
+		for len(pc.out) == 0 && pc.err == nil {
+			pc.cond.Wait()
+		}
+		if pc.err != nil {
+			pc.mu.Unlock()
+			return
+		}
+		n := len(pc.out)
+		if n > maxPollBatch {
+			n = maxPollBatch
+		}
+		batch := make([]byte, n)
+		copy(batch, pc.out)
+		pc.out = pc.out[n:]
+		pc.cond.Broadcast()
+		pc.mu.Unlock()
+		resp, err := pc.request(pc.ctx, http.MethodPost, bytes.NewReader(batch))
+		if err != nil {
+			pc.fail(err)
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+func (pc *pollConn) fail(err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil {
+		return
+	}
+	var se *cnet.StatusError
+	if errors.As(err, &se) && se.Code == http.StatusGone {
+		err = io.EOF
+	}
+	pc.err = err
+	pc.pw.CloseWithError(err)
+	pc.cond.Broadcast()
+	pc.cancel()
+}
+
+func (pc *pollConn) Read(b []byte) (int, error) {
+	return pc.pr.Read(b)
+}
+
+func (pc *pollConn) Write(b []byte) (int, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for len(pc.out) >= 4*maxPollBatch && pc.err == nil {
+		pc.cond.Wait()
+	}
+	if pc.err != nil {
+		return 0, pc.err
+	}
+	pc.out = append(pc.out, b...)
+	pc.cond.Broadcast()
+	return len(b), nil
+}
+
+func (pc *pollConn) Close() error {
+	pc.mu.Lock()
+	closed := pc.err != nil
+	pc.mu.Unlock()
+	if !closed {
+		//best effort, the server drops idle sessions anyway
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if resp, err := pc.request(ctx, http.MethodDelete, nil); err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+	}
+	pc.fail(net.ErrClosed)
+	return nil
+}
+
+func (pc *pollConn) LocalAddr() net.Addr {
+	return cnet.Addr("")
+}
+
+func (pc *pollConn) RemoteAddr() net.Addr {
+	return pc.remote
+}
+
+func (pc *pollConn) SetDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (pc *pollConn) SetReadDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (pc *pollConn) SetWriteDeadline(t time.Time) error {
+	return nil //no-op
+}
@@ -13,6 +13,7 @@ import (
 	chserver "github.com/zgsm-ai/cotun/server"
 	chshare "github.com/zgsm-ai/cotun/share"
 	"github.com/zgsm-ai/cotun/share/ccrypto"
+	"github.com/zgsm-ai/cotun/share/cnet"
 	"github.com/zgsm-ai/cotun/share/cos"
 	"github.com/zgsm-ai/cotun/share/settings"
 )
@@ -83,6 +84,43 @@ var serverHelp = `
     always come in the form "<remote-host>:<remote-port>" for normal remotes
     and "R:<local-interface>:<local-port>" for reverse port forwarding
     remotes. This file will be automatically reloaded on change.
+    A user may also be an object, to set its quotas:
+      {
+        "<user:pass>": {
+          "remotes": ["<addr-regex>"],
+          "quota": {
+            "maxSessions": 2,          concurrent ssh sessions
+            "maxPorts": 4,             reverse (mapping) ports
+            "minPort": 30100,          allowed mapping port sub-range
+            "maxPort": 30199,
+            "bandwidth": 1048576       bytes per second, per direction
+          }
+        }
+      }
+    Quotas apply to the verified user of the session, the auth file user
+    or else the X-User-Id checked against the JWT (see --jwt-jwks), and
+    to the control plane API with JWT verification, zero or missing limits
+    are unlimited.
+    The object may also restrict who connects to the reverse ports:
+      "acls": [
+        {
+          "port": 30100,               a mapping port, or
+          "app": "<app-name>",         the ports of an app, or neither
+                                       for all the ports of the user
+          "allow": ["10.0.0.0/8"],     source IPs/CIDRs, empty allows all
+          "deny": ["10.1.2.3"],        checked before allow
+          "secret": "<secret>",        the peer first sends the secret
+                                       followed by a newline, or
+          "clientCA": "<ca.pem>"       the peer does a TLS handshake with
+                                       a certificate signed by the CA
+                                       (the server needs --tls-key/cert)
+        }
+      ]
+    A port ACL protects the mapping port whoever binds it, including
+    sessions without a user; app and user-wide ACLs apply to the
+    sessions of their user.
+    Rejected connections are counted (cotun_acl_rejections_total) and
+    audited as conn_reject events.
 
     --auth, An optional string representing a single user with full
     access, in the form of <user:pass>. It is equivalent to creating an
@@ -130,11 +168,102 @@ var serverHelp = `
 
     --maxport, Defines the maximum port for port allocation (defaults to 31000).
 
+    --port-store, Where port allocations are kept as leases, so that they
+    survive restarts. Either a file path (or bolt:///path/to/ports.db) for a
+    single server, or redis://[:password@]host:port/db to share the port range
+    between several cotun servers. By default allocations are kept in memory.
+
+    --port-lease, How long a mapping port stays reserved for its client after
+    the tunnel disconnects. Connected tunnels renew their lease automatically
+    (defaults to 10m).
+
+    --jwt-jwks, URL of the JWKS used to verify the bearer tokens of the control
+    plane API and of tunnel clients (e.g. https://casdoor.example.com/.well-known/jwks).
+    When set (or --jwt-pubkey), requests without a valid token are rejected,
+    users can only see and manage their own ports, and tunnel clients must
+    send "Authorization: Bearer <token>" (see the client --header option),
+    their X-User-Id must match the token.
+    Without JWT verification the user ids are not checked, which is insecure:
+    reading quotas, ACLs and sessions then requires the --admin-token.
+
+    --jwt-pubkey, PEM public key or certificate (file path or PEM content)
+    used to verify tokens instead of, or as a fallback of, --jwt-jwks.
+
+    --jwt-issuer, Expected token issuer (iss), not checked if empty.
+
+    --jwt-audience, Expected token audience (aud), not checked if empty.
+
+    --jwt-user-claim, Token claim holding the user id (defaults to id).
+
+    --jwt-admin-role, Role whose holders can see and manage the ports of all
+    users (defaults to admin). Casdoor tokens with isAdmin are admins too.
+
+    --admin-token, Token of the admin APIs of the control plane (quotas,
+    ACLs, disconnecting sessions), sent in the X-Admin-Token header. Admin
+    JWTs are accepted too; without both, the admin APIs are disabled.
+    It can also be set with the COTUN_ADMIN_TOKEN environment variable.
+
+    --transports, Comma separated transports accepted from clients
+    (defaults to all of them):
+      ws, websocket (the default transport of clients)
+      h2, full-duplex HTTP/2 stream (h2c on servers without TLS)
+      tls, raw TLS connection, selected through ALPN (needs TLS)
+      poll, HTTP long-poll, for proxies which break or buffer streams
+    Clients can ask the server for its transports and pick one.
+
+    --ingress-port, Port of the HTTP ingress of the reverse tunnels,
+    disabled if empty. Requests are routed, including websocket upgrades,
+    to the reverse tunnel connected on this server whose allocation
+    matches the request:
+      /t/<clientId>/<appName>/<path>, the prefix is stripped and sent
+      in the X-Forwarded-Prefix header, or
+      Host <appName>-<clientId>.<ingress-domain>, see --ingress-domain
+    Uses the --tls-key and --tls-cert of the server when set.
+    The ACL of the mapping port is checked against the address of the
+    ingress client; ports protected by a secret or a clientCA are not
+    reachable through the ingress.
+
+    --ingress-domain, Domain of the virtual hosts of the ingress, e.g.
+    with tunnel.example.com the tunnel of app "web" of client "abc" is
+    reached at web-abc.tunnel.example.com (needs a wildcard DNS record).
+    The host is split at its first "-": apps whose name contains a "-"
+    are only reached by path.
+
+    --ingress-auth, Require a JWT on ingress requests (see --jwt-jwks),
+    users can only reach their own tunnels, admins reach all of them.
+    The Authorization header is not forwarded to the tunnels.
+
+    --audit, Where audit events are written, disabled if empty. Either a
+    file path (or file:///path/to/audit.jsonl), written as JSON lines, or
+    a http(s):// webhook receiving each batch of events as a JSON lines
+    POST body. Events: session_open, session_close, auth_failure,
+    remote_bind, conn_open, conn_close (with peer address, bytes and
+    duration), port_allocate and port_free.
+
+    --audit-max-size, Size in MB above which the audit file is rotated
+    to <file>.1, <file>.2... (defaults to 100, 0 to never rotate).
+
+    --audit-max-files, Number of rotated audit files kept (defaults to 5,
+    at least 1).
+
+    --audit-users, Comma separated users whose events are audited,
+    defaults to all users.
+
     --control-port, Control plane port, used for managing port information. Supports the following API endpoints:
       GET /{moduleName}/api/v1/ports - Get all port information
       POST /{moduleName}/api/v1/ports - Create new port
       GET /{moduleName}/api/v1/ports/{clientId}/{appName} - Get port information for specific client and application
       DELETE /{moduleName}/api/v1/ports?clientId=xx&appName=xx - Delete port
+      GET /{moduleName}/api/v1/quotas[/{user}] - Get user quotas and usage
+      PUT /{moduleName}/api/v1/quotas/{user} - Override the quota of a user (admin)
+      DELETE /{moduleName}/api/v1/quotas/{user} - Remove the quota override (admin)
+      GET /{moduleName}/api/v1/acls[/{user}] - Get the reverse port ACLs of users
+      PUT /{moduleName}/api/v1/acls/{user} - Override the ACL list of a user (admin)
+      DELETE /{moduleName}/api/v1/acls/{user} - Remove the ACL override (admin)
+      GET /{moduleName}/api/v1/sessions[?user=xx] - List live sessions with their user, client, remotes and traffic
+      DELETE /{moduleName}/api/v1/sessions/{id} - Forcibly disconnect a session (admin, or its user with JWT)
+      GET /metrics - Prometheus metrics: sessions, channels, bytes per user/app,
+        handshake failures, port pool utilization and reconnects
       Default port is 7890.
 ` + commonHelp
 
@@ -158,6 +287,23 @@ func main() {
 	flags.StringVar(&config.ControlPort, "control-port", "7890", "Control plane port, default is 7890")
 	flags.IntVar(&config.MinPort, "minport", 30000, "Minimum port for port allocation, default is 30000")
 	flags.IntVar(&config.MaxPort, "maxport", 31000, "Maximum port for port allocation, default is 31000")
+	flags.StringVar(&config.PortStore, "port-store", "", "Port lease store, file path or redis:// url")
+	flags.DurationVar(&config.PortLease, "port-lease", chserver.DefaultPortLease, "Port lease duration, default is 10m")
+	flags.StringVar(&config.JWT.JwksURL, "jwt-jwks", "", "JWKS url used to verify tokens")
+	flags.StringVar(&config.JWT.PublicKey, "jwt-pubkey", "", "PEM public key used to verify tokens")
+	flags.StringVar(&config.JWT.Issuer, "jwt-issuer", "", "Expected token issuer")
+	flags.StringVar(&config.JWT.Audience, "jwt-audience", "", "Expected token audience")
+	flags.StringVar(&config.JWT.UserClaim, "jwt-user-claim", "id", "Token claim holding the user id, default is id")
+	flags.StringVar(&config.JWT.AdminRole, "jwt-admin-role", "admin", "Admin role name, default is admin")
+	flags.StringVar(&config.AdminToken, "admin-token", "", "Token of the control plane admin APIs")
+	transports := flags.String("transports", "", "Transports accepted from clients, default is all")
+	flags.StringVar(&config.Ingress.Port, "ingress-port", "", "HTTP ingress port of the reverse tunnels")
+	flags.StringVar(&config.Ingress.Domain, "ingress-domain", "", "Virtual host domain of the ingress")
+	flags.BoolVar(&config.Ingress.Auth, "ingress-auth", false, "Require a JWT on ingress requests")
+	flags.StringVar(&config.Audit.Sink, "audit", "", "Audit log file or webhook url")
+	auditMaxSize := flags.Int64("audit-max-size", 100, "Audit file size in MB above which it's rotated")
+	flags.IntVar(&config.Audit.MaxFiles, "audit-max-files", 5, "Number of rotated audit files kept")
+	auditUsers := flags.String("audit-users", "", "Users whose events are audited, default is all")
 
 	host := flags.String("host", "", "")
 	p := flags.String("p", "", "")
@@ -207,6 +353,18 @@ func main() {
 	if config.Auth == "" {
 		config.Auth = os.Getenv("AUTH")
 	}
+	if config.AdminToken == "" {
+		config.AdminToken = settings.Env("ADMIN_TOKEN")
+	}
+	list, err := cnet.ParseTransports(*transports)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Transports = list
+	config.Audit.MaxSize = *auditMaxSize * 1024 * 1024
+	if *auditUsers != "" {
+		config.Audit.Users = strings.Split(*auditUsers, ",")
+	}
 	s, err := chserver.NewServer(config)
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,257 @@
+package chserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zgsm-ai/cotun/share/settings"
+)
+
+/**
+ * aclManager holds the access control lists of the reverse ports of each user
+ * @description
+ * - ACLs come from the auth file, and can be replaced at runtime through the control API
+ * - The ACLs of a user are matched by port, then by app name, then the user-wide ACL applies
+ * - A port ACL protects the mapping port whoever binds it, sessions without a user included
+ */
+type aclManager struct {
+	users     *settings.UserIndex
+	mu        sync.Mutex
+	overrides map[string][]settings.ACL
+}
+
+func newACLManager(users *settings.UserIndex) *aclManager {
+	return &aclManager{
+		users:     users,
+		overrides: make(map[string][]settings.ACL),
+	}
+}
+
+// Get 用户的ACL，运行时设置的ACL优先于认证文件中的ACL
+func (am *aclManager) Get(user string) []settings.ACL {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if acls, ok := am.overrides[user]; ok {
+		return acls
+	}
+	if u, ok := am.users.Get(user); ok {
+		return u.ACLs
+	}
+	return nil
+}
+
+// UserACLs 控制面返回的用户ACL，共享密钥已隐去
+type UserACLs struct {
+	User string         `json:"user"`
+	ACLs []settings.ACL `json:"acls"`
+}
+
+// 控制面返回的ACL中替代共享密钥的内容
+const secretMask = "******"
+
+// View 用户的ACL，用于控制面返回，共享密钥替换为secretMask
+func (am *aclManager) View(user string) UserACLs {
+	acls := append([]settings.ACL{}, am.Get(user)...)
+	for i := range acls {
+		if acls[i].Secret != "" {
+			acls[i].Secret = secretMask
+		}
+	}
+	return UserACLs{User: user, ACLs: acls}
+}
+
+// List 所有设置了ACL的用户，共享密钥已隐去
+func (am *aclManager) List() []UserACLs {
+	list := []UserACLs{}
+	for _, name := range am.names() {
+		list = append(list, am.View(name))
+	}
+	return list
+}
+
+// names 所有设置了ACL的用户名，按名称排序
+func (am *aclManager) names() []string {
+	names := map[string]bool{}
+	am.mu.Lock()
+	for name := range am.overrides {
+		names[name] = true
+	}
+	am.mu.Unlock()
+	for _, u := range am.users.All() {
+		if len(u.ACLs) > 0 {
+			names[u.Name] = true
+		}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Set 运行时设置用户的ACL，替换该用户所有的ACL
+func (am *aclManager) Set(user string, acls []settings.ACL) error {
+	for i := range acls {
+		if err := acls[i].Validate(); err != nil {
+			return err
+		}
+	}
+	am.mu.Lock()
+	am.overrides[user] = acls
+	am.mu.Unlock()
+	return nil
+}
+
+// Delete 删除运行时设置的ACL，恢复为认证文件中的ACL
+func (am *aclManager) Delete(user string) bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	_, ok := am.overrides[user]
+	delete(am.overrides, user)
+	return ok
+}
+
+/**
+ * Match returns the ACL of a reverse port, nil if none applies
+ * @param {string} user - User of the session, may be empty
+ * @param {string} app - App name of the session
+ * @param {int} port - The reverse port
+ * @description
+ * - The ACLs of the session user apply first
+ * - Then the port ACLs of every user, so a port stays protected when bound by another
+ *   user or by a session without a user
+ */
+func (am *aclManager) Match(user, app string, port int) *settings.ACL {
+	if user != "" {
+		if acl := settings.MatchACL(am.Get(user), app, port); acl != nil {
+			return acl
+		}
+	}
+	for _, name := range am.names() {
+		acls := am.Get(name)
+		for i := range acls {
+			if acls[i].Port == port {
+				return &acls[i]
+			}
+		}
+	}
+	return nil
+}
+
+// aclHandshakeTimeout 连接反向端口的对端完成握手(密钥或mTLS)的时限
+var aclHandshakeTimeout = settings.EnvDuration("ACL_HANDSHAKE_TIMEOUT", 10*time.Second)
+
+/**
+ * acceptInbound checks a connection accepted by a reverse port of a session
+ * @param {liveSession} ls - Session owning the reverse port
+ * @param {settings.Remote} r - Reverse remote of the port
+ * @param {net.Conn} conn - Accepted connection
+ * @returns {net.Conn, error} Connection to proxy, wrapped when a handshake was done
+ * @description
+ * - The peer address is checked against the allow/deny lists of the ACL
+ * - With a secret, the peer must first send the secret followed by a newline
+ * - With a client CA, the peer must do a TLS handshake with a certificate signed by the CA
+ * - Rejected connections are counted and audited
+ * - Connections of the HTTP ingress don't go through the port, the ingress checks the ACL
+ *   against the address of its client
+ */
+func (s *Server) acceptInbound(ls *liveSession, r *settings.Remote, conn net.Conn) (net.Conn, error) {
+	port, _ := strconv.Atoi(r.LocalPort)
+	acl := s.acls.Match(ls.user, ls.appName, port)
+	if acl == nil {
+		return conn, nil
+	}
+	reject := func(reason string, err error) (net.Conn, error) {
+		aclRejections.WithLabelValues(reason).Inc()
+		ev := ls.auditEvent(AuditConnReject)
+		ev.Remote = r.String()
+		ev.Peer = conn.RemoteAddr().String()
+		ev.Port = port
+		ev.Detail = err.Error()
+		s.audit.Log(ev)
+		return nil, err
+	}
+	if ip := addrIP(conn.RemoteAddr()); ip == nil || !acl.AllowIP(ip) {
+		return reject("ip", fmt.Errorf("address %s not allowed", conn.RemoteAddr()))
+	}
+	switch {
+	case acl.Secret != "":
+		conn.SetReadDeadline(time.Now().Add(aclHandshakeTimeout))
+		br := bufio.NewReader(conn)
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return reject("secret", fmt.Errorf("read secret: %w", err))
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if subtle.ConstantTimeCompare([]byte(line), []byte(acl.Secret)) != 1 {
+			return reject("secret", errors.New("invalid secret"))
+		}
+		conn.SetReadDeadline(time.Time{})
+		return &bufferedConn{Conn: conn, r: br}, nil
+	case acl.ClientCAs() != nil:
+		c, err := s.aclTLSConfig(acl)
+		if err != nil {
+			return reject("tls", err)
+		}
+		tc := tls.Server(conn, c)
+		ctx, cancel := context.WithTimeout(context.Background(), aclHandshakeTimeout)
+		defer cancel()
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return reject("tls", fmt.Errorf("tls handshake: %w", err))
+		}
+		return tc, nil
+	}
+	return conn, nil
+}
+
+// allowInboundPacket 检查发往反向UDP端口的数据包的来源，拒绝的数据包只计数
+func (s *Server) allowInboundPacket(ls *liveSession, r *settings.Remote, addr *net.UDPAddr) bool {
+	port, _ := strconv.Atoi(r.LocalPort)
+	acl := s.acls.Match(ls.user, ls.appName, port)
+	if acl == nil || acl.AllowIP(addr.IP) {
+		return true
+	}
+	aclRejections.WithLabelValues("ip").Inc()
+	return false
+}
+
+// aclTLSConfig mTLS使用服务端的证书，校验对端证书
+func (s *Server) aclTLSConfig(acl *settings.ACL) (*tls.Config, error) {
+	if s.config.TLS.Key == "" || s.config.TLS.Cert == "" {
+		return nil, errors.New("mTLS needs the server --tls-key and --tls-cert")
+	}
+	keypair, err := tls.LoadX509KeyPair(s.config.TLS.Cert, s.config.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{keypair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    acl.ClientCAs(),
+	}, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
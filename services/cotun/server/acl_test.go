@@ -0,0 +1,86 @@
+package chserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+)
+
+func TestACLMatch(t *testing.T) {
+	am := newACLManager(settings.NewUserIndex(cio.NewLogger("test")))
+	if err := am.Set("u1", []settings.ACL{
+		{Port: 30100, Allow: []string{"10.0.0.0/8"}},
+		{App: "web", Deny: []string{"10.1.2.3"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.Set("u2", []settings.ACL{{Deny: []string{"192.0.2.1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range []struct {
+		user, app string
+		port      int
+		match     *settings.ACL
+	}{
+		{"u1", "web", 30100, &am.Get("u1")[0]},
+		{"u1", "web", 30101, &am.Get("u1")[1]},
+		{"u1", "api", 30101, nil},
+		//port ACLs protect the port whoever binds it
+		{"", "web", 30100, &am.Get("u1")[0]},
+		{"u2", "api", 30100, &am.Get("u2")[0]},
+		{"u3", "api", 30100, &am.Get("u1")[0]},
+		//app and user-wide ACLs only apply to the sessions of their user
+		{"", "web", 30101, nil},
+	} {
+		if got := am.Match(test.user, test.app, test.port); got != test.match {
+			t.Fatalf("#%d: expected %+v, got %+v", i+1, test.match, got)
+		}
+	}
+}
+
+func TestACLAPI(t *testing.T) {
+	s := &Server{
+		Logger: cio.NewLogger("test"),
+		config: &Config{AdminToken: "s3"},
+		acls:   newACLManager(settings.NewUserIndex(cio.NewLogger("test"))),
+	}
+	if err := s.acls.Set("u1", []settings.ACL{{App: "web", Secret: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			r.Header.Set(AdminTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		s.handleACLs(w, r)
+		return w
+	}
+	//listing the ACLs of all the users is for admins only
+	if w := get("/cotun/api/v1/acls", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an anonymous listing, got %d", w.Code)
+	}
+	for _, path := range []string{"/cotun/api/v1/acls", "/cotun/api/v1/acls/u1"} {
+		w := get(path, "s3")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+		if body := w.Body.String(); strings.Contains(body, "s3cret") || !strings.Contains(body, secretMask) {
+			t.Fatalf("%s: expected the secret to be masked: %s", path, body)
+		}
+	}
+	if s.acls.Get("u1")[0].Secret != "s3cret" {
+		t.Fatal("masking must not modify the ACLs")
+	}
+	//port ACLs of other users are matched with their secret
+	if err := s.acls.Set("u2", []settings.ACL{{Port: 30100, Secret: "p0rt"}}); err != nil {
+		t.Fatal(err)
+	}
+	if acl := s.acls.Match("", "web", 30100); acl == nil || acl.Secret != "p0rt" {
+		t.Fatalf("expected the port ACL with its secret, got %+v", acl)
+	}
+}
@@ -0,0 +1,219 @@
+package chserver
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zgsm-ai/cotun/share/tunnel"
+)
+
+// 审计事件类型
+const (
+	AuditSessionOpen  = "session_open"
+	AuditSessionClose = "session_close"
+	AuditAuthFailure  = "auth_failure"
+	AuditRemoteBind   = "remote_bind"
+	AuditConnOpen     = "conn_open"
+	AuditConnClose    = "conn_close"
+	AuditConnReject   = "conn_reject"
+	AuditPortAllocate = "port_allocate"
+	AuditPortFree     = "port_free"
+)
+
+// AuditConfig 审计日志配置
+type AuditConfig struct {
+	Sink     string   // 文件路径或webhook地址，为空时不记录
+	MaxSize  int64    // 日志文件超过该字节数时轮转，0表示不轮转
+	MaxFiles int      // 保留的轮转文件数，至少保留1个
+	Users    []string // 只记录这些用户的事件，为空时记录所有用户
+}
+
+/**
+ * AuditEvent is a record of the audit log
+ * @description
+ * - Connection events are about a connection proxied by a session: Peer is the address
+ *   connecting to a reverse port, it's empty for connections opened by the client
+ * - BytesIn are the bytes from the client (session) or from the connecting side (connection)
+ */
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Session    int32     `json:"session,omitempty"`
+	User       string    `json:"user,omitempty"`
+	ClientId   string    `json:"clientId,omitempty"`
+	AppName    string    `json:"appName,omitempty"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	Transport  string    `json:"transport,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	Peer       string    `json:"peer,omitempty"`
+	Port       int       `json:"port,omitempty"`
+	BytesIn    int64     `json:"bytesIn,omitempty"`
+	BytesOut   int64     `json:"bytesOut,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// AuditSink 审计事件的输出
+type AuditSink interface {
+	Write(events []AuditEvent) error
+	Close() error
+}
+
+/**
+ * NewAuditSink opens the audit sink described by uri
+ * @param {string} uri - A file path (or file:///path/to/audit.jsonl), or a http(s):// webhook
+ * @param {int64} maxSize - Size of the file above which it's rotated, 0 to never rotate
+ * @param {int} maxFiles - Number of rotated files kept, at least 1
+ * @returns {AuditSink, error} Opened sink, nil if uri is empty
+ */
+func NewAuditSink(uri string, maxSize int64, maxFiles int) (AuditSink, error) {
+	if uri == "" {
+		return nil, nil
+	}
+	if !strings.Contains(uri, "://") {
+		return newFileAuditSink(uri, maxSize, maxFiles)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit sink '%s': %w", uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return newFileAuditSink(u.Host+u.Path, maxSize, maxFiles)
+	case "http", "https":
+		return newWebhookAuditSink(uri), nil
+	}
+	return nil, fmt.Errorf("unsupported audit sink '%s'", u.Scheme)
+}
+
+// auditBacklog 等待写出的事件数上限，超出时丢弃事件
+const auditBacklog = 4096
+
+/**
+ * auditor writes the audit events in the background
+ * @description
+ * - Events are written in batches, slow sinks don't block the tunnels
+ * - Events are dropped (and counted) when the backlog is full
+ */
+type auditor struct {
+	sink   AuditSink
+	users  map[string]bool
+	mu     sync.RWMutex
+	closed bool
+	events chan AuditEvent
+	done   chan struct{}
+}
+
+func newAuditor(c *AuditConfig) (*auditor, error) {
+	sink, err := NewAuditSink(c.Sink, c.MaxSize, c.MaxFiles)
+	if err != nil || sink == nil {
+		return nil, err
+	}
+	a := &auditor{
+		sink:   sink,
+		events: make(chan AuditEvent, auditBacklog),
+		done:   make(chan struct{}),
+	}
+	if len(c.Users) > 0 {
+		a.users = map[string]bool{}
+		for _, u := range c.Users {
+			a.users[u] = true
+		}
+	}
+	go a.run()
+	return a, nil
+}
+
+// Log 记录事件，未启用审计时忽略
+func (a *auditor) Log(ev AuditEvent) {
+	if a == nil {
+		return
+	}
+	if a.users != nil && !a.users[ev.User] {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.events <- ev:
+	default:
+		auditDropped.Inc()
+	}
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+	for ev := range a.events {
+		batch := []AuditEvent{ev}
+	more:
+		for len(batch) < 100 {
+			select {
+			case ev, ok := <-a.events:
+				if !ok {
+					break more
+				}
+				batch = append(batch, ev)
+			default:
+				break more
+			}
+		}
+		if err := a.sink.Write(batch); err != nil {
+			log.Printf("audit: %v", err)
+			auditDropped.Add(float64(len(batch)))
+		}
+	}
+}
+
+// Close 写出剩余事件后关闭输出
+func (a *auditor) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.events)
+	a.mu.Unlock()
+	<-a.done
+	return a.sink.Close()
+}
+
+// auditPort 记录端口的分配和释放
+func (s *Server) auditPort(typ string, p PortAllocation, detail string) {
+	s.audit.Log(AuditEvent{
+		Type:     typ,
+		User:     p.UserId,
+		ClientId: p.ClientId,
+		AppName:  p.AppName,
+		Port:     p.MappingPort,
+		Detail:   detail,
+	})
+}
+
+// auditConn 记录会话转发的连接
+func (s *Server) auditConn(ls *liveSession, ev tunnel.ConnEvent) {
+	typ := AuditConnOpen
+	if ev.Closed {
+		typ = AuditConnClose
+	}
+	e := ls.auditEvent(typ)
+	e.Remote = ev.Remote
+	e.Peer = ev.Peer
+	e.BytesIn = ev.Sent
+	e.BytesOut = ev.Received
+	e.DurationMs = ev.Duration.Milliseconds()
+	s.audit.Log(e)
+}
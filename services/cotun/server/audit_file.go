@@ -0,0 +1,93 @@
+package chserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/**
+ * fileAuditSink appends the audit events to a JSONL file
+ * @description
+ * - When the file would grow above maxSize, it's renamed to <path>.1, <path>.1
+ *   to <path>.2 and so on, keeping maxFiles rotated files
+ * - At least one rotated file is kept, so the events logged before a rotation are never deleted at once
+ */
+type fileAuditSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+}
+
+func newFileAuditSink(path string, maxSize int64, maxFiles int) (*fileAuditSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	fs := &fileAuditSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *fileAuditSink) open() error {
+	f, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f = f
+	fs.size = info.Size()
+	return nil
+}
+
+func (fs *fileAuditSink) rotate() error {
+	fs.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", fs.path, fs.maxFiles))
+	for i := fs.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", fs.path, i), fmt.Sprintf("%s.%d", fs.path, i+1))
+	}
+	os.Rename(fs.path, fs.path+".1")
+	return fs.open()
+}
+
+func (fs *fileAuditSink) Write(events []AuditEvent) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		if fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(b)) > fs.maxSize {
+			if err := fs.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := fs.f.Write(b)
+		fs.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fileAuditSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.f.Close()
+}
@@ -0,0 +1,69 @@
+package chserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := newAuditor(&AuditConfig{Sink: path, MaxSize: 300, MaxFiles: 2, Users: []string{"foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		a.Log(AuditEvent{Type: AuditSessionOpen, User: "foo", ClientId: "client"})
+		a.Log(AuditEvent{Type: AuditSessionOpen, User: "bar"})
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//each event is about 100 bytes, so the files hold a few events each
+	lines := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > 300 {
+			t.Fatalf("%s not rotated: %d bytes", name, len(b))
+		}
+		if strings.Contains(string(b), `"bar"`) {
+			t.Fatalf("%s has events of unaudited users", name)
+		}
+		lines += strings.Count(string(b), "\n")
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected 2 rotated files at most")
+	}
+	if lines == 0 || lines >= 10 {
+		t.Fatalf("expected the oldest events to be dropped, got %d", lines)
+	}
+	//logging after close is ignored
+	a.Log(AuditEvent{Type: AuditSessionClose, User: "foo"})
+}
+
+func TestAuditFileRotateKeepsOne(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	fs, err := newFileAuditSink(path, 150, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := fs.Write([]AuditEvent{{Type: AuditSessionOpen, User: "foo", ClientId: "client"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.Close()
+	//the events written before the last rotation are kept in <path>.1
+	for _, name := range []string{path, path + ".1"} {
+		if b, err := os.ReadFile(name); err != nil || strings.Count(string(b), "\n") != 1 {
+			t.Fatalf("%s: expected one event, got %q (%v)", name, b, err)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Fatalf("expected 1 rotated file at most")
+	}
+}
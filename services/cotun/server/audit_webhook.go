@@ -0,0 +1,45 @@
+package chserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookAuditSink posts the audit events to a webhook, each batch as a JSONL body
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookAuditSink(url string) *webhookAuditSink {
+	return &webhookAuditSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (ws *webhookAuditSink) Write(events []AuditEvent) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	resp, err := ws.client.Post(ws.url, "application/x-ndjson", &body)
+	if err != nil {
+		return fmt.Errorf("audit webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func (ws *webhookAuditSink) Close() error {
+	ws.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,253 @@
+package chserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"github.com/jpillora/requestlog"
+)
+
+// IngressConfig 服务端HTTP入口，按Host或路径前缀把请求转发到反向隧道
+type IngressConfig struct {
+	Port   string // 入口端口，为空时不启用
+	Domain string // 虚拟主机域名，<app>-<client>.<domain> 路由到对应隧道，应用名不能包含'-'
+	Auth   bool   // 要求JWT令牌，非管理员只能访问自己的隧道
+}
+
+// ingressPathPrefix 路径路由前缀，/t/<client>/<app>/ 路由到对应隧道
+const ingressPathPrefix = "/t/"
+
+/**
+ * startIngress starts the HTTP ingress of the reverse tunnels
+ * @param {context.Context} ctx - The ingress is closed with the context
+ * @description
+ * - Routes come from the port allocations connected on this server
+ * - Uses the TLS key and certificate of the server when set
+ */
+func (s *Server) startIngress(ctx context.Context) error {
+	addr := "0.0.0.0:" + s.config.Ingress.Port
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.Errorf("Failed to start ingress: %v", err)
+		return err
+	}
+	proto := "http"
+	if s.config.TLS.Key != "" && s.config.TLS.Cert != "" {
+		c, err := s.tlsKeyCert(s.config.TLS.Key, s.config.TLS.Cert, "")
+		if err != nil {
+			l.Close()
+			s.Errorf("Failed to start ingress: %v", err)
+			return err
+		}
+		c.NextProtos = []string{"http/1.1"}
+		l = tls.NewListener(l, c)
+		proto = "https"
+	}
+	s.Infof("Ingress listening on %s://%s", proto, addr)
+	h := http.Handler(http.HandlerFunc(s.handleIngress))
+	if s.Debug {
+		o := requestlog.DefaultOptions
+		o.TrustProxy = true
+		h = requestlog.WrapWith(h, o)
+	}
+	return s.ingressServer.GoServe(ctx, l, h)
+}
+
+/**
+ * routeIngress finds the tunnel of a request
+ * @param {*http.Request} r - Request to the ingress
+ * @returns {PortAllocation, string, string, bool} Tunnel, path to forward, stripped path prefix, found
+ * @description
+ * - Host <app>-<client>.<domain>, when an ingress domain is set; the label is split at its
+ *   first '-', so apps named with a '-' are only routed by path, client ids may contain '-'
+ * - Path /t/<client>/<app>/..., the prefix is stripped
+ */
+func (s *Server) routeIngress(r *http.Request) (PortAllocation, string, string, bool) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if domain := strings.ToLower(s.config.Ingress.Domain); domain != "" && strings.HasSuffix(host, "."+domain) {
+		appName, clientId, found := strings.Cut(strings.TrimSuffix(host, "."+domain), "-")
+		if !found || appName == "" || clientId == "" {
+			return PortAllocation{}, "", "", false
+		}
+		alloc, ok := s.allocator.FindLive(func(a *PortAllocation) bool {
+			return strings.EqualFold(a.AppName, appName) && strings.EqualFold(a.ClientId, clientId)
+		})
+		return alloc, r.URL.Path, "", ok
+	}
+	if !strings.HasPrefix(r.URL.Path, ingressPathPrefix) {
+		return PortAllocation{}, "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, ingressPathPrefix), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return PortAllocation{}, "", "", false
+	}
+	clientId, appName := parts[0], parts[1]
+	alloc, ok := s.allocator.FindLive(func(a *PortAllocation) bool {
+		return a.ClientId == clientId && a.AppName == appName
+	})
+	path := "/"
+	if len(parts) == 3 {
+		path += parts[2]
+	}
+	return alloc, path, ingressPathPrefix + clientId + "/" + appName, ok
+}
+
+// handleIngress 将入口请求(包括WebSocket升级)转发到反向隧道的映射端口
+func (s *Server) handleIngress(w http.ResponseWriter, r *http.Request) {
+	alloc, path, prefix, ok := s.routeIngress(r)
+	if !ok {
+		ingressRequests.WithLabelValues("not_found").Inc()
+		http.Error(w, "tunnel not found", http.StatusNotFound)
+		return
+	}
+	if prefix != "" && r.URL.Path == prefix {
+		//相对路径以前缀为基准
+		http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+	if s.config.Ingress.Auth {
+		id, err := s.verifier.Verify(r)
+		if err != nil {
+			ingressRequests.WithLabelValues("unauthorized").Inc()
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !id.Admin && id.UserId != alloc.UserId {
+			ingressRequests.WithLabelValues("forbidden").Inc()
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	if err := s.checkIngressACL(alloc, r); err != nil {
+		s.Debugf("Ingress %s/%s: %v", alloc.ClientId, alloc.AppName, err)
+		ingressRequests.WithLabelValues("forbidden").Inc()
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	target := "127.0.0.1:" + strconv.Itoa(alloc.MappingPort)
+	proxy := &httputil.ReverseProxy{
+		Transport: s.ingressTransport(),
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = target
+			req.URL.Path = path
+			req.URL.RawPath = ""
+			if s.config.Ingress.Auth {
+				//the token of the ingress is not for the tunneled app
+				req.Header.Del("Authorization")
+			}
+			req.Header.Set("X-Forwarded-Host", r.Host)
+			req.Header.Set("X-Forwarded-Proto", proto)
+			if prefix != "" {
+				req.Header.Set("X-Forwarded-Prefix", prefix)
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			s.Debugf("Ingress %s/%s: %v", alloc.ClientId, alloc.AppName, err)
+			ingressRequests.WithLabelValues("bad_gateway").Inc()
+			http.Error(w, "tunnel unavailable", http.StatusBadGateway)
+		},
+	}
+	ingressRequests.WithLabelValues("proxied").Inc()
+	proxy.ServeHTTP(w, r)
+}
+
+/**
+ * checkIngressACL checks a request to the ingress against the ACL of the mapping port
+ * @param {PortAllocation} alloc - Tunnel of the request
+ * @param {*http.Request} r - Request to the ingress
+ * @returns {error} Error when the request is rejected, it's counted and audited
+ * @description
+ * - The address of the ingress client is checked against the allow/deny lists
+ * - Ports protected by a secret or mTLS are refused, the ingress can't do the handshake
+ */
+func (s *Server) checkIngressACL(alloc PortAllocation, r *http.Request) error {
+	acl := s.acls.Match(alloc.UserId, alloc.AppName, alloc.MappingPort)
+	if acl == nil {
+		return nil
+	}
+	reason, err := "", error(nil)
+	if acl.Secret != "" || acl.ClientCAs() != nil {
+		reason, err = "ingress", errors.New("port needs a secret or mTLS, not reachable through the ingress")
+	} else if ip := net.ParseIP(remoteHost(r.RemoteAddr)); ip == nil || !acl.AllowIP(ip) {
+		reason, err = "ip", fmt.Errorf("address %s not allowed", r.RemoteAddr)
+	} else {
+		return nil
+	}
+	aclRejections.WithLabelValues(reason).Inc()
+	s.audit.Log(AuditEvent{
+		Type:     AuditConnReject,
+		User:     alloc.UserId,
+		ClientId: alloc.ClientId,
+		AppName:  alloc.AppName,
+		Peer:     r.RemoteAddr,
+		Port:     alloc.MappingPort,
+		Detail:   err.Error(),
+	})
+	return err
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+/**
+ * ingressTransport returns the transport of the ingress to the mapping ports
+ * @description
+ * - Connections don't go through the network: each one is an in-process pipe handed
+ *   to the session listening on the mapping port, which skips the ACL of the port
+ */
+func (s *Server) ingressTransport() *http.Transport {
+	s.ingressOnce.Do(func() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			conn, peer := net.Pipe()
+			if err := s.live.ServeConn(port, &ingressConn{Conn: peer}); err != nil {
+				conn.Close()
+				peer.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+		s.ingressProxy = t
+	})
+	return s.ingressProxy
+}
+
+// ingressAddr 入口连接的对端地址
+type ingressAddr struct{}
+
+func (ingressAddr) Network() string { return "pipe" }
+func (ingressAddr) String() string  { return "ingress" }
+
+// ingressConn 入口交给映射端口的连接，审计日志中对端为ingress
+type ingressConn struct {
+	net.Conn
+}
+
+func (c *ingressConn) RemoteAddr() net.Addr {
+	return ingressAddr{}
+}
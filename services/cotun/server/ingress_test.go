@@ -0,0 +1,225 @@
+package chserver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"github.com/zgsm-ai/cotun/share/tunnel"
+)
+
+// tunnelStub stands in for the tunnel of a session, serving the connections of its mapping port
+type tunnelStub struct {
+	port  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (ts *tunnelStub) ServeConn(port string, conn net.Conn) error {
+	if port != ts.port {
+		return tunnel.ErrNotBound
+	}
+	select {
+	case ts.conns <- conn:
+		return nil
+	case <-ts.done:
+		return tunnel.ErrNotBound
+	}
+}
+
+func (ts *tunnelStub) Accept() (net.Conn, error) {
+	select {
+	case c := <-ts.conns:
+		return c, nil
+	case <-ts.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ts *tunnelStub) Close() error {
+	ts.once.Do(func() { close(ts.done) })
+	return nil
+}
+
+func (ts *tunnelStub) Addr() net.Addr {
+	return ingressAddr{}
+}
+
+// serveTunnel registers a session whose mapping port is served by h
+func serveTunnel(t *testing.T, s *Server, port int, h http.HandlerFunc) {
+	ts := &tunnelStub{port: strconv.Itoa(port), conns: make(chan net.Conn), done: make(chan struct{})}
+	go http.Serve(ts, h)
+	t.Cleanup(func() { ts.Close() })
+	ls := &liveSession{id: 1}
+	ls.setTunnel(ts)
+	t.Cleanup(s.live.Add(ls))
+}
+
+// testPort 一个空闲端口，作为映射端口
+func testPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestIngressRoute(t *testing.T) {
+	port := testPort(t)
+	s := &Server{
+		Logger:    cio.NewLogger("test"),
+		config:    &Config{Ingress: IngressConfig{Domain: "tunnel.example"}},
+		allocator: NewPortAllocator(port, port),
+		acls:      newACLManager(settings.NewUserIndex(cio.NewLogger("test"))),
+		live:      newSessionRegistry(),
+	}
+	serveTunnel(t, s, port, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + "|" + r.Header.Get("X-Forwarded-Prefix") + r.Header.Get("Authorization")))
+	})
+	alloc, err := s.allocator.AllocatePort("c-1", "u1", "web", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := s.allocator.OnConnected(&alloc, alloc.ClientPort, alloc.MappingPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		host, path string
+		code       int
+		body       string
+	}{
+		{"cotun.local", "/t/c-1/web/api?x=1", http.StatusOK, "/api|/t/c-1/web"},
+		{"cotun.local", "/t/c-1/web", http.StatusMovedPermanently, ""},
+		{"cotun.local", "/t/c-1/other/", http.StatusNotFound, ""},
+		{"WEB-c-1.tunnel.example:8443", "/index.html", http.StatusOK, "/index.html|"},
+		{"api-c-1.tunnel.example", "/", http.StatusNotFound, ""},
+		//the label is split at its first '-', "web-c" is no app
+		{"web-c-1.tunnel.example", "/", http.StatusOK, "/|"},
+		{"web-c.tunnel.example", "/", http.StatusNotFound, ""},
+		{"webc1.tunnel.example", "/", http.StatusNotFound, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tc.host+tc.path, nil)
+		rec := httptest.NewRecorder()
+		s.handleIngress(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("%s%s: expected %d, got %d", tc.host, tc.path, tc.code, rec.Code)
+		}
+		if tc.body != "" && rec.Body.String() != tc.body {
+			t.Fatalf("%s%s: expected %q, got %q", tc.host, tc.path, tc.body, rec.Body.String())
+		}
+	}
+	//disconnected tunnels are not routed
+	s.allocator.OnDisconnected(live)
+	rec := httptest.NewRecorder()
+	s.handleIngress(rec, httptest.NewRequest(http.MethodGet, "http://cotun.local/t/c-1/web/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after disconnect, got %d", rec.Code)
+	}
+}
+
+func TestIngressAuth(t *testing.T) {
+	port := testPort(t)
+	v, key := testVerifier(t)
+	s := &Server{
+		Logger:    cio.NewLogger("test"),
+		config:    &Config{Ingress: IngressConfig{Auth: true}},
+		allocator: NewPortAllocator(port, port),
+		verifier:  v,
+		acls:      newACLManager(settings.NewUserIndex(cio.NewLogger("test"))),
+		live:      newSessionRegistry(),
+	}
+	serveTunnel(t, s, port, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("auth=" + r.Header.Get("Authorization")))
+	})
+	alloc, err := s.allocator.AllocatePort("c1", "u1", "web", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.allocator.OnConnected(&alloc, alloc.ClientPort, alloc.MappingPort); err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	for _, tc := range []struct {
+		user string
+		code int
+	}{
+		{"u1", http.StatusOK},
+		{"u2", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://cotun.local/t/c1/web/", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.MapClaims{"id": tc.user, "iss": "casdoor", "exp": exp}, key))
+		rec := httptest.NewRecorder()
+		s.handleIngress(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d", tc.user, tc.code, rec.Code)
+		}
+		if tc.code == http.StatusOK && rec.Body.String() != "auth=" {
+			t.Fatalf("ingress token forwarded to the tunnel: %q", rec.Body.String())
+		}
+	}
+}
+
+func TestIngressACL(t *testing.T) {
+	port := testPort(t)
+	s := &Server{
+		Logger:    cio.NewLogger("test"),
+		config:    &Config{},
+		allocator: NewPortAllocator(port, port),
+		acls:      newACLManager(settings.NewUserIndex(cio.NewLogger("test"))),
+		live:      newSessionRegistry(),
+	}
+	alloc, err := s.allocator.AllocatePort("c-1", "u1", "web", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.allocator.OnConnected(&alloc, alloc.ClientPort, alloc.MappingPort); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://cotun.local/t/c-1/web/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		s.handleIngress(rec, req)
+		return rec.Code
+	}
+	//no session of this server listens on the port yet
+	if code := get("10.1.2.3:1234"); code != http.StatusBadGateway {
+		t.Fatalf("expected 502 without tunnel, got %d", code)
+	}
+	//the mapping port gets the connections of the ingress from the ingress itself
+	peers := make(chan string, 1)
+	serveTunnel(t, s, port, func(w http.ResponseWriter, r *http.Request) { peers <- r.RemoteAddr })
+	//a port ACL of another user applies too
+	s.acls.Set("u2", []settings.ACL{{Port: alloc.MappingPort, Allow: []string{"10.0.0.0/8"}}})
+	if code := get("192.0.2.1:1234"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a denied address, got %d", code)
+	}
+	if code := get("10.1.2.3:1234"); code != http.StatusOK {
+		t.Fatalf("expected 200 for an allowed address, got %d", code)
+	}
+	select {
+	case peer := <-peers:
+		if peer != "ingress" {
+			t.Fatalf("expected the connection to come from the ingress, got %s", peer)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no connection to the mapping port")
+	}
+	//the ingress can't do the handshake of a secret
+	s.acls.Set("u1", []settings.ACL{{App: "web", Secret: "s3cret"}})
+	if code := get("10.1.2.3:1234"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a port with a secret, got %d", code)
+	}
+}
@@ -0,0 +1,117 @@
+package chserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// userBytes 每个用户、应用经过隧道的字节数，in为客户端发往服务端，out为服务端发往客户端
+	// 标签只取验证过的身份(见trafficLabels)，用户、应用的最后一个会话结束时删除
+	userBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotun_user_bytes_total",
+		Help: "Bytes transferred through the tunnels of each user and app",
+	}, []string{"user", "app", "direction"})
+
+	// quotaRejections 因超出配额被拒绝的请求数
+	quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotun_quota_rejections_total",
+		Help: "Sessions and port allocations rejected because of user quotas",
+	}, []string{"user", "quota"})
+
+	// sessionsActive 当前连接中的会话数
+	sessionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cotun_sessions_active",
+		Help: "Live tunnel sessions",
+	})
+
+	// channelsActive 当前打开的通道数，remote由客户端指定，不作为标签，会话的通道数见会话API
+	channelsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cotun_channels_active",
+		Help: "Open SSH channels",
+	})
+
+	// channelsTotal 累计打开的通道数
+	channelsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cotun_channels_total",
+		Help: "SSH channels opened",
+	})
+
+	// handshakeFailures 握手失败数，reason: auth(请求头认证)、ssh(SSH握手)、config(配置校验)
+	handshakeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotun_handshake_failures_total",
+		Help: "Failed tunnel handshakes by stage",
+	}, []string{"reason"})
+
+	// ingressRequests HTTP入口的请求数，result: proxied、not_found、unauthorized、forbidden、bad_gateway
+	ingressRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotun_ingress_requests_total",
+		Help: "Requests to the HTTP ingress of the reverse tunnels by result",
+	}, []string{"result"})
+
+	// aclRejections 被ACL拒绝的反向端口连接数，reason: ip、secret、tls、ingress(入口不能完成密钥或mTLS握手)
+	aclRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cotun_acl_rejections_total",
+		Help: "Connections to reverse ports rejected by the ACLs by reason",
+	}, []string{"reason"})
+
+	// auditDropped 因积压或写出失败丢弃的审计事件数
+	auditDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cotun_audit_dropped_total",
+		Help: "Audit events dropped because the sink was too slow or failed",
+	})
+
+	// reconnects 之前连接过的客户端重新连接的次数
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cotun_reconnects_total",
+		Help: "Sessions opened by clients which were connected before",
+	})
+)
+
+// 未认证会话的流量统计在这个用户名下
+const anonymousUser = "anonymous"
+
+// trafficLabels 流量指标的用户、应用标签
+// 请求头中的X-User-Id、X-App-Name由客户端自行填写，只有用户经过令牌或认证文件验证时才作为标签
+func trafficLabels(verifiedUser, appName string) (string, string) {
+	if verifiedUser == "" {
+		return anonymousUser, ""
+	}
+	return verifiedUser, appName
+}
+
+// observeChannel 统计通道的打开和关闭
+func observeChannel(delta int) {
+	if delta > 0 {
+		channelsTotal.Inc()
+	}
+	channelsActive.Add(float64(delta))
+}
+
+// portPoolCollector 在采集时统计端口池的使用情况
+type portPoolCollector struct {
+	allocator *PortAllocator
+	size      *prometheus.Desc
+	allocs    *prometheus.Desc
+}
+
+func newPortPoolCollector(allocator *PortAllocator) *portPoolCollector {
+	return &portPoolCollector{
+		allocator: allocator,
+		size:      prometheus.NewDesc("cotun_port_pool_size", "Mapping ports available for allocation", nil, nil),
+		allocs:    prometheus.NewDesc("cotun_port_pool_allocations", "Mapping port allocations by status", []string{"status"}, nil),
+	}
+}
+
+func (c *portPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.allocs
+}
+
+func (c *portPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	size, counts := c.allocator.Stats()
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(size))
+	for _, status := range []PortStatus{Allocated, Connected, Freed} {
+		ch <- prometheus.MustNewConstMetric(c.allocs, prometheus.GaugeValue, float64(counts[status]), string(status))
+	}
+}
@@ -3,23 +3,43 @@ package chserver
 import (
 	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 )
 
+// DefaultPortLease 端口租约默认时长，隧道断开后端口仍为该客户端保留这么久
+const DefaultPortLease = 10 * time.Minute
+
+// 从共享存储同步其它副本分配的最短间隔，端口的归属最终以存储中的租约为准
+const portSyncInterval = 2 * time.Second
+
+// errLeaseLost 端口的租约已被其它客户端取得
+var errLeaseLost = errors.New("lease was taken by another client")
+
 /**
  * PortAllocator manages available ports allocation
  * @description
  * - Maintains port pool with unused/allocated/occupied states
  * - Provides thread-safe port allocation
  * - Supports port lookup by clientId and appName
+ * - Allocations are leases: connected sessions renew them, unrenewed leases expire
+ * - With a PortStore, leases survive restarts and are shared between replicas
+ * - The store is read and written without holding mu, a slow store doesn't stall the
+ *   other allocations, lookups and renewals; the state is checked again once mu is held
  */
 type PortAllocator struct {
-	mu      sync.Mutex
-	names   map[string]*PortAllocation // key: "clientId-appName" -> alloc
-	ports   map[int]*PortAllocation    // port number -> alloc
-	minPort int
-	maxPort int
+	mu       sync.Mutex
+	names    map[string]*PortAllocation // key: "clientId-userId-appName" -> alloc
+	ports    map[int]*PortAllocation    // port number -> alloc
+	live     map[int]*PortAllocation    // 本实例上正在连接的隧道，port number -> alloc
+	minPort  int
+	maxPort  int
+	store    PortStore     // 持久化存储，为nil时只保存在内存中
+	leaseTTL time.Duration // 租约时长
+	synced   time.Time     // 上次从共享存储同步的时间
+	version  int           // 本地分配记录的版本，增删记录时递增，用于判断同步期间是否有变化
+	pending  map[int]bool  // 正在向存储申请租约的端口，申请期间不再分配给其它请求
 }
 
 /**
@@ -30,10 +50,171 @@ type PortAllocator struct {
  */
 func NewPortAllocator(minPort, maxPort int) *PortAllocator {
 	return &PortAllocator{
-		names:   make(map[string]*PortAllocation),
-		ports:   make(map[int]*PortAllocation),
-		minPort: minPort,
-		maxPort: maxPort,
+		names:    make(map[string]*PortAllocation),
+		ports:    make(map[int]*PortAllocation),
+		live:     make(map[int]*PortAllocation),
+		pending:  make(map[int]bool),
+		minPort:  minPort,
+		maxPort:  maxPort,
+		leaseTTL: DefaultPortLease,
+	}
+}
+
+/**
+ * UseStore sets the lease store and restores the allocations kept in it
+ * @param {PortStore} store - Lease store, nil keeps allocations in memory only
+ * @param {time.Duration} ttl - Lease duration, 0 keeps DefaultPortLease
+ * @returns {error} Error if the store can't be read
+ * @description
+ * - 本地存储中处于连接状态的分配在重启后已经断开，恢复为已分配状态，等待客户端用原端口重连
+ */
+func (pa *PortAllocator) UseStore(store PortStore, ttl time.Duration) error {
+	var allocs []PortAllocation
+	if store != nil {
+		var err error
+		if allocs, err = store.List(); err != nil {
+			return err
+		}
+	}
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if ttl > 0 {
+		pa.leaseTTL = ttl
+	}
+	pa.store = store
+	for i := range allocs {
+		alloc := &allocs[i]
+		if alloc.Status == Connected && !store.Shared() {
+			alloc.Status = Allocated
+			alloc.StartTime = nil
+		}
+		pa.put(alloc)
+	}
+	return nil
+}
+
+// LeaseTTL 租约时长
+func (pa *PortAllocator) LeaseTTL() time.Duration {
+	return pa.leaseTTL
+}
+
+func (pa *PortAllocator) put(alloc *PortAllocation) {
+	if old, exists := pa.ports[alloc.MappingPort]; exists {
+		delete(pa.names, allocKey(old.ClientId, old.UserId, old.AppName))
+	}
+	pa.names[allocKey(alloc.ClientId, alloc.UserId, alloc.AppName)] = alloc
+	pa.ports[alloc.MappingPort] = alloc
+	pa.version++
+}
+
+// remove 删除分配记录，键或端口已经属于另一个分配时保留那个分配
+func (pa *PortAllocator) remove(alloc *PortAllocation) {
+	key := allocKey(alloc.ClientId, alloc.UserId, alloc.AppName)
+	if cur, exists := pa.names[key]; exists && cur.MappingPort == alloc.MappingPort {
+		delete(pa.names, key)
+	}
+	if cur, exists := pa.ports[alloc.MappingPort]; exists && allocKey(cur.ClientId, cur.UserId, cur.AppName) == key {
+		delete(pa.ports, alloc.MappingPort)
+		delete(pa.live, alloc.MappingPort)
+	}
+	pa.version++
+}
+
+/**
+ *	续租并写入存储，端口已被其它副本占用时返回false
+ *	调用时持有mu，写入存储期间释放mu，返回时重新持有，调用者需要重新检查本地记录
+ */
+func (pa *PortAllocator) lease(alloc *PortAllocation) (bool, error) {
+	expire := time.Now().Local().Add(pa.leaseTTL)
+	alloc.ExpireTime = &expire
+	store := pa.store
+	if store == nil {
+		return true, nil
+	}
+	key, ttl := allocKey(alloc.ClientId, alloc.UserId, alloc.AppName), pa.leaseTTL
+	claimed := *alloc
+	pa.mu.Unlock()
+	defer pa.mu.Lock()
+	return store.Claim(key, &claimed, ttl)
+}
+
+/**
+ *	为已有的分配续租，租约已被其它客户端取得时删除本地记录并返回errLeaseLost
+ *	续租期间分配已被释放时，交还刚续的租约，同样返回errLeaseLost
+ */
+func (pa *PortAllocator) renew(alloc *PortAllocation) error {
+	ok, err := pa.lease(alloc)
+	if err != nil {
+		return err
+	}
+	if pa.ports[alloc.MappingPort] != alloc {
+		if ok {
+			pa.unlease(allocKey(alloc.ClientId, alloc.UserId, alloc.AppName), alloc.MappingPort)
+		}
+		return fmt.Errorf("port %d: %w", alloc.MappingPort, errLeaseLost)
+	}
+	if !ok {
+		pa.remove(alloc)
+		return fmt.Errorf("port %d: %w", alloc.MappingPort, errLeaseLost)
+	}
+	return nil
+}
+
+// 删除存储中的租约，调用时持有mu，删除期间释放mu
+func (pa *PortAllocator) unlease(key string, port int) {
+	store := pa.store
+	if store == nil {
+		return
+	}
+	pa.mu.Unlock()
+	defer pa.mu.Lock()
+	if err := store.Release(key, port); err != nil {
+		log.Printf("port allocator: release port %d: %v", port, err)
+	}
+}
+
+/**
+ *	从共享存储同步其它副本的分配，本实例正在连接的隧道以本地为准
+ *	每portSyncInterval最多同步一次，期间使用本地记录；分配和续租由存储的Claim保证不会冲突
+ *	调用时不持有mu，读取存储期间本地记录有增删时放弃这次结果，下次调用再同步
+ */
+func (pa *PortAllocator) sync() {
+	pa.mu.Lock()
+	store := pa.store
+	now := time.Now()
+	if store == nil || !store.Shared() || now.Sub(pa.synced) < portSyncInterval {
+		pa.mu.Unlock()
+		return
+	}
+	pa.synced = now
+	version := pa.version
+	pa.mu.Unlock()
+
+	allocs, err := store.List()
+	if err != nil {
+		log.Printf("port allocator: sync store: %v", err)
+		return
+	}
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	if pa.version != version {
+		pa.synced = time.Time{}
+		return
+	}
+	seen := make(map[int]bool, len(allocs))
+	for i := range allocs {
+		alloc := &allocs[i]
+		seen[alloc.MappingPort] = true
+		if _, exists := pa.live[alloc.MappingPort]; exists {
+			continue
+		}
+		pa.put(alloc)
+	}
+	for port, alloc := range pa.ports {
+		if _, exists := pa.live[port]; !seen[port] && !exists {
+			pa.remove(alloc)
+		}
 	}
 }
 
@@ -45,53 +226,91 @@ func NewPortAllocator(minPort, maxPort int) *PortAllocator {
  * @returns {PortAllocation, error} Allocation details or error
  */
 func (pa *PortAllocator) AllocatePort(clientId, userId, appName string, clientPort int) (PortAllocation, error) {
+	return pa.AllocatePortIn(clientId, userId, appName, clientPort, pa.minPort, pa.maxPort)
+}
+
+/**
+ * AllocatePortIn assigns a new port mapping inside [minPort, maxPort]
+ * @description
+ * - The range is narrowed to the allocator range, used to apply user port quotas
+ */
+func (pa *PortAllocator) AllocatePortIn(clientId, userId, appName string, clientPort, minPort, maxPort int) (PortAllocation, error) {
+	pa.sync()
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
+	if minPort < pa.minPort {
+		minPort = pa.minPort
+	}
+	if maxPort > pa.maxPort {
+		maxPort = pa.maxPort
+	}
+
 	now := time.Now().Local()
-	key := clientId + "-" + userId + "-" + appName
-	if alloc, exists := pa.names[key]; exists {
+	if alloc, exists := pa.find(clientId, userId, appName); exists {
 		alloc.ClientPort = clientPort
-		alloc.Status = Allocated
+		if alloc.Status != Connected {
+			alloc.Status = Allocated
+			alloc.StartTime = nil
+		}
 		alloc.AllocTime = &now
-		alloc.StartTime = nil
-		return *alloc, nil
+		// 原端口已被其它客户端取得时，重新分配一个端口
+		err := pa.renew(alloc)
+		if err == nil {
+			return *alloc, nil
+		}
+		if !errors.Is(err, errLeaseLost) {
+			return PortAllocation{}, err
+		}
+	}
+	newAlloc := func(port int) (*PortAllocation, error) {
+		alloc := &PortAllocation{
+			ClientId:    clientId,
+			UserId:      userId,
+			AppName:     appName,
+			ClientPort:  clientPort,
+			MappingPort: port,
+			AllocTime:   &now,
+			StartTime:   nil,
+			Status:      Allocated,
+		}
+		pa.pending[port] = true
+		ok, err := pa.lease(alloc)
+		delete(pa.pending, port)
+		if err != nil || !ok {
+			return nil, err
+		}
+		// 申请租约期间同一客户端的另一个请求已经分配了端口，交还这个端口
+		if existing, exists := pa.find(clientId, userId, appName); exists && existing.Status != Freed {
+			pa.unlease(allocKey(clientId, userId, appName), port)
+			return existing, nil
+		}
+		pa.put(alloc)
+		return alloc, nil
 	}
 	//先分配空槽
-	for port := pa.minPort; port <= pa.maxPort; port++ {
-		_, exists := pa.ports[port]
-		if !exists {
-			alloc := &PortAllocation{
-				ClientId:    clientId,
-				UserId:      userId,
-				AppName:     appName,
-				ClientPort:  clientPort,
-				MappingPort: port,
-				AllocTime:   &now,
-				StartTime:   nil,
-				Status:      Allocated,
-			}
-			pa.names[key] = alloc
-			pa.ports[port] = alloc
+	for port := minPort; port <= maxPort; port++ {
+		if _, exists := pa.ports[port]; exists || pa.pending[port] {
+			continue
+		}
+		alloc, err := newAlloc(port)
+		if err != nil {
+			return PortAllocation{}, err
+		}
+		if alloc != nil {
 			return *alloc, nil
 		}
 	}
 	// 再分配回收再利用的旧槽
-	for port := pa.minPort; port <= pa.maxPort; port++ {
-		alloc, exists := pa.ports[port]
-		if exists && alloc.Status == Freed {
-			alloc = &PortAllocation{
-				ClientId:    clientId,
-				UserId:      userId,
-				AppName:     appName,
-				ClientPort:  clientPort,
-				MappingPort: port,
-				AllocTime:   &now,
-				StartTime:   nil,
-				Status:      Allocated,
-			}
-			pa.names[key] = alloc
-			pa.ports[port] = alloc
+	for port := minPort; port <= maxPort; port++ {
+		if old, exists := pa.ports[port]; !exists || old.Status != Freed || pa.pending[port] {
+			continue
+		}
+		alloc, err := newAlloc(port)
+		if err != nil {
+			return PortAllocation{}, err
+		}
+		if alloc != nil {
 			return *alloc, nil
 		}
 	}
@@ -99,11 +318,19 @@ func (pa *PortAllocator) AllocatePort(clientId, userId, appName string, clientPo
 	return PortAllocation{}, errors.New("no available ports")
 }
 
+// find 查找客户端、用户和应用都一致的分配记录，不会把其他用户的端口和租约交给调用者
+func (pa *PortAllocator) find(clientId, userId, appName string) (*PortAllocation, bool) {
+	alloc, exists := pa.names[allocKey(clientId, userId, appName)]
+	if !exists || alloc.ClientId != clientId || alloc.UserId != userId || alloc.AppName != appName {
+		return nil, false
+	}
+	return alloc, true
+}
+
 func (pa *PortAllocator) applyNew(clientId, userId, appName string, clientPort, mappingPort int) (*PortAllocation, error) {
 	//	先找该客户端的分配记录
 	now := time.Now().Local()
-	key := clientId + "-" + userId + "-" + appName
-	if alloc, exists := pa.names[key]; exists {
+	if alloc, exists := pa.find(clientId, userId, appName); exists {
 		if clientPort != alloc.ClientPort {
 			return nil, fmt.Errorf("client port conflict: %d - %d", clientPort, alloc.ClientPort)
 		}
@@ -112,6 +339,10 @@ func (pa *PortAllocator) applyNew(clientId, userId, appName string, clientPort,
 		}
 		alloc.Status = Connected
 		alloc.StartTime = &now
+		if err := pa.renew(alloc); err != nil {
+			return nil, err
+		}
+		pa.live[mappingPort] = alloc
 		return alloc, nil
 	}
 	//	如果没找到，说明之前没申请过，可能是因为cotund重启，导致客户端使用原端口重新连接
@@ -119,6 +350,9 @@ func (pa *PortAllocator) applyNew(clientId, userId, appName string, clientPort,
 	if p, exists := pa.ports[mappingPort]; exists {
 		return nil, fmt.Errorf("mapping port already allocated to: %+v", p)
 	}
+	if pa.pending[mappingPort] {
+		return nil, fmt.Errorf("mapping port %d is being allocated", mappingPort)
+	}
 
 	alloc := &PortAllocation{
 		ClientId:    clientId,
@@ -130,8 +364,17 @@ func (pa *PortAllocator) applyNew(clientId, userId, appName string, clientPort,
 		StartTime:   &now,
 		Status:      Connected,
 	}
-	pa.names[key] = alloc
-	pa.ports[mappingPort] = alloc
+	pa.pending[mappingPort] = true
+	ok, err := pa.lease(alloc)
+	delete(pa.pending, mappingPort)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("mapping port %d is leased by another client", mappingPort)
+	}
+	pa.put(alloc)
+	pa.live[mappingPort] = alloc
 	return alloc, nil
 }
 
@@ -149,6 +392,10 @@ func (pa *PortAllocator) applyOld(clientPort, mappingPort int) (*PortAllocation,
 	now := time.Now().Local()
 	alloc.StartTime = &now
 	alloc.Status = Connected
+	if err := pa.renew(alloc); err != nil {
+		return nil, err
+	}
+	pa.live[mappingPort] = alloc
 	return alloc, nil
 }
 
@@ -158,6 +405,7 @@ func (pa *PortAllocator) applyOld(clientPort, mappingPort int) (*PortAllocation,
  *	新版本的cotun客户端，会在http请求头中携带X-Client-Id, X-User-Id, X-App-Name
  */
 func (pa *PortAllocator) OnConnected(c *PortAllocation, clientPort, mappingPort int) (*PortAllocation, error) {
+	pa.sync()
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
@@ -168,16 +416,39 @@ func (pa *PortAllocator) OnConnected(c *PortAllocation, clientPort, mappingPort
 }
 
 /**
- *	隧道连接断开，端口仍然给该客户端保留10min
+ *	隧道连接仍然存活，续租
+ *	端口的租约已被其它副本取得时删除本地记录，返回errLeaseLost，调用者应关闭隧道
+ */
+func (pa *PortAllocator) Renew(alloc *PortAllocation) error {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if pa.live[alloc.MappingPort] != alloc {
+		return nil
+	}
+	return pa.renew(alloc)
+}
+
+/**
+ *	隧道连接断开，端口仍然给该客户端保留一个租约时长
+ *	续租失败时释放端口，不再保留
  */
 func (pa *PortAllocator) OnDisconnected(alloc *PortAllocation) {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
+	if pa.live[alloc.MappingPort] != alloc {
+		return
+	}
+	delete(pa.live, alloc.MappingPort)
 	now := time.Now().Local()
 	alloc.Status = Allocated
 	alloc.AllocTime = &now
 	alloc.StartTime = nil
+	if err := pa.renew(alloc); err != nil {
+		log.Printf("port allocator: renew port %d: %v, release it", alloc.MappingPort, err)
+		pa.release(*alloc)
+	}
 }
 
 /**
@@ -186,6 +457,7 @@ func (pa *PortAllocator) OnDisconnected(alloc *PortAllocation) {
  * @param {string} appName - Application name (empty releases all client ports)
  */
 func (pa *PortAllocator) FreePort(clientId, userId, appName string) []PortAllocation {
+	pa.sync()
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
@@ -203,19 +475,22 @@ func (pa *PortAllocator) FreePort(clientId, userId, appName string) []PortAlloca
 		return allocs
 	}
 
-	key := clientId + "-" + userId + "-" + appName
-	if alloc, exists := pa.names[key]; exists {
+	if alloc, exists := pa.find(clientId, userId, appName); exists {
 		alloc.Status = Freed
 		allocs = append(allocs, *alloc)
 	}
 	for _, a := range allocs {
-		key := a.ClientId + "-" + a.UserId + "-" + a.AppName
-		delete(pa.names, key)
-		delete(pa.ports, a.MappingPort)
+		pa.release(a)
 	}
 	return allocs
 }
 
+// release 删除分配记录及其租约，调用时持有mu，删除租约期间释放mu
+func (pa *PortAllocator) release(a PortAllocation) {
+	pa.remove(&a)
+	pa.unlease(allocKey(a.ClientId, a.UserId, a.AppName), a.MappingPort)
+}
+
 /**
  * LookupPort finds allocated port for client/app
  * @param {string} clientId - Client identifier
@@ -223,11 +498,11 @@ func (pa *PortAllocator) FreePort(clientId, userId, appName string) []PortAlloca
  * @returns {int, error} Port number or error if not found
  */
 func (pa *PortAllocator) LookupPort(clientId, userId, appName string) (*PortAllocation, error) {
+	pa.sync()
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
-	key := clientId + "-" + userId + "-" + appName
-	if alloc, exists := pa.names[key]; exists {
+	if alloc, exists := pa.find(clientId, userId, appName); exists {
 		if alloc.Status == Freed {
 			return nil, errors.New("port mapping not found")
 		}
@@ -239,6 +514,7 @@ func (pa *PortAllocator) LookupPort(clientId, userId, appName string) (*PortAllo
 func (pa *PortAllocator) QueryPorts(clientId, userId, appName string) []PortAllocation {
 	ports := []PortAllocation{}
 
+	pa.sync()
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
@@ -257,22 +533,60 @@ func (pa *PortAllocator) QueryPorts(clientId, userId, appName string) []PortAllo
 	return ports
 }
 
-func (pa *PortAllocator) FreeHangingPorts() []PortAllocation {
+/**
+ *	释放租约已到期的端口
+ *	连接中的端口由会话续租，不会到期；共享存储中的租约由存储自行过期，这里只清理本地记录
+ */
+func (pa *PortAllocator) ExpireLeases() []PortAllocation {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
 	ports := []PortAllocation{}
 	now := time.Now()
 	for _, alloc := range pa.names {
-		if alloc.Status == Allocated && now.Sub(*alloc.AllocTime) > 10*time.Minute {
+		if _, exists := pa.live[alloc.MappingPort]; !exists && alloc.ExpireTime != nil && now.After(*alloc.ExpireTime) {
 			alloc.Status = Freed
 			ports = append(ports, *alloc)
 		}
 	}
-	for _, a := range ports {
-		key := a.ClientId + "-" + a.UserId + "-" + a.AppName
-		delete(pa.names, key)
-		delete(pa.ports, a.MappingPort)
+	for i := range ports {
+		if pa.store != nil && pa.store.Shared() {
+			pa.remove(&ports[i])
+			continue
+		}
+		pa.release(ports[i])
 	}
 	return ports
 }
+
+// Stats 端口池大小及各状态的分配数
+func (pa *PortAllocator) Stats() (int, map[PortStatus]int) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	counts := map[PortStatus]int{}
+	for _, alloc := range pa.ports {
+		counts[alloc.Status]++
+	}
+	return pa.maxPort - pa.minPort + 1, counts
+}
+
+// FindLive 查找本实例上连接中、满足条件的隧道，多个满足时取最近建立的
+func (pa *PortAllocator) FindLive(match func(alloc *PortAllocation) bool) (PortAllocation, bool) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	var found *PortAllocation
+	for _, alloc := range pa.live {
+		if alloc.Status != Connected || !match(alloc) {
+			continue
+		}
+		if found == nil || (alloc.StartTime != nil && found.StartTime != nil && alloc.StartTime.After(*found.StartTime)) {
+			found = alloc
+		}
+	}
+	if found == nil {
+		return PortAllocation{}, false
+	}
+	return *found, true
+}
@@ -0,0 +1,281 @@
+package chserver
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPortLeaseRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.db")
+	store, err := NewPortStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pa := NewPortAllocator(30000, 30001)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := pa.AllocatePort("c1", "u1", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pa.OnConnected(&PortAllocation{ClientId: "c1", UserId: "u1", AppName: "app"}, 8080, alloc.MappingPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pa.Renew(conn); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	//restart: the port is kept for the same client, waiting for it to reconnect
+	store, err = NewPortStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	pa = NewPortAllocator(30000, 30001)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := pa.LookupPort("c1", "u1", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MappingPort != alloc.MappingPort || got.Status != Allocated {
+		t.Fatalf("unexpected restored allocation: %+v", got)
+	}
+	other, err := pa.AllocatePort("c2", "u2", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.MappingPort == alloc.MappingPort {
+		t.Fatalf("leased port %d allocated twice", other.MappingPort)
+	}
+}
+
+func TestPortLeaseExpire(t *testing.T) {
+	pa := NewPortAllocator(30000, 30000)
+	if err := pa.UseStore(nil, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := pa.AllocatePort("c1", "u1", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frees := pa.ExpireLeases(); len(frees) != 0 {
+		t.Fatalf("unexpired lease freed: %+v", frees)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if frees := pa.ExpireLeases(); len(frees) != 1 || frees[0].MappingPort != alloc.MappingPort {
+		t.Fatalf("expected lease of port %d to expire, got %+v", alloc.MappingPort, frees)
+	}
+	if _, err := pa.AllocatePort("c2", "u2", "app", 8080); err != nil {
+		t.Fatalf("expired port not reusable: %v", err)
+	}
+}
+
+// sharedStore 模拟多副本共享的存储，owners记录每个端口租约的持有者
+type sharedStore struct {
+	mu     sync.Mutex
+	owners map[int]string
+	allocs map[int]PortAllocation
+	lists  int
+	claim  chan struct{} // 不为nil时，Claim等到可以读取才写入，模拟慢的存储
+}
+
+func newSharedStore() *sharedStore {
+	return &sharedStore{owners: map[int]string{}, allocs: map[int]PortAllocation{}}
+}
+
+func (s *sharedStore) Claim(key string, alloc *PortAllocation, ttl time.Duration) (bool, error) {
+	if s.claim != nil {
+		<-s.claim
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, exists := s.owners[alloc.MappingPort]; exists && owner != key {
+		return false, nil
+	}
+	s.owners[alloc.MappingPort] = key
+	s.allocs[alloc.MappingPort] = *alloc
+	return true, nil
+}
+
+func (s *sharedStore) Release(key string, port int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[port] == key {
+		delete(s.owners, port)
+		delete(s.allocs, port)
+	}
+	return nil
+}
+
+func (s *sharedStore) List() ([]PortAllocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists++
+	allocs := []PortAllocation{}
+	for _, alloc := range s.allocs {
+		allocs = append(allocs, alloc)
+	}
+	return allocs, nil
+}
+
+func (s *sharedStore) Shared() bool { return true }
+func (s *sharedStore) Close() error { return nil }
+
+// takeOver 模拟其它副本在租约过期后取得端口
+func (s *sharedStore) takeOver(port int, key string) {
+	var ids []string
+	json.Unmarshal([]byte(key), &ids)
+	alloc := s.allocs[port]
+	alloc.ClientId, alloc.UserId, alloc.AppName = ids[0], ids[1], ids[2]
+	s.owners[port] = key
+	s.allocs[port] = alloc
+}
+
+func TestPortLeaseLost(t *testing.T) {
+	store := newSharedStore()
+	pa := NewPortAllocator(30000, 30001)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := pa.AllocatePort("c1", "u1", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pa.OnConnected(&PortAllocation{ClientId: "c1", UserId: "u1", AppName: "app"}, 8080, alloc.MappingPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.takeOver(alloc.MappingPort, allocKey("c2", "u2", "app"))
+	if err := pa.Renew(conn); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("Renew() = %v, want lease lost", err)
+	}
+	if _, err := pa.LookupPort("c1", "u1", "app"); err == nil {
+		t.Fatal("lost lease still allocated")
+	}
+	//the allocation is taken elsewhere, reallocate instead of reusing it
+	again, err := pa.AllocatePort("c1", "u1", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.MappingPort == alloc.MappingPort {
+		t.Fatalf("port %d leased by another client reused", again.MappingPort)
+	}
+	store.takeOver(again.MappingPort, allocKey("c3", "u3", "app"))
+	if _, err := pa.OnConnected(&PortAllocation{ClientId: "c1", UserId: "u1", AppName: "app"}, 8080, again.MappingPort); !errors.Is(err, errLeaseLost) {
+		t.Fatalf("OnConnected() = %v, want lease lost", err)
+	}
+}
+
+func TestPortLeaseLostOnDisconnect(t *testing.T) {
+	store := newSharedStore()
+	pa := NewPortAllocator(30000, 30001)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := pa.AllocatePort("c1", "u1", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pa.OnConnected(&PortAllocation{ClientId: "c1", UserId: "u1", AppName: "app"}, 8080, alloc.MappingPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.takeOver(alloc.MappingPort, allocKey("c2", "u2", "app"))
+	pa.OnDisconnected(conn)
+	if _, err := pa.LookupPort("c1", "u1", "app"); err == nil {
+		t.Fatal("lost lease kept after disconnect")
+	}
+	if store.owners[alloc.MappingPort] != allocKey("c2", "u2", "app") {
+		t.Fatal("released the lease of another client")
+	}
+}
+
+func TestPortSyncThrottled(t *testing.T) {
+	store := newSharedStore()
+	pa := NewPortAllocator(30000, 30009)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		pa.QueryPorts("", "", "")
+	}
+	if store.lists != 2 {
+		t.Fatalf("store listed %d times, want 2", store.lists)
+	}
+	pa.synced = time.Now().Add(-portSyncInterval)
+	pa.QueryPorts("", "", "")
+	if store.lists != 3 {
+		t.Fatalf("store listed %d times after the sync interval, want 3", store.lists)
+	}
+}
+
+func TestPortAllocKeyUnambiguous(t *testing.T) {
+	pa := NewPortAllocator(30000, 30001)
+	bob, err := pa.AllocatePort("c", "bob", "x-y", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the fields joined with dashes are the same, the allocations are not
+	other, err := pa.AllocatePort("c-bob", "x", "y", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.MappingPort == bob.MappingPort {
+		t.Fatalf("user x got the port %d of bob", bob.MappingPort)
+	}
+	if _, err := pa.LookupPort("c-bob", "x", "y"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortStoreOutsideLock(t *testing.T) {
+	store := newSharedStore()
+	pa := NewPortAllocator(30000, 30001)
+	if err := pa.UseStore(store, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	pa.QueryPorts("", "", "")
+	store.claim = make(chan struct{})
+	done := make(chan PortAllocation)
+	go func() {
+		alloc, err := pa.AllocatePort("c1", "u1", "app", 8080)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- alloc
+	}()
+	//the allocator keeps serving while the store is slow
+	for {
+		if _, err := pa.LookupPort("c1", "u1", "app"); err == nil {
+			t.Fatal("allocation visible before its lease was claimed")
+		}
+		pa.mu.Lock()
+		pending := len(pa.pending)
+		pa.mu.Unlock()
+		if pending == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if size, _ := pa.Stats(); size != 2 {
+		t.Fatalf("pool size %d, want 2", size)
+	}
+	//a port being claimed is not handed out twice
+	go close(store.claim)
+	other, err := pa.AllocatePort("c2", "u2", "app", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc := <-done; alloc.MappingPort == other.MappingPort {
+		t.Fatalf("port %d allocated twice", other.MappingPort)
+	}
+}
@@ -0,0 +1,68 @@
+package chserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/**
+ * PortStore persists port allocations as leases
+ * @description
+ * - Each mapping port is owned by one allocation key (clientId-userId-appName)
+ * - A lease not renewed before its expiry may be claimed by another key
+ * - Shared backends (redis) let several cotun replicas allocate from the same port range
+ *   without conflicts, local backends (bolt) let allocations survive restarts
+ */
+type PortStore interface {
+	// Claim takes or renews the lease of alloc.MappingPort for key.
+	// Returns false if the port is leased to another key and the lease has not expired.
+	Claim(key string, alloc *PortAllocation, ttl time.Duration) (bool, error)
+	// Release drops the lease of port if it is still owned by key
+	Release(key string, port int) error
+	// List returns all unexpired leases
+	List() ([]PortAllocation, error)
+	// Shared reports whether the store is shared by several cotun replicas
+	Shared() bool
+	Close() error
+}
+
+// portLease is the persisted form of a lease
+type portLease struct {
+	Key        string         `json:"key"`
+	Alloc      PortAllocation `json:"alloc"`
+	ExpireTime time.Time      `json:"expireTime"`
+}
+
+// allocKey 分配记录及租约所有者的键，编码为JSON数组，不同的客户端、用户和应用不会得到相同的键
+func allocKey(clientId, userId, appName string) string {
+	b, _ := json.Marshal([]string{clientId, userId, appName})
+	return string(b)
+}
+
+/**
+ * NewPortStore opens the port store described by uri
+ * @param {string} uri - bolt:///path/to/ports.db, a plain file path, or redis://[:password@]host:port/db
+ * @returns {PortStore, error} Opened store, nil if uri is empty (allocations kept in memory only)
+ */
+func NewPortStore(uri string) (PortStore, error) {
+	if uri == "" {
+		return nil, nil
+	}
+	if !strings.Contains(uri, "://") {
+		return newBoltPortStore(uri)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port store '%s': %w", uri, err)
+	}
+	switch u.Scheme {
+	case "bolt", "file":
+		return newBoltPortStore(u.Host + u.Path)
+	case "redis", "rediss":
+		return newRedisPortStore(uri)
+	}
+	return nil, fmt.Errorf("unsupported port store '%s'", u.Scheme)
+}
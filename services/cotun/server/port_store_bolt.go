@@ -0,0 +1,89 @@
+package chserver
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var portsBucket = []byte("ports")
+
+// boltPortStore keeps leases in a local bbolt file, for a single cotun instance
+type boltPortStore struct {
+	db *bolt.DB
+}
+
+func newBoltPortStore(path string) (*boltPortStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(portsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltPortStore{db: db}, nil
+}
+
+func (s *boltPortStore) Claim(key string, alloc *PortAllocation, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(portsBucket)
+		id := []byte(strconv.Itoa(alloc.MappingPort))
+		if data := b.Get(id); data != nil {
+			var old portLease
+			if json.Unmarshal(data, &old) == nil && old.Key != key && time.Now().Before(old.ExpireTime) {
+				return nil
+			}
+		}
+		data, err := json.Marshal(portLease{Key: key, Alloc: *alloc, ExpireTime: time.Now().Add(ttl)})
+		if err != nil {
+			return err
+		}
+		claimed = true
+		return b.Put(id, data)
+	})
+	return claimed, err
+}
+
+func (s *boltPortStore) Release(key string, port int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(portsBucket)
+		id := []byte(strconv.Itoa(port))
+		var old portLease
+		if data := b.Get(id); data == nil || json.Unmarshal(data, &old) != nil || old.Key != key {
+			return nil
+		}
+		return b.Delete(id)
+	})
+}
+
+func (s *boltPortStore) List() ([]PortAllocation, error) {
+	allocs := []PortAllocation{}
+	now := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(portsBucket).ForEach(func(_, data []byte) error {
+			var l portLease
+			if json.Unmarshal(data, &l) == nil && now.Before(l.ExpireTime) {
+				l.Alloc.ExpireTime = &l.ExpireTime
+				allocs = append(allocs, l.Alloc)
+			}
+			return nil
+		})
+	})
+	return allocs, err
+}
+
+func (s *boltPortStore) Shared() bool {
+	return false
+}
+
+func (s *boltPortStore) Close() error {
+	return s.db.Close()
+}
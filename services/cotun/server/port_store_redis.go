@@ -0,0 +1,119 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisPortPrefix = "cotun:port:"
+
+// Take the lease if it is free or already owned by the caller (ARGV[1]=key, ARGV[2]=lease, ARGV[3]=ttl ms)
+var redisClaimScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+  local ok, lease = pcall(cjson.decode, cur)
+  if ok and lease.key ~= ARGV[1] then
+    return 0
+  end
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1`)
+
+// Delete the lease only if it is owned by the caller (ARGV[1]=key)
+var redisReleaseScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+  local ok, lease = pcall(cjson.decode, cur)
+  if ok and lease.key == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+  end
+end
+return 0`)
+
+// redisPortStore keeps leases in redis, shared by all cotun replicas
+type redisPortStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func newRedisPortStore(uri string) (*redisPortStore, error) {
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisPortStore{client: redis.NewClient(opts), timeout: 5 * time.Second}
+	ctx, cancel := s.context()
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *redisPortStore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *redisPortStore) Claim(key string, alloc *PortAllocation, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(portLease{Key: key, Alloc: *alloc, ExpireTime: time.Now().Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	n, err := redisClaimScript.Run(ctx, s.client, []string{redisPortPrefix + strconv.Itoa(alloc.MappingPort)},
+		key, data, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *redisPortStore) Release(key string, port int) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return redisReleaseScript.Run(ctx, s.client, []string{redisPortPrefix + strconv.Itoa(port)}, key).Err()
+}
+
+func (s *redisPortStore) List() ([]PortAllocation, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	allocs := []PortAllocation{}
+	iter := s.client.Scan(ctx, 0, redisPortPrefix+"*", 256).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return allocs, nil
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue // expired between SCAN and MGET
+		}
+		var l portLease
+		if json.Unmarshal([]byte(str), &l) == nil {
+			l.Alloc.ExpireTime = &l.ExpireTime
+			allocs = append(allocs, l.Alloc)
+		}
+	}
+	return allocs, nil
+}
+
+func (s *redisPortStore) Shared() bool {
+	return true
+}
+
+func (s *redisPortStore) Close() error {
+	return s.client.Close()
+}
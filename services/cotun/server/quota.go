@@ -0,0 +1,223 @@
+package chserver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zgsm-ai/cotun/share/cnet"
+	"github.com/zgsm-ai/cotun/share/settings"
+)
+
+/**
+ * quotaManager enforces per-user quotas
+ * @description
+ * - Quotas come from the auth file, and can be overridden at runtime through the control API
+ * - Tracks concurrent sessions and reverse ports held by the sessions of each user
+ * - Each user has one shaper, shared by all its sessions, so the bandwidth limit is per user
+ */
+type quotaManager struct {
+	users     *settings.UserIndex
+	mu        sync.Mutex
+	overrides map[string]settings.Quota
+	sessions  map[string]int
+	ports     map[string]int
+	shapers   map[string]*userShaper
+}
+
+type userShaper struct {
+	bps    int64
+	shaper *cnet.Shaper
+}
+
+// QuotaError 超出配额
+type QuotaError struct {
+	User  string
+	Quota string
+	Msg   string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded (%s) for user %s: %s", e.Quota, e.User, e.Msg)
+}
+
+func newQuotaManager(users *settings.UserIndex) *quotaManager {
+	return &quotaManager{
+		users:     users,
+		overrides: make(map[string]settings.Quota),
+		sessions:  make(map[string]int),
+		ports:     make(map[string]int),
+		shapers:   make(map[string]*userShaper),
+	}
+}
+
+func (qm *quotaManager) reject(user, quota, format string, args ...interface{}) error {
+	quotaRejections.WithLabelValues(user, quota).Inc()
+	return &QuotaError{User: user, Quota: quota, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Get 用户的配额，运行时设置的配额优先于认证文件中的配额
+func (qm *quotaManager) Get(user string) settings.Quota {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	return qm.get(user)
+}
+
+func (qm *quotaManager) get(user string) settings.Quota {
+	if q, ok := qm.overrides[user]; ok {
+		return q
+	}
+	if u, ok := qm.users.Get(user); ok && u.Quota != nil {
+		return *u.Quota
+	}
+	return settings.Quota{}
+}
+
+// UserQuota 控制面返回的用户配额及当前用量
+type UserQuota struct {
+	User     string         `json:"user"`
+	Quota    settings.Quota `json:"quota"`
+	Sessions int            `json:"sessions"`
+	Ports    int            `json:"ports"`
+}
+
+// List 所有设置了配额或者有会话的用户
+func (qm *quotaManager) List() []UserQuota {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	names := map[string]bool{}
+	for name := range qm.overrides {
+		names[name] = true
+	}
+	for name := range qm.sessions {
+		names[name] = true
+	}
+	for _, u := range qm.users.All() {
+		if u.Quota != nil {
+			names[u.Name] = true
+		}
+	}
+	list := []UserQuota{}
+	for name := range names {
+		list = append(list, qm.usage(name))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].User < list[j].User })
+	return list
+}
+
+// Usage 用户的配额及当前用量
+func (qm *quotaManager) Usage(user string) UserQuota {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	return qm.usage(user)
+}
+
+func (qm *quotaManager) usage(user string) UserQuota {
+	return UserQuota{User: user, Quota: qm.get(user), Sessions: qm.sessions[user], Ports: qm.ports[user]}
+}
+
+// Set 运行时设置用户配额
+func (qm *quotaManager) Set(user string, q settings.Quota) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	qm.mu.Lock()
+	qm.overrides[user] = q
+	qm.mu.Unlock()
+	return nil
+}
+
+// Delete 删除运行时设置的配额，恢复为认证文件中的配额
+func (qm *quotaManager) Delete(user string) bool {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	_, ok := qm.overrides[user]
+	delete(qm.overrides, user)
+	return ok
+}
+
+/**
+ * Acquire checks and takes a session of user holding the given reverse ports
+ * @param {string} user - Owner of the session
+ * @param {[]int} ports - Mapping ports of the reverse remotes of the session
+ * @returns {func(), error} Release function to call when the session ends, or a QuotaError
+ */
+func (qm *quotaManager) Acquire(user string, ports []int) (func(), error) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q := qm.get(user)
+	if q.MaxSessions > 0 && qm.sessions[user] >= q.MaxSessions {
+		return nil, qm.reject(user, "sessions", "%d concurrent sessions allowed", q.MaxSessions)
+	}
+	if err := qm.checkPorts(user, q, ports); err != nil {
+		return nil, err
+	}
+	qm.sessions[user]++
+	qm.ports[user] += len(ports)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			qm.mu.Lock()
+			defer qm.mu.Unlock()
+			qm.ports[user] -= len(ports)
+			if qm.sessions[user]--; qm.sessions[user] <= 0 {
+				delete(qm.sessions, user)
+				delete(qm.ports, user)
+			}
+		})
+	}, nil
+}
+
+func (qm *quotaManager) checkPorts(user string, q settings.Quota, ports []int) error {
+	if q.MaxPorts > 0 && qm.ports[user]+len(ports) > q.MaxPorts {
+		return qm.reject(user, "ports", "%d reverse ports allowed, %d in use", q.MaxPorts, qm.ports[user])
+	}
+	for _, port := range ports {
+		if !q.AllowPort(port) {
+			return qm.reject(user, "port_range", "port %d outside of allowed range %d-%d", port, q.MinPort, q.MaxPort)
+		}
+	}
+	return nil
+}
+
+// AcquirePorts 会话连接期间增加反向端口时占用端口配额，返回归还配额的函数，须在会话的归还函数之前调用
+func (qm *quotaManager) AcquirePorts(user string, ports []int) (func(), error) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if err := qm.checkPorts(user, qm.get(user), ports); err != nil {
+		return nil, err
+	}
+	qm.ports[user] += len(ports)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			qm.mu.Lock()
+			defer qm.mu.Unlock()
+			qm.ports[user] -= len(ports)
+		})
+	}, nil
+}
+
+// CheckAllocate 通过控制面申请新端口前，检查用户已持有的端口数
+func (qm *quotaManager) CheckAllocate(user string, held int) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q := qm.get(user)
+	if q.MaxPorts > 0 && held >= q.MaxPorts {
+		return qm.reject(user, "ports", "%d ports allowed, %d allocated", q.MaxPorts, held)
+	}
+	return nil
+}
+
+// Shaper 用户的限速器，所有会话共享；带宽配额变化时重建
+func (qm *quotaManager) Shaper(user string) *cnet.Shaper {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	bps := qm.get(user).Bandwidth
+	if s, ok := qm.shapers[user]; ok && s.bps == bps {
+		return s.shaper
+	}
+	s := &userShaper{bps: bps, shaper: cnet.NewShaper(bps)}
+	qm.shapers[user] = s
+	return s.shaper
+}
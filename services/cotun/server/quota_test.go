@@ -0,0 +1,47 @@
+package chserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+)
+
+func TestQuotaAcquire(t *testing.T) {
+	users := settings.NewUserIndex(cio.NewLogger("test"))
+	users.AddUser(&settings.User{Name: "foo", Quota: &settings.Quota{MaxSessions: 1, MaxPorts: 2, MinPort: 30000, MaxPort: 30009}})
+	qm := newQuotaManager(users)
+
+	var qe *QuotaError
+	if _, err := qm.Acquire("foo", []int{31000}); !errors.As(err, &qe) || qe.Quota != "port_range" {
+		t.Fatalf("expected port range rejection, got %v", err)
+	}
+	if _, err := qm.Acquire("foo", []int{30000, 30001, 30002}); !errors.As(err, &qe) || qe.Quota != "ports" {
+		t.Fatalf("expected ports rejection, got %v", err)
+	}
+	release, err := qm.Acquire("foo", []int{30000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qm.Acquire("foo", nil); !errors.As(err, &qe) || qe.Quota != "sessions" {
+		t.Fatalf("expected sessions rejection, got %v", err)
+	}
+	release()
+	release()
+	if u := qm.Usage("foo"); u.Sessions != 0 || u.Ports != 0 {
+		t.Fatalf("usage not released: %+v", u)
+	}
+	//runtime override wins over the auth file
+	if err := qm.Set("foo", settings.Quota{MaxSessions: 2}); err != nil {
+		t.Fatal(err)
+	}
+	r1, err := qm.Acquire("foo", []int{31000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1()
+	if _, err := qm.Acquire("foo", nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -10,16 +10,20 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/requestlog"
+	"github.com/prometheus/client_golang/prometheus"
 	chshare "github.com/zgsm-ai/cotun/share"
 	"github.com/zgsm-ai/cotun/share/ccrypto"
 	"github.com/zgsm-ai/cotun/share/cio"
 	"github.com/zgsm-ai/cotun/share/cnet"
 	"github.com/zgsm-ai/cotun/share/settings"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Config is the configuration for the cotun service
@@ -36,6 +40,13 @@ type Config struct {
 	ControlPort string // 控制面端口
 	MinPort     int
 	MaxPort     int
+	PortStore   string        // 端口租约存储，为空时只保存在内存中
+	PortLease   time.Duration // 端口租约时长
+	JWT         JWTConfig     // 控制面及隧道连接的令牌校验
+	AdminToken  string        // 控制面管理接口(配额、ACL、断开会话)的静态令牌
+	Transports  []string      // 允许的传输方式，为空时全部允许
+	Ingress     IngressConfig // 反向隧道的HTTP入口
+	Audit       AuditConfig   // 会话及连接的审计日志
 }
 
 // Server respresent a cotun service
@@ -45,12 +56,24 @@ type Server struct {
 	fingerprint   string
 	httpServer    *cnet.HTTPServer
 	controlServer *cnet.HTTPServer // 控制面服务器
+	ingressServer *cnet.HTTPServer // 反向隧道的HTTP入口
 	reverseProxy  *httputil.ReverseProxy
+	ingressOnce   sync.Once
+	ingressProxy  *http.Transport // 入口转发到映射端口的连接
 	sessCount     int32
 	sessions      *settings.Users
 	sshConfig     *ssh.ServerConfig
 	users         *settings.UserIndex
 	allocator     *PortAllocator
+	portStore     PortStore
+	verifier      *jwtVerifier // 为nil时不校验令牌
+	quotas        *quotaManager
+	acls          *aclManager          // 反向端口的访问控制
+	live          *sessionRegistry     // 连接中的隧道会话
+	registry      *prometheus.Registry // 本实例的指标，如端口池使用情况
+	transports    []string             // 允许的传输方式
+	polls         *pollSessions        // 长轮询传输的会话
+	audit         *auditor             // 为nil时不记录审计日志
 }
 
 var upgrader = websocket.Upgrader{
@@ -65,12 +88,45 @@ func NewServer(c *Config) (*Server, error) {
 		config:        c,
 		httpServer:    cnet.NewHTTPServer(),
 		controlServer: cnet.NewHTTPServer(),
+		ingressServer: cnet.NewHTTPServer(),
 		Logger:        cio.NewLogger("server"),
 		sessions:      settings.NewUsers(),
 		allocator:     NewPortAllocator(c.MinPort, c.MaxPort),
+		live:          newSessionRegistry(),
+		registry:      prometheus.NewRegistry(),
+		transports:    c.Transports,
+		polls:         &pollSessions{sessions: make(map[string]*cnet.PollConn)},
 	}
+	if len(server.transports) == 0 {
+		server.transports = cnet.Transports
+	}
+	server.registry.MustRegister(newPortPoolCollector(server.allocator))
 	server.Info = true
+	store, err := NewPortStore(c.PortStore)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.allocator.UseStore(store, c.PortLease); err != nil {
+		if store != nil {
+			store.Close()
+		}
+		return nil, err
+	}
+	server.portStore = store
+	if server.audit, err = newAuditor(&c.Audit); err != nil {
+		return nil, err
+	}
+	if c.JWT.Enabled() {
+		if server.verifier, err = newJWTVerifier(&c.JWT); err != nil {
+			return nil, err
+		}
+	}
+	if c.Ingress.Auth && server.verifier == nil {
+		return nil, errors.New("ingress authentication requires JWT verification (--jwt-jwks or --jwt-pubkey)")
+	}
 	server.users = settings.NewUserIndex(server.Logger)
+	server.quotas = newQuotaManager(server.users)
+	server.acls = newACLManager(server.users)
 	if c.AuthFile != "" {
 		if err := server.users.LoadUsers(c.AuthFile); err != nil {
 			return nil, err
@@ -85,7 +141,6 @@ func NewServer(c *Config) (*Server, error) {
 	}
 
 	var pemBytes []byte
-	var err error
 	if c.KeyFile != "" {
 		var key []byte
 
@@ -172,9 +227,15 @@ func (s *Server) StartContext(ctx context.Context, host, port string) error {
 	if s.users.Len() > 0 {
 		s.Infof("User authentication enabled")
 	}
+	if s.verifier != nil {
+		s.Infof("JWT authentication enabled")
+	}
 	if s.reverseProxy != nil {
 		s.Infof("Reverse proxy enabled")
 	}
+	if s.audit != nil {
+		s.Infof("Audit log enabled (%s)", s.config.Audit.Sink)
+	}
 
 	// 启动主HTTP服务器
 	l, err := s.listener(host, port)
@@ -187,22 +248,28 @@ func (s *Server) StartContext(ctx context.Context, host, port string) error {
 		o.TrustProxy = true
 		h = requestlog.WrapWith(h, o)
 	}
+	// 明文连接上的HTTP/2(h2c)
+	h = h2c.NewHandler(h, &http2.Server{})
 
 	// 启动控制面服务器
 	if s.config.ControlPort != "" {
 		go s.startControlServer(ctx)
 	}
-	go s.RunFreeHangingTimer(ctx)
+	// 启动反向隧道的HTTP入口
+	if s.config.Ingress.Port != "" {
+		go s.startIngress(ctx)
+	}
+	go s.RunLeaseTimer(ctx)
 	return s.httpServer.GoServe(ctx, l, h)
 }
 
 /**
- * RunFreeHangingTimer 定期执行FreeHangingPorts函数
+ * RunLeaseTimer 定期释放租约到期的端口
  * @param {context.Context} ctx - 上下文参数，用于控制定时器的生命周期
- * 该函数每5分钟执行一次FreeHangingPorts，如果上下文通道被关闭，则退出。
+ * 该函数每半个租约时长执行一次ExpireLeases，如果上下文通道被关闭，则退出。
  */
-func (s *Server) RunFreeHangingTimer(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
+func (s *Server) RunLeaseTimer(ctx context.Context) {
+	ticker := time.NewTicker(s.allocator.LeaseTTL() / 2)
 	defer ticker.Stop()
 
 	for {
@@ -211,10 +278,12 @@ func (s *Server) RunFreeHangingTimer(ctx context.Context) {
 			// 上下文通道被关闭，退出定时器
 			return
 		case <-ticker.C:
-			// 每5分钟执行一次FreeHangingPorts
-			frees := s.allocator.FreeHangingPorts()
+			frees := s.allocator.ExpireLeases()
 			if len(frees) > 0 {
-				s.Infof("Free hanging ports: %v", frees)
+				s.Infof("Expired port leases: %v", frees)
+			}
+			for _, p := range frees {
+				s.auditPort(AuditPortFree, p, "lease expired")
 			}
 		}
 	}
@@ -250,7 +319,12 @@ func (s *Server) Wait() error {
 
 // Close forcibly closes the http server
 func (s *Server) Close() error {
-	return s.httpServer.Close()
+	err := s.httpServer.Close()
+	if s.portStore != nil {
+		s.portStore.Close()
+	}
+	s.audit.Close()
+	return err
 }
 
 // GetFingerprint is used to access the server fingerprint
@@ -269,6 +343,12 @@ func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions
 	user, found := s.users.Get(n)
 	if !found || user.Pass != string(password) {
 		s.Debugf("Login failed for user: %s", n)
+		s.audit.Log(AuditEvent{
+			Type:       AuditAuthFailure,
+			User:       n,
+			RemoteAddr: c.RemoteAddr().String(),
+			Detail:     "invalid password",
+		})
 		return nil, errors.New("Invalid authentication for username: %s")
 	}
 	// insert the user session map
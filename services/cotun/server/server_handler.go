@@ -1,8 +1,12 @@
 package chserver
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,6 +14,8 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	chshare "github.com/zgsm-ai/cotun/share"
 	"github.com/zgsm-ai/cotun/share/cio"
 	"github.com/zgsm-ai/cotun/share/cnet"
@@ -33,6 +39,15 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		s.Infof("ignored client connection using protocol '%s', expected '%s'",
 			protocol, chshare.ProtocolVersion)
 	}
+	//other transports AND has cotun protocol
+	if version := r.Header.Get(cnet.ProtocolHeader); version != "" {
+		if version == chshare.ProtocolVersion {
+			s.handleTransport(w, r)
+			return
+		}
+		s.Infof("ignored client connection using protocol '%s', expected '%s'",
+			version, chshare.ProtocolVersion)
+	}
 	//proxy target was provided
 	if s.reverseProxy != nil {
 		s.reverseProxy.ServeHTTP(w, r)
@@ -54,22 +69,66 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 
 // handleWebsocket is responsible for handling the websocket connection
 func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
-	id := atomic.AddInt32(&s.sessCount, 1)
-	l := s.Fork("session#%d", id)
-
-	alloc := s.handleRequestHeader(req)
-
+	cs := s.acceptClient(w, req, cnet.TransportWebSocket)
+	if cs == nil {
+		return
+	}
 	wsConn, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
-		l.Debugf("Failed to upgrade (%s)", err)
+		cs.l.Debugf("Failed to upgrade (%s)", err)
 		return
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
+	s.handleSession(cs, req, cnet.NewWebSocketConn(wsConn))
+}
+
+// clientSession is an authenticated client, before its ssh handshake
+type clientSession struct {
+	id        int32
+	l         *cio.Logger
+	alloc     *PortAllocation
+	transport string
+}
+
+// acceptClient checks the transport is enabled and authenticates the client of a new session
+func (s *Server) acceptClient(w http.ResponseWriter, req *http.Request, transport string) *clientSession {
+	id := atomic.AddInt32(&s.sessCount, 1)
+	l := s.Fork("session#%d", id)
+	if !s.transportEnabled(transport) {
+		l.Infof("Client rejected: transport %s disabled", transport)
+		w.Header().Set(cnet.TransportsHeader, strings.Join(s.transports, ","))
+		http.Error(w, "transport "+transport+" disabled", http.StatusNotImplemented)
+		return nil
+	}
+	alloc, status, err := s.handleRequestHeader(req)
+	if err != nil {
+		l.Infof("Client rejected: %v", err)
+		handshakeFailures.WithLabelValues("auth").Inc()
+		s.audit.Log(AuditEvent{
+			Type:       AuditAuthFailure,
+			Session:    id,
+			User:       req.Header.Get("X-User-Id"),
+			ClientId:   req.Header.Get("X-Client-Id"),
+			AppName:    req.Header.Get("X-App-Name"),
+			RemoteAddr: req.RemoteAddr,
+			Transport:  transport,
+			Detail:     err.Error(),
+		})
+		http.Error(w, err.Error(), status)
+		return nil
+	}
+	return &clientSession{id: id, l: l, alloc: alloc, transport: transport}
+}
+
+// handleSession runs the ssh session of a client over the connection of its transport, and blocks until closed
+func (s *Server) handleSession(cs *clientSession, req *http.Request, tc net.Conn) {
+	id, l, alloc := cs.id, cs.l, cs.alloc
+	conn := cnet.NewShapedConn(tc)
 	// perform SSH handshake on net.Conn
-	l.Debugf("Handshaking with %s...", req.RemoteAddr)
+	l.Debugf("Handshaking with %s over %s...", req.RemoteAddr, cs.transport)
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
 	if err != nil {
 		s.Debugf("Failed to handshake (%s)", err)
+		handshakeFailures.WithLabelValues("ssh").Inc()
 		return
 	}
 	// pull the users from the session map
@@ -87,16 +146,30 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	// verify configuration
 	l.Debugf("Verifying configuration")
 	// wait for request, with timeout
+	// auto reverse remotes (R:auto:...) ask for their ports before the config
 	var r *ssh.Request
-	select {
-	case r = <-reqs:
-	case <-time.After(settings.EnvDuration("CONFIG_TIMEOUT", 10*time.Second)):
-		l.Debugf("Timeout waiting for configuration")
-		sshConn.Close()
-		return
+	timeout := time.After(settings.EnvDuration("CONFIG_TIMEOUT", 10*time.Second))
+	remotes := newSessionRemotes(s, l, req, user, alloc)
+	for r == nil {
+		select {
+		case req, ok := <-reqs:
+			if !ok {
+				return
+			}
+			if req.Type != "port" {
+				r = req
+				continue
+			}
+			remotes.handleRequest(req)
+		case <-timeout:
+			l.Debugf("Timeout waiting for configuration")
+			sshConn.Close()
+			return
+		}
 	}
 	failed := func(err error) {
 		l.Debugf("Failed: %s", err)
+		handshakeFailures.WithLabelValues("config").Inc()
 		r.Reply(false, []byte(err.Error()))
 	}
 	if r.Type != "config" {
@@ -122,7 +195,7 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	//validate remotes
 	for _, r := range c.Remotes {
 		//	处理remote选项(R:30001:127.0.0.1:9001)
-		alloc = s.handleRemote(w, req, l, alloc, r)
+		alloc = s.handleRemote(req, l, alloc, r)
 		if alloc == nil {
 			failed(s.Errorf("allocated port failed: %+v", r))
 			return
@@ -132,6 +205,17 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		if user != nil {
 			addr := r.UserAddr()
 			if !user.HasAccess(addr) {
+				s.audit.Log(AuditEvent{
+					Type:       AuditAuthFailure,
+					Session:    id,
+					User:       user.Name,
+					ClientId:   alloc.ClientId,
+					AppName:    alloc.AppName,
+					RemoteAddr: req.RemoteAddr,
+					Transport:  cs.transport,
+					Remote:     r.String(),
+					Detail:     "access denied",
+				})
 				failed(s.Errorf("access to '%s' denied", addr))
 				return
 			}
@@ -148,8 +232,67 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	//apply the quotas of the session owner, the bandwidth
+	//limit shapes all the streams piped through this session
+	owner := s.sessionOwner(alloc, user)
+	if owner != "" {
+		release, err := s.quotas.Acquire(owner, nil)
+		if err != nil {
+			l.Infof("Client rejected: %v", err)
+			failed(err)
+			return
+		}
+		defer release()
+		conn.SetShaper(s.quotas.Shaper(owner))
+	}
+	remotes.alloc = alloc
+	if err := remotes.Reserve(owner, c.Remotes.Reversed(true)); err != nil {
+		l.Infof("Client rejected: %v", err)
+		failed(err)
+		return
+	}
+	defer remotes.Release()
 	//successfuly validated config!
 	r.Reply(true, nil)
+	//register the live session, the traffic is labeled
+	//with the verified user only, not the request headers
+	userLabel, appLabel := trafficLabels(owner, alloc.AppName)
+	ls := &liveSession{
+		id:            id,
+		user:          owner,
+		clientId:      alloc.ClientId,
+		appName:       alloc.AppName,
+		clientVersion: cv,
+		remoteAddr:    req.RemoteAddr,
+		transport:     cs.transport,
+		startTime:     time.Now(),
+		conn:          conn,
+		sshConn:       sshConn,
+		traffic:       [2]string{userLabel, appLabel},
+	}
+	for _, r := range c.Remotes {
+		ls.remotes = append(ls.remotes, r.String())
+	}
+	defer s.live.Add(ls)()
+	conn.SetCounter(func(read, written int) {
+		ls.bytesIn.Add(float64(read))
+		ls.bytesOut.Add(float64(written))
+	})
+	s.audit.Log(ls.auditEvent(AuditSessionOpen))
+	for _, r := range c.Remotes {
+		ev := ls.auditEvent(AuditRemoteBind)
+		ev.Remote = r.String()
+		if r.Reverse {
+			ev.Port, _ = strconv.Atoi(r.LocalPort)
+		}
+		s.audit.Log(ev)
+	}
+	defer func() {
+		ev := ls.auditEvent(AuditSessionClose)
+		ev.BytesIn, ev.BytesOut = conn.Traffic()
+		ev.DurationMs = time.Since(ls.startTime).Milliseconds()
+		s.audit.Log(ev)
+	}()
 	//tunnel per ssh connection
 	tunnel := tunnel.New(tunnel.Config{
 		Logger:    l,
@@ -157,33 +300,68 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		Outbound:  true, //server always accepts outbound
 		Socks:     s.config.Socks5,
 		KeepAlive: s.config.KeepAlive,
+		OnChannel: ls.onChannel,
+		OnConnection: func(ev tunnel.ConnEvent) {
+			s.auditConn(ls, ev)
+		},
+		OnAccept: func(r *settings.Remote, conn net.Conn) (net.Conn, error) {
+			return s.acceptInbound(ls, r, conn)
+		},
+		AllowPacket: func(r *settings.Remote, addr *net.UDPAddr) bool {
+			return s.allowInboundPacket(ls, r, addr)
+		},
+		OnRequest: remotes.handleRequest,
 	})
+	ls.setTunnel(tunnel)
 	//bind
 	eg, ctx := errgroup.WithContext(req.Context())
 	eg.Go(func() error {
 		//connected, handover ssh connection for tunnel to use, and block
 		return tunnel.BindSSH(ctx, sshConn, reqs, chans)
 	})
-	eg.Go(func() error {
-		//connected, setup reversed-remotes?
-		serverInbound := c.Remotes.Reversed(true)
-		if len(serverInbound) == 0 {
-			return nil
-		}
-		//block
-		return tunnel.BindRemotes(ctx, serverInbound)
-	})
+	//connected, setup reversed-remotes, they can be changed while connected
+	remotes.Start(ctx, ls, tunnel)
+	//renew the port lease while connected
+	go s.renewLease(ctx, l, remotes.current, sshConn)
 	err = eg.Wait()
+	alloc = remotes.current()
 	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
 		l.Infof("Closed connection (%s)", err)
 	} else {
 		l.Infof("Closed connection: %+v", alloc)
 	}
-	alloc.Status = Allocated
+	s.allocator.OnDisconnected(alloc)
+}
+
+// renewLease 隧道连接期间定期为映射端口续租，直到连接关闭
+// 端口的租约已被其它客户端取得时关闭连接，客户端重连后重新分配端口
+func (s *Server) renewLease(ctx context.Context, l *cio.Logger, current func() *PortAllocation, sshConn ssh.Conn) {
+	ticker := time.NewTicker(s.allocator.LeaseTTL() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.allocator.Renew(current())
+			if errors.Is(err, errLeaseLost) {
+				l.Infof("Port lease lost, close connection: %v", err)
+				sshConn.Close()
+				return
+			}
+			if err != nil {
+				l.Infof("Renew port lease failed: %v", err)
+			}
+		}
+	}
 }
 
 // handleControlPlaneHandler 处理控制面API请求
 func (s *Server) handleControlPlaneHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, s.registry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 
 	method := r.Method
@@ -197,7 +375,23 @@ func (s *Server) handleControlPlaneHandler(w http.ResponseWriter, r *http.Reques
 		rError(w, http.StatusNotFound, "API endpoint not found")
 		return
 	}
-	if paths[2] != "api" || paths[3] != "v1" || paths[4] != "ports" {
+	if paths[2] != "api" || paths[3] != "v1" {
+		rError(w, http.StatusNotFound, "API endpoint not found")
+		return
+	}
+	if paths[4] == "quotas" {
+		s.handleQuotas(w, r)
+		return
+	}
+	if paths[4] == "acls" {
+		s.handleACLs(w, r)
+		return
+	}
+	if paths[4] == "sessions" {
+		s.handleSessions(w, r)
+		return
+	}
+	if paths[4] != "ports" {
 		rError(w, http.StatusNotFound, "API endpoint not found")
 		return
 	}
@@ -213,20 +407,80 @@ func (s *Server) handleControlPlaneHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-func (s *Server) getUserId(r *http.Request) string {
-	// Get Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return ""
+// 用户通过控制面持有的端口数
+func (s *Server) heldPorts(userId string) int {
+	held := 0
+	for _, p := range s.allocator.QueryPorts("", userId, "") {
+		if p.Status != Freed {
+			held++
+		}
+	}
+	return held
+}
+
+/**
+ * authorize resolves the user a control plane request acts for
+ * @param {string} userId - userId given in the request, may be empty
+ * @param {bool} all - empty userId means all users (queries by admin)
+ * @returns {string, bool} Effective userId, false if the request was rejected
+ * @description
+ * - Without JWT verification configured, the userId is taken from the request or the unverified token,
+ *   nobody checks it, this mode is insecure and only kept for deployments behind a trusted gateway
+ * - Otherwise users can only act for themselves, admins can act for anyone
+ */
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, userId string, all bool) (string, bool) {
+	if s.verifier == nil {
+		if userId == "" {
+			userId = s.getUserId(r)
+		}
+		return userId, true
+	}
+	id, err := s.verifier.Verify(r)
+	if err != nil {
+		s.Infof("Unauthorized request(url=%s): %v", r.URL.Path, err)
+		rError(w, http.StatusUnauthorized, "Unauthorized")
+		return "", false
+	}
+	if id.Admin {
+		if userId == "" && !all {
+			userId = id.UserId
+		}
+		return userId, true
+	}
+	if userId != "" && userId != id.UserId {
+		s.Infof("Forbidden request(url=%s): user %s acts for %s", r.URL.Path, id.UserId, userId)
+		rError(w, http.StatusForbidden, "Forbidden")
+		return "", false
+	}
+	return id.UserId, true
+}
+
+/**
+ * authorizeRead resolves the user whose data a control plane query reads
+ * @param {string} userId - userId given in the request, empty means all users
+ * @returns {string, bool} Effective userId, false if the request was rejected
+ * @description
+ * - Without JWT verification the identity of the caller is unknown, reading requires the admin token
+ * - Otherwise as authorize, users only read their own data, admins read anyone's
+ */
+func (s *Server) authorizeRead(w http.ResponseWriter, r *http.Request, userId string) (string, bool) {
+	if s.verifier == nil {
+		if !s.requireAdmin(w, r) {
+			return "", false
+		}
+		return userId, true
 	}
+	return s.authorize(w, r, userId, true)
+}
 
-	// Check if the header has Bearer prefix
-	tokenString := authHeader
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		tokenString = authHeader[7:] // Remove "Bearer " prefix
+// 未配置令牌校验时，从令牌中直接读取用户ID（不校验签名）
+func (s *Server) getUserId(r *http.Request) string {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return ""
 	}
 
-	// Parse token without verification (for now)
+	// Parse token without verification
 	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
 	if err != nil {
 		return ""
@@ -282,24 +536,97 @@ type PortQueryResponse struct {
 	MappingPort int `json:"mappingPort"`
 }
 
+/**
+ * sessionOwner returns the verified user of a tunnel session
+ * @param {*PortAllocation} alloc - The session, its UserId comes from the X-User-Id header
+ * @param {*settings.User} user - The user authenticated by the ssh handshake, nil without auth file
+ * @returns {string} The auth file user, else the user checked against the JWT, else empty
+ * @description
+ * - The quotas, ACLs and metric labels of the session use this user, never the raw header
+ */
+func (s *Server) sessionOwner(alloc *PortAllocation, user *settings.User) string {
+	if user != nil {
+		return user.Name
+	}
+	if s.verifier != nil {
+		return alloc.UserId
+	}
+	return ""
+}
+
 /**
  *	处理新版本的cotun客户端连接请求的http头部
  *	新版本的cotun客户端连接请求，会在header中带上标识信息：X-Client-Id, X-App-Name, X-User-Id
+ *	开启令牌校验后，连接请求必须携带有效令牌，X-User-Id必须与令牌中的用户一致（管理员除外）
  */
-func (s *Server) handleRequestHeader(req *http.Request) *PortAllocation {
+func (s *Server) handleRequestHeader(req *http.Request) (*PortAllocation, int, error) {
 	// 新版本在请求头中带了标识信息，可以从HTTP请求头获取客户端的这些标识信息
 	c := &PortAllocation{}
 	c.Status = Freed
 	c.ClientId = req.Header.Get("X-Client-Id")
 	c.AppName = req.Header.Get("X-App-Name")
 	c.UserId = req.Header.Get("X-User-Id")
-	return c
+	if s.verifier == nil {
+		return c, 0, nil
+	}
+	id, err := s.verifier.Verify(req)
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("unauthorized: %w", err)
+	}
+	if c.UserId == "" {
+		c.UserId = id.UserId
+	} else if c.UserId != id.UserId && !id.Admin {
+		return nil, http.StatusForbidden, fmt.Errorf("user %s can't connect as %s", id.UserId, c.UserId)
+	}
+	return c, 0, nil
+}
+
+/**
+ * handlePortRequest assigns the mapping port of an auto reverse remote during the handshake
+ * @param {*PortAllocation} alloc - The session, its app name and user are completed from the request
+ * @param {*settings.User} user - The user authenticated by the ssh handshake, nil without auth file
+ * @param {[]byte} payload - The encoded settings.PortRequest
+ * @returns {[]byte, error} The encoded settings.PortReply
+ * @description
+ * - The session is applied to the port when the config with the assigned port arrives
+ */
+func (s *Server) handlePortRequest(alloc *PortAllocation, user *settings.User, payload []byte) ([]byte, error) {
+	var pr settings.PortRequest
+	if err := json.Unmarshal(payload, &pr); err != nil {
+		return nil, errors.New("invalid port request")
+	}
+	if !s.config.Reverse {
+		return nil, errors.New("Reverse port forwaring not enabled on server")
+	}
+	if alloc.AppName == "" {
+		alloc.AppName = pr.AppName
+	} else if pr.AppName != "" && pr.AppName != alloc.AppName {
+		return nil, fmt.Errorf("app name %s differs from the connection (%s)", pr.AppName, alloc.AppName)
+	}
+	if alloc.UserId == "" && user != nil {
+		alloc.UserId = user.Name
+	}
+	if alloc.ClientId == "" || alloc.AppName == "" {
+		return nil, errors.New("auto ports need a client id and an app name")
+	}
+	ret, err := s.allocatePort(PortAllocationRequest{
+		ClientId:   alloc.ClientId,
+		UserId:     alloc.UserId,
+		AppName:    alloc.AppName,
+		ClientPort: pr.ClientPort,
+	}, s.sessionOwner(alloc, user), pr.MappingPort)
+	if err != nil {
+		return nil, err
+	}
+	s.Infof("Client allocated: alloc: %+v, ret: %+v", alloc, ret)
+	b, _ := json.Marshal(settings.PortReply{MappingPort: ret.MappingPort})
+	return b, nil
 }
 
 /**
  *	处理R:32001:127.0.0.1:7009这样的映射选项
  */
-func (s *Server) handleRemote(w http.ResponseWriter, req *http.Request, l *cio.Logger, alloc *PortAllocation, r *settings.Remote) *PortAllocation {
+func (s *Server) handleRemote(req *http.Request, l *cio.Logger, alloc *PortAllocation, r *settings.Remote) *PortAllocation {
 	if alloc != nil && alloc.Status != Freed {
 		return alloc
 	}
@@ -336,8 +663,9 @@ func (s *Server) handleGetPorts(w http.ResponseWriter, r *http.Request) {
 		clientId = paths[5]
 		appName = paths[6]
 	}
-	if userId == "" {
-		userId = s.getUserId(r)
+	userId, ok := s.authorize(w, r, userId, true)
+	if !ok {
+		return
 	}
 
 	ports := s.allocator.QueryPorts(clientId, userId, appName)
@@ -366,31 +694,79 @@ func (s *Server) handleCreatePort(w http.ResponseWriter, r *http.Request) {
 		rError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-	if req.UserId == "" {
-		req.UserId = s.getUserId(r)
+	userId, ok := s.authorize(w, r, req.UserId, false)
+	if !ok {
+		return
 	}
+	req.UserId = userId
 
 	if req.ClientId == "" || req.AppName == "" || req.UserId == "" {
 		s.Infof("Client allocate error: req: %+v, error: missing required fields", req)
 		rError(w, http.StatusBadRequest, "Missing required fields")
 		return
 	}
-	ret, err := s.allocator.AllocatePort(req.ClientId, req.UserId, req.AppName, req.ClientPort)
+	//without JWT verification the userId isn't verified, no quota applies
+	owner := ""
+	if s.verifier != nil {
+		owner = req.UserId
+	}
+	ret, err := s.allocatePort(req, owner, 0)
 	if err != nil {
 		s.Infof("Client allocate error: req: %+v, error: %v", req, err)
-		rError(w, http.StatusInsufficientStorage, "No available ports")
+		var qe *QuotaError
+		if errors.As(err, &qe) {
+			rError(w, http.StatusForbidden, err.Error())
+		} else {
+			rError(w, http.StatusInsufficientStorage, "No available ports")
+		}
 		return
 	}
 	s.Infof("Client allocated: req: %+v, ret: %+v", req, ret)
 	rJSON(w, http.StatusOK, ret)
 }
 
+/**
+ * allocatePort allocates the mapping port of a client application
+ * @param {PortAllocationRequest} req - The client application
+ * @param {string} owner - Verified user whose quotas apply, empty for none
+ * @param {int} hint - Port to use when the application has no allocation yet, 0 for any
+ * @returns {PortAllocation, error} The allocation, the port already allocated to the application if any
+ * @description
+ * - New allocations are checked against the port quota of the owner, and taken from its port range
+ */
+func (s *Server) allocatePort(req PortAllocationRequest, owner string, hint int) (PortAllocation, error) {
+	// 新申请端口时检查端口数配额，重复申请返回原端口
+	_, err := s.allocator.LookupPort(req.ClientId, req.UserId, req.AppName)
+	existed := err == nil
+	if !existed && owner != "" {
+		if err := s.quotas.CheckAllocate(owner, s.heldPorts(owner)); err != nil {
+			return PortAllocation{}, err
+		}
+	}
+	q := s.quotas.Get(owner)
+	minPort, maxPort := q.PortRange(s.config.MinPort, s.config.MaxPort)
+	var ret PortAllocation
+	err = errors.New("no available ports")
+	// 优先沿用客户端上次分配到的端口，如服务端重启后
+	if hint >= minPort && hint <= maxPort {
+		ret, err = s.allocator.AllocatePortIn(req.ClientId, req.UserId, req.AppName, req.ClientPort, hint, hint)
+	}
+	if err != nil {
+		ret, err = s.allocator.AllocatePortIn(req.ClientId, req.UserId, req.AppName, req.ClientPort, minPort, maxPort)
+	}
+	if err == nil && !existed {
+		s.auditPort(AuditPortAllocate, ret, "")
+	}
+	return ret, err
+}
+
 func (s *Server) handleDeletePort(w http.ResponseWriter, r *http.Request) {
 	clientID := r.URL.Query().Get("clientId")
 	appName := r.URL.Query().Get("appName")
 	userId := r.URL.Query().Get("userId")
-	if userId == "" {
-		userId = s.getUserId(r)
+	userId, ok := s.authorize(w, r, userId, false)
+	if !ok {
+		return
 	}
 	if clientID == "" || appName == "" || userId == "" {
 		s.Infof("Client free error: URL(%s) missing parameters: clientId=%s,userId=%s,appName=%s", r.URL.Path, clientID, userId, appName)
@@ -404,6 +780,222 @@ func (s *Server) handleDeletePort(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.Infof("Client freed: clientId=%s,appName=%s,userId=%s, ports=%+v", clientID, appName, userId, ports)
+	for _, p := range ports {
+		s.auditPort(AuditPortFree, p, "")
+	}
 
 	rJSON(w, http.StatusOK, "Port deleted successfully")
 }
+
+// AdminTokenHeader 携带控制面管理令牌(--admin-token)的请求头
+const AdminTokenHeader = "X-Admin-Token"
+
+/**
+ * requireAdmin checks that a control plane request is made by an admin
+ * @returns {bool} false if the request was rejected
+ * @description
+ * - Accepts the --admin-token in the X-Admin-Token header, or the JWT of an admin
+ * - Without admin token and JWT verification, the admin APIs are disabled
+ */
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := s.config.AdminToken
+	if got := r.Header.Get(AdminTokenHeader); got != "" && token != "" {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			return true
+		}
+		s.Infof("Unauthorized request(url=%s): invalid admin token", r.URL.Path)
+		rError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	if s.verifier == nil {
+		if token == "" {
+			s.Infof("Forbidden request(url=%s): admin API is disabled", r.URL.Path)
+			rError(w, http.StatusForbidden, "Admin API is disabled")
+		} else {
+			s.Infof("Unauthorized request(url=%s): missing admin token", r.URL.Path)
+			rError(w, http.StatusUnauthorized, "Unauthorized")
+		}
+		return false
+	}
+	id, err := s.verifier.Verify(r)
+	if err != nil {
+		s.Infof("Unauthorized request(url=%s): %v", r.URL.Path, err)
+		rError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	if !id.Admin {
+		s.Infof("Forbidden request(url=%s): user %s is not admin", r.URL.Path, id.UserId)
+		rError(w, http.StatusForbidden, "Forbidden")
+		return false
+	}
+	return true
+}
+
+/**
+ *	处理用户配额API
+ *	GET /cotun/api/v1/quotas[/{user}] - 查询配额及用量，普通用户只能查询自己，未开启令牌校验时需要管理员
+ *	PUT /cotun/api/v1/quotas/{user} - 设置用户配额(管理员)，覆盖认证文件中的配额
+ *	DELETE /cotun/api/v1/quotas/{user} - 删除设置的配额(管理员)，恢复为认证文件中的配额
+ */
+func (s *Server) handleQuotas(w http.ResponseWriter, r *http.Request) {
+	paths := strings.Split(r.URL.Path, "/")
+	user := ""
+	if len(paths) > 5 {
+		user = paths[5]
+	}
+	switch r.Method {
+	case "GET":
+		user, ok := s.authorizeRead(w, r, user)
+		if !ok {
+			return
+		}
+		if user == "" {
+			rJSON(w, http.StatusOK, s.quotas.List())
+			return
+		}
+		rJSON(w, http.StatusOK, s.quotas.Usage(user))
+	case "PUT":
+		if user == "" {
+			rError(w, http.StatusBadRequest, "Missing user")
+			return
+		}
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		var q settings.Quota
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			rError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := s.quotas.Set(user, q); err != nil {
+			rError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.Infof("Quota set: user=%s, quota=%+v", user, q)
+		rJSON(w, http.StatusOK, s.quotas.Usage(user))
+	case "DELETE":
+		if user == "" {
+			rError(w, http.StatusBadRequest, "Missing user")
+			return
+		}
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		if !s.quotas.Delete(user) {
+			rError(w, http.StatusNotFound, "Quota not found")
+			return
+		}
+		s.Infof("Quota deleted: user=%s", user)
+		rJSON(w, http.StatusOK, "Quota deleted successfully")
+	default:
+		rError(w, http.StatusNotFound, "API endpoint not found")
+	}
+}
+
+/**
+ *	处理反向端口的ACL API
+ *	GET /cotun/api/v1/acls[/{user}] - 查询ACL，普通用户只能查询自己，列出所有用户以及未开启令牌校验时需要管理员，共享密钥不返回
+ *	PUT /cotun/api/v1/acls/{user} - 设置用户的ACL列表(管理员)，覆盖认证文件中的ACL
+ *	DELETE /cotun/api/v1/acls/{user} - 删除设置的ACL(管理员)，恢复为认证文件中的ACL
+ */
+func (s *Server) handleACLs(w http.ResponseWriter, r *http.Request) {
+	paths := strings.Split(r.URL.Path, "/")
+	user := ""
+	if len(paths) > 5 {
+		user = paths[5]
+	}
+	switch r.Method {
+	case "GET":
+		user, ok := s.authorizeRead(w, r, user)
+		if !ok {
+			return
+		}
+		if user == "" {
+			if !s.requireAdmin(w, r) {
+				return
+			}
+			rJSON(w, http.StatusOK, s.acls.List())
+			return
+		}
+		rJSON(w, http.StatusOK, s.acls.View(user))
+	case "PUT":
+		if user == "" {
+			rError(w, http.StatusBadRequest, "Missing user")
+			return
+		}
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		acls := []settings.ACL{}
+		if err := json.NewDecoder(r.Body).Decode(&acls); err != nil {
+			rError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := s.acls.Set(user, acls); err != nil {
+			rError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.Infof("ACLs set: user=%s, acls=%d", user, len(acls))
+		rJSON(w, http.StatusOK, s.acls.View(user))
+	case "DELETE":
+		if user == "" {
+			rError(w, http.StatusBadRequest, "Missing user")
+			return
+		}
+		if !s.requireAdmin(w, r) {
+			return
+		}
+		if !s.acls.Delete(user) {
+			rError(w, http.StatusNotFound, "ACL not found")
+			return
+		}
+		s.Infof("ACLs deleted: user=%s", user)
+		rJSON(w, http.StatusOK, "ACL deleted successfully")
+	default:
+		rError(w, http.StatusNotFound, "API endpoint not found")
+	}
+}
+
+/**
+ *	处理会话API
+ *	GET /cotun/api/v1/sessions[?user=xx] - 列出连接中的会话，普通用户只能查看自己的会话，未开启令牌校验时需要管理员
+ *	DELETE /cotun/api/v1/sessions/{id} - 强制断开会话，没有所属用户的会话以及未开启令牌校验时只有管理员可以断开
+ */
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	paths := strings.Split(r.URL.Path, "/")
+	switch r.Method {
+	case "GET":
+		user, ok := s.authorizeRead(w, r, r.URL.Query().Get("user"))
+		if !ok {
+			return
+		}
+		rJSON(w, http.StatusOK, s.live.List(user))
+	case "DELETE":
+		if len(paths) < 6 {
+			rError(w, http.StatusBadRequest, "Missing session id")
+			return
+		}
+		id, err := strconv.Atoi(paths[5])
+		if err != nil {
+			rError(w, http.StatusBadRequest, "Invalid session id")
+			return
+		}
+		ls, found := s.live.Get(int32(id))
+		if !found {
+			rError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		if ls.user == "" || s.verifier == nil {
+			if !s.requireAdmin(w, r) {
+				return
+			}
+		} else if _, ok := s.authorize(w, r, ls.user, false); !ok {
+			return
+		}
+		ls.sshConn.Close()
+		s.Infof("Session #%d disconnected: user=%s, clientId=%s", id, ls.user, ls.clientId)
+		rJSON(w, http.StatusOK, "Session disconnected successfully")
+	default:
+		rError(w, http.StatusNotFound, "API endpoint not found")
+	}
+}
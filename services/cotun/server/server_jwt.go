@@ -0,0 +1,309 @@
+package chserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig configures verification of the bearer tokens used by the control plane and tunnel clients
+type JWTConfig struct {
+	JwksURL   string        // JWKS地址，如casdoor的 https://<host>/.well-known/jwks
+	PublicKey string        // PEM格式的公钥或证书，可以是文件路径，也可以直接是PEM内容
+	Issuer    string        // 不为空时校验iss
+	Audience  string        // 不为空时校验aud
+	UserClaim string        // 用户ID所在的claim，默认为id
+	AdminRole string        // 管理员角色名，持有该角色的用户可以查看、管理所有用户的端口
+	Refresh   time.Duration // JWKS刷新间隔
+}
+
+// Enabled 配置了JWKS或公钥时才开启令牌校验
+func (c *JWTConfig) Enabled() bool {
+	return c.JwksURL != "" || c.PublicKey != ""
+}
+
+// Identity 经过校验的令牌所代表的用户
+type Identity struct {
+	UserId string
+	Admin  bool
+}
+
+var errNoToken = errors.New("missing bearer token")
+
+/**
+ * jwtVerifier verifies bearer tokens against a JWKS url or a fixed public key
+ * @description
+ * - JWKS keys are cached by kid, refreshed periodically or when an unknown kid shows up
+ * - Checks signature, exp/nbf, and iss/aud when configured
+ */
+type jwtVerifier struct {
+	config *JWTConfig
+	parser *jwt.Parser
+	static crypto.PublicKey
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newJWTVerifier(c *JWTConfig) (*jwtVerifier, error) {
+	if c.UserClaim == "" {
+		c.UserClaim = "id"
+	}
+	if c.Refresh <= 0 {
+		c.Refresh = time.Hour
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		opts = append(opts, jwt.WithAudience(c.Audience))
+	}
+	v := &jwtVerifier{
+		config: c,
+		parser: jwt.NewParser(opts...),
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]crypto.PublicKey),
+	}
+	if c.PublicKey != "" {
+		key, err := loadPublicKey(c.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt public key: %w", err)
+		}
+		v.static = key
+	}
+	return v, nil
+}
+
+/**
+ * Verify checks the bearer token of the request and returns its identity
+ * @param {*http.Request} r - Request carrying "Authorization: Bearer <token>"
+ * @returns {*Identity, error} Identity of the token, errNoToken if there is no token
+ */
+func (v *jwtVerifier) Verify(r *http.Request) (*Identity, error) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return nil, errNoToken
+	}
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.keyfunc); err != nil {
+		return nil, err
+	}
+	id := &Identity{UserId: toString(claims[v.config.UserClaim])}
+	if id.UserId == "" {
+		return nil, fmt.Errorf("claim '%s' missing in token", v.config.UserClaim)
+	}
+	id.Admin = isAdmin(claims, v.config.AdminRole)
+	return id, nil
+}
+
+func (v *jwtVerifier) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if v.config.JwksURL == "" {
+		return v.static, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, exists := v.keys[kid]
+	// 未知的kid说明签名密钥可能已轮换，刷新一次，但限制刷新频率
+	stale := time.Since(v.fetched) > v.config.Refresh
+	if stale || (!exists && time.Since(v.fetched) > 30*time.Second) {
+		if err := v.fetch(); err != nil {
+			if !exists {
+				return nil, err
+			}
+		} else {
+			key, exists = v.keys[kid]
+		}
+	}
+	if !exists && kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, nil
+		}
+	}
+	if !exists {
+		if v.static != nil {
+			return v.static, nil
+		}
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kid string   `json:"kid"`
+	Kty string   `json:"kty"`
+	Crv string   `json:"crv"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
+	X5c []string `json:"x5c"`
+}
+
+func (v *jwtVerifier) fetch() error {
+	v.fetched = time.Now()
+	resp, err := v.client.Get(v.config.JwksURL)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable key in jwks")
+	}
+	v.keys = keys
+	return nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.N == "" && len(k.X5c) > 0 {
+			return x5cKey(k.X5c[0])
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func x5cKey(der string) (crypto.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(der)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
+
+// 加载PEM格式的公钥，支持PUBLIC KEY、RSA PUBLIC KEY和CERTIFICATE(如casdoor导出的证书)
+func loadPublicKey(s string) (crypto.PublicKey, error) {
+	data := []byte(s)
+	if !strings.Contains(s, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(s); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func bearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return authHeader[7:]
+	}
+	return authHeader
+}
+
+// 管理员判定：casdoor的isAdmin，或者roles/role中包含管理员角色
+func isAdmin(claims jwt.MapClaims, adminRole string) bool {
+	if admin, ok := claims["isAdmin"].(bool); ok && admin {
+		return true
+	}
+	if adminRole == "" {
+		return false
+	}
+	for _, name := range []string{"roles", "role"} {
+		switch roles := claims[name].(type) {
+		case string:
+			if roles == adminRole {
+				return true
+			}
+		case []interface{}:
+			for _, role := range roles {
+				// casdoor的roles是对象数组，取其name
+				if m, ok := role.(map[string]interface{}); ok {
+					role = m["name"]
+				}
+				if toString(role) == adminRole {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/crypto/ssh"
+)
+
+// testVerifier 使用测试JWKS的令牌校验器，key为JWKS中的密钥
+func testVerifier(t *testing.T) (*jwtVerifier, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(jwks.Close)
+
+	v, err := newJWTVerifier(&JWTConfig{JwksURL: jwks.URL, Issuer: "casdoor", AdminRole: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, key
+}
+
+// signToken 签发测试令牌
+func signToken(t *testing.T, claims jwt.MapClaims, signer *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTVerifier(t *testing.T) {
+	v, key := testVerifier(t)
+	sign := func(claims jwt.MapClaims, signer *rsa.PrivateKey) *http.Request {
+		r := httptest.NewRequest("GET", "/cotun/api/v1/ports", nil)
+		r.Header.Set("Authorization", "Bearer "+signToken(t, claims, signer))
+		return r
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	id, err := v.Verify(sign(jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": exp}, key))
+	if err != nil || id.UserId != "u1" || id.Admin {
+		t.Fatalf("unexpected identity %+v: %v", id, err)
+	}
+	id, err = v.Verify(sign(jwt.MapClaims{"id": "u2", "iss": "casdoor", "exp": exp,
+		"roles": []interface{}{map[string]interface{}{"name": "admin"}}}, key))
+	if err != nil || !id.Admin {
+		t.Fatalf("expected admin identity %+v: %v", id, err)
+	}
+
+	forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := v.Verify(sign(jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": exp}, forged)); err == nil {
+		t.Fatal("token signed by another key accepted")
+	}
+	if _, err := v.Verify(sign(jwt.MapClaims{"id": "u1", "iss": "other", "exp": exp}, key)); err == nil {
+		t.Fatal("token of another issuer accepted")
+	}
+	if _, err := v.Verify(sign(jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": time.Now().Add(-time.Hour).Unix()}, key)); err == nil {
+		t.Fatal("expired token accepted")
+	}
+
+	s := &Server{Logger: cio.NewLogger("test"), config: &Config{}, verifier: v, live: newSessionRegistry()}
+	w := httptest.NewRecorder()
+	if _, ok := s.authorize(w, sign(jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": exp}, key), "u2", true); ok || w.Code != http.StatusForbidden {
+		t.Fatalf("user acted for another user, status %d", w.Code)
+	}
+
+	//sessions without an owner can only be disconnected by admins
+	conn := &closeConn{}
+	defer s.live.Add(&liveSession{id: 1, sshConn: conn})()
+	kill := func(claims jwt.MapClaims) int {
+		r := sign(claims, key)
+		r.Method = "DELETE"
+		r.URL.Path = "/cotun/api/v1/sessions/1"
+		w := httptest.NewRecorder()
+		s.handleSessions(w, r)
+		return w.Code
+	}
+	if code := kill(jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": exp}); code != http.StatusForbidden || conn.closed {
+		t.Fatalf("user disconnected a session without owner, status %d", code)
+	}
+	if code := kill(jwt.MapClaims{"id": "u2", "iss": "casdoor", "exp": exp,
+		"roles": []interface{}{map[string]interface{}{"name": "admin"}}}); code != http.StatusOK || !conn.closed {
+		t.Fatalf("admin can't disconnect a session without owner, status %d", code)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	v, key := testVerifier(t)
+	exp := time.Now().Add(time.Hour).Unix()
+	admin := signToken(t, jwt.MapClaims{"id": "u2", "iss": "casdoor", "exp": exp,
+		"roles": []interface{}{map[string]interface{}{"name": "admin"}}}, key)
+	user := signToken(t, jwt.MapClaims{"id": "u1", "iss": "casdoor", "exp": exp}, key)
+
+	tests := []struct {
+		name     string
+		token    string
+		verifier *jwtVerifier
+		header   string
+		bearer   string
+		status   int
+	}{
+		{name: "disabled", status: http.StatusForbidden},
+		{name: "disabled with any token", header: "x", status: http.StatusForbidden},
+		{name: "missing token", token: "s3", status: http.StatusUnauthorized},
+		{name: "invalid token", token: "s3", header: "s4", status: http.StatusUnauthorized},
+		{name: "valid token", token: "s3", header: "s3", status: http.StatusOK},
+		{name: "admin jwt", verifier: v, bearer: admin, status: http.StatusOK},
+		{name: "user jwt", verifier: v, bearer: user, status: http.StatusForbidden},
+		{name: "token or jwt", token: "s3", verifier: v, bearer: admin, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Logger: cio.NewLogger("test"), config: &Config{AdminToken: tt.token}, verifier: tt.verifier}
+			r := httptest.NewRequest("PUT", "/cotun/api/v1/quotas/u1", nil)
+			if tt.header != "" {
+				r.Header.Set(AdminTokenHeader, tt.header)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			if ok := s.requireAdmin(w, r); ok != (tt.status == http.StatusOK) || (!ok && w.Code != tt.status) {
+				t.Fatalf("got %v, status %d, want status %d", ok, w.Code, tt.status)
+			}
+		})
+	}
+
+	//without JWT verification, only admins disconnect sessions
+	s := &Server{Logger: cio.NewLogger("test"), config: &Config{AdminToken: "s3"}, live: newSessionRegistry()}
+	conn := &closeConn{}
+	defer s.live.Add(&liveSession{id: 1, user: "u1", sshConn: conn})()
+	r := httptest.NewRequest("DELETE", "/cotun/api/v1/sessions/1", nil)
+	w := httptest.NewRecorder()
+	s.handleSessions(w, r)
+	if w.Code != http.StatusUnauthorized || conn.closed {
+		t.Fatalf("anonymous request disconnected a session, status %d", w.Code)
+	}
+	r.Header.Set(AdminTokenHeader, "s3")
+	w = httptest.NewRecorder()
+	s.handleSessions(w, r)
+	if w.Code != http.StatusOK || !conn.closed {
+		t.Fatalf("admin can't disconnect a session, status %d", w.Code)
+	}
+
+	//without JWT verification, the identity in the token is not trusted for reads
+	s = &Server{Logger: cio.NewLogger("test"), config: &Config{AdminToken: "s3"}, live: newSessionRegistry(),
+		quotas: newQuotaManager(settings.NewUserIndex(cio.NewLogger("test")))}
+	forged := signToken(t, jwt.MapClaims{"id": "u1", "exp": exp}, key)
+	for _, path := range []string{"/cotun/api/v1/quotas", "/cotun/api/v1/quotas/u1", "/cotun/api/v1/sessions?user=u1"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer "+forged)
+		w := httptest.NewRecorder()
+		s.handleControlPlaneHandler(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: unverified token read, status %d", path, w.Code)
+		}
+		r.Header.Set(AdminTokenHeader, "s3")
+		w = httptest.NewRecorder()
+		s.handleControlPlaneHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: admin can't read, status %d", path, w.Code)
+		}
+	}
+}
+
+func TestSessionOwner(t *testing.T) {
+	alloc := &PortAllocation{UserId: "forged"}
+	user := &settings.User{Name: "u1"}
+	s := &Server{}
+	if owner := s.sessionOwner(alloc, nil); owner != "" {
+		t.Errorf("unverified X-User-Id used as owner: %q", owner)
+	}
+	if owner := s.sessionOwner(alloc, user); owner != "u1" {
+		t.Errorf("auth file user should own the session, got %q", owner)
+	}
+	s.verifier = &jwtVerifier{}
+	if owner := s.sessionOwner(alloc, user); owner != "u1" {
+		t.Errorf("auth file user should own the session, got %q", owner)
+	}
+	if owner := s.sessionOwner(alloc, nil); owner != "forged" {
+		t.Errorf("X-User-Id checked against the JWT should own the session, got %q", owner)
+	}
+}
+
+// closeConn 只记录是否被关闭的ssh连接
+type closeConn struct {
+	ssh.Conn
+	closed bool
+}
+
+func (c *closeConn) Close() error {
+	c.closed = true
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 
 	"github.com/zgsm-ai/cotun/share/settings"
 	"golang.org/x/crypto/acme/autocert"
@@ -42,6 +43,10 @@ func (s *Server) listener(host, port string) (net.Listener, error) {
 			extra = " (WARNING: LetsEncrypt will attempt to connect to your domain on port 443)"
 		}
 	}
+	if err := s.configureTransports(tlsConf); err != nil {
+		return nil, err
+	}
+	s.Infof("Transports: %s", strings.Join(s.transports, ","))
 	//tcp listen
 	l, err := net.Listen("tcp", host+":"+port)
 	if err != nil {
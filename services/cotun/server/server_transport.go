@@ -0,0 +1,291 @@
+package chserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	chshare "github.com/zgsm-ai/cotun/share"
+	"github.com/zgsm-ai/cotun/share/cnet"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/net/http2"
+)
+
+/**
+ * Transports other than websocket
+ * @description
+ * - Requests carry the protocol version in the X-Cotun-Protocol header, and the transport in X-Cotun-Transport
+ * - A request without transport returns the enabled transports, so clients can negotiate
+ * - h2: a POST request whose body and response form a full-duplex HTTP/2 stream (h2c on cleartext servers)
+ * - tls: a raw TLS connection selected through ALPN, opened by a HTTP/1.1 request header
+ * - poll: HTTP long-poll, the client pushes bytes with POST and pulls with GET
+ */
+func (s *Server) handleTransport(w http.ResponseWriter, r *http.Request) {
+	switch t := r.Header.Get(cnet.TransportHeader); t {
+	case "":
+		w.Header().Set(cnet.TransportsHeader, strings.Join(s.transports, ","))
+		w.Write([]byte(strings.Join(s.transports, ",") + "\n"))
+	case cnet.TransportHTTP2:
+		s.handleHTTP2(w, r)
+	case cnet.TransportPoll:
+		s.handlePoll(w, r)
+	default:
+		w.Header().Set(cnet.TransportsHeader, strings.Join(s.transports, ","))
+		http.Error(w, fmt.Sprintf("transport '%s' not supported over HTTP", t), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) transportEnabled(transport string) bool {
+	for _, t := range s.transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// configureTransports enables HTTP/2 and the raw TLS transport on the http server
+func (s *Server) configureTransports(tlsConf *tls.Config) error {
+	if err := http2.ConfigureServer(s.httpServer.Server, &http2.Server{}); err != nil {
+		return err
+	}
+	if tlsConf == nil {
+		return nil
+	}
+	tlsConf.NextProtos = append([]string{"h2", "http/1.1"}, tlsConf.NextProtos...)
+	if s.transportEnabled(cnet.TransportTLS) {
+		tlsConf.NextProtos = append(tlsConf.NextProtos, cnet.ALPNProtocol)
+		s.httpServer.TLSNextProto[cnet.ALPNProtocol] = func(_ *http.Server, tc *tls.Conn, _ http.Handler) {
+			s.handleTLSConn(tc)
+		}
+	}
+	return nil
+}
+
+// h2Writer serializes the writes to a HTTP/2 response, and
+// stops them once the stream is closed
+type h2Writer struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	closed bool
+	body   io.Closer
+}
+
+func (hw *h2Writer) Write(b []byte) (int, error) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	if hw.closed {
+		return 0, net.ErrClosed
+	}
+	n, err := hw.w.Write(b)
+	if err == nil {
+		hw.w.(http.Flusher).Flush()
+	}
+	return n, err
+}
+
+func (hw *h2Writer) Close() error {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.closed = true
+	return hw.body.Close()
+}
+
+// handleHTTP2 runs a session over the request and response bodies of a HTTP/2 stream
+func (s *Server) handleHTTP2(w http.ResponseWriter, req *http.Request) {
+	if req.ProtoMajor != 2 {
+		http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	cs := s.acceptClient(w, req, cnet.TransportHTTP2)
+	if cs == nil {
+		return
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	//send the response headers now, the client waits for them
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	hw := &h2Writer{w: w, body: req.Body}
+	conn := cnet.NewStreamConn(req.Body, hw, nil, hw.Close, cnet.Addr(req.Host), cnet.Addr(req.RemoteAddr))
+	s.handleSession(cs, req, conn)
+	conn.Close()
+}
+
+// rawResponse writes a HTTP/1.1 response on a raw connection
+type rawResponse struct {
+	conn   net.Conn
+	header http.Header
+	wrote  bool
+}
+
+func (rr *rawResponse) Header() http.Header {
+	return rr.header
+}
+
+func (rr *rawResponse) WriteHeader(code int) {
+	if rr.wrote {
+		return
+	}
+	rr.wrote = true
+	fmt.Fprintf(rr.conn, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	rr.header.Write(rr.conn)
+	io.WriteString(rr.conn, "\r\n")
+}
+
+func (rr *rawResponse) Write(b []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.conn.Write(b)
+}
+
+// bufferedConn is a connection whose first bytes were read into a buffer
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// handleTLSConn runs a session over a raw TLS connection, which starts
+// with a HTTP/1.1 request header carrying the client headers
+func (s *Server) handleTLSConn(tc *tls.Conn) {
+	defer tc.Close()
+	tc.SetReadDeadline(time.Now().Add(settings.EnvDuration("WS_TIMEOUT", 45*time.Second)))
+	br := bufio.NewReader(tc)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		s.Debugf("Invalid TLS transport request (%s)", err)
+		return
+	}
+	tc.SetReadDeadline(time.Time{})
+	state := tc.ConnectionState()
+	req.TLS = &state
+	req.RemoteAddr = tc.RemoteAddr().String()
+	rw := &rawResponse{conn: tc, header: http.Header{}}
+	if v := req.Header.Get(cnet.ProtocolHeader); v != chshare.ProtocolVersion {
+		s.Infof("ignored client connection using protocol '%s', expected '%s'", v, chshare.ProtocolVersion)
+		http.Error(rw, "protocol mismatch", http.StatusBadRequest)
+		return
+	}
+	cs := s.acceptClient(rw, req, cnet.TransportTLS)
+	if cs == nil {
+		return
+	}
+	rw.WriteHeader(http.StatusSwitchingProtocols)
+	s.handleSession(cs, req, &bufferedConn{Conn: tc, r: br})
+}
+
+// pollSessions are the sessions of the long-poll transport, by id
+type pollSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*cnet.PollConn
+}
+
+func (ps *pollSessions) get(id string) *cnet.PollConn {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.sessions[id]
+}
+
+func (ps *pollSessions) set(id string, conn *cnet.PollConn) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if conn == nil {
+		delete(ps.sessions, id)
+	} else {
+		ps.sessions[id] = conn
+	}
+}
+
+// handlePoll opens a long-poll session, or pushes, pulls or closes its bytes
+func (s *Server) handlePoll(w http.ResponseWriter, req *http.Request) {
+	id := req.Header.Get(cnet.SessionHeader)
+	if id == "" {
+		if req.Method != http.MethodPost {
+			http.Error(w, "session required", http.StatusBadRequest)
+			return
+		}
+		s.openPoll(w, req)
+		return
+	}
+	conn := s.polls.get(id)
+	if conn == nil {
+		http.Error(w, "session closed", http.StatusGone)
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		if err := conn.Push(req.Body); err != nil {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		b, err := conn.Pull(settings.EnvDuration("POLL_WAIT", 20*time.Second), 256*1024)
+		if err != nil {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(b)
+	case http.MethodDelete:
+		conn.Close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) openPoll(w http.ResponseWriter, req *http.Request) {
+	cs := s.acceptClient(w, req, cnet.TransportPoll)
+	if cs == nil {
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(b)
+	conn := cnet.NewPollConn(cnet.Addr(req.Host), cnet.Addr(req.RemoteAddr))
+	s.polls.set(id, conn)
+	//the session outlives this request
+	sreq := req.Clone(context.WithoutCancel(req.Context()))
+	go func() {
+		s.handleSession(cs, sreq, conn)
+		conn.Close()
+		s.polls.set(id, nil)
+	}()
+	//close sessions whose client stopped polling
+	go func() {
+		idle := settings.EnvDuration("POLL_IDLE", time.Minute)
+		ticker := time.NewTicker(idle / 4)
+		defer ticker.Stop()
+		for range ticker.C {
+			if s.polls.get(id) != conn {
+				return
+			}
+			if conn.Idle() > idle {
+				cs.l.Debugf("Poll session idle, closing")
+				conn.Close()
+				return
+			}
+		}
+	}()
+	w.Header().Set(cnet.SessionHeader, id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,233 @@
+package chserver
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zgsm-ai/cotun/share/cnet"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"github.com/zgsm-ai/cotun/share/tunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxSeenClients 记录连接过的客户端数上限，超出时清理最早的记录
+const maxSeenClients = 10000
+
+// liveSession 一个连接中的隧道会话
+type liveSession struct {
+	id            int32
+	user          string
+	clientId      string
+	appName       string
+	clientVersion string
+	remoteAddr    string
+	transport     string
+	remotes       []string
+	startTime     time.Time
+	conn          *cnet.ShapedConn
+	sshConn       ssh.Conn
+	traffic       [2]string          // 流量指标的用户、应用标签
+	bytesIn       prometheus.Counter // 登记会话时设置
+	bytesOut      prometheus.Counter
+
+	mu       sync.Mutex
+	channels int
+	tunnel   connServer // 会话的隧道，创建后设置
+}
+
+// connServer 通过映射端口转发连接，即会话的隧道
+type connServer interface {
+	ServeConn(port string, conn net.Conn) error
+}
+
+// SessionInfo 控制面返回的会话信息
+type SessionInfo struct {
+	Id            int32     `json:"id"`
+	User          string    `json:"user"`
+	ClientId      string    `json:"clientId"`
+	AppName       string    `json:"appName"`
+	ClientVersion string    `json:"clientVersion"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	Transport     string    `json:"transport"`
+	Remotes       []string  `json:"remotes"`
+	StartTime     time.Time `json:"startTime"`
+	Channels      int       `json:"channels"`
+	BytesIn       int64     `json:"bytesIn"`  //客户端发往服务端的字节数
+	BytesOut      int64     `json:"bytesOut"` //服务端发往客户端的字节数
+}
+
+func (ls *liveSession) info() SessionInfo {
+	ls.mu.Lock()
+	channels, remotes := ls.channels, ls.remotes
+	ls.mu.Unlock()
+	in, out := ls.conn.Traffic()
+	return SessionInfo{
+		Id:            ls.id,
+		User:          ls.user,
+		ClientId:      ls.clientId,
+		AppName:       ls.appName,
+		ClientVersion: ls.clientVersion,
+		RemoteAddr:    ls.remoteAddr,
+		Transport:     ls.transport,
+		Remotes:       remotes,
+		StartTime:     ls.startTime,
+		Channels:      channels,
+		BytesIn:       in,
+		BytesOut:      out,
+	}
+}
+
+// setRemotes 更新会话的远端，客户端连接期间可以增删远端
+func (ls *liveSession) setRemotes(remotes settings.Remotes) {
+	list := []string{}
+	for _, r := range remotes {
+		list = append(list, r.String())
+	}
+	ls.mu.Lock()
+	ls.remotes = list
+	ls.mu.Unlock()
+}
+
+// auditEvent 会话的审计事件
+func (ls *liveSession) auditEvent(typ string) AuditEvent {
+	return AuditEvent{
+		Type:       typ,
+		Session:    ls.id,
+		User:       ls.user,
+		ClientId:   ls.clientId,
+		AppName:    ls.appName,
+		RemoteAddr: ls.remoteAddr,
+		Transport:  ls.transport,
+	}
+}
+
+// onChannel 统计会话的通道数
+func (ls *liveSession) onChannel(remote string, delta int) {
+	ls.mu.Lock()
+	ls.channels += delta
+	ls.mu.Unlock()
+	observeChannel(delta)
+}
+
+/**
+ * sessionRegistry tracks the live tunnel sessions
+ * @description
+ * - Sessions are listed and can be forcibly disconnected through the control plane
+ * - Remembers the clients seen before, to count reconnects
+ * - Owns the traffic series of the sessions, a series is deleted when its last session ends
+ */
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[int32]*liveSession
+	seen     map[string]time.Time
+	traffic  map[[2]string]int // 流量指标标签 -> 使用中的会话数
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[int32]*liveSession),
+		seen:     make(map[string]time.Time),
+		traffic:  make(map[[2]string]int),
+	}
+}
+
+// Add 登记会话，返回会话结束时调用的注销函数
+func (sr *sessionRegistry) Add(ls *liveSession) func() {
+	sr.mu.Lock()
+	sr.sessions[ls.id] = ls
+	sr.traffic[ls.traffic]++
+	ls.bytesIn = userBytes.WithLabelValues(ls.traffic[0], ls.traffic[1], "in")
+	ls.bytesOut = userBytes.WithLabelValues(ls.traffic[0], ls.traffic[1], "out")
+	if ls.clientId != "" {
+		key := ls.clientId + "-" + ls.appName
+		if _, ok := sr.seen[key]; ok {
+			reconnects.Inc()
+		}
+		sr.seen[key] = ls.startTime
+		sr.pruneSeen()
+	}
+	sr.mu.Unlock()
+	sessionsActive.Inc()
+	return func() {
+		sr.mu.Lock()
+		delete(sr.sessions, ls.id)
+		if sr.traffic[ls.traffic]--; sr.traffic[ls.traffic] <= 0 {
+			delete(sr.traffic, ls.traffic)
+			userBytes.DeleteLabelValues(ls.traffic[0], ls.traffic[1], "in")
+			userBytes.DeleteLabelValues(ls.traffic[0], ls.traffic[1], "out")
+		}
+		sr.mu.Unlock()
+		sessionsActive.Dec()
+	}
+}
+
+func (sr *sessionRegistry) pruneSeen() {
+	if len(sr.seen) <= maxSeenClients {
+		return
+	}
+	oldest, oldestTime := "", time.Time{}
+	for key, t := range sr.seen {
+		if oldest == "" || t.Before(oldestTime) {
+			oldest, oldestTime = key, t
+		}
+	}
+	delete(sr.seen, oldest)
+}
+
+// List 列出会话，user为空时列出所有会话
+func (sr *sessionRegistry) List(user string) []SessionInfo {
+	sr.mu.Lock()
+	list := []SessionInfo{}
+	for _, ls := range sr.sessions {
+		if user == "" || ls.user == user {
+			list = append(list, ls.info())
+		}
+	}
+	sr.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// setTunnel 设置会话的隧道
+func (ls *liveSession) setTunnel(t connServer) {
+	ls.mu.Lock()
+	ls.tunnel = t
+	ls.mu.Unlock()
+}
+
+/**
+ * ServeConn proxies a connection through the session listening on a mapping port
+ * @param {string} port - Mapping port
+ * @param {net.Conn} conn - Connection, checked already, it skips the ACL of the port
+ * @returns {error} tunnel.ErrNotBound if no session of this server listens on the port
+ */
+func (sr *sessionRegistry) ServeConn(port string, conn net.Conn) error {
+	sr.mu.Lock()
+	var tunnels []connServer
+	for _, ls := range sr.sessions {
+		ls.mu.Lock()
+		if ls.tunnel != nil {
+			tunnels = append(tunnels, ls.tunnel)
+		}
+		ls.mu.Unlock()
+	}
+	sr.mu.Unlock()
+	for _, t := range tunnels {
+		if err := t.ServeConn(port, conn); !errors.Is(err, tunnel.ErrNotBound) {
+			return err
+		}
+	}
+	return tunnel.ErrNotBound
+}
+
+// Get 按ID查找会话
+func (sr *sessionRegistry) Get(id int32) (*liveSession, bool) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	ls, ok := sr.sessions[id]
+	return ls, ok
+}
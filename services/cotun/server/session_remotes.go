@@ -0,0 +1,287 @@
+package chserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"github.com/zgsm-ai/cotun/share/tunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+// boundRemote 会话中的一个反向远端
+type boundRemote struct {
+	remote  *settings.Remote
+	release func() // 归还端口配额
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+/**
+ * sessionRemotes holds the reverse remotes of a session
+ * @description
+ * - The remotes of the handshake config are reserved before the config is accepted
+ * - The client changes its reverse remotes over the connection with a "remotes" request,
+ *   carrying its whole config; the change is checked like a handshake and applied at once, or rejected
+ * - Each remote holds its reverse port quota until it's removed or the session ends
+ * - A remote failing closes the session, the client reconnects with its config
+ */
+type sessionRemotes struct {
+	s     *Server
+	l     *cio.Logger
+	req   *http.Request
+	user  *settings.User // 认证文件中的用户，未配置认证文件时为nil
+	owner string         // 配额所属的用户，为空时不限制
+
+	mu       sync.Mutex
+	alloc    *PortAllocation
+	autoPort int                     // 本连接协商的自动端口，一个连接只能协商一个
+	bound    map[string]*boundRemote // remote.Local() -> remote
+	ls       *liveSession
+	tunnel   *tunnel.Tunnel
+	ctx      context.Context
+}
+
+func newSessionRemotes(s *Server, l *cio.Logger, req *http.Request, user *settings.User, alloc *PortAllocation) *sessionRemotes {
+	return &sessionRemotes{
+		s:     s,
+		l:     l,
+		req:   req,
+		user:  user,
+		alloc: alloc,
+		bound: make(map[string]*boundRemote),
+	}
+}
+
+// current 会话当前的端口分配，连接期间增加反向远端时可能变化
+func (sr *sessionRemotes) current() *PortAllocation {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.alloc
+}
+
+/**
+ * negotiate assigns the port of an auto reverse remote
+ * @description
+ * - Auto ports are negotiated during the handshake, or while connected for an auto remote added at runtime
+ * - A connection has one auto port, a new one is only assigned once the remote on the previous one is removed
+ */
+func (sr *sessionRemotes) negotiate(payload []byte) ([]byte, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.autoPort != 0 && (sr.tunnel == nil || sr.listens(sr.autoPort)) {
+		return nil, errors.New("only one auto port per connection")
+	}
+	reply, err := sr.s.handlePortRequest(sr.alloc, sr.user, payload)
+	if err != nil {
+		return nil, err
+	}
+	var pr settings.PortReply
+	json.Unmarshal(reply, &pr)
+	sr.autoPort = pr.MappingPort
+	return reply, nil
+}
+
+// listens 是否有反向远端监听port，caller holds mu
+func (sr *sessionRemotes) listens(port int) bool {
+	for _, b := range sr.bound {
+		if b.remote.LocalPort == strconv.Itoa(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// check 校验用户是否可以使用反向远端，replaced为同时移除的远端
+func (sr *sessionRemotes) check(r *settings.Remote, replaced map[string]*boundRemote) error {
+	if sr.user != nil && !sr.user.HasAccess(r.UserAddr()) {
+		return fmt.Errorf("access to '%s' denied", r.UserAddr())
+	}
+	if !sr.s.config.Reverse {
+		return errors.New("Reverse port forwaring not enabled on server")
+	}
+	if _, ok := replaced[r.Local()]; !ok && !r.CanListen() {
+		return fmt.Errorf("Server cannot listen on %s", r.String())
+	}
+	return nil
+}
+
+// reserve 为反向远端占用端口配额，caller holds mu；失败时归还已占用的配额
+func (sr *sessionRemotes) reserve(remotes []*settings.Remote) ([]*boundRemote, error) {
+	reserved := []*boundRemote{}
+	for _, r := range remotes {
+		b := &boundRemote{remote: r, release: func() {}}
+		if sr.owner != "" {
+			port, _ := strconv.Atoi(r.LocalPort)
+			release, err := sr.s.quotas.AcquirePorts(sr.owner, []int{port})
+			if err != nil {
+				for _, b := range reserved {
+					b.release()
+				}
+				return nil, err
+			}
+			b.release = release
+		}
+		reserved = append(reserved, b)
+	}
+	return reserved, nil
+}
+
+/**
+ * Reserve takes the quota of the reverse remotes of the handshake config
+ * @param {string} owner - Owner of the session quotas, empty without quotas
+ * @param {[]*settings.Remote} remotes - The reverse remotes, already checked
+ */
+func (sr *sessionRemotes) Reserve(owner string, remotes []*settings.Remote) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.owner = owner
+	reserved, err := sr.reserve(remotes)
+	if err != nil {
+		return err
+	}
+	for _, b := range reserved {
+		sr.bound[b.remote.Local()] = b
+	}
+	return nil
+}
+
+// Start 会话建立后开始监听所有反向远端
+func (sr *sessionRemotes) Start(ctx context.Context, ls *liveSession, t *tunnel.Tunnel) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.ctx, sr.ls, sr.tunnel = ctx, ls, t
+	for _, b := range sr.bound {
+		sr.bind(b)
+	}
+}
+
+// bind 监听反向远端，直到被移除或会话结束，caller holds mu
+func (sr *sessionRemotes) bind(b *boundRemote) {
+	ctx, cancel := context.WithCancel(sr.ctx)
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		err := sr.tunnel.BindRemotes(ctx, []*settings.Remote{b.remote})
+		if ctx.Err() != nil {
+			return
+		}
+		sr.l.Infof("Remote %s closed (%v), closing connection", b.remote, err)
+		sr.ls.sshConn.Close()
+	}()
+}
+
+// unbind 停止监听反向远端并归还配额
+func (sr *sessionRemotes) unbind(b *boundRemote) {
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+	}
+	b.release()
+}
+
+/**
+ * Update applies the config sent by the client over the connection
+ * @param {settings.Config} c - The whole config of the client, forward remotes only update the session info
+ * @returns {error} Error when a new reverse remote is rejected, then nothing is changed
+ */
+func (sr *sessionRemotes) Update(c settings.Config) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	wanted := map[string]*settings.Remote{}
+	for _, r := range c.Remotes.Reversed(true) {
+		wanted[r.Local()] = r
+	}
+	removed := map[string]*boundRemote{}
+	for local, b := range sr.bound {
+		if r, ok := wanted[local]; !ok || r.String() != b.remote.String() {
+			removed[local] = b
+		}
+	}
+	added := []*settings.Remote{}
+	for _, r := range c.Remotes.Reversed(true) {
+		if b, ok := sr.bound[r.Local()]; ok && b.remote.String() == r.String() {
+			continue
+		}
+		if err := sr.check(r, removed); err != nil {
+			return err
+		}
+		added = append(added, r)
+	}
+	reserved, err := sr.reserve(added)
+	if err != nil {
+		return err
+	}
+	alloc := sr.alloc
+	for _, r := range added {
+		if alloc = sr.s.handleRemote(sr.req, sr.l, alloc, r); alloc == nil {
+			for _, b := range reserved {
+				b.release()
+			}
+			return fmt.Errorf("allocated port failed: %+v", r)
+		}
+	}
+	sr.alloc = alloc
+	//apply
+	for local, b := range removed {
+		sr.unbind(b)
+		delete(sr.bound, local)
+		sr.l.Infof("Removed remote %s", b.remote)
+	}
+	for _, b := range reserved {
+		sr.bound[b.remote.Local()] = b
+		sr.bind(b)
+		sr.l.Infof("Added remote %s", b.remote)
+		ev := sr.ls.auditEvent(AuditRemoteBind)
+		ev.Remote = b.remote.String()
+		ev.Port, _ = strconv.Atoi(b.remote.LocalPort)
+		sr.s.audit.Log(ev)
+	}
+	sr.ls.setRemotes(c.Remotes)
+	return nil
+}
+
+// Release 会话结束时归还所有反向远端的配额
+func (sr *sessionRemotes) Release() {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for _, b := range sr.bound {
+		b.release()
+	}
+}
+
+// handleRequest 处理客户端连接期间发送的请求
+func (sr *sessionRemotes) handleRequest(r *ssh.Request) {
+	switch r.Type {
+	case "port":
+		reply, err := sr.negotiate(r.Payload)
+		if err != nil {
+			sr.l.Infof("Client port request failed: %v", err)
+			r.Reply(false, []byte(err.Error()))
+			return
+		}
+		r.Reply(true, reply)
+	case "remotes":
+		c, err := settings.DecodeConfig(r.Payload)
+		if err != nil {
+			r.Reply(false, []byte("invalid config"))
+			return
+		}
+		if err := sr.Update(*c); err != nil {
+			sr.l.Infof("Client remotes rejected: %v", err)
+			r.Reply(false, []byte(err.Error()))
+			return
+		}
+		r.Reply(true, nil)
+	default:
+		sr.l.Debugf("Unknown request: %s", r.Type)
+		r.Reply(false, nil)
+	}
+}
@@ -0,0 +1,87 @@
+package chserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/zgsm-ai/cotun/share/cnet"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	sr := newSessionRegistry()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	add := func(id int32, user string) func() {
+		return sr.Add(&liveSession{id: id, user: user, clientId: "c-" + user, appName: "app",
+			startTime: time.Now(), conn: cnet.NewShapedConn(c1)})
+	}
+	remove1 := add(1, "foo")
+	defer add(2, "bar")()
+	if list := sr.List(""); len(list) != 2 || list[0].Id != 1 || list[1].Id != 2 {
+		t.Fatalf("unexpected sessions: %+v", list)
+	}
+	if list := sr.List("bar"); len(list) != 1 || list[0].User != "bar" {
+		t.Fatalf("unexpected sessions of bar: %+v", list)
+	}
+	remove1()
+	if _, ok := sr.Get(1); ok {
+		t.Fatal("removed session still listed")
+	}
+	//the same client connects again
+	defer add(3, "foo")()
+	if _, ok := sr.seen["c-foo-app"]; !ok {
+		t.Fatal("reconnecting client not remembered")
+	}
+}
+
+// hasTraffic 是否存在该用户、应用的流量指标
+func hasTraffic(user, app string) bool {
+	ch := make(chan prometheus.Metric, 100)
+	userBytes.Collect(ch)
+	close(ch)
+	for m := range ch {
+		var pb dto.Metric
+		m.Write(&pb)
+		labels := map[string]string{}
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["user"] == user && labels["app"] == app {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSessionTraffic(t *testing.T) {
+	sr := newSessionRegistry()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	add := func(id int32, user, app string) func() {
+		ls := &liveSession{id: id, user: user, appName: app, startTime: time.Now(), conn: cnet.NewShapedConn(c1)}
+		ls.traffic[0], ls.traffic[1] = trafficLabels(user, app)
+		remove := sr.Add(ls)
+		ls.bytesIn.Add(1)
+		return remove
+	}
+	remove1 := add(1, "alice", "app")
+	remove2 := add(2, "alice", "app")
+	remove1()
+	if !hasTraffic("alice", "app") {
+		t.Fatal("traffic deleted while a session is live")
+	}
+	remove2()
+	if hasTraffic("alice", "app") {
+		t.Fatal("traffic kept after the last session ended")
+	}
+	//unverified sessions don't label by the names they claim
+	defer add(3, "", "random-app")()
+	if !hasTraffic(anonymousUser, "") || hasTraffic(anonymousUser, "random-app") {
+		t.Fatal("unverified session labeled by its app name")
+	}
+}
@@ -0,0 +1,151 @@
+package cnet
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//maxPollBuffer bounds the bytes waiting for the
+//next poll, writers block above it
+const maxPollBuffer = 1 << 20
+
+//PollConn is the server side of a long-poll transport. The
+//client pushes the bytes it sends in POST requests, and
+//pulls the bytes it receives with GET requests held open
+//until data is available
+type PollConn struct {
+	inR      *io.PipeReader
+	inW      *io.PipeWriter
+	mu       sync.Mutex
+	drained  *sync.Cond
+	out      []byte
+	closed   bool
+	notify   chan struct{}
+	lastSeen atomic.Int64
+	local    net.Addr
+	remote   net.Addr
+}
+
+//NewPollConn creates the server side of a long-poll session
+func NewPollConn(local, remote net.Addr) *PollConn {
+	c := &PollConn{
+		notify: make(chan struct{}, 1),
+		local:  local,
+		remote: remote,
+	}
+	c.inR, c.inW = io.Pipe()
+	c.drained = sync.NewCond(&c.mu)
+	c.touch()
+	return c
+}
+
+func (c *PollConn) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+func (c *PollConn) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+//Idle returns the time since the last push or pull
+func (c *PollConn) Idle() time.Duration {
+	return time.Since(time.Unix(0, c.lastSeen.Load()))
+}
+
+//Push feeds the body of a client request to the reader of the connection
+func (c *PollConn) Push(r io.Reader) error {
+	c.touch()
+	_, err := io.Copy(c.inW, r)
+	return err
+}
+
+//Pull takes up to max bytes written to the connection, waiting
+//at most wait for some, io.EOF is returned once closed and drained
+func (c *PollConn) Pull(wait time.Duration, max int) ([]byte, error) {
+	c.touch()
+	defer c.touch()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		if len(c.out) > 0 {
+			n := len(c.out)
+			if n > max {
+				n = max
+			}
+			b := make([]byte, n)
+			copy(b, c.out)
+			c.out = c.out[n:]
+			c.drained.Broadcast()
+			c.mu.Unlock()
+			return b, nil
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil, io.EOF
+		}
+		select {
+		case <-c.notify:
+		case <-timer.C:
+			return nil, nil
+		}
+	}
+}
+
+func (c *PollConn) Read(b []byte) (int, error) {
+	return c.inR.Read(b)
+}
+
+func (c *PollConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.out) >= maxPollBuffer && !c.closed {
+		c.drained.Wait()
+	}
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.out = append(c.out, b...)
+	c.signal()
+	return len(b), nil
+}
+
+func (c *PollConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.inW.CloseWithError(io.EOF)
+	c.drained.Broadcast()
+	c.signal()
+	return nil
+}
+
+func (c *PollConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *PollConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *PollConn) SetDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *PollConn) SetReadDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *PollConn) SetWriteDeadline(t time.Time) error {
+	return nil //no-op
+}
@@ -0,0 +1,118 @@
+package cnet
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// Shaper limits the bytes flowing through a connection, it
+// may be shared by several connections (e.g. all sessions of a user)
+type Shaper struct {
+	Read, Write *rate.Limiter //token buckets, nil means unlimited
+}
+
+// NewShaper creates a shaper allowing bps bytes per second in each
+// direction, bps <= 0 is unlimited
+func NewShaper(bps int64) *Shaper {
+	s := &Shaper{}
+	if bps > 0 {
+		burst := int(bps)
+		if burst < 64*1024 {
+			burst = 64 * 1024
+		}
+		s.Read = rate.NewLimiter(rate.Limit(bps), burst)
+		s.Write = rate.NewLimiter(rate.Limit(bps), burst)
+	}
+	return s
+}
+
+func wait(l *rate.Limiter, n int) {
+	if l == nil {
+		return
+	}
+	for n > 0 {
+		chunk := n
+		if b := l.Burst(); chunk > b {
+			chunk = b
+		}
+		l.WaitN(context.Background(), chunk)
+		n -= chunk
+	}
+}
+
+// ShapedConn applies a Shaper to a connection and counts its
+// traffic, the shaper and counter can be set once the owner of
+// the connection is known
+type ShapedConn struct {
+	net.Conn
+	shaper        atomic.Pointer[Shaper]
+	counter       atomic.Pointer[func(read, written int)]
+	read, written atomic.Int64
+}
+
+// NewShapedConn wraps c, initially without shaper
+func NewShapedConn(c net.Conn) *ShapedConn {
+	return &ShapedConn{Conn: c}
+}
+
+// SetShaper sets (or with nil removes) the shaper of the connection
+func (c *ShapedConn) SetShaper(s *Shaper) {
+	c.shaper.Store(s)
+}
+
+// SetCounter sets a function called with the bytes read and written
+func (c *ShapedConn) SetCounter(count func(read, written int)) {
+	c.counter.Store(&count)
+}
+
+// Traffic returns the total bytes read and written
+func (c *ShapedConn) Traffic() (read, written int64) {
+	return c.read.Load(), c.written.Load()
+}
+
+func (c *ShapedConn) count(read, written int) {
+	c.read.Add(int64(read))
+	c.written.Add(int64(written))
+	if f := c.counter.Load(); f != nil {
+		(*f)(read, written)
+	}
+}
+
+func (c *ShapedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if s := c.shaper.Load(); s != nil {
+			wait(s.Read, n)
+		}
+		c.count(n, 0)
+	}
+	return n, err
+}
+
+func (c *ShapedConn) Write(b []byte) (int, error) {
+	var limiter *rate.Limiter
+	if s := c.shaper.Load(); s != nil {
+		limiter = s.Write
+	}
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if limiter != nil && len(chunk) > limiter.Burst() {
+			chunk = chunk[:limiter.Burst()]
+		}
+		wait(limiter, len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if n > 0 {
+			c.count(0, n)
+		}
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
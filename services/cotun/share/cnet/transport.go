@@ -0,0 +1,128 @@
+package cnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//transports carrying the ssh connection between client and server
+const (
+	TransportWebSocket = "ws"   //websocket upgrade (default)
+	TransportHTTP2     = "h2"   //bidirectional HTTP/2 request/response stream
+	TransportTLS       = "tls"  //raw TLS TCP, selected via ALPN
+	TransportPoll      = "poll" //HTTP long-poll, for proxies breaking streams
+)
+
+//Transports lists the known transports, in the order
+//a client negotiating the transport tries them
+var Transports = []string{TransportWebSocket, TransportHTTP2, TransportTLS, TransportPoll}
+
+//headers of the non-websocket transports
+const (
+	ProtocolHeader   = "X-Cotun-Protocol"   //protocol version, like the websocket sub-protocol
+	TransportHeader  = "X-Cotun-Transport"  //transport requested by the client
+	TransportsHeader = "X-Cotun-Transports" //transports enabled on the server
+	SessionHeader    = "X-Cotun-Session"    //long-poll session id
+)
+
+//ALPNProtocol selects the raw TLS transport during the TLS handshake
+const ALPNProtocol = "cotun"
+
+//ParseTransports parses a comma separated list of transports
+func ParseTransports(s string) ([]string, error) {
+	list := []string{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		known := false
+		for _, k := range Transports {
+			known = known || k == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown transport '%s' (expected %s)", t, strings.Join(Transports, ","))
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+type streamConn struct {
+	io.Reader
+	w      io.Writer
+	flush  func()
+	closer func() error
+	once   sync.Once
+	local  net.Addr
+	remote net.Addr
+}
+
+//NewStreamConn converts the reading and writing halves of
+//a stream (e.g. an HTTP/2 request and its response) into a
+//net.Conn, flush is called after each write when not nil
+func NewStreamConn(r io.Reader, w io.Writer, flush func(), closer func() error, local, remote net.Addr) net.Conn {
+	return &streamConn{Reader: r, w: w, flush: flush, closer: closer, local: local, remote: remote}
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	if err == nil && c.flush != nil {
+		c.flush()
+	}
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.closer()
+	})
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil //no-op
+}
+
+//Addr is a net.Addr from a "host:port" string
+type Addr string
+
+func (a Addr) Network() string {
+	return "tcp"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+//StatusError is an HTTP error returned while opening a transport
+type StatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.Code), e.Msg)
+}
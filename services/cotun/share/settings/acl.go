@@ -0,0 +1,124 @@
+package settings
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ACL restricts the peers connecting to the reverse ports of a user, it
+// applies to the port Port, else to the ports of app App, else to all the
+// ports of the user when both are empty
+type ACL struct {
+	Port     int      `json:"port,omitempty"`
+	App      string   `json:"app,omitempty"`
+	Allow    []string `json:"allow,omitempty"`    //IPs or CIDRs allowed, empty allows all
+	Deny     []string `json:"deny,omitempty"`     //IPs or CIDRs denied, checked before allow
+	Secret   string   `json:"secret,omitempty"`   //shared secret the peer sends first, as a line
+	ClientCA string   `json:"clientCA,omitempty"` //PEM file of the CAs of the peer certificates (mTLS)
+
+	allow, deny []*net.IPNet
+	clientCAs   *x509.CertPool
+}
+
+// Validate checks the ACL, and prepares its address lists and CAs
+func (a *ACL) Validate() error {
+	if a.Port < 0 || a.Port > 65535 {
+		return fmt.Errorf("invalid acl port %d", a.Port)
+	}
+	var err error
+	if a.allow, err = parseNets(a.Allow); err != nil {
+		return err
+	}
+	if a.deny, err = parseNets(a.Deny); err != nil {
+		return err
+	}
+	if a.Secret != "" && a.ClientCA != "" {
+		return fmt.Errorf("acl can't use both a secret and a client CA")
+	}
+	a.clientCAs = nil
+	if a.ClientCA != "" {
+		b, err := os.ReadFile(a.ClientCA)
+		if err != nil {
+			return fmt.Errorf("acl client CA: %w", err)
+		}
+		a.clientCAs = x509.NewCertPool()
+		if !a.clientCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("acl client CA: no certificate in %s", a.ClientCA)
+		}
+	}
+	return nil
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid acl address %s", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl address %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// AllowIP checks ip against the deny and allow lists
+func (a *ACL) AllowIP(ip net.IP) bool {
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCAs is the pool of the CAs of the peer certificates, nil without mTLS
+func (a *ACL) ClientCAs() *x509.CertPool {
+	return a.clientCAs
+}
+
+// MatchACL returns the ACL of a reverse port of app, nil if none applies
+func MatchACL(acls []ACL, app string, port int) *ACL {
+	var appACL, userACL *ACL
+	for i := range acls {
+		a := &acls[i]
+		switch {
+		case a.Port != 0:
+			if a.Port == port {
+				return a
+			}
+		case a.App != "":
+			if a.App == app && appACL == nil {
+				appACL = a
+			}
+		case userACL == nil:
+			userACL = a
+		}
+	}
+	if appACL != nil {
+		return appACL
+	}
+	return userACL
+}
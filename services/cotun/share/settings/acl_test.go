@@ -0,0 +1,52 @@
+package settings
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACLMatch(t *testing.T) {
+	acls := []ACL{
+		{Deny: []string{"10.0.0.1"}},
+		{App: "web", Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}},
+		{Port: 30100, Allow: []string{"192.168.1.1", "::1"}},
+	}
+	for i := range acls {
+		if err := acls[i].Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//test table
+	for i, test := range []struct {
+		App   string
+		Port  int
+		IP    string
+		Match int
+		Allow bool
+	}{
+		{"web", 30100, "192.168.1.1", 2, true},
+		{"web", 30100, "::1", 2, true},
+		{"web", 30100, "10.0.0.2", 2, false},
+		{"web", 30101, "10.0.0.2", 1, true},
+		{"web", 30101, "10.1.2.3", 1, false},
+		{"web", 30101, "127.0.0.1", 1, false},
+		{"api", 30101, "127.0.0.1", 0, true},
+		{"api", 30101, "10.0.0.1", 0, false},
+	} {
+		a := MatchACL(acls, test.App, test.Port)
+		if a != &acls[test.Match] {
+			t.Fatalf("#%d: expected acl %d, got %+v", i+1, test.Match, a)
+		}
+		if allow := a.AllowIP(net.ParseIP(test.IP)); allow != test.Allow {
+			t.Fatalf("#%d: expected %s allowed=%v", i+1, test.IP, test.Allow)
+		}
+	}
+	if MatchACL(acls[1:], "api", 30101) != nil {
+		t.Fatal("expected no acl")
+	}
+	for _, a := range []ACL{{Allow: []string{"10.0.0.0/33"}}, {Deny: []string{"host"}}, {Secret: "s", ClientCA: "ca.pem"}} {
+		if err := a.Validate(); err == nil {
+			t.Fatalf("expected %+v to be invalid", a)
+		}
+	}
+}
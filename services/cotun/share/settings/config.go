@@ -24,3 +24,16 @@ func EncodeConfig(c Config) []byte {
 	b, _ := json.Marshal(c)
 	return b
 }
+
+//PortRequest asks the server for the port of an auto
+//reverse remote, it's sent before the config
+type PortRequest struct {
+	AppName     string
+	ClientPort  int
+	MappingPort int //port assigned by a previous connection, kept when possible
+}
+
+//PortReply is the port assigned by the server
+type PortReply struct {
+	MappingPort int
+}
@@ -0,0 +1,48 @@
+package settings
+
+import "fmt"
+
+// Quota limits what a user can consume, zero values mean unlimited
+type Quota struct {
+	MaxSessions int   `json:"maxSessions,omitempty"` //maximum concurrent ssh sessions
+	MaxPorts    int   `json:"maxPorts,omitempty"`    //maximum reverse (mapping) ports
+	MinPort     int   `json:"minPort,omitempty"`     //allowed mapping port sub-range
+	MaxPort     int   `json:"maxPort,omitempty"`
+	Bandwidth   int64 `json:"bandwidth,omitempty"` //bytes per second, per direction, shared by all sessions of the user
+}
+
+// Validate checks the quota is consistent
+func (q *Quota) Validate() error {
+	if q.MaxSessions < 0 || q.MaxPorts < 0 || q.Bandwidth < 0 {
+		return fmt.Errorf("quota limits can't be negative")
+	}
+	if q.MinPort < 0 || q.MaxPort < 0 || q.MinPort > 65535 || q.MaxPort > 65535 {
+		return fmt.Errorf("invalid quota port range %d-%d", q.MinPort, q.MaxPort)
+	}
+	if q.MinPort != 0 && q.MaxPort != 0 && q.MinPort > q.MaxPort {
+		return fmt.Errorf("invalid quota port range %d-%d", q.MinPort, q.MaxPort)
+	}
+	return nil
+}
+
+// AllowPort checks the mapping port is inside the allowed sub-range
+func (q *Quota) AllowPort(port int) bool {
+	if q.MinPort != 0 && port < q.MinPort {
+		return false
+	}
+	if q.MaxPort != 0 && port > q.MaxPort {
+		return false
+	}
+	return true
+}
+
+// PortRange narrows [min, max] to the allowed sub-range
+func (q *Quota) PortRange(min, max int) (int, int) {
+	if q.MinPort > min {
+		min = q.MinPort
+	}
+	if q.MaxPort != 0 && q.MaxPort < max {
+		max = q.MaxPort
+	}
+	return min, max
+}
@@ -31,6 +31,18 @@ import (
 //   1.1.1.1:53/udp
 //     local  127.0.0.1:53/udp
 //     remote 1.1.1.1:53/udp
+//   5353/tcp:1.1.1.1:53/udp
+//     local  0.0.0.0:5353/tcp
+//     remote 1.1.1.1:53/udp
+//   5000/udp:example.com:5000
+//     local  0.0.0.0:5000/udp
+//     remote example.com:5000/tcp
+//   R:auto:3000
+//     local  0.0.0.0:<port assigned by the server>
+//     remote 127.0.0.1:3000
+//
+// cross-protocol remotes carry datagrams over the tcp side
+// with a 2-byte big-endian length prefix on each datagram
 
 type Remote struct {
 	LocalHost, LocalPort, LocalProto    string
@@ -40,6 +52,10 @@ type Remote struct {
 
 const revPrefix = "R:"
 
+//AutoPort in place of the local port of a reverse remote
+//asks the server to assign the port during the handshake
+const AutoPort = "auto"
+
 func DecodeRemote(s string) (*Remote, error) {
 	reverse := false
 	if strings.HasPrefix(s, revPrefix) {
@@ -74,6 +90,10 @@ func DecodeRemote(s string) (*Remote, error) {
 				r.LocalProto = proto
 			}
 		}
+		if p == AutoPort && r.Reverse && !r.Socks && r.RemotePort != "" {
+			r.LocalPort = p
+			continue
+		}
 		if isPort(p) {
 			if !r.Socks && r.RemotePort == "" {
 				r.RemotePort = p
@@ -117,15 +137,12 @@ func DecodeRemote(s string) (*Remote, error) {
 	if r.LocalProto == "" {
 		r.LocalProto = r.RemoteProto
 	}
-	if r.LocalProto != r.RemoteProto {
-		//TODO support cross protocol
-		//tcp <-> udp, is faily straight forward
-		//udp <-> tcp, is trickier since udp is stateless and tcp is not
-		return nil, errors.New("cross-protocol remotes are not supported yet")
-	}
 	if r.Socks && r.RemoteProto != "tcp" {
 		return nil, errors.New("only TCP SOCKS is supported")
 	}
+	if r.Stdio && r.LocalProto != r.RemoteProto {
+		return nil, errors.New("stdio cannot be cross-protocol")
+	}
 	if r.Stdio && r.Reverse {
 		return nil, errors.New("stdio cannot be reversed")
 	}
@@ -169,6 +186,9 @@ func (r Remote) String() string {
 		sb.WriteString(revPrefix)
 	}
 	sb.WriteString(strings.TrimPrefix(r.Local(), "0.0.0.0:"))
+	if r.CrossProto() {
+		sb.WriteString("/" + r.LocalProto)
+	}
 	sb.WriteString("=>")
 	sb.WriteString(strings.TrimPrefix(r.Remote(), "127.0.0.1:"))
 	if r.RemoteProto == "udp" {
@@ -183,6 +203,9 @@ func (r Remote) Encode() string {
 		r.LocalPort = r.RemotePort
 	}
 	local := r.Local()
+	if r.CrossProto() {
+		local += "/" + r.LocalProto
+	}
 	remote := r.Remote()
 	if r.RemoteProto == "udp" {
 		remote += "/udp"
@@ -215,6 +238,11 @@ func (r Remote) Remote() string {
 	return r.RemoteHost + ":" + r.RemotePort
 }
 
+//CrossProto is true when the local and remote protocols differ (tcp <-> udp)
+func (r Remote) CrossProto() bool {
+	return r.LocalProto != "" && r.RemoteProto != "" && r.LocalProto != r.RemoteProto
+}
+
 //UserAddr is checked when checking if a
 //user has access to a given remote
 func (r Remote) UserAddr() string {
@@ -224,6 +252,11 @@ func (r Remote) UserAddr() string {
 	return r.RemoteHost + ":" + r.RemotePort
 }
 
+//Auto tells whether the server assigns the port of the reverse remote
+func (r Remote) Auto() bool {
+	return r.Reverse && r.LocalPort == AutoPort
+}
+
 //CanListen checks if the port can be listened on
 func (r Remote) CanListen() bool {
 	//valid protocols
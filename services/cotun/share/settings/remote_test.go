@@ -90,6 +90,29 @@ func TestRemoteDecode(t *testing.T) {
 			},
 			"localhost:5353:1.1.1.1:53/udp",
 		},
+		{
+			"5353/tcp:1.1.1.1:53/udp",
+			Remote{
+				LocalPort:   "5353",
+				LocalProto:  "tcp",
+				RemoteHost:  "1.1.1.1",
+				RemotePort:  "53",
+				RemoteProto: "udp",
+			},
+			"0.0.0.0:5353/tcp:1.1.1.1:53/udp",
+		},
+		{
+			"R:5000/udp:example.com:5000",
+			Remote{
+				LocalPort:   "5000",
+				LocalProto:  "udp",
+				RemoteHost:  "example.com",
+				RemotePort:  "5000",
+				RemoteProto: "tcp",
+				Reverse:     true,
+			},
+			"R:0.0.0.0:5000/udp:example.com:5000",
+		},
 		{
 			"[::1]:8080:google.com:80",
 			Remote{
@@ -111,6 +134,16 @@ func TestRemoteDecode(t *testing.T) {
 			},
 			"R:[::]:3000:[::1]:3000",
 		},
+		{
+			"R:auto:3000",
+			Remote{
+				LocalPort:  "auto",
+				RemoteHost: "127.0.0.1",
+				RemotePort: "3000",
+				Reverse:    true,
+			},
+			"R:0.0.0.0:auto:127.0.0.1:3000",
+		},
 	} {
 		//expected defaults
 		expected := test.Output
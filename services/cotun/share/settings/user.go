@@ -19,6 +19,8 @@ type User struct {
 	Name  string
 	Pass  string
 	Addrs []*regexp.Regexp
+	Quota *Quota
+	ACLs  []ACL
 }
 
 func (u *User) HasAccess(addr string) bool {
@@ -37,6 +37,17 @@ func (u *Users) Get(key string) (*User, bool) {
 	return user, found
 }
 
+// All returns a snapshot of the users
+func (u *Users) All() []*User {
+	u.RLock()
+	users := make([]*User, 0, len(u.inner))
+	for _, user := range u.inner {
+		users = append(users, user)
+	}
+	u.RUnlock()
+	return users
+}
+
 // Set a users into the list by specific key
 func (u *Users) Set(key string, user *User) {
 	u.Lock()
@@ -129,18 +140,42 @@ func (u *UserIndex) loadUserIndex() error {
 	if err != nil {
 		return fmt.Errorf("Failed to read auth file: %s, error: %s", u.configFile, err)
 	}
-	var raw map[string][]string
+	//each user is either a list of address regexes, or
+	//an object {"remotes": [...], "quota": {...}, "acls": [...]}
+	var raw map[string]json.RawMessage
 	if err := json.Unmarshal(b, &raw); err != nil {
 		return errors.New("Invalid JSON: " + err.Error())
 	}
 	users := []*User{}
-	for auth, remotes := range raw {
+	for auth, value := range raw {
 		user := &User{}
 		user.Name, user.Pass = ParseAuth(auth)
 		if user.Name == "" {
 			return errors.New("Invalid user:pass string")
 		}
-		for _, r := range remotes {
+		var entry struct {
+			Remotes []string `json:"remotes"`
+			Quota   *Quota   `json:"quota"`
+			ACLs    []ACL    `json:"acls"`
+		}
+		if err := json.Unmarshal(value, &entry.Remotes); err != nil {
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return fmt.Errorf("Invalid user %s: %s", user.Name, err)
+			}
+		}
+		if entry.Quota != nil {
+			if err := entry.Quota.Validate(); err != nil {
+				return fmt.Errorf("Invalid user %s: %s", user.Name, err)
+			}
+			user.Quota = entry.Quota
+		}
+		for i := range entry.ACLs {
+			if err := entry.ACLs[i].Validate(); err != nil {
+				return fmt.Errorf("Invalid user %s: %s", user.Name, err)
+			}
+		}
+		user.ACLs = entry.ACLs
+		for _, r := range entry.Remotes {
 			if r == "" || r == "*" {
 				user.Addrs = append(user.Addrs, UserAllowAll)
 			} else {
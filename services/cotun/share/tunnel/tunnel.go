@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -25,6 +26,30 @@ type Config struct {
 	Outbound  bool
 	Socks     bool
 	KeepAlive time.Duration
+	//OnChannel is called when a channel to remote opens (delta 1) or closes (delta -1)
+	OnChannel func(remote string, delta int)
+	//OnConnection is called when a proxied connection opens and when it closes
+	OnConnection func(ev ConnEvent)
+	//OnAccept checks each connection accepted by the tcp proxies of remote,
+	//it may wrap the connection (e.g. with TLS), which is closed on error
+	OnAccept func(remote *settings.Remote, conn net.Conn) (net.Conn, error)
+	//AllowPacket checks the source of the packets received by the udp proxies of remote
+	AllowPacket func(remote *settings.Remote, addr *net.UDPAddr) bool
+	//OnRequest handles the global requests the tunnel doesn't know,
+	//it must reply to requests wanting a reply
+	OnRequest func(r *ssh.Request)
+}
+
+// ConnEvent is a proxied connection opening or closing
+type ConnEvent struct {
+	Remote string
+	//Peer is the address of the connecting side of inbound connections
+	Peer   string
+	Closed bool
+	//bytes from and to the connecting side, and the duration, once closed
+	Sent     int64
+	Received int64
+	Duration time.Duration
 }
 
 // Tunnel represents an SSH tunnel with proxy capabilities.
@@ -40,8 +65,10 @@ type Tunnel struct {
 	activeConnMut  sync.RWMutex
 	activatingConn waitGroup
 	activeConn     ssh.Conn
-	//proxies
+	//proxies, bound concurrently by clients changing their remotes
+	proxyMut   sync.Mutex
 	proxyCount int
+	served     map[string]servedProxy //tcp proxies by local port, see ServeConn
 	//internals
 	connStats   cnet.ConnCount
 	socksServer *socks5.Server
@@ -151,15 +178,19 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	}
 	proxies := make([]*Proxy, len(remotes))
 	for i, remote := range remotes {
-		p, err := NewProxy(t.Logger, t, t.proxyCount, remote)
+		t.proxyMut.Lock()
+		index := t.proxyCount
+		t.proxyCount++
+		t.proxyMut.Unlock()
+		p, err := NewProxy(t.Logger, t, index, remote)
 		if err != nil {
 			return err
 		}
 		proxies[i] = p
-		t.proxyCount++
 	}
 	//TODO: handle tunnel close
 	eg, ctx := errgroup.WithContext(ctx)
+	defer t.serve(ctx, proxies)()
 	for _, proxy := range proxies {
 		p := proxy
 		eg.Go(func() error {
@@ -172,6 +203,92 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	return err
 }
 
+// ErrNotBound is returned by ServeConn when no proxy of the tunnel listens on the port
+var ErrNotBound = errors.New("port not bound")
+
+// servedProxy is a bound tcp proxy and the context it runs with
+type servedProxy struct {
+	ctx   context.Context
+	proxy *Proxy
+}
+
+// serve registers the tcp proxies for ServeConn, and returns the function unregistering them
+func (t *Tunnel) serve(ctx context.Context, proxies []*Proxy) func() {
+	var ports []string
+	t.proxyMut.Lock()
+	for _, p := range proxies {
+		if p.tcp == nil || p.remote.RemoteProto != "tcp" {
+			continue
+		}
+		if t.served == nil {
+			t.served = make(map[string]servedProxy)
+		}
+		t.served[p.remote.LocalPort] = servedProxy{ctx: ctx, proxy: p}
+		ports = append(ports, p.remote.LocalPort)
+	}
+	t.proxyMut.Unlock()
+	return func() {
+		t.proxyMut.Lock()
+		for _, port := range ports {
+			if t.served[port].ctx == ctx {
+				delete(t.served, port)
+			}
+		}
+		t.proxyMut.Unlock()
+	}
+}
+
+// ServeConn proxies conn through the tcp proxy listening on port, as if the proxy
+// had accepted it, without OnAccept: the caller checked the connection already.
+// The connection is closed once proxied.
+func (t *Tunnel) ServeConn(port string, conn net.Conn) error {
+	t.proxyMut.Lock()
+	sp, ok := t.served[port]
+	t.proxyMut.Unlock()
+	if !ok {
+		return ErrNotBound
+	}
+	go sp.proxy.pipeRemote(sp.ctx, conn)
+	return nil
+}
+
+func (t *Tunnel) observeChannel(remote string, delta int) {
+	if t.Config.OnChannel != nil {
+		t.Config.OnChannel(remote, delta)
+	}
+}
+
+func (t *Tunnel) acceptConn(remote *settings.Remote, conn net.Conn) (net.Conn, error) {
+	if t.Config.OnAccept == nil {
+		return conn, nil
+	}
+	return t.Config.OnAccept(remote, conn)
+}
+
+func (t *Tunnel) allowPacket(remote *settings.Remote, addr *net.UDPAddr) bool {
+	return t.Config.AllowPacket == nil || t.Config.AllowPacket(remote, addr)
+}
+
+// observeConn reports the opening of a connection, and returns the
+// function reporting its closing
+func (t *Tunnel) observeConn(remote, peer string) func(sent, received int64) {
+	if t.Config.OnConnection == nil {
+		return func(sent, received int64) {}
+	}
+	start := time.Now()
+	t.Config.OnConnection(ConnEvent{Remote: remote, Peer: peer})
+	return func(sent, received int64) {
+		t.Config.OnConnection(ConnEvent{
+			Remote:   remote,
+			Peer:     peer,
+			Closed:   true,
+			Sent:     sent,
+			Received: received,
+			Duration: time.Since(start),
+		})
+	}
+}
+
 func (t *Tunnel) keepAliveLoop(sshConn ssh.Conn) {
 	//ping forever
 	for {
@@ -15,6 +15,10 @@ import (
 // sshTunnel exposes a subset of Tunnel to subtypes
 type sshTunnel interface {
 	getSSH(ctx context.Context) ssh.Conn
+	observeChannel(remote string, delta int)
+	observeConn(remote, peer string) func(sent, received int64)
+	acceptConn(remote *settings.Remote, conn net.Conn) (net.Conn, error)
+	allowPacket(remote *settings.Remote, addr *net.UDPAddr) bool
 }
 
 // Proxy is the inbound portion of a Tunnel
@@ -27,6 +31,7 @@ type Proxy struct {
 	dialer net.Dialer
 	tcp    *net.TCPListener
 	udp    *udpListener
+	udpTCP *udpStreamListener
 	mu     sync.Mutex
 }
 
@@ -56,6 +61,13 @@ func (p *Proxy) listen() error {
 		}
 		p.Infof("Listening")
 		p.tcp = l
+	} else if p.remote.LocalProto == "udp" && p.remote.RemoteProto == "tcp" {
+		l, err := listenUDPStream(p.Logger, p.sshTun, p.remote)
+		if err != nil {
+			return err
+		}
+		p.Infof("Listening")
+		p.udpTCP = l
 	} else if p.remote.LocalProto == "udp" {
 		l, err := listenUDP(p.Logger, p.sshTun, p.remote)
 		if err != nil {
@@ -76,6 +88,8 @@ func (p *Proxy) Run(ctx context.Context) error {
 		return p.runStdio(ctx)
 	} else if p.remote.LocalProto == "tcp" {
 		return p.runTCP(ctx)
+	} else if p.udpTCP != nil {
+		return p.udpTCP.run(ctx)
 	} else if p.remote.LocalProto == "udp" {
 		return p.udp.run(ctx)
 	}
@@ -118,8 +132,23 @@ func (p *Proxy) runTCP(ctx context.Context) error {
 			close(done)
 			return err
 		}
-		go p.pipeRemote(ctx, src)
+		go p.accept(ctx, src)
+	}
+}
+
+// accept checks an accepted connection, then proxies it
+func (p *Proxy) accept(ctx context.Context, src net.Conn) {
+	conn, err := p.sshTun.acceptConn(p.remote, src)
+	if err != nil {
+		p.Debugf("Rejected %s: %s", src.RemoteAddr(), err)
+		src.Close()
+		return
 	}
+	if p.remote.RemoteProto == "udp" {
+		p.pipeRemoteUDP(ctx, conn)
+		return
+	}
+	p.pipeRemote(ctx, conn)
 }
 
 func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
@@ -144,7 +173,28 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 		return
 	}
 	go ssh.DiscardRequests(reqs)
+	p.sshTun.observeChannel(p.remote.String(), 1)
+	defer p.sshTun.observeChannel(p.remote.String(), -1)
+	peer := ""
+	if c, ok := src.(net.Conn); ok {
+		peer = c.RemoteAddr().String()
+	}
+	closed := p.sshTun.observeConn(p.remote.String(), peer)
 	//then pipe
 	s, r := cio.Pipe(src, dst)
+	closed(s, r)
 	l.Debugf("Close (sent %s received %s)", sizestr.ToString(s), sizestr.ToString(r))
 }
+
+func (p *Proxy) pipeRemoteUDP(ctx context.Context, src net.Conn) {
+	defer src.Close()
+
+	p.mu.Lock()
+	p.count++
+	cid := p.count
+	p.mu.Unlock()
+
+	l := p.Fork("conn#%d", cid)
+	l.Debugf("Open")
+	p.pipeDatagrams(ctx, l, src)
+}
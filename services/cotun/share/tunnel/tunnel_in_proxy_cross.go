@@ -0,0 +1,302 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jpillora/sizestr"
+	"github.com/zgsm-ai/cotun/share/cio"
+	"github.com/zgsm-ai/cotun/share/settings"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// cross-protocol remotes carry datagrams over the tcp side of
+// the remote, each datagram prefixed with its 2-byte big-endian
+// length (like DNS over TCP). the exit node is unaware of this,
+// it only ever sees a plain tcp stream or a udp channel:
+//
+//	tcp -> udp: tcp client --frames--> proxy --udp channel--> udp handler -> dst
+//	udp -> tcp: udp client --packets-> proxy --tcp channel (frames)-------> dst
+
+const maxFrameSize = 0xffff
+
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > maxFrameSize {
+		return fmt.Errorf("datagram too large (%d bytes)", len(b))
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader, buff []byte) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(size[:]))
+	if n > len(buff) {
+		return nil, fmt.Errorf("datagram too large (%d bytes)", n)
+	}
+	if _, err := io.ReadFull(r, buff[:n]); err != nil {
+		return nil, err
+	}
+	return buff[:n], nil
+}
+
+// pipeDatagrams forwards the length-prefixed datagrams of a
+// tcp connection to a udp remote, using a udp channel per
+// tcp connection. replies are framed back onto the connection.
+func (p *Proxy) pipeDatagrams(ctx context.Context, l *cio.Logger, src net.Conn) {
+	sshConn := p.sshTun.getSSH(ctx)
+	if sshConn == nil {
+		l.Debugf("No remote connection")
+		return
+	}
+	rwc, reqs, err := sshConn.OpenChannel("cotun", []byte(p.remote.Remote()+"/udp"))
+	if err != nil {
+		l.Infof("Stream error: %s", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	defer rwc.Close()
+	p.sshTun.observeChannel(p.remote.String(), 1)
+	defer p.sshTun.observeChannel(p.remote.String(), -1)
+	uc := &udpChannel{
+		r: gob.NewDecoder(rwc),
+		w: gob.NewEncoder(rwc),
+		c: rwc,
+	}
+	var sent, recv int64
+	closed := p.sshTun.observeConn(p.remote.String(), src.RemoteAddr().String())
+	defer func() {
+		closed(sent, atomic.LoadInt64(&recv))
+	}()
+	go func() {
+		//channel closed, unblock the reader below
+		defer src.Close()
+		for {
+			pkt := udpPacket{}
+			if err := uc.decode(&pkt); err != nil {
+				return
+			}
+			if err := writeFrame(src, pkt.Payload); err != nil {
+				return
+			}
+			atomic.AddInt64(&recv, int64(len(pkt.Payload)))
+		}
+	}()
+	buff := make([]byte, settings.EnvInt("UDP_MAX_SIZE", 9012))
+	srcAddr := src.RemoteAddr().String()
+	for {
+		b, err := readFrame(src, buff)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				l.Debugf("read error: %s", err)
+			}
+			break
+		}
+		if err := uc.encode(srcAddr, b); err != nil {
+			l.Debugf("encode error: %s", err)
+			break
+		}
+		sent += int64(len(b))
+	}
+	l.Debugf("Close (sent %s received %s)", sizestr.ToString(sent), sizestr.ToString(atomic.LoadInt64(&recv)))
+}
+
+// listenUDPStream is a udp listener which forwards the packets of
+// each source address over its own tcp stream to a tcp remote.
+// sessions are dropped after being idle for UDP_DEADLINE.
+func listenUDPStream(l *cio.Logger, sshTun sshTunnel, remote *settings.Remote) (*udpStreamListener, error) {
+	a, err := net.ResolveUDPAddr("udp", remote.Local())
+	if err != nil {
+		return nil, l.Errorf("resolve: %s", err)
+	}
+	conn, err := net.ListenUDP("udp", a)
+	if err != nil {
+		return nil, l.Errorf("listen: %s", err)
+	}
+	u := &udpStreamListener{
+		Logger:   l,
+		sshTun:   sshTun,
+		remote:   remote,
+		inbound:  conn,
+		sessions: map[string]*udpSession{},
+		maxMTU:   settings.EnvInt("UDP_MAX_SIZE", 9012),
+		idle:     settings.EnvDuration("UDP_DEADLINE", 15*time.Second),
+	}
+	u.Debugf("UDP max size: %d bytes, idle timeout: %s", u.maxMTU, u.idle)
+	return u, nil
+}
+
+type udpStreamListener struct {
+	*cio.Logger
+	sshTun     sshTunnel
+	remote     *settings.Remote
+	inbound    *net.UDPConn
+	mu         sync.Mutex
+	sessions   map[string]*udpSession
+	count      int
+	sent, recv int64
+	maxMTU     int
+	idle       time.Duration
+}
+
+// udpSession is the tcp stream of one udp source address
+type udpSession struct {
+	src    *net.UDPAddr
+	stream io.ReadWriteCloser
+	active atomic.Int64 //unix nano of the last packet
+}
+
+func (u *udpStreamListener) run(ctx context.Context) error {
+	defer u.inbound.Close()
+	defer u.closeAll()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return u.runInbound(ctx)
+	})
+	eg.Go(func() error {
+		u.runSweeper(ctx)
+		return nil
+	})
+	if err := eg.Wait(); err != nil {
+		u.Debugf("listen: %s", err)
+		return err
+	}
+	u.Debugf("Close (sent %s received %s)", sizestr.ToString(atomic.LoadInt64(&u.sent)), sizestr.ToString(atomic.LoadInt64(&u.recv)))
+	return nil
+}
+
+func (u *udpStreamListener) runInbound(ctx context.Context) error {
+	buff := make([]byte, u.maxMTU)
+	for !isDone(ctx) {
+		u.inbound.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := u.inbound.ReadFromUDP(buff)
+		if e, ok := err.(net.Error); ok && (e.Timeout() || e.Temporary()) {
+			continue
+		}
+		if err != nil {
+			return u.Errorf("read error: %w", err)
+		}
+		if !u.sshTun.allowPacket(u.remote, addr) {
+			continue //dropped packet...
+		}
+		s, err := u.getSession(ctx, addr)
+		if err != nil {
+			u.Debugf("session error: %s", err)
+			continue //dropped packet...
+		}
+		if err := writeFrame(s.stream, buff[:n]); err != nil {
+			u.Debugf("write error: %s", err)
+			u.remove(s)
+			continue
+		}
+		s.active.Store(time.Now().UnixNano())
+		atomic.AddInt64(&u.sent, int64(n))
+	}
+	return nil
+}
+
+func (u *udpStreamListener) getSession(ctx context.Context, addr *net.UDPAddr) (*udpSession, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if s, ok := u.sessions[addr.String()]; ok {
+		return s, nil
+	}
+	sshConn := u.sshTun.getSSH(ctx)
+	if sshConn == nil {
+		return nil, fmt.Errorf("ssh-conn nil")
+	}
+	stream, reqs, err := sshConn.OpenChannel("cotun", []byte(u.remote.Remote()))
+	if err != nil {
+		return nil, fmt.Errorf("ssh-chan error: %s", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	s := &udpSession{src: addr, stream: stream}
+	s.active.Store(time.Now().UnixNano())
+	u.sessions[addr.String()] = s
+	u.count++
+	u.sshTun.observeChannel(u.remote.String(), 1)
+	go u.runOutbound(u.Fork("session#%d", u.count), s)
+	return s, nil
+}
+
+// runOutbound writes the framed replies of the stream back to the udp source
+func (u *udpStreamListener) runOutbound(l *cio.Logger, s *udpSession) {
+	defer u.sshTun.observeChannel(u.remote.String(), -1)
+	defer u.remove(s)
+	l.Debugf("Open %s", s.src)
+	buff := make([]byte, u.maxMTU)
+	for {
+		b, err := readFrame(s.stream, buff)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !os.IsTimeout(err) && !strings.HasSuffix(err.Error(), "EOF") {
+				l.Debugf("read error: %s", err)
+			}
+			break
+		}
+		n, err := u.inbound.WriteToUDP(b, s.src)
+		if err != nil {
+			l.Debugf("write error: %s", err)
+			break
+		}
+		s.active.Store(time.Now().UnixNano())
+		atomic.AddInt64(&u.recv, int64(n))
+	}
+	l.Debugf("Close %s", s.src)
+}
+
+// runSweeper closes the sessions idle for longer than the idle timeout
+func (u *udpStreamListener) runSweeper(ctx context.Context) {
+	ticker := time.NewTicker(u.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deadline := time.Now().Add(-u.idle).UnixNano()
+		u.mu.Lock()
+		for id, s := range u.sessions {
+			if s.active.Load() < deadline {
+				s.stream.Close()
+				delete(u.sessions, id)
+			}
+		}
+		u.mu.Unlock()
+	}
+}
+
+func (u *udpStreamListener) remove(s *udpSession) {
+	s.stream.Close()
+	u.mu.Lock()
+	if u.sessions[s.src.String()] == s {
+		delete(u.sessions, s.src.String())
+	}
+	u.mu.Unlock()
+}
+
+func (u *udpStreamListener) closeAll() {
+	u.mu.Lock()
+	for id, s := range u.sessions {
+		s.stream.Close()
+		delete(u.sessions, id)
+	}
+	u.mu.Unlock()
+}
@@ -95,6 +95,9 @@ func (u *udpListener) runInbound(ctx context.Context) error {
 		if err != nil {
 			return u.Errorf("read error: %w", err)
 		}
+		if !u.sshTun.allowPacket(u.remote, addr) {
+			continue //dropped packet...
+		}
 		//upsert ssh channel
 		uc, err := u.getUDPChan(ctx)
 		if err != nil {
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	"github.com/jpillora/sizestr"
 	"github.com/zgsm-ai/cotun/share/cio"
@@ -19,7 +20,12 @@ func (t *Tunnel) handleSSHRequests(reqs <-chan *ssh.Request) {
 		case "ping":
 			r.Reply(true, []byte("pong"))
 		default:
+			if t.Config.OnRequest != nil {
+				t.Config.OnRequest(r)
+				continue
+			}
 			t.Debugf("Unknown request: %s", r.Type)
+			r.Reply(false, nil)
 		}
 	}
 }
@@ -58,6 +64,10 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 	l := t.Logger.Fork("conn#%d", t.connStats.New())
 	//ready to handle
 	t.connStats.Open()
+	t.observeChannel(remote, 1)
+	counted := &countedRWC{ReadWriteCloser: stream}
+	stream = counted
+	closed := t.observeConn(remote, "")
 	l.Debugf("Open %s", t.connStats.String())
 	if socks {
 		err = t.handleSocks(stream)
@@ -67,6 +77,8 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 		err = t.handleTCP(l, stream, hostPort)
 	}
 	t.connStats.Close()
+	t.observeChannel(remote, -1)
+	closed(atomic.LoadInt64(&counted.read), atomic.LoadInt64(&counted.written))
 	errmsg := ""
 	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
 		errmsg = fmt.Sprintf(" (error %s)", err)
@@ -74,6 +86,24 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 	l.Debugf("Close %s%s", t.connStats.String(), errmsg)
 }
 
+// countedRWC counts the bytes of a stream
+type countedRWC struct {
+	io.ReadWriteCloser
+	read, written int64
+}
+
+func (c *countedRWC) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countedRWC) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
 func (t *Tunnel) handleSocks(src io.ReadWriteCloser) error {
 	return t.socksServer.ServeConn(cnet.NewRWCConn(src))
 }
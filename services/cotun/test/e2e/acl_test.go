@@ -0,0 +1,94 @@
+package e2e_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/zgsm-ai/cotun/client"
+	chserver "github.com/zgsm-ai/cotun/server"
+)
+
+// rawPost sends prefix then a POST of body to addr, and returns the raw response
+func rawPost(addr, prefix, body string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(prefix + "POST / HTTP/1.0\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	b, _ := io.ReadAll(conn)
+	return string(b)
+}
+
+func TestACL(t *testing.T) {
+	minPort, _ := strconv.Atoi(availablePort())
+	controlPort := availablePort()
+	authFile := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(authFile, []byte(`{"u1:pass": {"remotes": [""], "acls": [{"app": "web", "deny": ["127.0.0.1"]}]}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse:     true,
+			MinPort:     minPort,
+			MaxPort:     minPort,
+			AuthFile:    authFile,
+			ControlPort: controlPort,
+			AdminToken:  "admin-secret",
+		},
+		client: &chclient.Config{
+			Auth:    "u1:pass",
+			Remotes: []string{"R:auto:$FILEPORT"},
+			Headers: http.Header{"X-Client-Id": {"c1"}, "X-App-Name": {"web"}},
+			Managed: true,
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	for i := 0; i < 40 && (len(client.RemoteStatus()) == 0 || client.RemoteStatus()[0].State != chclient.RemoteActive); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	addr := "127.0.0.1:" + strconv.Itoa(minPort)
+	//the auth file denies the local address
+	if result := rawPost(addr, "", "foo"); result != "" {
+		t.Fatalf("expected denied connection, got %q", result)
+	}
+	//the ACLs can only be changed by admins
+	req, _ := http.NewRequest("PUT", "http://localhost:"+controlPort+"/cotun/api/v1/acls/u1",
+		strings.NewReader(`[]`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous ACL update to be rejected, got %s", resp.Status)
+	}
+	//replace the ACL with a shared secret
+	req, _ = http.NewRequest("PUT", "http://localhost:"+controlPort+"/cotun/api/v1/acls/u1",
+		strings.NewReader(`[{"secret": "s3"}]`))
+	req.Header.Set(chserver.AdminTokenHeader, "admin-secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ACL update to succeed, got %s", resp.Status)
+	}
+	if result := rawPost(addr, "bad\n", "foo"); result != "" {
+		t.Fatalf("expected invalid secret to be rejected, got %q", result)
+	}
+	if result := rawPost(addr, "s3\n", "foo"); !strings.HasSuffix(result, "foo!") {
+		t.Fatalf("expected valid secret to be proxied, got %q", result)
+	}
+}
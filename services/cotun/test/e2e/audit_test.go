@@ -0,0 +1,77 @@
+package e2e_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	chclient "github.com/zgsm-ai/cotun/client"
+	chserver "github.com/zgsm-ai/cotun/server"
+)
+
+func TestAudit(t *testing.T) {
+	minPort, _ := strconv.Atoi(availablePort())
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse: true,
+			MinPort: minPort,
+			MaxPort: minPort,
+			Audit:   chserver.AuditConfig{Sink: auditFile},
+		},
+		client: &chclient.Config{
+			Remotes: []string{"R:auto:$FILEPORT"},
+			Headers: http.Header{"X-Client-Id": {"c1"}, "X-App-Name": {"web"}},
+		},
+		fileServer: true,
+	}
+	_, _, teardown := conf.setup(t)
+	defer teardown()
+	var result string
+	var err error
+	for i := 0; i < 40; i++ {
+		if result, err = post("http://localhost:"+strconv.Itoa(minPort), "foo"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected reverse tunnel to work, got %q %v", result, err)
+	}
+	//close the kept-alive connection, then wait for its
+	//events, they are written in the background
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	seen := map[string]chserver.AuditEvent{}
+	for i := 0; i < 40 && seen[chserver.AuditConnClose].Type == ""; i++ {
+		time.Sleep(50 * time.Millisecond)
+		f, err := os.Open(auditFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			var ev chserver.AuditEvent
+			if err := json.Unmarshal(s.Bytes(), &ev); err != nil {
+				t.Fatalf("invalid audit line %s: %v", s.Bytes(), err)
+			}
+			seen[ev.Type] = ev
+		}
+		f.Close()
+	}
+	for _, typ := range []string{chserver.AuditPortAllocate, chserver.AuditSessionOpen, chserver.AuditRemoteBind, chserver.AuditConnOpen, chserver.AuditConnClose} {
+		if seen[typ].Type == "" {
+			t.Fatalf("missing %s event, got %+v", typ, seen)
+		}
+	}
+	if ev := seen[chserver.AuditRemoteBind]; ev.Port != minPort || ev.ClientId != "c1" {
+		t.Fatalf("unexpected remote_bind event %+v", ev)
+	}
+	if ev := seen[chserver.AuditConnClose]; ev.Peer == "" || ev.BytesIn == 0 || ev.BytesOut == 0 {
+		t.Fatalf("unexpected conn_close event %+v", ev)
+	}
+}
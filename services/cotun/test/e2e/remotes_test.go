@@ -0,0 +1,186 @@
+package e2e_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/zgsm-ai/cotun/client"
+	chserver "github.com/zgsm-ai/cotun/server"
+)
+
+func TestManagedRemotes(t *testing.T) {
+	tmpPort := availablePort()
+	conf := testLayout{
+		server: &chserver.Config{},
+		client: &chclient.Config{
+			Remotes: []string{tmpPort + ":$FILEPORT"},
+			Managed: true,
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	status := client.RemoteStatus()
+	if len(status) != 1 || status[0].State != chclient.RemoteActive {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	fileAddr := status[0].Remote[strings.Index(status[0].Remote, "=>")+2:]
+	//add a remote over the existing connection
+	addPort := availablePort()
+	if err := client.AddRemote(chclient.NamedRemote{Name: "web", Remote: addPort + ":" + fileAddr}); err != nil {
+		t.Fatal(err)
+	}
+	//the remote is bound in the background
+	var result string
+	var err error
+	for i := 0; i < 20; i++ {
+		if result, err = post("http://localhost:"+addPort, "foo"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//remove it, the first remote keeps working
+	if err := client.RemoveRemote("web"); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+addPort); err == nil {
+		conn.Close()
+		t.Fatal("expected removed remote to be closed")
+	}
+	if result, err := post("http://localhost:"+tmpPort, "bar"); err != nil || result != "bar!" {
+		t.Fatalf("expected first remote to work, got %q %v", result, err)
+	}
+	if err := client.RemoveRemote("web"); err == nil {
+		t.Fatal("expected error removing unknown remote")
+	}
+	//the server rejects reverse remotes, nothing is changed
+	if err := client.AddRemote(chclient.NamedRemote{Name: "rev", Remote: "R:" + availablePort() + ":" + fileAddr}); err == nil {
+		t.Fatal("expected reverse remote to be rejected by the server")
+	}
+	if status := client.RemoteStatus(); len(status) != 1 || !client.Connected() {
+		t.Fatalf("rejected remote changed the client: %+v", status)
+	}
+}
+
+func TestAutoPort(t *testing.T) {
+	minPort, _ := strconv.Atoi(availablePort())
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	conf := testLayout{
+		server: &chserver.Config{
+			Reverse: true,
+			MinPort: minPort,
+			MaxPort: minPort,
+		},
+		client: &chclient.Config{
+			Remotes:    []string{"R:auto:$FILEPORT"},
+			Headers:    http.Header{"X-Client-Id": {"c1"}, "X-App-Name": {"web"}},
+			StatusFile: statusFile,
+			Managed:    true,
+		},
+		fileServer: true,
+	}
+	_, client, teardown := conf.setup(t)
+	defer teardown()
+	//wait for the server to accept the assigned port
+	waitPort := func() int {
+		for i := 0; i < 40; i++ {
+			for _, s := range client.RemoteStatus() {
+				if s.Reverse && s.State == chclient.RemoteActive && s.MappingPort != 0 {
+					return s.MappingPort
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("auto remote not active: %+v", client.RemoteStatus())
+		return 0
+	}
+	port := waitPort()
+	if port != minPort {
+		t.Fatalf("expected port %d, got %d", minPort, port)
+	}
+	if result, err := post("http://localhost:"+strconv.Itoa(port), "foo"); err != nil || result != "foo!" {
+		t.Fatalf("expected reverse tunnel to work, got %q %v", result, err)
+	}
+	b, err := os.ReadFile(statusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status chclient.ControlStatus
+	if err := json.Unmarshal(b, &status); err != nil || len(status.Remotes) != 1 || status.Remotes[0].MappingPort != port {
+		t.Fatalf("unexpected status file %s (%v)", b, err)
+	}
+	//keep a connection open through the auto remote, changing
+	//the reverse remotes must not reconnect the client
+	kept, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Close()
+	keptPost := func(body string) string {
+		req, _ := http.NewRequest("POST", "http://localhost/", strings.NewReader(body))
+		if err := req.Write(kept); err != nil {
+			t.Fatalf("kept connection closed: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(kept), req)
+		if err != nil {
+			t.Fatalf("kept connection closed: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	if result := keptPost("foo"); result != "foo!" {
+		t.Fatalf("unexpected response %q", result)
+	}
+	revPort := availablePort()
+	fileAddr := status.Remotes[0].Remote[strings.Index(status.Remotes[0].Remote, "=>")+2:]
+	if err := client.AddRemote(chclient.NamedRemote{Name: "rev", Remote: "R:" + revPort + ":" + fileAddr}); err != nil {
+		t.Fatal(err)
+	}
+	var result string
+	for i := 0; i < 40; i++ {
+		if result, err = post("http://localhost:"+revPort, "bar"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if result != "bar!" {
+		t.Fatalf("expected added reverse remote to work, got %q %v", result, err)
+	}
+	if p := waitPort(); p != port {
+		t.Fatalf("expected port %d, got %d", port, p)
+	}
+	if result := keptPost("baz"); result != "baz!" {
+		t.Fatalf("expected auto remote to work over the same connection, got %q", result)
+	}
+	//removing it closes its port only
+	if err := client.RemoveRemote("rev"); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", "127.0.0.1:"+revPort); err == nil {
+		conn.Close()
+		t.Fatal("expected removed reverse remote to be closed")
+	}
+	if result := keptPost("qux"); result != "qux!" {
+		t.Fatalf("expected auto remote to work after removing a remote, got %q", result)
+	}
+	//a second auto remote is rejected
+	if err := client.AddRemote(chclient.NamedRemote{Name: "auto2", Remote: "R:auto:" + fileAddr}); err == nil {
+		t.Fatal("expected second auto remote to be rejected")
+	}
+}
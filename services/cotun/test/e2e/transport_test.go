@@ -0,0 +1,60 @@
+package e2e_test
+
+import (
+	"testing"
+
+	chclient "github.com/zgsm-ai/cotun/client"
+	chserver "github.com/zgsm-ai/cotun/server"
+)
+
+func testTransport(t *testing.T, s *chserver.Config, c *chclient.Config) {
+	tmpPort := availablePort()
+	c.Remotes = []string{tmpPort + ":$FILEPORT"}
+	teardown := simpleSetup(t, s, c)
+	defer teardown()
+	//several requests, each over its own channel
+	for _, body := range []string{"foo", "bar", "baz"} {
+		result, err := post("http://localhost:"+tmpPort, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != body+"!" {
+			t.Fatalf("expected exclamation mark added, got %q", result)
+		}
+	}
+}
+
+func TestTransportHTTP2(t *testing.T) {
+	testTransport(t, &chserver.Config{}, &chclient.Config{Transport: "h2"})
+}
+
+func TestTransportPoll(t *testing.T) {
+	testTransport(t, &chserver.Config{}, &chclient.Config{Transport: "poll"})
+}
+
+func TestTransportTLS(t *testing.T) {
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConfig.Close()
+	for _, transport := range []string{"tls", "h2"} {
+		testTransport(t,
+			&chserver.Config{TLS: *tlsConfig.serverTLS},
+			&chclient.Config{Transport: transport, TLS: *tlsConfig.clientTLS})
+	}
+}
+
+func TestTransportAuto(t *testing.T) {
+	//only long-poll is enabled, the client has to negotiate it
+	testTransport(t,
+		&chserver.Config{Transports: []string{"poll"}},
+		&chclient.Config{Transport: "auto"})
+}
+
+func TestTransportFallback(t *testing.T) {
+	//h2 is rejected by the server, the client falls back to long-poll
+	testTransport(t,
+		&chserver.Config{Transports: []string{"ws", "poll"}},
+		&chclient.Config{Transport: "h2,poll"})
+}
@@ -1,6 +1,8 @@
 package e2e_test
 
 import (
+	"encoding/binary"
+	"io"
 	"log"
 	"net"
 	"testing"
@@ -73,6 +75,136 @@ func TestUDP(t *testing.T) {
 	}
 }
 
+func TestTCPToUDP(t *testing.T) {
+	//udp echo server, duplicates each datagram
+	echoPort := availableUDPPort()
+	a, _ := net.ResolveUDPAddr("udp", ":"+echoPort)
+	l, err := net.ListenUDP("udp", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		b := make([]byte, 128)
+		for {
+			n, a, err := l.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			l.WriteTo(append(b[:n], b[:n]...), a)
+		}
+	}()
+	//cotun client+server, tcp in, udp out
+	inboundPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{
+				inboundPort + "/tcp:" + echoPort + "/udp",
+			},
+		},
+	)
+	defer teardown()
+	//tcp client sends length-prefixed datagrams
+	conn, err := net.Dial("tcp", "localhost:"+inboundPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	for _, msg := range []string{"foo", "bazz"} {
+		if err := writeFrame(conn, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg+msg {
+			t.Fatalf("expected %s, got %s", msg+msg, got)
+		}
+	}
+}
+
+func TestUDPToTCP(t *testing.T) {
+	//tcp echo server, reads length-prefixed datagrams and duplicates them
+	echoPort := availablePort()
+	l, err := net.Listen("tcp", "127.0.0.1:"+echoPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				for {
+					b, err := readFrame(c)
+					if err != nil {
+						return
+					}
+					if err := writeFrame(c, append(b, b...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	//cotun client+server, udp in, tcp out
+	inboundPort := availableUDPPort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{
+				inboundPort + "/udp:" + echoPort,
+			},
+		},
+	)
+	defer teardown()
+	//two udp clients, each gets its own session
+	for _, msg := range []string{"foo", "bazz"} {
+		conn, err := net.Dial("udp4", "localhost:"+inboundPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 128)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != msg+msg {
+			t.Fatalf("expected %s, got %s", msg+msg, b[:n])
+		}
+	}
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
 func availableUDPPort() string {
 	a, _ := net.ResolveUDPAddr("udp", ":0")
 	l, err := net.ListenUDP("udp", a)